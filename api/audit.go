@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/danesparza/iamserver/data"
+)
+
+// GetAuditLog gets audit events.  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
+func (service Service) GetAuditLog(rw http.ResponseWriter, req *http.Request) {
+
+	//	req.Body is a ReadCloser -- we need to remember to close it:
+	defer req.Body.Close()
+
+	//	Get the authorization header:
+	authHeader := req.Header.Get("Authorization")
+
+	//	If the auth header wasn't supplied, return an error
+	if authHeaderValid(authHeader) != true {
+		sendErrorResponse(rw, fmt.Errorf("Bearer token was not supplied"), http.StatusForbidden)
+		return
+	}
+
+	//	Get just the bearer token itself:
+	token := getBearerTokenFromAuthHeader(authHeader)
+
+	//	Get the user from the token:
+	user, err := service.DB.GetUserForToken(token)
+	if err != nil {
+		sendErrorResponse(rw, fmt.Errorf("Token not authorized or not valid"), http.StatusUnauthorized)
+		return
+	}
+
+	//	Get the audit query:
+	query, err := getAuditQuery(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	//	Perform the action with the context user
+	dataResponse, nextCursor, err := service.DB.GetAuditLog(user, query)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusInternalServerError)
+		return
+	}
+
+	//	Create our response and send information back:
+	response := SystemResponse{
+		Status:  http.StatusOK,
+		Message: "Audit log fetched",
+		Data:    dataResponse,
+
+		NextCursor: nextCursor,
+	}
+
+	//	Serialize to JSON & return the response:
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
+
+// getAuditQuery gets the audit query from the query parameters:  since, until (RFC3339 times),
+// actor, action, target, cursor and limit.  If no limit is passed, the default page size is used
+func getAuditQuery(req *http.Request) (data.AuditQuery, error) {
+	query := req.URL.Query()
+	retval := data.AuditQuery{
+		Actor:  query.Get("actor"),
+		Action: query.Get("action"),
+		Target: query.Get("target"),
+		Cursor: query.Get("cursor"),
+		Limit:  defaultPageSize,
+	}
+
+	if param := query.Get("since"); param != "" {
+		since, err := time.Parse(time.RFC3339, param)
+		if err != nil {
+			return retval, fmt.Errorf("since is not a valid RFC3339 time: %s", param)
+		}
+		retval.Since = since
+	}
+
+	if param := query.Get("until"); param != "" {
+		until, err := time.Parse(time.RFC3339, param)
+		if err != nil {
+			return retval, fmt.Errorf("until is not a valid RFC3339 time: %s", param)
+		}
+		retval.Until = until
+	}
+
+	if param := query.Get("limit"); param != "" {
+		limit, err := strconv.Atoi(param)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return retval, fmt.Errorf("limit must be a number from 1 to %v", maxPageSize)
+		}
+		retval.Limit = limit
+	}
+
+	return retval, nil
+}
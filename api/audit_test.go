@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetAuditQuery_ValidQuery_ReturnsQuery(t *testing.T) {
+	//	Arrange
+	req := httptest.NewRequest("GET", "/system/audit?actor=admin&target=User:bob&since=2018-01-02T00:00:00Z&limit=5", nil)
+
+	//	Act
+	query, err := getAuditQuery(req)
+
+	//	Assert
+	if err != nil {
+		t.Fatalf("getAuditQuery should parse the query without an error, but got %s", err)
+	}
+
+	if query.Actor != "admin" || query.Target != "User:bob" || query.Since.Year() != 2018 || query.Limit != 5 {
+		t.Errorf("getAuditQuery returned an unexpected query: %+v", query)
+	}
+}
+
+func TestGetSourceIP_ForwardedRequest_OnlyTrustsProxies(t *testing.T) {
+	//	Arrange
+	proxies, err := ParseTrustedProxies("10.0.0.0/24, 172.16.0.1")
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies should parse the proxies without an error, but got %s", err)
+	}
+	service := Service{TrustedProxies: proxies}
+
+	req := httptest.NewRequest("GET", "/system/audit", nil)
+	req.RemoteAddr = "10.0.0.2:5000"
+	direct := service.getSourceIP(req)
+
+	//	Act
+	req.Header.Set("X-Forwarded-For", "1.2.3.4, 192.168.1.10, 172.16.0.1")
+	forwarded := service.getSourceIP(req)
+
+	req.RemoteAddr = "192.168.1.99:5000"
+	spoofed := service.getSourceIP(req)
+
+	//	Assert
+	if direct != "10.0.0.2" || forwarded != "192.168.1.10" || spoofed != "192.168.1.99" {
+		t.Errorf("getSourceIP returned unexpected addresses: %s / %s / %s", direct, forwarded, spoofed)
+	}
+
+	if _, err := ParseTrustedProxies("10.0.0.1, proxy"); err == nil {
+		t.Errorf("ParseTrustedProxies should not parse an invalid address")
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/spf13/viper"
 
 	"github.com/danesparza/iamserver/data"
+	"github.com/gorilla/mux"
 )
 
 // TokenResponse is a response for a bearer token
@@ -28,6 +29,12 @@ type AuthResponse struct {
 	Authorized bool `json:"authorized"`
 }
 
+// ActionsResponse is a response structure returned after finding the permitted actions on a resource
+type ActionsResponse struct {
+	Resource string   `json:"resource"`
+	Actions  []string `json:"actions"`
+}
+
 // GetTokenForCredentials gets a bearer token for a given set of credentials
 func (service Service) GetTokenForCredentials(rw http.ResponseWriter, req *http.Request) {
 
@@ -122,12 +129,73 @@ func (service Service) IsRequestAuthorized(rw http.ResponseWriter, req *http.Req
 		return
 	}
 
-	//	See if the request is valid
-	authorized := service.DB.IsUserRequestAuthorized(user, &request)
+	//	See if the request is valid (and log the decision)
+	decision := service.DB.AuthorizeUserRequest(user, &request)
+	service.DecisionLog.Log(decision)
 
 	//	Create our response and send information back:
 	response := AuthResponse{
-		Authorized: authorized,
+		Authorized: decision.Allowed,
+	}
+
+	//	Serialize to JSON & return the response:
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
+
+// GetPermittedActions returns the registered actions of a resource that are authorized for a given bearer token.  Any
+// 'context.<key>' query parameters are the context of the requests.  If the resource doesn't exist, StatusNotFound is returned
+func (service Service) GetPermittedActions(rw http.ResponseWriter, req *http.Request) {
+
+	//	req.Body is a ReadCloser -- we need to remember to close it:
+	defer req.Body.Close()
+
+	//	Get the authorization header:
+	authHeader := req.Header.Get("Authorization")
+
+	//	If the auth header wasn't supplied, return an error
+	if authHeaderValid(authHeader) != true {
+		sendErrorResponse(rw, fmt.Errorf("Bearer token was not supplied"), http.StatusForbidden)
+		return
+	}
+
+	//	Get just the bearer token itself:
+	token := getBearerTokenFromAuthHeader(authHeader)
+
+	//	Get the user from the token:
+	user, err := service.DB.GetUserForToken(token)
+	if err != nil {
+		sendErrorResponse(rw, fmt.Errorf("Token not authorized or not valid"), http.StatusUnauthorized)
+		return
+	}
+
+	//	Parse the request
+	vars := mux.Vars(req)
+	query := req.URL.Query()
+	context := map[string]string{}
+	for key := range query {
+		if strings.HasPrefix(key, "context.") {
+			context[strings.TrimPrefix(key, "context.")] = query.Get(key)
+		}
+	}
+
+	//	See which actions are authorized (and log the decisions)
+	decisions, err := service.DB.AuthorizeUserActions(user, vars["resourcename"], context)
+	if err != nil {
+		sendErrorResponse(rw, err, getErrorStatusCode(err, http.StatusInternalServerError))
+		return
+	}
+
+	//	Create our response and send information back:
+	response := ActionsResponse{
+		Resource: vars["resourcename"],
+		Actions:  []string{},
+	}
+	for _, decision := range decisions {
+		service.DecisionLog.Log(decision)
+		if decision.Allowed {
+			response.Actions = append(response.Actions, decision.Action)
+		}
 	}
 
 	//	Serialize to JSON & return the response:
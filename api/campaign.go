@@ -0,0 +1,331 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/danesparza/iamserver/data"
+	"github.com/gorilla/mux"
+)
+
+// AddCampaign starts an access review campaign (with a snapshot of the access of each user).  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
+func (service Service) AddCampaign(rw http.ResponseWriter, req *http.Request) {
+
+	//	req.Body is a ReadCloser -- we need to remember to close it:
+	defer req.Body.Close()
+
+	//	Get the authorization header:
+	authHeader := req.Header.Get("Authorization")
+
+	//	If the auth header wasn't supplied, return an error
+	if authHeaderValid(authHeader) != true {
+		sendErrorResponse(rw, fmt.Errorf("Bearer token was not supplied"), http.StatusForbidden)
+		return
+	}
+
+	//	Get just the bearer token itself:
+	token := getBearerTokenFromAuthHeader(authHeader)
+
+	//	Get the user from the token:
+	user, err := service.DB.GetUserForToken(token)
+	if err != nil {
+		sendErrorResponse(rw, fmt.Errorf("Token not authorized or not valid"), http.StatusUnauthorized)
+		return
+	}
+
+	//	Parse the request JSON
+	request := data.Campaign{}
+	err = json.NewDecoder(req.Body).Decode(&request)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	//	Perform the action with the context user
+	dataResponse, err := service.DB.WithSource(service.getSourceIP(req)).AddCampaign(user, request)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusUnauthorized)
+		return
+	}
+
+	//	Create our response and send information back:
+	response := SystemResponse{
+		Status:  http.StatusCreated,
+		Message: "Campaign started",
+		Data:    dataResponse,
+	}
+
+	//	Serialize to JSON & return the response:
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
+
+// GetCampaign gets an access review campaign.  Reviewers only get the items they review.  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
+func (service Service) GetCampaign(rw http.ResponseWriter, req *http.Request) {
+
+	//	req.Body is a ReadCloser -- we need to remember to close it:
+	defer req.Body.Close()
+
+	//	Get the authorization header:
+	authHeader := req.Header.Get("Authorization")
+
+	//	If the auth header wasn't supplied, return an error
+	if authHeaderValid(authHeader) != true {
+		sendErrorResponse(rw, fmt.Errorf("Bearer token was not supplied"), http.StatusForbidden)
+		return
+	}
+
+	//	Get just the bearer token itself:
+	token := getBearerTokenFromAuthHeader(authHeader)
+
+	//	Get the user from the token:
+	user, err := service.DB.GetUserForToken(token)
+	if err != nil {
+		sendErrorResponse(rw, fmt.Errorf("Token not authorized or not valid"), http.StatusUnauthorized)
+		return
+	}
+
+	//	Parse the request
+	vars := mux.Vars(req)
+
+	//	Perform the action with the context user
+	dataResponse, err := service.DB.GetCampaign(user, vars["campaignname"])
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusUnauthorized)
+		return
+	}
+
+	setETag(rw, dataResponse.Version)
+
+	//	Create our response and send information back:
+	response := SystemResponse{
+		Status:  http.StatusOK,
+		Message: "Campaign fetched",
+		Data:    dataResponse,
+	}
+
+	//	Serialize to JSON & return the response:
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
+
+// GetAllCampaigns gets all access review campaigns.  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
+func (service Service) GetAllCampaigns(rw http.ResponseWriter, req *http.Request) {
+
+	//	req.Body is a ReadCloser -- we need to remember to close it:
+	defer req.Body.Close()
+
+	//	Get the authorization header:
+	authHeader := req.Header.Get("Authorization")
+
+	//	If the auth header wasn't supplied, return an error
+	if authHeaderValid(authHeader) != true {
+		sendErrorResponse(rw, fmt.Errorf("Bearer token was not supplied"), http.StatusForbidden)
+		return
+	}
+
+	//	Get just the bearer token itself:
+	token := getBearerTokenFromAuthHeader(authHeader)
+
+	//	Get the user from the token:
+	user, err := service.DB.GetUserForToken(token)
+	if err != nil {
+		sendErrorResponse(rw, fmt.Errorf("Token not authorized or not valid"), http.StatusUnauthorized)
+		return
+	}
+
+	//	Perform the action with the context user
+	dataResponse, err := service.DB.GetAllCampaigns(user)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusUnauthorized)
+		return
+	}
+
+	//	Create our response and send information back:
+	response := SystemResponse{
+		Status:  http.StatusOK,
+		Message: "Campaigns fetched",
+		Data:    dataResponse,
+	}
+
+	//	Serialize to JSON & return the response:
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
+
+// RecordReviewDecision records a keep or revoke decision for an item of an access review campaign.  If the bearer token is not one of the item's reviewers (or authorized for the operation), StatusUnauthorized is returned
+func (service Service) RecordReviewDecision(rw http.ResponseWriter, req *http.Request) {
+
+	//	req.Body is a ReadCloser -- we need to remember to close it:
+	defer req.Body.Close()
+
+	//	Get the authorization header:
+	authHeader := req.Header.Get("Authorization")
+
+	//	If the auth header wasn't supplied, return an error
+	if authHeaderValid(authHeader) != true {
+		sendErrorResponse(rw, fmt.Errorf("Bearer token was not supplied"), http.StatusForbidden)
+		return
+	}
+
+	//	Get just the bearer token itself:
+	token := getBearerTokenFromAuthHeader(authHeader)
+
+	//	Get the user from the token:
+	user, err := service.DB.GetUserForToken(token)
+	if err != nil {
+		sendErrorResponse(rw, fmt.Errorf("Token not authorized or not valid"), http.StatusUnauthorized)
+		return
+	}
+
+	//	Parse the request
+	vars := mux.Vars(req)
+	itemID, err := strconv.Atoi(vars["itemid"])
+	if err != nil {
+		sendErrorResponse(rw, fmt.Errorf("The review item id should be a number"), http.StatusBadRequest)
+		return
+	}
+
+	request := data.ReviewDecision{}
+	err = json.NewDecoder(req.Body).Decode(&request)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	//	Get the version of the item the caller expects to update (if any):
+	version, err := getIfMatchVersion(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	//	Perform the action with the context user
+	dataResponse, err := service.DB.WithSource(service.getSourceIP(req)).IfMatch(version).RecordReviewDecision(user, vars["campaignname"], itemID, request)
+	if err != nil {
+		sendErrorResponse(rw, err, getErrorStatusCode(err, http.StatusUnauthorized))
+		return
+	}
+
+	//	Create our response and send information back:
+	response := SystemResponse{
+		Status:  http.StatusOK,
+		Message: "Review decision recorded",
+		Data:    dataResponse,
+	}
+
+	//	Serialize to JSON & return the response:
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
+
+// CloseCampaign closes an access review campaign and applies the revocations.  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
+func (service Service) CloseCampaign(rw http.ResponseWriter, req *http.Request) {
+
+	//	req.Body is a ReadCloser -- we need to remember to close it:
+	defer req.Body.Close()
+
+	//	Get the authorization header:
+	authHeader := req.Header.Get("Authorization")
+
+	//	If the auth header wasn't supplied, return an error
+	if authHeaderValid(authHeader) != true {
+		sendErrorResponse(rw, fmt.Errorf("Bearer token was not supplied"), http.StatusForbidden)
+		return
+	}
+
+	//	Get just the bearer token itself:
+	token := getBearerTokenFromAuthHeader(authHeader)
+
+	//	Get the user from the token:
+	user, err := service.DB.GetUserForToken(token)
+	if err != nil {
+		sendErrorResponse(rw, fmt.Errorf("Token not authorized or not valid"), http.StatusUnauthorized)
+		return
+	}
+
+	//	Parse the request
+	vars := mux.Vars(req)
+
+	//	Get the version of the item the caller expects to update (if any):
+	version, err := getIfMatchVersion(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	//	Perform the action with the context user
+	dataResponse, err := service.DB.WithSource(service.getSourceIP(req)).IfMatch(version).CloseCampaign(user, vars["campaignname"])
+	if err != nil {
+		sendErrorResponse(rw, err, getErrorStatusCode(err, http.StatusUnauthorized))
+		return
+	}
+
+	//	Create our response and send information back:
+	response := SystemResponse{
+		Status:  http.StatusOK,
+		Message: "Campaign closed",
+		Data:    dataResponse,
+	}
+
+	//	Serialize to JSON & return the response:
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
+
+// GetCampaignReport exports an access review campaign (its items and decisions) as CSV, or as JSON with 'format=json'.  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
+func (service Service) GetCampaignReport(rw http.ResponseWriter, req *http.Request) {
+
+	//	req.Body is a ReadCloser -- we need to remember to close it:
+	defer req.Body.Close()
+
+	//	Get the authorization header:
+	authHeader := req.Header.Get("Authorization")
+
+	//	If the auth header wasn't supplied, return an error
+	if authHeaderValid(authHeader) != true {
+		sendErrorResponse(rw, fmt.Errorf("Bearer token was not supplied"), http.StatusForbidden)
+		return
+	}
+
+	//	Get just the bearer token itself:
+	token := getBearerTokenFromAuthHeader(authHeader)
+
+	//	Get the user from the token:
+	user, err := service.DB.GetUserForToken(token)
+	if err != nil {
+		sendErrorResponse(rw, fmt.Errorf("Token not authorized or not valid"), http.StatusUnauthorized)
+		return
+	}
+
+	//	Parse the request
+	vars := mux.Vars(req)
+	format := req.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		sendErrorResponse(rw, fmt.Errorf("The 'format' parameter should be 'csv' or 'json'"), http.StatusBadRequest)
+		return
+	}
+
+	//	Perform the action with the context user
+	campaign, err := service.DB.GetCampaign(user, vars["campaignname"])
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusUnauthorized)
+		return
+	}
+
+	//	Send the report back (as a download):
+	rw.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", campaign.Name+"."+format))
+	if format == "json" {
+		rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(rw).Encode(campaign)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	campaign.WriteCSV(rw)
+}
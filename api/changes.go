@@ -0,0 +1,190 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/danesparza/iamserver/data"
+)
+
+var (
+	// changePollInterval is how often the change stream checks for new changes
+	changePollInterval = 1 * time.Second
+
+	// changeKeepAliveInterval is how often the change stream sends a comment (so proxies don't close an idle stream)
+	changeKeepAliveInterval = 15 * time.Second
+)
+
+// GetChanges gets a page of change notifications after the cursor (query parameter).  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
+func (service Service) GetChanges(rw http.ResponseWriter, req *http.Request) {
+
+	//	req.Body is a ReadCloser -- we need to remember to close it:
+	defer req.Body.Close()
+
+	//	Get the authorization header:
+	authHeader := req.Header.Get("Authorization")
+
+	//	If the auth header wasn't supplied, return an error
+	if authHeaderValid(authHeader) != true {
+		sendErrorResponse(rw, fmt.Errorf("Bearer token was not supplied"), http.StatusForbidden)
+		return
+	}
+
+	//	Get just the bearer token itself:
+	token := getBearerTokenFromAuthHeader(authHeader)
+
+	//	Get the user from the token:
+	user, err := service.DB.GetUserForToken(token)
+	if err != nil {
+		sendErrorResponse(rw, fmt.Errorf("Token not authorized or not valid"), http.StatusUnauthorized)
+		return
+	}
+
+	//	Perform the action with the context user
+	dataResponse, nextCursor, err := service.DB.GetChanges(user, getChangeCursor(req), defaultPageSize)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusUnauthorized)
+		return
+	}
+
+	//	Create our response and send information back:
+	response := SystemResponse{
+		Status:     http.StatusOK,
+		Message:    "Changes fetched",
+		Data:       dataResponse,
+		NextCursor: nextCursor,
+	}
+
+	//	Serialize to JSON & return the response:
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
+
+// StreamChanges streams change notifications as server-sent events (each with the cursor as its id).
+// To resume after a disconnect, pass the last cursor in the Last-Event-ID header (or the cursor query parameter).
+// Without a cursor, only new changes are streamed.  If the bearer token is not authorized for the operation, StatusUnauthorized is returned.
+// The token is checked again on each poll:  once it has expired (or been revoked), the stream ends with an error event
+func (service Service) StreamChanges(rw http.ResponseWriter, req *http.Request) {
+
+	//	req.Body is a ReadCloser -- we need to remember to close it:
+	defer req.Body.Close()
+
+	//	Get the authorization header:
+	authHeader := req.Header.Get("Authorization")
+
+	//	If the auth header wasn't supplied, return an error
+	if authHeaderValid(authHeader) != true {
+		sendErrorResponse(rw, fmt.Errorf("Bearer token was not supplied"), http.StatusForbidden)
+		return
+	}
+
+	//	Get just the bearer token itself:
+	token := getBearerTokenFromAuthHeader(authHeader)
+
+	//	Get the user from the token:
+	user, err := service.DB.GetUserForToken(token)
+	if err != nil {
+		sendErrorResponse(rw, fmt.Errorf("Token not authorized or not valid"), http.StatusUnauthorized)
+		return
+	}
+
+	//	Make sure we can stream the response
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		sendErrorResponse(rw, fmt.Errorf("Streaming is not supported"), http.StatusInternalServerError)
+		return
+	}
+
+	//	Find where to start (this also checks that the user is authorized)
+	cursor := getChangeCursor(req)
+	if cursor == "" {
+		cursor, err = service.DB.GetLatestChangeCursor(user)
+	} else {
+		_, _, err = service.DB.GetChanges(user, cursor, 1)
+	}
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusUnauthorized)
+		return
+	}
+
+	//	Start the stream
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("Connection", "keep-alive")
+	rw.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	poll := time.NewTicker(changePollInterval)
+	defer poll.Stop()
+	keepAlive := time.NewTicker(changeKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		//	Make sure the token is still valid (and pick up any changes to the user)
+		user, err = service.DB.GetUserForToken(token)
+		if err != nil {
+			fmt.Fprint(rw, "event: error\ndata: Token not authorized or not valid\n\n")
+			flusher.Flush()
+			return
+		}
+
+		//	Send any new changes (a page at a time)
+		changes, nextCursor, err := service.DB.GetChanges(user, cursor, defaultPageSize)
+		if err != nil {
+			fmt.Fprintf(rw, "event: error\ndata: %s\n\n", err)
+			flusher.Flush()
+			return
+		}
+
+		for _, change := range changes {
+			if err := writeChangeEvent(rw, change); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+		cursor = nextCursor
+
+		//	If there may be more changes waiting, get them right away
+		if len(changes) == defaultPageSize {
+			continue
+		}
+
+		//	Otherwise, wait for the next poll (or for the client to go away)
+		waiting := true
+		for waiting {
+			select {
+			case <-req.Context().Done():
+				return
+			case <-keepAlive.C:
+				fmt.Fprint(rw, ": keep-alive\n\n")
+				flusher.Flush()
+			case <-poll.C:
+				waiting = false
+			}
+		}
+	}
+}
+
+// getChangeCursor gets the cursor to resume changes from:  the Last-Event-ID header
+// (sent by clients reconnecting to a stream) or the cursor query parameter
+func getChangeCursor(req *http.Request) string {
+	if lastEventID := req.Header.Get("Last-Event-ID"); lastEventID != "" {
+		return lastEventID
+	}
+
+	return req.URL.Query().Get("cursor")
+}
+
+// writeChangeEvent writes the change notification as a server-sent event
+func writeChangeEvent(w io.Writer, change data.ChangeNotification) error {
+	encoded, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %s\nevent: change\ndata: %s\n\n", change.ID, encoded)
+	return err
+}
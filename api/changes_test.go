@@ -0,0 +1,40 @@
+package api
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/danesparza/iamserver/data"
+)
+
+func TestGetChangeCursor_LastEventID_TakesPrecedence(t *testing.T) {
+	//	Arrange
+	reconnect := httptest.NewRequest("GET", "/system/changes/stream?cursor=abc", nil)
+	reconnect.Header.Set("Last-Event-ID", "def")
+	first := httptest.NewRequest("GET", "/system/changes/stream?cursor=abc", nil)
+
+	//	Act
+	reconnectCursor := getChangeCursor(reconnect)
+	firstCursor := getChangeCursor(first)
+
+	//	Assert
+	if reconnectCursor != "def" || firstCursor != "abc" {
+		t.Errorf("getChangeCursor returned unexpected cursors: %s / %s", reconnectCursor, firstCursor)
+	}
+}
+
+func TestWriteChangeEvent_WritesServerSentEvent(t *testing.T) {
+	//	Arrange
+	buf := &bytes.Buffer{}
+	change := data.ChangeNotification{ID: "b9m4ue4a", Action: "AddUser", Items: []string{"User:bob"}, Users: []string{"bob"}}
+
+	//	Act
+	err := writeChangeEvent(buf, change)
+
+	//	Assert
+	expected := "id: b9m4ue4a\nevent: change\ndata: {\"id\":\"b9m4ue4a\",\"action\":\"AddUser\",\"items\":[\"User:bob\"],\"users\":[\"bob\"]}\n\n"
+	if err != nil || buf.String() != expected {
+		t.Errorf("writeChangeEvent wrote an unexpected event: %q (%v)", buf.String(), err)
+	}
+}
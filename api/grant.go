@@ -0,0 +1,62 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// GetGrants gets the time-bound grants of all users (soonest to expire first).  Pass within (a duration like '24h') to
+// only get the grants that expire within that time.  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
+func (service Service) GetGrants(rw http.ResponseWriter, req *http.Request) {
+
+	//	req.Body is a ReadCloser -- we need to remember to close it:
+	defer req.Body.Close()
+
+	//	Get the authorization header:
+	authHeader := req.Header.Get("Authorization")
+
+	//	If the auth header wasn't supplied, return an error
+	if authHeaderValid(authHeader) != true {
+		sendErrorResponse(rw, fmt.Errorf("Bearer token was not supplied"), http.StatusForbidden)
+		return
+	}
+
+	//	Get just the bearer token itself:
+	token := getBearerTokenFromAuthHeader(authHeader)
+
+	//	Get the user from the token:
+	user, err := service.DB.GetUserForToken(token)
+	if err != nil {
+		sendErrorResponse(rw, fmt.Errorf("Token not authorized or not valid"), http.StatusUnauthorized)
+		return
+	}
+
+	//	Parse the request
+	within := time.Duration(0)
+	if param := req.URL.Query().Get("within"); param != "" {
+		if within, err = time.ParseDuration(param); err != nil || within <= 0 {
+			sendErrorResponse(rw, fmt.Errorf("The 'within' parameter should be a positive duration (like '24h')"), http.StatusBadRequest)
+			return
+		}
+	}
+
+	//	Perform the action with the context user
+	dataResponse, err := service.DB.GetGrants(user, within)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusUnauthorized)
+		return
+	}
+
+	//	Create our response and send information back:
+	response := SystemResponse{
+		Status:  http.StatusOK,
+		Message: "Grants fetched",
+		Data:    dataResponse,
+	}
+
+	//	Serialize to JSON & return the response:
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
@@ -44,7 +44,7 @@ func (service Service) AddGroup(rw http.ResponseWriter, req *http.Request) {
 	}
 
 	//	Perform the action with the context user
-	dataResponse, err := service.DB.AddGroup(user, request.Name, request.Description)
+	dataResponse, err := service.DB.WithSource(service.getSourceIP(req)).AddGroup(user, request.Name, request.Description)
 	if err != nil {
 		sendErrorResponse(rw, err, http.StatusInternalServerError)
 		return
@@ -62,7 +62,7 @@ func (service Service) AddGroup(rw http.ResponseWriter, req *http.Request) {
 	json.NewEncoder(rw).Encode(response)
 }
 
-// GetGroup gets group information.  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
+// GetGroup gets group information (a deleted group is only returned with include_deleted=true).  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
 func (service Service) GetGroup(rw http.ResponseWriter, req *http.Request) {
 
 	//	req.Body is a ReadCloser -- we need to remember to close it:
@@ -89,9 +89,14 @@ func (service Service) GetGroup(rw http.ResponseWriter, req *http.Request) {
 
 	//	Parse the request
 	vars := mux.Vars(req)
+	includeDeleted, err := getIncludeDeleted(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
 
 	//	Perform the action with the context user
-	dataResponse, err := service.DB.GetGroup(user, vars["groupname"])
+	dataResponse, err := service.DB.WithDeleted(includeDeleted).GetGroup(user, vars["groupname"])
 	if err != nil {
 		sendErrorResponse(rw, err, http.StatusNotFound)
 		return
@@ -104,7 +109,8 @@ func (service Service) GetGroup(rw http.ResponseWriter, req *http.Request) {
 		Data:    dataResponse,
 	}
 
-	//	Serialize to JSON & return the response:
+	//	Serialize to JSON & return the response (with the version of the item):
+	setETag(rw, dataResponse.Version)
 	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
 	json.NewEncoder(rw).Encode(response)
 }
@@ -134,8 +140,15 @@ func (service Service) GetAllGroups(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	//	Get the paging, filtering and sorting options:
+	options, err := getListOptions(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
 	//	Perform the action with the context user
-	dataResponse, err := service.DB.GetAllGroups(user)
+	dataResponse, nextCursor, err := service.DB.GetAllGroups(user, options)
 	if err != nil {
 		sendErrorResponse(rw, err, http.StatusInternalServerError)
 		return
@@ -146,6 +159,8 @@ func (service Service) GetAllGroups(rw http.ResponseWriter, req *http.Request) {
 		Status:  http.StatusOK,
 		Message: "All groups fetched",
 		Data:    dataResponse,
+
+		NextCursor: nextCursor,
 	}
 
 	//	Serialize to JSON & return the response:
@@ -153,7 +168,7 @@ func (service Service) GetAllGroups(rw http.ResponseWriter, req *http.Request) {
 	json.NewEncoder(rw).Encode(response)
 }
 
-// AddUsersToGroup adds user(s) to a group.  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
+// AddUsersToGroup adds user(s) to a group.  Pass not_before / expires (or expires_in) for a time-bound attachment.  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
 func (service Service) AddUsersToGroup(rw http.ResponseWriter, req *http.Request) {
 
 	//	req.Body is a ReadCloser -- we need to remember to close it:
@@ -181,14 +196,28 @@ func (service Service) AddUsersToGroup(rw http.ResponseWriter, req *http.Request
 	//	Parse the request
 	vars := mux.Vars(req)
 
+	//	Get the version of the item the caller expects to update (if any):
+	version, err := getIfMatchVersion(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	//	Get the time period of the attachment (if it's time-bound):
+	notBefore, expires, err := getGrantPeriod(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
 	//	Get our list of users:
 	users := vars["userlist"]
 	userList := strings.Split(users, ",")
 
 	//	Perform the action with the context user
-	dataResponse, err := service.DB.AddUsersToGroup(user, vars["groupname"], userList...)
+	dataResponse, err := service.DB.WithSource(service.getSourceIP(req)).IfMatch(version).During(notBefore, expires).AddUsersToGroup(user, vars["groupname"], userList...)
 	if err != nil {
-		sendErrorResponse(rw, err, http.StatusInternalServerError)
+		sendErrorResponse(rw, err, getErrorStatusCode(err, http.StatusInternalServerError))
 		return
 	}
 
@@ -199,7 +228,117 @@ func (service Service) AddUsersToGroup(rw http.ResponseWriter, req *http.Request
 		Data:    dataResponse,
 	}
 
+	//	Serialize to JSON & return the response (with the new version of the item):
+	setETag(rw, dataResponse.Version)
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
+
+// DeleteGroup deletes a group (and removes it from any related items).  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
+func (service Service) DeleteGroup(rw http.ResponseWriter, req *http.Request) {
+
+	//	req.Body is a ReadCloser -- we need to remember to close it:
+	defer req.Body.Close()
+
+	//	Get the authorization header:
+	authHeader := req.Header.Get("Authorization")
+
+	//	If the auth header wasn't supplied, return an error
+	if authHeaderValid(authHeader) != true {
+		sendErrorResponse(rw, fmt.Errorf("Bearer token was not supplied"), http.StatusForbidden)
+		return
+	}
+
+	//	Get just the bearer token itself:
+	token := getBearerTokenFromAuthHeader(authHeader)
+
+	//	Get the user from the token:
+	user, err := service.DB.GetUserForToken(token)
+	if err != nil {
+		sendErrorResponse(rw, fmt.Errorf("Token not authorized or not valid"), http.StatusUnauthorized)
+		return
+	}
+
+	//	Parse the request
+	vars := mux.Vars(req)
+
+	//	Get the version of the item the caller expects to update (if any):
+	version, err := getIfMatchVersion(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	//	Perform the action with the context user
+	dataResponse, err := service.DB.WithSource(service.getSourceIP(req)).IfMatch(version).DeleteGroup(user, vars["groupname"])
+	if err != nil {
+		sendErrorResponse(rw, err, getErrorStatusCode(err, http.StatusInternalServerError))
+		return
+	}
+
+	//	Create our response and send information back:
+	response := SystemResponse{
+		Status:  http.StatusOK,
+		Message: "Group deleted",
+		Data:    dataResponse,
+	}
+
 	//	Serialize to JSON & return the response:
 	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
 	json.NewEncoder(rw).Encode(response)
 }
+
+// RestoreGroup restores a deleted group (and adds it back to the items it was removed from).  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
+func (service Service) RestoreGroup(rw http.ResponseWriter, req *http.Request) {
+
+	//	req.Body is a ReadCloser -- we need to remember to close it:
+	defer req.Body.Close()
+
+	//	Get the authorization header:
+	authHeader := req.Header.Get("Authorization")
+
+	//	If the auth header wasn't supplied, return an error
+	if authHeaderValid(authHeader) != true {
+		sendErrorResponse(rw, fmt.Errorf("Bearer token was not supplied"), http.StatusForbidden)
+		return
+	}
+
+	//	Get just the bearer token itself:
+	token := getBearerTokenFromAuthHeader(authHeader)
+
+	//	Get the user from the token:
+	user, err := service.DB.GetUserForToken(token)
+	if err != nil {
+		sendErrorResponse(rw, fmt.Errorf("Token not authorized or not valid"), http.StatusUnauthorized)
+		return
+	}
+
+	//	Parse the request
+	vars := mux.Vars(req)
+
+	//	Get the version of the item the caller expects to update (if any):
+	version, err := getIfMatchVersion(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	//	Perform the action with the context user
+	dataResponse, err := service.DB.WithSource(service.getSourceIP(req)).IfMatch(version).RestoreGroup(user, vars["groupname"])
+	if err != nil {
+		sendErrorResponse(rw, err, getErrorStatusCode(err, http.StatusInternalServerError))
+		return
+	}
+
+	//	Create our response and send information back:
+	response := SystemResponse{
+		Status:  http.StatusOK,
+		Message: "Group restored",
+		Data:    dataResponse,
+	}
+
+	//	Serialize to JSON & return the response (with the new version of the item):
+	setETag(rw, dataResponse.Version)
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
@@ -3,7 +3,9 @@ package api
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/danesparza/iamserver/data"
@@ -44,9 +46,9 @@ func (service Service) AddPolicy(rw http.ResponseWriter, req *http.Request) {
 	}
 
 	//	Perform the action with the context user
-	dataResponse, err := service.DB.AddPolicy(user, request)
+	dataResponse, err := service.DB.WithSource(service.getSourceIP(req)).AddPolicy(user, request)
 	if err != nil {
-		sendErrorResponse(rw, err, http.StatusInternalServerError)
+		sendErrorResponse(rw, err, getErrorStatusCode(err, http.StatusInternalServerError))
 		return
 	}
 
@@ -62,7 +64,7 @@ func (service Service) AddPolicy(rw http.ResponseWriter, req *http.Request) {
 	json.NewEncoder(rw).Encode(response)
 }
 
-// GetPolicy gets a policy.  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
+// GetPolicy gets a policy (a deleted policy is only returned with include_deleted=true).  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
 func (service Service) GetPolicy(rw http.ResponseWriter, req *http.Request) {
 
 	//	req.Body is a ReadCloser -- we need to remember to close it:
@@ -89,9 +91,14 @@ func (service Service) GetPolicy(rw http.ResponseWriter, req *http.Request) {
 
 	//	Parse the request
 	vars := mux.Vars(req)
+	includeDeleted, err := getIncludeDeleted(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
 
 	//	Perform the action with the context user
-	dataResponse, err := service.DB.GetPolicy(user, vars["policyname"])
+	dataResponse, err := service.DB.WithDeleted(includeDeleted).GetPolicy(user, vars["policyname"])
 	if err != nil {
 		sendErrorResponse(rw, err, http.StatusInternalServerError)
 		return
@@ -104,7 +111,8 @@ func (service Service) GetPolicy(rw http.ResponseWriter, req *http.Request) {
 		Data:    dataResponse,
 	}
 
-	//	Serialize to JSON & return the response:
+	//	Serialize to JSON & return the response (with the version of the item):
+	setETag(rw, dataResponse.Version)
 	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
 	json.NewEncoder(rw).Encode(response)
 }
@@ -134,8 +142,15 @@ func (service Service) GetAllPolicies(rw http.ResponseWriter, req *http.Request)
 		return
 	}
 
+	//	Get the paging, filtering and sorting options:
+	options, err := getListOptions(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
 	//	Perform the action with the context user
-	dataResponse, err := service.DB.GetAllPolicies(user)
+	dataResponse, nextCursor, err := service.DB.GetAllPolicies(user, options)
 	if err != nil {
 		sendErrorResponse(rw, err, http.StatusInternalServerError)
 		return
@@ -146,6 +161,8 @@ func (service Service) GetAllPolicies(rw http.ResponseWriter, req *http.Request)
 		Status:  http.StatusOK,
 		Message: "Policies fetched",
 		Data:    dataResponse,
+
+		NextCursor: nextCursor,
 	}
 
 	//	Serialize to JSON & return the response:
@@ -153,7 +170,7 @@ func (service Service) GetAllPolicies(rw http.ResponseWriter, req *http.Request)
 	json.NewEncoder(rw).Encode(response)
 }
 
-// AttachPolicyToUsers attaches user(s) to a policy.  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
+// AttachPolicyToUsers attaches user(s) to a policy.  Pass not_before / expires (or expires_in) for a time-bound attachment.  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
 func (service Service) AttachPolicyToUsers(rw http.ResponseWriter, req *http.Request) {
 
 	//	req.Body is a ReadCloser -- we need to remember to close it:
@@ -181,14 +198,28 @@ func (service Service) AttachPolicyToUsers(rw http.ResponseWriter, req *http.Req
 	//	Parse the request
 	vars := mux.Vars(req)
 
+	//	Get the version of the item the caller expects to update (if any):
+	version, err := getIfMatchVersion(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	//	Get the time period of the attachment (if it's time-bound):
+	notBefore, expires, err := getGrantPeriod(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
 	//	Get our list of users:
 	users := vars["userlist"]
 	userList := strings.Split(users, ",")
 
 	//	Perform the action with the context user
-	dataResponse, err := service.DB.AttachPolicyToUsers(user, vars["policyname"], userList...)
+	dataResponse, err := service.DB.WithSource(service.getSourceIP(req)).IfMatch(version).During(notBefore, expires).AttachPolicyToUsers(user, vars["policyname"], userList...)
 	if err != nil {
-		sendErrorResponse(rw, err, http.StatusInternalServerError)
+		sendErrorResponse(rw, err, getErrorStatusCode(err, http.StatusInternalServerError))
 		return
 	}
 
@@ -199,7 +230,8 @@ func (service Service) AttachPolicyToUsers(rw http.ResponseWriter, req *http.Req
 		Data:    dataResponse,
 	}
 
-	//	Serialize to JSON & return the response:
+	//	Serialize to JSON & return the response (with the new version of the item):
+	setETag(rw, dataResponse.Version)
 	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
 	json.NewEncoder(rw).Encode(response)
 }
@@ -232,14 +264,21 @@ func (service Service) AttachPolicyToGroups(rw http.ResponseWriter, req *http.Re
 	//	Parse the request
 	vars := mux.Vars(req)
 
+	//	Get the version of the item the caller expects to update (if any):
+	version, err := getIfMatchVersion(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
 	//	Get our list of groups:
 	groups := vars["grouplist"]
 	groupList := strings.Split(groups, ",")
 
 	//	Perform the action with the context user
-	dataResponse, err := service.DB.AttachPolicyToGroups(user, vars["policyname"], groupList...)
+	dataResponse, err := service.DB.WithSource(service.getSourceIP(req)).IfMatch(version).AttachPolicyToGroups(user, vars["policyname"], groupList...)
 	if err != nil {
-		sendErrorResponse(rw, err, http.StatusInternalServerError)
+		sendErrorResponse(rw, err, getErrorStatusCode(err, http.StatusInternalServerError))
 		return
 	}
 
@@ -250,7 +289,8 @@ func (service Service) AttachPolicyToGroups(rw http.ResponseWriter, req *http.Re
 		Data:    dataResponse,
 	}
 
-	//	Serialize to JSON & return the response:
+	//	Serialize to JSON & return the response (with the new version of the item):
+	setETag(rw, dataResponse.Version)
 	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
 	json.NewEncoder(rw).Encode(response)
 }
@@ -301,3 +341,397 @@ func (service Service) GetPoliciesForUser(rw http.ResponseWriter, req *http.Requ
 	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
 	json.NewEncoder(rw).Encode(response)
 }
+
+// DeletePolicy deletes a policy (and removes it from any related items).  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
+func (service Service) DeletePolicy(rw http.ResponseWriter, req *http.Request) {
+
+	//	req.Body is a ReadCloser -- we need to remember to close it:
+	defer req.Body.Close()
+
+	//	Get the authorization header:
+	authHeader := req.Header.Get("Authorization")
+
+	//	If the auth header wasn't supplied, return an error
+	if authHeaderValid(authHeader) != true {
+		sendErrorResponse(rw, fmt.Errorf("Bearer token was not supplied"), http.StatusForbidden)
+		return
+	}
+
+	//	Get just the bearer token itself:
+	token := getBearerTokenFromAuthHeader(authHeader)
+
+	//	Get the user from the token:
+	user, err := service.DB.GetUserForToken(token)
+	if err != nil {
+		sendErrorResponse(rw, fmt.Errorf("Token not authorized or not valid"), http.StatusUnauthorized)
+		return
+	}
+
+	//	Parse the request
+	vars := mux.Vars(req)
+
+	//	Get the version of the item the caller expects to update (if any):
+	version, err := getIfMatchVersion(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	//	Perform the action with the context user
+	dataResponse, err := service.DB.WithSource(service.getSourceIP(req)).IfMatch(version).DeletePolicy(user, vars["policyname"])
+	if err != nil {
+		sendErrorResponse(rw, err, getErrorStatusCode(err, http.StatusInternalServerError))
+		return
+	}
+
+	//	Create our response and send information back:
+	response := SystemResponse{
+		Status:  http.StatusOK,
+		Message: "Policy deleted",
+		Data:    dataResponse,
+	}
+
+	//	Serialize to JSON & return the response:
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
+
+// RestorePolicy restores a deleted policy (and adds it back to the items it was removed from).  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
+func (service Service) RestorePolicy(rw http.ResponseWriter, req *http.Request) {
+
+	//	req.Body is a ReadCloser -- we need to remember to close it:
+	defer req.Body.Close()
+
+	//	Get the authorization header:
+	authHeader := req.Header.Get("Authorization")
+
+	//	If the auth header wasn't supplied, return an error
+	if authHeaderValid(authHeader) != true {
+		sendErrorResponse(rw, fmt.Errorf("Bearer token was not supplied"), http.StatusForbidden)
+		return
+	}
+
+	//	Get just the bearer token itself:
+	token := getBearerTokenFromAuthHeader(authHeader)
+
+	//	Get the user from the token:
+	user, err := service.DB.GetUserForToken(token)
+	if err != nil {
+		sendErrorResponse(rw, fmt.Errorf("Token not authorized or not valid"), http.StatusUnauthorized)
+		return
+	}
+
+	//	Parse the request
+	vars := mux.Vars(req)
+
+	//	Get the version of the item the caller expects to update (if any):
+	version, err := getIfMatchVersion(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	//	Perform the action with the context user
+	dataResponse, err := service.DB.WithSource(service.getSourceIP(req)).IfMatch(version).RestorePolicy(user, vars["policyname"])
+	if err != nil {
+		sendErrorResponse(rw, err, getErrorStatusCode(err, http.StatusInternalServerError))
+		return
+	}
+
+	//	Create our response and send information back:
+	response := SystemResponse{
+		Status:  http.StatusOK,
+		Message: "Policy restored",
+		Data:    dataResponse,
+	}
+
+	//	Serialize to JSON & return the response (with the new version of the item):
+	setETag(rw, dataResponse.Version)
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
+
+// ImportPolicy adds a policy from an AWS IAM policy document.  The policy is named with the 'name' query
+// parameter (or the Id of the document).  If the document has anything that isn't supported, StatusBadRequest
+// is returned.  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
+func (service Service) ImportPolicy(rw http.ResponseWriter, req *http.Request) {
+
+	//	req.Body is a ReadCloser -- we need to remember to close it:
+	defer req.Body.Close()
+
+	//	Get the authorization header:
+	authHeader := req.Header.Get("Authorization")
+
+	//	If the auth header wasn't supplied, return an error
+	if authHeaderValid(authHeader) != true {
+		sendErrorResponse(rw, fmt.Errorf("Bearer token was not supplied"), http.StatusForbidden)
+		return
+	}
+
+	//	Get just the bearer token itself:
+	token := getBearerTokenFromAuthHeader(authHeader)
+
+	//	Get the user from the token:
+	user, err := service.DB.GetUserForToken(token)
+	if err != nil {
+		sendErrorResponse(rw, fmt.Errorf("Token not authorized or not valid"), http.StatusUnauthorized)
+		return
+	}
+
+	//	Parse the request document
+	document, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	request, err := data.ImportAWSPolicy(req.URL.Query().Get("name"), document)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	//	Perform the action with the context user
+	dataResponse, err := service.DB.WithSource(service.getSourceIP(req)).AddPolicy(user, request)
+	if err != nil {
+		sendErrorResponse(rw, err, getErrorStatusCode(err, http.StatusInternalServerError))
+		return
+	}
+
+	//	Create our response and send information back:
+	response := SystemResponse{
+		Status:  http.StatusCreated,
+		Message: "Policy imported",
+		Data:    dataResponse,
+	}
+
+	//	Serialize to JSON & return the response:
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
+
+// ExportPolicy gets a policy as an AWS IAM policy document.  If the policy uses anything that has no AWS
+// equivalent, StatusUnprocessableEntity is returned.  If the bearer token is not authorized for the operation,
+// StatusUnauthorized is returned
+func (service Service) ExportPolicy(rw http.ResponseWriter, req *http.Request) {
+
+	//	req.Body is a ReadCloser -- we need to remember to close it:
+	defer req.Body.Close()
+
+	//	Get the authorization header:
+	authHeader := req.Header.Get("Authorization")
+
+	//	If the auth header wasn't supplied, return an error
+	if authHeaderValid(authHeader) != true {
+		sendErrorResponse(rw, fmt.Errorf("Bearer token was not supplied"), http.StatusForbidden)
+		return
+	}
+
+	//	Get just the bearer token itself:
+	token := getBearerTokenFromAuthHeader(authHeader)
+
+	//	Get the user from the token:
+	user, err := service.DB.GetUserForToken(token)
+	if err != nil {
+		sendErrorResponse(rw, fmt.Errorf("Token not authorized or not valid"), http.StatusUnauthorized)
+		return
+	}
+
+	//	Parse the request
+	vars := mux.Vars(req)
+
+	//	Perform the action with the context user
+	existing, err := service.DB.GetPolicy(user, vars["policyname"])
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusInternalServerError)
+		return
+	}
+
+	dataResponse, err := data.ExportAWSPolicy(existing)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	//	Create our response and send information back:
+	response := SystemResponse{
+		Status:  http.StatusOK,
+		Message: "Policy exported",
+		Data:    dataResponse,
+	}
+
+	//	Serialize to JSON & return the response (with the version of the item):
+	setETag(rw, existing.Version)
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
+
+// LintPolicies checks the policies in the system for problems.  Groups with at least the 'large_group' query parameter
+// number of users are large groups.  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
+func (service Service) LintPolicies(rw http.ResponseWriter, req *http.Request) {
+
+	//	req.Body is a ReadCloser -- we need to remember to close it:
+	defer req.Body.Close()
+
+	//	Get the authorization header:
+	authHeader := req.Header.Get("Authorization")
+
+	//	If the auth header wasn't supplied, return an error
+	if authHeaderValid(authHeader) != true {
+		sendErrorResponse(rw, fmt.Errorf("Bearer token was not supplied"), http.StatusForbidden)
+		return
+	}
+
+	//	Get just the bearer token itself:
+	token := getBearerTokenFromAuthHeader(authHeader)
+
+	//	Get the user from the token:
+	user, err := service.DB.GetUserForToken(token)
+	if err != nil {
+		sendErrorResponse(rw, fmt.Errorf("Token not authorized or not valid"), http.StatusUnauthorized)
+		return
+	}
+
+	//	Parse the request
+	options := data.LintOptions{}
+	if largeGroup := req.URL.Query().Get("large_group"); largeGroup != "" {
+		if options.LargeGroupSize, err = strconv.Atoi(largeGroup); err != nil {
+			sendErrorResponse(rw, fmt.Errorf("The 'large_group' parameter should be a number"), http.StatusBadRequest)
+			return
+		}
+	}
+
+	//	Perform the action with the context user
+	dataResponse, err := service.DB.LintPolicies(user, options)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusInternalServerError)
+		return
+	}
+
+	//	Create our response and send information back:
+	response := SystemResponse{
+		Status:  http.StatusOK,
+		Message: "Policies linted",
+		Data:    dataResponse,
+	}
+
+	//	Serialize to JSON & return the response:
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
+
+// SimulatePolicies decides a set of requests for a user before and after a change to the user's policies, roles or
+// groups (without making the change).  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
+func (service Service) SimulatePolicies(rw http.ResponseWriter, req *http.Request) {
+
+	//	req.Body is a ReadCloser -- we need to remember to close it:
+	defer req.Body.Close()
+
+	//	Get the authorization header:
+	authHeader := req.Header.Get("Authorization")
+
+	//	If the auth header wasn't supplied, return an error
+	if authHeaderValid(authHeader) != true {
+		sendErrorResponse(rw, fmt.Errorf("Bearer token was not supplied"), http.StatusForbidden)
+		return
+	}
+
+	//	Get just the bearer token itself:
+	token := getBearerTokenFromAuthHeader(authHeader)
+
+	//	Get the user from the token:
+	user, err := service.DB.GetUserForToken(token)
+	if err != nil {
+		sendErrorResponse(rw, fmt.Errorf("Token not authorized or not valid"), http.StatusUnauthorized)
+		return
+	}
+
+	//	Parse the request JSON
+	request := data.Simulation{}
+	err = json.NewDecoder(req.Body).Decode(&request)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	//	Perform the action with the context user
+	dataResponse, err := service.DB.SimulatePolicies(user, request)
+	if err != nil {
+		sendErrorResponse(rw, err, getErrorStatusCode(err, http.StatusInternalServerError))
+		return
+	}
+
+	//	Create our response and send information back:
+	response := SystemResponse{
+		Status:  http.StatusOK,
+		Message: "Policies simulated",
+		Data:    dataResponse,
+	}
+
+	//	Serialize to JSON & return the response:
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
+
+// WhoCan gets the users that are allowed to perform an action on a resource (and how they are allowed).  Any other
+// 'context.<key>' query parameters are the context of the request.  If the bearer token is not authorized for the
+// operation, StatusUnauthorized is returned
+func (service Service) WhoCan(rw http.ResponseWriter, req *http.Request) {
+
+	//	req.Body is a ReadCloser -- we need to remember to close it:
+	defer req.Body.Close()
+
+	//	Get the authorization header:
+	authHeader := req.Header.Get("Authorization")
+
+	//	If the auth header wasn't supplied, return an error
+	if authHeaderValid(authHeader) != true {
+		sendErrorResponse(rw, fmt.Errorf("Bearer token was not supplied"), http.StatusForbidden)
+		return
+	}
+
+	//	Get just the bearer token itself:
+	token := getBearerTokenFromAuthHeader(authHeader)
+
+	//	Get the user from the token:
+	user, err := service.DB.GetUserForToken(token)
+	if err != nil {
+		sendErrorResponse(rw, fmt.Errorf("Token not authorized or not valid"), http.StatusUnauthorized)
+		return
+	}
+
+	//	Parse the request
+	query := req.URL.Query()
+	request := data.Request{Resource: query.Get("resource"), Action: query.Get("action")}
+	for key := range query {
+		if strings.HasPrefix(key, "context.") {
+			if request.Context == nil {
+				request.Context = map[string]string{}
+			}
+			request.Context[strings.TrimPrefix(key, "context.")] = query.Get(key)
+		}
+	}
+
+	if request.Resource == "" || request.Action == "" {
+		sendErrorResponse(rw, fmt.Errorf("The 'resource' and 'action' parameters are required"), http.StatusBadRequest)
+		return
+	}
+
+	//	Perform the action with the context user
+	dataResponse, err := service.DB.WhoCan(user, request)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusInternalServerError)
+		return
+	}
+
+	//	Create our response and send information back:
+	response := SystemResponse{
+		Status:  http.StatusOK,
+		Message: fmt.Sprintf("%v users allowed", len(dataResponse.Allowed)),
+		Data:    dataResponse,
+	}
+
+	//	Serialize to JSON & return the response:
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
@@ -44,7 +44,7 @@ func (service Service) AddResource(rw http.ResponseWriter, req *http.Request) {
 	}
 
 	//	Perform the action with the context user
-	dataResponse, err := service.DB.AddResource(user, request.Name, request.Description)
+	dataResponse, err := service.DB.WithSource(service.getSourceIP(req)).AddResource(user, request.Name, request.Description)
 	if err != nil {
 		sendErrorResponse(rw, err, http.StatusUnauthorized)
 		return
@@ -104,7 +104,8 @@ func (service Service) GetResource(rw http.ResponseWriter, req *http.Request) {
 		Data:    dataResponse,
 	}
 
-	//	Serialize to JSON & return the response:
+	//	Serialize to JSON & return the response (with the version of the item):
+	setETag(rw, dataResponse.Version)
 	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
 	json.NewEncoder(rw).Encode(response)
 }
@@ -134,8 +135,15 @@ func (service Service) GetAllResources(rw http.ResponseWriter, req *http.Request
 		return
 	}
 
+	//	Get the paging, filtering and sorting options:
+	options, err := getListOptions(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
 	//	Perform the action with the context user
-	dataResponse, err := service.DB.GetAllResources(user)
+	dataResponse, nextCursor, err := service.DB.GetAllResources(user, options)
 	if err != nil {
 		sendErrorResponse(rw, err, http.StatusInternalServerError)
 		return
@@ -146,6 +154,8 @@ func (service Service) GetAllResources(rw http.ResponseWriter, req *http.Request
 		Status:  http.StatusOK,
 		Message: "Resources fetched",
 		Data:    dataResponse,
+
+		NextCursor: nextCursor,
 	}
 
 	//	Serialize to JSON & return the response:
@@ -181,14 +191,21 @@ func (service Service) AddActionsToResource(rw http.ResponseWriter, req *http.Re
 	//	Parse the request
 	vars := mux.Vars(req)
 
+	//	Get the version of the item the caller expects to update (if any):
+	version, err := getIfMatchVersion(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
 	//	Get our list of actions:
 	actions := vars["actionlist"]
 	actionList := strings.Split(actions, ",")
 
 	//	Perform the action with the context user
-	dataResponse, err := service.DB.AddActionToResource(user, vars["resourcename"], actionList...)
+	dataResponse, err := service.DB.WithSource(service.getSourceIP(req)).IfMatch(version).AddActionToResource(user, vars["resourcename"], actionList...)
 	if err != nil {
-		sendErrorResponse(rw, err, http.StatusInternalServerError)
+		sendErrorResponse(rw, err, getErrorStatusCode(err, http.StatusInternalServerError))
 		return
 	}
 
@@ -199,7 +216,8 @@ func (service Service) AddActionsToResource(rw http.ResponseWriter, req *http.Re
 		Data:    dataResponse,
 	}
 
-	//	Serialize to JSON & return the response:
+	//	Serialize to JSON & return the response (with the new version of the item):
+	setETag(rw, dataResponse.Version)
 	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
 	json.NewEncoder(rw).Encode(response)
 }
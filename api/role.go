@@ -44,7 +44,7 @@ func (service Service) AddRole(rw http.ResponseWriter, req *http.Request) {
 	}
 
 	//	Perform the action with the context user
-	dataResponse, err := service.DB.AddRole(user, request.Name, request.Description)
+	dataResponse, err := service.DB.WithSource(service.getSourceIP(req)).AddRole(user, request.Name, request.Description)
 	if err != nil {
 		sendErrorResponse(rw, err, http.StatusInternalServerError)
 		return
@@ -62,7 +62,7 @@ func (service Service) AddRole(rw http.ResponseWriter, req *http.Request) {
 	json.NewEncoder(rw).Encode(response)
 }
 
-// GetRole gets role information.  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
+// GetRole gets role information (a deleted role is only returned with include_deleted=true).  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
 func (service Service) GetRole(rw http.ResponseWriter, req *http.Request) {
 
 	//	req.Body is a ReadCloser -- we need to remember to close it:
@@ -89,9 +89,14 @@ func (service Service) GetRole(rw http.ResponseWriter, req *http.Request) {
 
 	//	Parse the request
 	vars := mux.Vars(req)
+	includeDeleted, err := getIncludeDeleted(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
 
 	//	Perform the action with the context user
-	dataResponse, err := service.DB.GetRole(user, vars["rolename"])
+	dataResponse, err := service.DB.WithDeleted(includeDeleted).GetRole(user, vars["rolename"])
 	if err != nil {
 		sendErrorResponse(rw, err, http.StatusNotFound)
 		return
@@ -104,7 +109,8 @@ func (service Service) GetRole(rw http.ResponseWriter, req *http.Request) {
 		Data:    dataResponse,
 	}
 
-	//	Serialize to JSON & return the response:
+	//	Serialize to JSON & return the response (with the version of the item):
+	setETag(rw, dataResponse.Version)
 	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
 	json.NewEncoder(rw).Encode(response)
 }
@@ -134,8 +140,15 @@ func (service Service) GetAllRoles(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	//	Get the paging, filtering and sorting options:
+	options, err := getListOptions(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
 	//	Perform the action with the context user
-	dataResponse, err := service.DB.GetAllRoles(user)
+	dataResponse, nextCursor, err := service.DB.GetAllRoles(user, options)
 	if err != nil {
 		sendErrorResponse(rw, err, http.StatusInternalServerError)
 		return
@@ -146,6 +159,8 @@ func (service Service) GetAllRoles(rw http.ResponseWriter, req *http.Request) {
 		Status:  http.StatusOK,
 		Message: "All roles fetched",
 		Data:    dataResponse,
+
+		NextCursor: nextCursor,
 	}
 
 	//	Serialize to JSON & return the response:
@@ -181,14 +196,21 @@ func (service Service) AttachPoliciesToRole(rw http.ResponseWriter, req *http.Re
 	//	Parse the request
 	vars := mux.Vars(req)
 
+	//	Get the version of the item the caller expects to update (if any):
+	version, err := getIfMatchVersion(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
 	//	Get our list of users:
 	policies := vars["policylist"]
 	policyList := strings.Split(policies, ",")
 
 	//	Perform the action with the context user
-	dataResponse, err := service.DB.AttachPoliciesToRole(user, vars["rolename"], policyList...)
+	dataResponse, err := service.DB.WithSource(service.getSourceIP(req)).IfMatch(version).AttachPoliciesToRole(user, vars["rolename"], policyList...)
 	if err != nil {
-		sendErrorResponse(rw, err, http.StatusInternalServerError)
+		sendErrorResponse(rw, err, getErrorStatusCode(err, http.StatusInternalServerError))
 		return
 	}
 
@@ -199,7 +221,8 @@ func (service Service) AttachPoliciesToRole(rw http.ResponseWriter, req *http.Re
 		Data:    dataResponse,
 	}
 
-	//	Serialize to JSON & return the response:
+	//	Serialize to JSON & return the response (with the new version of the item):
+	setETag(rw, dataResponse.Version)
 	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
 	json.NewEncoder(rw).Encode(response)
 }
@@ -232,14 +255,21 @@ func (service Service) AttachRoleToGroups(rw http.ResponseWriter, req *http.Requ
 	//	Parse the request
 	vars := mux.Vars(req)
 
+	//	Get the version of the item the caller expects to update (if any):
+	version, err := getIfMatchVersion(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
 	//	Get our list of users:
 	groups := vars["grouplist"]
 	groupList := strings.Split(groups, ",")
 
 	//	Perform the action with the context user
-	dataResponse, err := service.DB.AttachRoleToGroups(user, vars["rolename"], groupList...)
+	dataResponse, err := service.DB.WithSource(service.getSourceIP(req)).IfMatch(version).AttachRoleToGroups(user, vars["rolename"], groupList...)
 	if err != nil {
-		sendErrorResponse(rw, err, http.StatusInternalServerError)
+		sendErrorResponse(rw, err, getErrorStatusCode(err, http.StatusInternalServerError))
 		return
 	}
 
@@ -250,12 +280,13 @@ func (service Service) AttachRoleToGroups(rw http.ResponseWriter, req *http.Requ
 		Data:    dataResponse,
 	}
 
-	//	Serialize to JSON & return the response:
+	//	Serialize to JSON & return the response (with the new version of the item):
+	setETag(rw, dataResponse.Version)
 	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
 	json.NewEncoder(rw).Encode(response)
 }
 
-// AttachRoleToUsers attaches user(s) to a role.  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
+// AttachRoleToUsers attaches user(s) to a role.  Pass not_before / expires (or expires_in) for a time-bound attachment.  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
 func (service Service) AttachRoleToUsers(rw http.ResponseWriter, req *http.Request) {
 
 	//	req.Body is a ReadCloser -- we need to remember to close it:
@@ -283,14 +314,28 @@ func (service Service) AttachRoleToUsers(rw http.ResponseWriter, req *http.Reque
 	//	Parse the request
 	vars := mux.Vars(req)
 
+	//	Get the version of the item the caller expects to update (if any):
+	version, err := getIfMatchVersion(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	//	Get the time period of the attachment (if it's time-bound):
+	notBefore, expires, err := getGrantPeriod(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
 	//	Get our list of users:
 	users := vars["userlist"]
 	userList := strings.Split(users, ",")
 
 	//	Perform the action with the context user
-	dataResponse, err := service.DB.AttachRoleToUsers(user, vars["rolename"], userList...)
+	dataResponse, err := service.DB.WithSource(service.getSourceIP(req)).IfMatch(version).During(notBefore, expires).AttachRoleToUsers(user, vars["rolename"], userList...)
 	if err != nil {
-		sendErrorResponse(rw, err, http.StatusInternalServerError)
+		sendErrorResponse(rw, err, getErrorStatusCode(err, http.StatusInternalServerError))
 		return
 	}
 
@@ -301,7 +346,117 @@ func (service Service) AttachRoleToUsers(rw http.ResponseWriter, req *http.Reque
 		Data:    dataResponse,
 	}
 
+	//	Serialize to JSON & return the response (with the new version of the item):
+	setETag(rw, dataResponse.Version)
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
+
+// DeleteRole deletes a role (and removes it from any related items).  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
+func (service Service) DeleteRole(rw http.ResponseWriter, req *http.Request) {
+
+	//	req.Body is a ReadCloser -- we need to remember to close it:
+	defer req.Body.Close()
+
+	//	Get the authorization header:
+	authHeader := req.Header.Get("Authorization")
+
+	//	If the auth header wasn't supplied, return an error
+	if authHeaderValid(authHeader) != true {
+		sendErrorResponse(rw, fmt.Errorf("Bearer token was not supplied"), http.StatusForbidden)
+		return
+	}
+
+	//	Get just the bearer token itself:
+	token := getBearerTokenFromAuthHeader(authHeader)
+
+	//	Get the user from the token:
+	user, err := service.DB.GetUserForToken(token)
+	if err != nil {
+		sendErrorResponse(rw, fmt.Errorf("Token not authorized or not valid"), http.StatusUnauthorized)
+		return
+	}
+
+	//	Parse the request
+	vars := mux.Vars(req)
+
+	//	Get the version of the item the caller expects to update (if any):
+	version, err := getIfMatchVersion(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	//	Perform the action with the context user
+	dataResponse, err := service.DB.WithSource(service.getSourceIP(req)).IfMatch(version).DeleteRole(user, vars["rolename"])
+	if err != nil {
+		sendErrorResponse(rw, err, getErrorStatusCode(err, http.StatusInternalServerError))
+		return
+	}
+
+	//	Create our response and send information back:
+	response := SystemResponse{
+		Status:  http.StatusOK,
+		Message: "Role deleted",
+		Data:    dataResponse,
+	}
+
 	//	Serialize to JSON & return the response:
 	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
 	json.NewEncoder(rw).Encode(response)
 }
+
+// RestoreRole restores a deleted role (and adds it back to the items it was removed from).  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
+func (service Service) RestoreRole(rw http.ResponseWriter, req *http.Request) {
+
+	//	req.Body is a ReadCloser -- we need to remember to close it:
+	defer req.Body.Close()
+
+	//	Get the authorization header:
+	authHeader := req.Header.Get("Authorization")
+
+	//	If the auth header wasn't supplied, return an error
+	if authHeaderValid(authHeader) != true {
+		sendErrorResponse(rw, fmt.Errorf("Bearer token was not supplied"), http.StatusForbidden)
+		return
+	}
+
+	//	Get just the bearer token itself:
+	token := getBearerTokenFromAuthHeader(authHeader)
+
+	//	Get the user from the token:
+	user, err := service.DB.GetUserForToken(token)
+	if err != nil {
+		sendErrorResponse(rw, fmt.Errorf("Token not authorized or not valid"), http.StatusUnauthorized)
+		return
+	}
+
+	//	Parse the request
+	vars := mux.Vars(req)
+
+	//	Get the version of the item the caller expects to update (if any):
+	version, err := getIfMatchVersion(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	//	Perform the action with the context user
+	dataResponse, err := service.DB.WithSource(service.getSourceIP(req)).IfMatch(version).RestoreRole(user, vars["rolename"])
+	if err != nil {
+		sendErrorResponse(rw, err, getErrorStatusCode(err, http.StatusInternalServerError))
+		return
+	}
+
+	//	Create our response and send information back:
+	response := SystemResponse{
+		Status:  http.StatusOK,
+		Message: "Role restored",
+		Data:    dataResponse,
+	}
+
+	//	Serialize to JSON & return the response (with the new version of the item):
+	setETag(rw, dataResponse.Version)
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
@@ -3,10 +3,15 @@ package api
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/danesparza/iamserver/data"
+	"github.com/danesparza/iamserver/decisionlog"
+	null "gopkg.in/guregu/null.v3"
 )
 
 // SystemResponse is a response for a system request
@@ -14,14 +19,29 @@ type SystemResponse struct {
 	Status  int         `json:"status"`
 	Message string      `json:"message"`
 	Data    interface{} `json:"data"`
+
+	//	NextCursor is the cursor for the next page of a list (if there is one)
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
 // Service encapsulates API service operations
 type Service struct {
-	DB        *data.Manager
-	StartTime time.Time
+	DB          *data.Manager
+	StartTime   time.Time
+	DecisionLog *decisionlog.Logger
+
+	//	TrustedProxies are the proxies whose X-Forwarded-For header is trusted (see ParseTrustedProxies)
+	TrustedProxies []*net.IPNet
 }
 
+const (
+	// defaultPageSize is the number of items returned by list endpoints if no limit is passed
+	defaultPageSize = 100
+
+	// maxPageSize is the largest limit that can be passed to list endpoints
+	maxPageSize = 1000
+)
+
 // ErrorResponse represents an API response
 type ErrorResponse struct {
 	Status  int    `json:"status"`
@@ -41,6 +61,248 @@ func sendErrorResponse(rw http.ResponseWriter, err error, code int) {
 	json.NewEncoder(rw).Encode(response)
 }
 
+// getErrorStatusCode returns the status code that goes with the given error (if it has one).
+// Otherwise, the default code is returned
+func getErrorStatusCode(err error, defaultCode int) int {
+	if coded, ok := err.(interface {
+		StatusCode() int
+	}); ok {
+		return coded.StatusCode()
+	}
+
+	return defaultCode
+}
+
+// setETag sets the ETag header to the given item version
+func setETag(rw http.ResponseWriter, version int64) {
+	rw.Header().Set("ETag", fmt.Sprintf("\"%d\"", version))
+}
+
+// getIfMatchVersion gets the item version from the If-Match header.  If the header
+// wasn't passed (or is '*') 0 is returned, meaning any version of the item can be updated
+func getIfMatchVersion(req *http.Request) (int64, error) {
+	header := strings.TrimSpace(req.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, nil
+	}
+
+	//	If-Match uses the strong comparison, so a weak validator never matches
+	if strings.HasPrefix(header, "W/") {
+		return 0, fmt.Errorf("If-Match header can't be a weak validator")
+	}
+
+	//	Strip the quotes:
+	header = strings.Trim(header, "\"")
+
+	version, err := strconv.ParseInt(header, 10, 64)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("If-Match header is not a valid item version")
+	}
+
+	return version, nil
+}
+
+// getGrantPeriod gets the time period of a time-bound attachment from the query parameters:  not_before and
+// expires (RFC3339 times), or expires_in (a duration like '4h', from not_before or now).  If none of them are
+// passed, zero times are returned (meaning the attachment is permanent)
+func getGrantPeriod(req *http.Request) (time.Time, time.Time, error) {
+	query := req.URL.Query()
+	notBefore, expires := time.Time{}, time.Time{}
+
+	if param := query.Get("not_before"); param != "" {
+		parsed, err := time.Parse(time.RFC3339, param)
+		if err != nil {
+			return notBefore, expires, fmt.Errorf("not_before is not a valid RFC3339 time: %s", param)
+		}
+		notBefore = parsed
+	}
+
+	if param := query.Get("expires"); param != "" {
+		parsed, err := time.Parse(time.RFC3339, param)
+		if err != nil {
+			return notBefore, expires, fmt.Errorf("expires is not a valid RFC3339 time: %s", param)
+		}
+		expires = parsed
+	}
+
+	if param := query.Get("expires_in"); param != "" {
+		duration, err := time.ParseDuration(param)
+		if err != nil || duration <= 0 || !expires.IsZero() {
+			return notBefore, expires, fmt.Errorf("expires_in should be a positive duration (and can't be passed with expires): %s", param)
+		}
+
+		start := notBefore
+		if start.IsZero() {
+			start = time.Now()
+		}
+		expires = start.Add(duration)
+	}
+
+	if !notBefore.IsZero() && expires.IsZero() {
+		return notBefore, expires, fmt.Errorf("not_before can only be passed with expires (or expires_in)")
+	}
+
+	return notBefore, expires, nil
+}
+
+// ParseTrustedProxies parses a comma separated list of proxy addresses (IP addresses or CIDR ranges)
+func ParseTrustedProxies(list string) ([]*net.IPNet, error) {
+	retval := []*net.IPNet{}
+
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		//	A single address is a range with just that address
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return retval, fmt.Errorf("Trusted proxy '%s' is not a valid IP address or CIDR range", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			retval = append(retval, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return retval, fmt.Errorf("Trusted proxy '%s' is not a valid IP address or CIDR range", entry)
+		}
+		retval = append(retval, network)
+	}
+
+	return retval, nil
+}
+
+// isTrustedProxy returns true if the address is one of the service's trusted proxies
+func (service Service) isTrustedProxy(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range service.TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getSourceIP gets the IP address of the client that made the request.  The X-Forwarded-For header is only
+// used if the request came from a trusted proxy:  the last address in it that isn't a trusted proxy is the client
+func (service Service) getSourceIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+
+	forwarded := req.Header.Get("X-Forwarded-For")
+	if forwarded == "" || !service.isTrustedProxy(host) {
+		return host
+	}
+
+	//	Walk back through the proxies (the client can put anything at the start of the header)
+	addresses := strings.Split(forwarded, ",")
+	for i := len(addresses) - 1; i >= 0; i-- {
+		address := strings.TrimSpace(addresses[i])
+		if !service.isTrustedProxy(address) {
+			return address
+		}
+		host = address
+	}
+
+	return host
+}
+
+// getIncludeDeleted gets the include_deleted query parameter (false if it isn't passed)
+func getIncludeDeleted(req *http.Request) (bool, error) {
+	param := req.URL.Query().Get("include_deleted")
+	if param == "" {
+		return false, nil
+	}
+
+	includeDeleted, err := strconv.ParseBool(param)
+	if err != nil {
+		return false, fmt.Errorf("include_deleted is not a valid boolean: %s", param)
+	}
+
+	return includeDeleted, nil
+}
+
+// getListOptions gets the paging, filtering and sorting options from the query parameters:
+// cursor, limit, include_deleted, enabled, created_after, created_before (RFC3339 times),
+// user, group, role, policy and sort (name, created or updated -- prefix with '-' to sort descending).
+// If no limit is passed, the default page size is used
+func getListOptions(req *http.Request) (data.ListOptions, error) {
+	query := req.URL.Query()
+	retval := data.ListOptions{
+		Cursor: query.Get("cursor"),
+		Limit:  defaultPageSize,
+		User:   query.Get("user"),
+		Group:  query.Get("group"),
+		Role:   query.Get("role"),
+		Policy: query.Get("policy"),
+	}
+
+	if param := query.Get("limit"); param != "" {
+		limit, err := strconv.Atoi(param)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return retval, fmt.Errorf("limit must be a number from 1 to %v", maxPageSize)
+		}
+		retval.Limit = limit
+	}
+
+	includeDeleted, err := getIncludeDeleted(req)
+	if err != nil {
+		return retval, err
+	}
+	retval.IncludeDeleted = includeDeleted
+
+	if param := query.Get("enabled"); param != "" {
+		enabled, err := strconv.ParseBool(param)
+		if err != nil {
+			return retval, fmt.Errorf("enabled is not a valid boolean: %s", param)
+		}
+		retval.Enabled = null.BoolFrom(enabled)
+	}
+
+	if param := query.Get("created_after"); param != "" {
+		createdAfter, err := time.Parse(time.RFC3339, param)
+		if err != nil {
+			return retval, fmt.Errorf("created_after is not a valid RFC3339 time: %s", param)
+		}
+		retval.CreatedAfter = createdAfter
+	}
+
+	if param := query.Get("created_before"); param != "" {
+		createdBefore, err := time.Parse(time.RFC3339, param)
+		if err != nil {
+			return retval, fmt.Errorf("created_before is not a valid RFC3339 time: %s", param)
+		}
+		retval.CreatedBefore = createdBefore
+	}
+
+	if param := query.Get("sort"); param != "" {
+		retval.Descending = strings.HasPrefix(param, "-")
+		retval.SortBy = strings.TrimPrefix(param, "-")
+
+		switch retval.SortBy {
+		case data.SortByName, data.SortByCreated, data.SortByUpdated:
+		default:
+			return retval, fmt.Errorf("Can't sort by '%s'.  Sort by %s, %s or %s", retval.SortBy, data.SortByName, data.SortByCreated, data.SortByUpdated)
+		}
+	}
+
+	return retval, nil
+}
+
 // ShowUI redirects to the /ui/ url path
 func ShowUI(rw http.ResponseWriter, req *http.Request) {
 	// http.Redirect(rw, req, "/ui/", 301)
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetIfMatchVersion_NoHeader_ReturnsAnyVersion(t *testing.T) {
+	//	Arrange
+	req := httptest.NewRequest("PUT", "/system/role/test", nil)
+
+	//	Act
+	version, err := getIfMatchVersion(req)
+
+	//	Assert
+	if err != nil || version != 0 {
+		t.Errorf("getIfMatchVersion should return version 0 without an error, but got %v / %v", version, err)
+	}
+}
+
+func TestGetIfMatchVersion_ValidHeader_ReturnsVersion(t *testing.T) {
+	//	Arrange
+	headers := map[string]int64{
+		`"12"`: 12,
+		`"1"`:  1,
+		`*`:    0,
+	}
+
+	for header, expected := range headers {
+		req := httptest.NewRequest("PUT", "/system/role/test", nil)
+		req.Header.Set("If-Match", header)
+
+		//	Act
+		version, err := getIfMatchVersion(req)
+
+		//	Assert
+		if err != nil || version != expected {
+			t.Errorf("getIfMatchVersion for %s should return %v without an error, but got %v / %v", header, expected, version, err)
+		}
+	}
+}
+
+func TestGetIfMatchVersion_InvalidHeader_ReturnsError(t *testing.T) {
+	for _, header := range []string{`"abc"`, `W/"12"`, `"0"`} {
+		//	Arrange
+		req := httptest.NewRequest("PUT", "/system/role/test", nil)
+		req.Header.Set("If-Match", header)
+
+		//	Act
+		_, err := getIfMatchVersion(req)
+
+		//	Assert
+		if err == nil {
+			t.Errorf("getIfMatchVersion should return an error for the invalid header %s", header)
+		}
+	}
+}
+
+func TestGetListOptions_ValidQuery_ReturnsOptions(t *testing.T) {
+	//	Arrange
+	req := httptest.NewRequest("GET", "/system/users?limit=10&cursor=abc&enabled=false&group=admins&sort=-created&created_after=2018-01-02T00:00:00Z", nil)
+
+	//	Act
+	options, err := getListOptions(req)
+
+	//	Assert
+	if err != nil {
+		t.Fatalf("getListOptions should parse the query without an error, but got %s", err)
+	}
+
+	if options.Limit != 10 || options.Cursor != "abc" || options.Group != "admins" {
+		t.Errorf("getListOptions returned unexpected paging / membership options: %+v", options)
+	}
+
+	if !options.Enabled.Valid || options.Enabled.Bool {
+		t.Errorf("getListOptions should filter on disabled items: %+v", options)
+	}
+
+	if options.SortBy != "created" || !options.Descending || options.CreatedAfter.Year() != 2018 {
+		t.Errorf("getListOptions returned unexpected sort / created options: %+v", options)
+	}
+}
+
+func TestGetListOptions_InvalidQuery_ReturnsError(t *testing.T) {
+	//	Arrange
+	queries := []string{"limit=0", "limit=100000", "sort=secrethash", "enabled=maybe", "created_before=yesterday"}
+
+	for _, query := range queries {
+		req := httptest.NewRequest("GET", "/system/users?"+query, nil)
+
+		//	Act
+		_, err := getListOptions(req)
+
+		//	Assert
+		if err == nil {
+			t.Errorf("getListOptions should return an error for %s", query)
+		}
+	}
+}
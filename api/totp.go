@@ -42,7 +42,7 @@ func (service Service) BeginTOTPEnrollment(rw http.ResponseWriter, req *http.Req
 	}
 
 	//	Perform the action with the context user
-	_, err = service.DB.BeginTOTPEnrollment(user.Name, 1*time.Hour)
+	_, err = service.DB.WithSource(service.getSourceIP(req)).BeginTOTPEnrollment(user.Name, 1*time.Hour)
 	if err != nil {
 		sendErrorResponse(rw, err, http.StatusInternalServerError)
 		return
@@ -141,7 +141,7 @@ func (service Service) FinishTOTPEnrollment(rw http.ResponseWriter, req *http.Re
 	}
 
 	//	Perform the action with the context user
-	_, err = service.DB.FinishTOTPEnrollment(user.Name, request.PassCode)
+	_, err = service.DB.WithSource(service.getSourceIP(req)).FinishTOTPEnrollment(user.Name, request.PassCode)
 	if err != nil {
 		sendErrorResponse(rw, err, http.StatusInternalServerError)
 		return
@@ -50,7 +50,7 @@ func (service Service) AddUser(rw http.ResponseWriter, req *http.Request) {
 	}
 
 	//	Perform the action with the context user
-	dataResponse, err := service.DB.AddUser(user, request.User, request.Password)
+	dataResponse, err := service.DB.WithSource(service.getSourceIP(req)).AddUser(user, request.User, request.Password)
 	if err != nil {
 		sendErrorResponse(rw, err, http.StatusInternalServerError)
 		return
@@ -68,7 +68,7 @@ func (service Service) AddUser(rw http.ResponseWriter, req *http.Request) {
 	json.NewEncoder(rw).Encode(response)
 }
 
-// GetUser gets a user.  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
+// GetUser gets a user (a deleted user is only returned with include_deleted=true).  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
 func (service Service) GetUser(rw http.ResponseWriter, req *http.Request) {
 
 	//	req.Body is a ReadCloser -- we need to remember to close it:
@@ -95,9 +95,14 @@ func (service Service) GetUser(rw http.ResponseWriter, req *http.Request) {
 
 	//	Parse the request
 	vars := mux.Vars(req)
+	includeDeleted, err := getIncludeDeleted(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
 
 	//	Perform the action with the context user
-	dataResponse, err := service.DB.GetUser(user, vars["username"])
+	dataResponse, err := service.DB.WithDeleted(includeDeleted).GetUser(user, vars["username"])
 	if err != nil {
 		sendErrorResponse(rw, err, http.StatusNotFound)
 		return
@@ -110,7 +115,8 @@ func (service Service) GetUser(rw http.ResponseWriter, req *http.Request) {
 		Data:    dataResponse,
 	}
 
-	//	Serialize to JSON & return the response:
+	//	Serialize to JSON & return the response (with the version of the item):
+	setETag(rw, dataResponse.Version)
 	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
 	json.NewEncoder(rw).Encode(response)
 }
@@ -140,8 +146,15 @@ func (service Service) GetAllUsers(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	//	Get the paging, filtering and sorting options:
+	options, err := getListOptions(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
 	//	Perform the action with the context user
-	dataResponse, err := service.DB.GetAllUsers(user)
+	dataResponse, nextCursor, err := service.DB.GetAllUsers(user, options)
 	if err != nil {
 		sendErrorResponse(rw, err, http.StatusInternalServerError)
 		return
@@ -152,9 +165,181 @@ func (service Service) GetAllUsers(rw http.ResponseWriter, req *http.Request) {
 		Status:  http.StatusOK,
 		Message: "Users fetched",
 		Data:    dataResponse,
+
+		NextCursor: nextCursor,
 	}
 
 	//	Serialize to JSON & return the response:
 	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
 	json.NewEncoder(rw).Encode(response)
 }
+
+// DeleteUser deletes a user (and removes it from any related items).  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
+func (service Service) DeleteUser(rw http.ResponseWriter, req *http.Request) {
+
+	//	req.Body is a ReadCloser -- we need to remember to close it:
+	defer req.Body.Close()
+
+	//	Get the authorization header:
+	authHeader := req.Header.Get("Authorization")
+
+	//	If the auth header wasn't supplied, return an error
+	if authHeaderValid(authHeader) != true {
+		sendErrorResponse(rw, fmt.Errorf("Bearer token was not supplied"), http.StatusForbidden)
+		return
+	}
+
+	//	Get just the bearer token itself:
+	token := getBearerTokenFromAuthHeader(authHeader)
+
+	//	Get the user from the token:
+	user, err := service.DB.GetUserForToken(token)
+	if err != nil {
+		sendErrorResponse(rw, fmt.Errorf("Token not authorized or not valid"), http.StatusUnauthorized)
+		return
+	}
+
+	//	Parse the request
+	vars := mux.Vars(req)
+
+	//	Get the version of the item the caller expects to update (if any):
+	version, err := getIfMatchVersion(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	//	Perform the action with the context user
+	dataResponse, err := service.DB.WithSource(service.getSourceIP(req)).IfMatch(version).DeleteUser(user, vars["username"])
+	if err != nil {
+		sendErrorResponse(rw, err, getErrorStatusCode(err, http.StatusInternalServerError))
+		return
+	}
+
+	//	Create our response and send information back:
+	response := SystemResponse{
+		Status:  http.StatusOK,
+		Message: "User deleted",
+		Data:    dataResponse,
+	}
+
+	//	Serialize to JSON & return the response:
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
+
+// RestoreUser restores a deleted user (and adds it back to the items it was removed from).  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
+func (service Service) RestoreUser(rw http.ResponseWriter, req *http.Request) {
+
+	//	req.Body is a ReadCloser -- we need to remember to close it:
+	defer req.Body.Close()
+
+	//	Get the authorization header:
+	authHeader := req.Header.Get("Authorization")
+
+	//	If the auth header wasn't supplied, return an error
+	if authHeaderValid(authHeader) != true {
+		sendErrorResponse(rw, fmt.Errorf("Bearer token was not supplied"), http.StatusForbidden)
+		return
+	}
+
+	//	Get just the bearer token itself:
+	token := getBearerTokenFromAuthHeader(authHeader)
+
+	//	Get the user from the token:
+	user, err := service.DB.GetUserForToken(token)
+	if err != nil {
+		sendErrorResponse(rw, fmt.Errorf("Token not authorized or not valid"), http.StatusUnauthorized)
+		return
+	}
+
+	//	Parse the request
+	vars := mux.Vars(req)
+
+	//	Get the version of the item the caller expects to update (if any):
+	version, err := getIfMatchVersion(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	//	Perform the action with the context user
+	dataResponse, err := service.DB.WithSource(service.getSourceIP(req)).IfMatch(version).RestoreUser(user, vars["username"])
+	if err != nil {
+		sendErrorResponse(rw, err, getErrorStatusCode(err, http.StatusInternalServerError))
+		return
+	}
+
+	//	Create our response and send information back:
+	response := SystemResponse{
+		Status:  http.StatusOK,
+		Message: "User restored",
+		Data:    dataResponse,
+	}
+
+	//	Serialize to JSON & return the response (with the new version of the item):
+	setETag(rw, dataResponse.Version)
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
+
+// SetUserAttributes sets the custom attributes of a user (from the JSON object in the request body).  If the bearer token is not authorized for the operation, StatusUnauthorized is returned
+func (service Service) SetUserAttributes(rw http.ResponseWriter, req *http.Request) {
+
+	//	req.Body is a ReadCloser -- we need to remember to close it:
+	defer req.Body.Close()
+
+	//	Get the authorization header:
+	authHeader := req.Header.Get("Authorization")
+
+	//	If the auth header wasn't supplied, return an error
+	if authHeaderValid(authHeader) != true {
+		sendErrorResponse(rw, fmt.Errorf("Bearer token was not supplied"), http.StatusForbidden)
+		return
+	}
+
+	//	Get just the bearer token itself:
+	token := getBearerTokenFromAuthHeader(authHeader)
+
+	//	Get the user from the token:
+	user, err := service.DB.GetUserForToken(token)
+	if err != nil {
+		sendErrorResponse(rw, fmt.Errorf("Token not authorized or not valid"), http.StatusUnauthorized)
+		return
+	}
+
+	//	Parse the request
+	vars := mux.Vars(req)
+	attributes := map[string]string{}
+	err = json.NewDecoder(req.Body).Decode(&attributes)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	//	Get the version of the item the caller expects to update (if any):
+	version, err := getIfMatchVersion(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	//	Perform the action with the context user
+	dataResponse, err := service.DB.WithSource(service.getSourceIP(req)).IfMatch(version).SetUserAttributes(user, vars["username"], attributes)
+	if err != nil {
+		sendErrorResponse(rw, err, getErrorStatusCode(err, http.StatusInternalServerError))
+		return
+	}
+
+	//	Create our response and send information back:
+	response := SystemResponse{
+		Status:  http.StatusOK,
+		Message: "User attributes set",
+		Data:    dataResponse,
+	}
+
+	//	Serialize to JSON & return the response (with the new version of the item):
+	setETag(rw, dataResponse.Version)
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/danesparza/iamserver/data"
+)
+
+// auditCmd represents the audit command
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Verify or export the audit trail",
+	Long: `Audit events are hash-chained:  each event commits to the event before it.  
+
+To check that no events have been changed or removed, use 'audit verify'.
+To export a signed bundle of audit events for auditors, use 'audit export'`,
+}
+
+var (
+	verifyBundleFile string
+	verifyPublicKey  string
+)
+
+// auditVerifyCmd represents the audit verify command
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verifies the audit chain",
+	Long: `Walks the audit chain in the system database and reports the first 
+broken link (an event that was changed, removed or inserted).
+
+The server must be stopped first, because the system database can only be opened once.
+
+To verify an exported bundle instead, pass the --bundle file and the --pubkey 
+the bundle should be signed with (the public key printed by 'audit export')`,
+	Run: func(cmd *cobra.Command, args []string) {
+		//	If we're verifying a bundle, we don't need the database
+		if verifyBundleFile != "" {
+			verifyBundle(verifyBundleFile, verifyPublicKey)
+			return
+		}
+
+		//	Spin up a Manager
+		db, err := data.NewManager(viper.GetString("datastore.system"), viper.GetString("datastore.tokens"))
+		if err != nil {
+			log.Fatalf("[ERROR] Error trying to open the system database: %s", err)
+		}
+		defer db.Close()
+
+		//	Verify the chain
+		result, err := db.VerifyAuditLog(data.SystemUser)
+		if err != nil {
+			db.Close()
+			log.Fatalf("[ERROR] %s (%v events verified before the broken link)", err, result.Events)
+		}
+
+		fmt.Printf("The audit chain is intact: %v events verified (%v events recorded before the chain existed).  Head: %s\n", result.Events, result.Unchained, result.Head)
+	},
+}
+
+// verifyBundle checks the bundle file was signed with the expected public key (base64 encoded, or a file
+// with the base64 encoded key) and checks the audit chain in the bundle
+func verifyBundle(bundleFile, expectedKey string) {
+	if expectedKey == "" {
+		log.Fatalf("[ERROR] The --pubkey the bundle should be signed with is required")
+	}
+
+	//	The key can be passed directly, or in a file
+	if contents, err := ioutil.ReadFile(expectedKey); err == nil {
+		expectedKey = strings.TrimSpace(string(contents))
+	} else if !os.IsNotExist(err) {
+		log.Fatalf("[ERROR] Error trying to read the public key file: %s", err)
+	}
+
+	publicKey, err := data.ParsePublicKey(expectedKey)
+	if err != nil {
+		log.Fatalf("[ERROR] %s", err)
+	}
+
+	contents, err := ioutil.ReadFile(bundleFile)
+	if err != nil {
+		log.Fatalf("[ERROR] Error trying to read the audit bundle: %s", err)
+	}
+
+	bundle := data.AuditBundle{}
+	if err := json.Unmarshal(contents, &bundle); err != nil {
+		log.Fatalf("[ERROR] Error trying to parse the audit bundle: %s", err)
+	}
+
+	result, err := bundle.Verify(publicKey)
+	if _, broken := err.(data.AuditChainError); broken {
+		log.Fatalf("[ERROR] %s (%v events verified before the broken link)", err, result.Events)
+	}
+	if err != nil {
+		log.Fatalf("[ERROR] %s", err)
+	}
+
+	fmt.Printf("The audit bundle is intact: %v events verified (signed with the expected key).  Head: %s\n", result.Events, result.Head)
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+	auditCmd.AddCommand(auditVerifyCmd)
+
+	auditVerifyCmd.Flags().StringVarP(&verifyBundleFile, "bundle", "b", "", "Exported bundle to verify (instead of the system database)")
+	auditVerifyCmd.Flags().StringVar(&verifyPublicKey, "pubkey", "", "Public key the bundle should be signed with (base64, or a file with it)")
+}
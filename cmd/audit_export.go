@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ed25519"
+
+	"github.com/danesparza/iamserver/data"
+)
+
+var (
+	exportKeyFile       string
+	exportOutputFile    string
+	exportPublicKeyFile string
+	exportSince         string
+	exportUntil         string
+)
+
+// auditExportCmd represents the audit export command
+var auditExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Exports a signed bundle of audit events",
+	Long: `Exports the audit events (optionally in a time range) as a JSON bundle 
+signed with an ed25519 key, so auditors can check the signature and the hash 
+chain of the exported events.
+
+The signing key is read from the --key file.  If the file doesn't exist, a new 
+key is created and saved there.  The public key is logged (and written to the 
+--pubkey-output file, if it's passed):  give it to auditors separately from the 
+bundle, so they can pin it with 'audit verify --bundle <file> --pubkey <key>'`,
+	Run: func(cmd *cobra.Command, args []string) {
+		//	Parse the time range
+		query := data.AuditQuery{}
+		var err error
+		if exportSince != "" {
+			if query.Since, err = time.Parse(time.RFC3339, exportSince); err != nil {
+				log.Fatalf("[ERROR] --since should be an RFC3339 time: %s", err)
+			}
+		}
+		if exportUntil != "" {
+			if query.Until, err = time.Parse(time.RFC3339, exportUntil); err != nil {
+				log.Fatalf("[ERROR] --until should be an RFC3339 time: %s", err)
+			}
+		}
+
+		//	Get the signing key
+		privateKey, err := getSigningKey(exportKeyFile)
+		if err != nil {
+			log.Fatalf("[ERROR] Error trying to get the signing key: %s", err)
+		}
+
+		//	Spin up a Manager and get the events
+		db, err := data.NewManager(viper.GetString("datastore.system"), viper.GetString("datastore.tokens"))
+		if err != nil {
+			log.Fatalf("[ERROR] Error trying to open the system database: %s", err)
+		}
+		events, _, err := db.GetAuditLog(data.SystemUser, query)
+		db.Close()
+		if err != nil {
+			log.Fatalf("[ERROR] Error trying to get the audit log: %s", err)
+		}
+
+		//	Create and sign the bundle
+		bundle, err := data.NewAuditBundle(events, privateKey)
+		if err != nil {
+			log.Fatalf("[ERROR] Error trying to sign the audit bundle: %s", err)
+		}
+
+		//	Give out the public key (separately from the bundle)
+		fmt.Fprintf(os.Stderr, "Public key: %s\n", bundle.PublicKey)
+		if exportPublicKeyFile != "" {
+			if err := ioutil.WriteFile(exportPublicKeyFile, []byte(bundle.PublicKey+"\n"), 0644); err != nil {
+				log.Fatalf("[ERROR] Error trying to write the public key: %s", err)
+			}
+		}
+
+		encoded, err := json.MarshalIndent(bundle, "", "  ")
+		if err != nil {
+			log.Fatalf("[ERROR] Error trying to serialize the audit bundle: %s", err)
+		}
+
+		//	Write the bundle to the output file (or stdout)
+		if exportOutputFile == "" {
+			fmt.Println(string(encoded))
+			return
+		}
+
+		if err := ioutil.WriteFile(exportOutputFile, encoded, 0644); err != nil {
+			log.Fatalf("[ERROR] Error trying to write the audit bundle: %s", err)
+		}
+
+		log.Printf("[INFO] Exported %v audit events to %s", len(events), exportOutputFile)
+	},
+}
+
+// getSigningKey reads the ed25519 key (a base64 encoded seed) from the key file.
+// If the key file doesn't exist, a new key is created and saved to the file
+func getSigningKey(keyFile string) (ed25519.PrivateKey, error) {
+	if keyFile == "" {
+		return nil, fmt.Errorf("The --key file is required")
+	}
+
+	contents, err := ioutil.ReadFile(keyFile)
+	if os.IsNotExist(err) {
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+
+		seed := base64.StdEncoding.EncodeToString(privateKey.Seed())
+		if err := ioutil.WriteFile(keyFile, []byte(seed+"\n"), 0600); err != nil {
+			return nil, err
+		}
+
+		log.Printf("[INFO] Created a new signing key: %s", keyFile)
+		return privateKey, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(contents)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("The key file should contain a base64 encoded ed25519 seed")
+	}
+
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+func init() {
+	auditCmd.AddCommand(auditExportCmd)
+
+	auditExportCmd.Flags().StringVarP(&exportKeyFile, "key", "k", "", "File with the signing key (created if it doesn't exist)")
+	auditExportCmd.Flags().StringVarP(&exportOutputFile, "output", "o", "", "File to write the bundle to (default is stdout)")
+	auditExportCmd.Flags().StringVar(&exportPublicKeyFile, "pubkey-output", "", "File to write the public key to (for auditors to pin)")
+	auditExportCmd.Flags().StringVar(&exportSince, "since", "", "Only export events at or after this (RFC3339) time")
+	auditExportCmd.Flags().StringVar(&exportUntil, "until", "", "Only export events at or before this (RFC3339) time")
+}
@@ -16,9 +16,25 @@ apiservice:
   port: 3001
   tlscert: cert.pem
   tlskey: key.pem
+  trustedproxies: ""
 datastore:
   system: ./db/system
   tokens: ./db/token
+  retention: 168
+decisionlog:
+  sinks: file
+  file: ./decisions.log
+  maxsize: 100
+  maxbackups: 5
+  allowsamplerate: 1
+  denysamplerate: 1
+webhooks:
+  interval: 5
+  maxattempts: 10
+# Seconds between grant sweeps.  The start and expiry of a grant are recorded (and
+# sent to webhooks and change streams) by the next sweep:  up to this long afterwards
+grants:
+  interval: 60
 `)
 
 // configcreateCmd represents the configcreate command
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"log"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/danesparza/iamserver/data"
+)
+
+var importPolicyName string
+
+// policyCmd represents the policy command
+var policyCmd = &cobra.Command{
+	Use:   "policy",
+	Short: "Import, export, lint or test policies",
+	Long: `Policies can be imported from (and exported to) AWS IAM policy documents.
+
+To add a policy from an AWS IAM policy document, use 'policy import'.
+To get a policy as an AWS IAM policy document, use 'policy export'.
+To check the policies for problems, use 'policy lint'.
+To run policy unit tests, use 'policy test'`,
+}
+
+// policyImportCmd represents the policy import command
+var policyImportCmd = &cobra.Command{
+	Use:   "import [file]",
+	Short: "Adds a policy from an AWS IAM policy document",
+	Long: `Adds a policy from an AWS IAM policy document (a JSON file with 'Version' 
+and 'Statement').  The policy is named with --name (or the 'Id' of the document).
+
+Anything in the document that isn't supported (like 'Principal', or condition 
+operators other than StringEquals, StringNotEquals, StringLike and StringNotLike)
+is reported, and the policy isn't added.
+
+The server must be stopped first, because the system database can only be opened once`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		//	Read and convert the document
+		document, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			log.Fatalf("[ERROR] Error trying to read the policy document: %s", err)
+		}
+
+		newPolicy, err := data.ImportAWSPolicy(importPolicyName, document)
+		if err != nil {
+			log.Fatalf("[ERROR] %s", err)
+		}
+
+		//	Spin up a Manager and add the policy
+		db, err := data.NewManager(viper.GetString("datastore.system"), viper.GetString("datastore.tokens"))
+		if err != nil {
+			log.Fatalf("[ERROR] Error trying to open the system database: %s", err)
+		}
+		added, err := db.AddPolicy(data.SystemUser, newPolicy)
+		db.Close()
+		if err != nil {
+			log.Fatalf("[ERROR] Error trying to add the policy: %s", err)
+		}
+
+		log.Printf("[INFO] Imported policy '%s' with %v statements", added.Name, len(added.Statements))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(policyCmd)
+	policyCmd.AddCommand(policyImportCmd)
+
+	policyImportCmd.Flags().StringVarP(&importPolicyName, "name", "n", "", "Name of the policy (default is the 'Id' of the document)")
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/danesparza/iamserver/data"
+)
+
+var exportPolicyOutputFile string
+
+// policyExportCmd represents the policy export command
+var policyExportCmd = &cobra.Command{
+	Use:   "export [policy name]",
+	Short: "Exports a policy as an AWS IAM policy document",
+	Long: `Exports a policy as an AWS IAM policy document.  
+
+Anything in the policy that has no AWS equivalent (like regexp templates, or 
+the ${user.groups} policy variable) is reported, and nothing is exported.
+
+The server must be stopped first, because the system database can only be opened once`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		//	Spin up a Manager and get the policy
+		db, err := data.NewManager(viper.GetString("datastore.system"), viper.GetString("datastore.tokens"))
+		if err != nil {
+			log.Fatalf("[ERROR] Error trying to open the system database: %s", err)
+		}
+		existing, err := db.GetPolicy(data.SystemUser, args[0])
+		db.Close()
+		if err != nil {
+			log.Fatalf("[ERROR] Error trying to get the policy: %s", err)
+		}
+
+		//	Convert the policy
+		document, err := data.ExportAWSPolicy(existing)
+		if err != nil {
+			log.Fatalf("[ERROR] %s", err)
+		}
+
+		encoded, err := json.MarshalIndent(document, "", "  ")
+		if err != nil {
+			log.Fatalf("[ERROR] Error trying to serialize the policy document: %s", err)
+		}
+
+		//	Write the document to the output file (or stdout)
+		if exportPolicyOutputFile == "" {
+			fmt.Println(string(encoded))
+			return
+		}
+
+		if err := ioutil.WriteFile(exportPolicyOutputFile, encoded, 0644); err != nil {
+			log.Fatalf("[ERROR] Error trying to write the policy document: %s", err)
+		}
+
+		log.Printf("[INFO] Exported policy '%s' to %s", existing.Name, exportPolicyOutputFile)
+	},
+}
+
+func init() {
+	policyCmd.AddCommand(policyExportCmd)
+
+	policyExportCmd.Flags().StringVarP(&exportPolicyOutputFile, "output", "o", "", "File to write the document to (default is stdout)")
+}
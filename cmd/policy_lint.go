@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/danesparza/iamserver/data"
+)
+
+var (
+	lintFormat     string
+	lintFailOn     string
+	lintLargeGroup int
+)
+
+// policyLintCmd represents the policy lint command
+var policyLintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Checks the policies for problems",
+	Long: `Checks the policies in the system for:
+- Statements that don't match any registered resource and action (no-match)
+- Allows that are always denied for the users they apply to (shadowed)
+- Policies with the same statements as another policy (duplicate)
+- Patterns that match everything, granted to large groups (over-broad)
+
+Each finding has a severity (error, warning or info).  The command exits with 
+status 1 if there are findings at (or above) the --fail-on severity, so it can
+be used in a change pipeline.  Use '--format json' for machine-readable output.
+
+The server must be stopped first, because the system database can only be opened once`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if lintFormat != "text" && lintFormat != "json" {
+			log.Fatalf("[ERROR] --format should be 'text' or 'json'")
+		}
+		if lintFailOn != data.LintError && lintFailOn != data.LintWarning && lintFailOn != data.LintInfo && lintFailOn != "none" {
+			log.Fatalf("[ERROR] --fail-on should be 'error', 'warning', 'info' or 'none'")
+		}
+
+		//	Spin up a Manager and lint the policies
+		db, err := data.NewManager(viper.GetString("datastore.system"), viper.GetString("datastore.tokens"))
+		if err != nil {
+			log.Fatalf("[ERROR] Error trying to open the system database: %s", err)
+		}
+		report, err := db.LintPolicies(data.SystemUser, data.LintOptions{LargeGroupSize: lintLargeGroup})
+		db.Close()
+		if err != nil {
+			log.Fatalf("[ERROR] Error trying to lint the policies: %s", err)
+		}
+
+		//	Report the findings
+		if lintFormat == "json" {
+			encoded, err := json.MarshalIndent(report, "", "  ")
+			if err != nil {
+				log.Fatalf("[ERROR] Error trying to serialize the lint report: %s", err)
+			}
+			fmt.Println(string(encoded))
+		} else {
+			for _, finding := range report.Findings {
+				fmt.Printf("%-8s %-11s %s: %s\n", finding.Severity, finding.Rule, finding.Policy, finding.Message)
+			}
+			fmt.Printf("%v errors, %v warnings, %v infos\n", report.Errors, report.Warnings, report.Infos)
+		}
+
+		if report.HasFindings(lintFailOn) {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	policyCmd.AddCommand(policyLintCmd)
+
+	policyLintCmd.Flags().StringVarP(&lintFormat, "format", "f", "text", "Output format: text/json")
+	policyLintCmd.Flags().StringVar(&lintFailOn, "fail-on", data.LintError, "Exit with status 1 if there are findings at (or above) this severity: error/warning/info/none")
+	policyLintCmd.Flags().IntVar(&lintLargeGroup, "large-group", 0, "Number of users a group needs to be a large group (default is 10)")
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/danesparza/iamserver/data"
+)
+
+// policyTestCmd represents the policy test command
+var policyTestCmd = &cobra.Command{
+	Use:   "test [dir]",
+	Short: "Runs policy unit tests",
+	Long: `Runs the policy test suites (.yaml or .yml files) in the directory.
+
+Each suite has fixtures (users, groups, roles, policies and resources) that are 
+loaded into a temporary store, and assertions to check against them:
+
+  tests:
+    - alice CAN orders:Read on orders/42
+    - bob CANNOT System:AddUser
+
+The command exits with status 1 if any of the assertions fail (or a suite can't 
+be loaded).  The system database isn't used, so the server doesn't have to be stopped`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		//	Find the suites
+		files := []string{}
+		err := filepath.Walk(args[0], func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if ext := strings.ToLower(filepath.Ext(path)); !info.IsDir() && (ext == ".yaml" || ext == ".yml") {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("[ERROR] Error trying to find the policy test suites: %s", err)
+		}
+		if len(files) == 0 {
+			log.Fatalf("[ERROR] No policy test suites (.yaml or .yml files) found in %s", args[0])
+		}
+
+		//	Run each suite and report the results
+		passed, failed, broken := 0, 0, 0
+		for _, file := range files {
+			result, err := runPolicyTestFile(file)
+			if err != nil {
+				fmt.Printf("%s\n  ERROR %s\n", file, err)
+				broken++
+				continue
+			}
+
+			fmt.Println(file)
+			for _, test := range result.Results {
+				if test.Passed {
+					fmt.Printf("  PASS  %s\n", describePolicyTest(test))
+					continue
+				}
+				fmt.Printf("  FAIL  %s\n        %s\n", describePolicyTest(test), test.Reason)
+			}
+
+			passed += result.Passed
+			failed += result.Failed
+		}
+
+		fmt.Printf("\n%v passed, %v failed", passed, failed)
+		if broken > 0 {
+			fmt.Printf(", %v suites couldn't be loaded", broken)
+		}
+		fmt.Println()
+
+		if failed > 0 || broken > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// runPolicyTestFile reads and runs the policy test suite in the file
+func runPolicyTestFile(file string) (data.PolicyTestSuiteResult, error) {
+	document, err := ioutil.ReadFile(file)
+	if err != nil {
+		return data.PolicyTestSuiteResult{}, err
+	}
+
+	suite, err := data.ParsePolicyTestSuite(file, document)
+	if err != nil {
+		return data.PolicyTestSuiteResult{}, err
+	}
+
+	return data.RunPolicyTestSuite(suite)
+}
+
+// describePolicyTest gets the assertion of the test (with its context, if it has one)
+func describePolicyTest(test data.PolicyTestResult) string {
+	if len(test.Context) == 0 {
+		return test.Assert
+	}
+
+	return fmt.Sprintf("%s (with context %v)", test.Assert, test.Context)
+}
+
+func init() {
+	policyCmd.AddCommand(policyTestCmd)
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/danesparza/iamserver/data"
+)
+
+var (
+	whoCanFormat  string
+	whoCanContext []string
+)
+
+// policyWhoCanCmd represents the policy who-can command
+var policyWhoCanCmd = &cobra.Command{
+	Use:   "who-can [resource] [action]",
+	Short: "Lists the users that are allowed to perform an action on a resource",
+	Long: `Lists the users that are allowed to perform an action on a resource, along 
+with the path each allowing policy is attached to the user by:
+- direct: The policy is attached to the user
+- group: The policy is attached to a group the user is in
+- role: The policy is attached to a role the user has
+- group→role: The policy is attached to a role of a group the user is in
+  (group_role in the JSON output)
+
+Users that would be allowed, but are denied by an explicit deny, are listed 
+separately (with the deny that overrides the allow).  Use '--format json' for 
+machine-readable output.
+
+The server must be stopped first, because the system database can only be opened once`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if whoCanFormat != "text" && whoCanFormat != "json" {
+			log.Fatalf("[ERROR] --format should be 'text' or 'json'")
+		}
+
+		//	Parse the context of the request
+		request := data.Request{Resource: args[0], Action: args[1], Context: map[string]string{}}
+		for _, pair := range whoCanContext {
+			parts := strings.SplitN(pair, "=", 2)
+			if len(parts) != 2 {
+				log.Fatalf("[ERROR] --context should be a key=value pair, but got '%s'", pair)
+			}
+			request.Context[parts[0]] = parts[1]
+		}
+
+		//	Spin up a Manager and find the users
+		db, err := data.NewManager(viper.GetString("datastore.system"), viper.GetString("datastore.tokens"))
+		if err != nil {
+			log.Fatalf("[ERROR] Error trying to open the system database: %s", err)
+		}
+		result, err := db.WhoCan(data.SystemUser, request)
+		db.Close()
+		if err != nil {
+			log.Fatalf("[ERROR] Error trying to find the users: %s", err)
+		}
+
+		//	Report the users
+		if whoCanFormat == "json" {
+			encoded, err := json.MarshalIndent(result, "", "  ")
+			if err != nil {
+				log.Fatalf("[ERROR] Error trying to serialize the users: %s", err)
+			}
+			fmt.Println(string(encoded))
+			return
+		}
+
+		for _, user := range result.Allowed {
+			fmt.Printf("ALLOWED %s\n", user.User)
+			printAccessGrants("allowed by", user.Grants)
+		}
+		for _, user := range result.Denied {
+			fmt.Printf("DENIED  %s\n", user.User)
+			printAccessGrants("allowed by", user.Grants)
+			printAccessGrants("denied by", user.Denies)
+		}
+		fmt.Printf("%v users allowed, %v denied by an explicit deny\n", len(result.Allowed), len(result.Denied))
+	},
+}
+
+// printAccessGrants prints each of the grants (and the path of the grant)
+func printAccessGrants(label string, grants []data.AccessGrant) {
+	for _, grant := range grants {
+		path := []string{grant.Path}
+		if grant.Path == data.GrantGroupRole {
+			path[0] = "group→role"
+		}
+		if grant.Group != "" {
+			path = append(path, "group '"+grant.Group+"'")
+		}
+		if grant.Role != "" {
+			path = append(path, "role '"+grant.Role+"'")
+		}
+		fmt.Printf("  %s '%s' (statement %s) via %s\n", label, grant.Policy, grant.Statement, strings.Join(path, ", "))
+	}
+}
+
+func init() {
+	policyCmd.AddCommand(policyWhoCanCmd)
+
+	policyWhoCanCmd.Flags().StringVarP(&whoCanFormat, "format", "f", "text", "Output format: text/json")
+	policyWhoCanCmd.Flags().StringSliceVar(&whoCanContext, "context", nil, "Context of the request (key=value pairs)")
+}
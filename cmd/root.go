@@ -74,12 +74,23 @@ func initConfig() {
 	viper.SetDefault("apiservice.port", "3000")
 	viper.SetDefault("uiservice.port", "3001")
 	viper.SetDefault("apiservice.tokenttl", "60")
+	viper.SetDefault("apiservice.trustedproxies", "")
 	viper.SetDefault("apiservice.tlscert", path.Join(home, "iamserver", "cert.pem"))
 	viper.SetDefault("apiservice.tlskey", path.Join(home, "iamserver", "key.pem"))
 	viper.SetDefault("uiservice.tlscert", path.Join(home, "iamserver", "cert.pem"))
 	viper.SetDefault("uiservice.tlskey", path.Join(home, "iamserver", "key.pem"))
 	viper.SetDefault("datastore.system", path.Join(home, "iamserver", "db", "system"))
 	viper.SetDefault("datastore.tokens", path.Join(home, "iamserver", "db", "token"))
+	viper.SetDefault("datastore.retention", "168")
+	viper.SetDefault("decisionlog.sinks", "")
+	viper.SetDefault("decisionlog.file", path.Join(home, "iamserver", "decisions.log"))
+	viper.SetDefault("decisionlog.maxsize", "100")
+	viper.SetDefault("decisionlog.maxbackups", "5")
+	viper.SetDefault("decisionlog.allowsamplerate", "1")
+	viper.SetDefault("decisionlog.denysamplerate", "1")
+	viper.SetDefault("webhooks.interval", "5")
+	viper.SetDefault("webhooks.maxattempts", "10")
+	viper.SetDefault("grants.interval", "60")
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err != nil {
@@ -7,11 +7,13 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/danesparza/iamserver/api"
 	"github.com/danesparza/iamserver/data"
+	"github.com/danesparza/iamserver/decisionlog"
 	"github.com/gorilla/mux"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -97,7 +99,54 @@ func start(cmd *cobra.Command, args []string) {
 		return
 	}
 	defer db.Close()
-	apiService := api.Service{DB: db, StartTime: time.Now()}
+
+	//	Set how long deleted items are kept around:
+	retentionstring := viper.GetString("datastore.retention")
+	retention, err := strconv.Atoi(retentionstring)
+	if err != nil {
+		log.Fatalf("[ERROR] The datastore.retention config is invalid: %s", err)
+	}
+	db.DeletedRetention = time.Duration(retention) * time.Hour
+	log.Printf("[INFO] Deleted item retention: %s hours", retentionstring)
+
+	//	Set up the authorization decision log:
+	decisionLog, err := getDecisionLogger()
+	if err != nil {
+		log.Fatalf("[ERROR] The decisionlog config is invalid: %s", err)
+	}
+	defer decisionLog.Close()
+
+	//	Set the proxies we trust the X-Forwarded-For header of:
+	trustedProxies, err := api.ParseTrustedProxies(viper.GetString("apiservice.trustedproxies"))
+	if err != nil {
+		log.Fatalf("[ERROR] The apiservice.trustedproxies config is invalid: %s", err)
+	}
+
+	apiService := api.Service{DB: db, StartTime: time.Now(), DecisionLog: decisionLog, TrustedProxies: trustedProxies}
+
+	//	Start delivering webhooks from the outbox:
+	webhookinterval, err := strconv.Atoi(viper.GetString("webhooks.interval"))
+	if err != nil {
+		log.Fatalf("[ERROR] The webhooks.interval config is invalid: %s", err)
+	}
+	webhookDispatcher := data.NewWebhookDispatcher(db)
+	webhookDispatcher.MaxAttempts, err = strconv.Atoi(viper.GetString("webhooks.maxattempts"))
+	if err != nil {
+		log.Fatalf("[ERROR] The webhooks.maxattempts config is invalid: %s", err)
+	}
+	stopWebhooks := make(chan struct{})
+	defer close(stopWebhooks)
+	go webhookDispatcher.Run(stopWebhooks, time.Duration(webhookinterval)*time.Second)
+
+	//	Start removing expired grants (and reporting started grants).  Decisions follow the grant times
+	//	exactly, but the audit events (and webhooks and change notifications) lag by up to the interval:
+	grantinterval, err := strconv.Atoi(viper.GetString("grants.interval"))
+	if err != nil {
+		log.Fatalf("[ERROR] The grants.interval config is invalid: %s", err)
+	}
+	stopGrants := make(chan struct{})
+	defer close(stopGrants)
+	go db.RunGrantExpiry(stopGrants, time.Duration(grantinterval)*time.Second)
 
 	//	Log the token TTL:
 	tokenttlstring := viper.GetString("apiservice.tokenttl")
@@ -129,23 +178,47 @@ func start(cmd *cobra.Command, args []string) {
 		UIRouter.PathPrefix("/ui").Handler(http.StripPrefix("/ui", http.FileServer(http.Dir(viper.GetString("uiservice.ui-dir")))))
 	}
 	//	-- Auth and overview
-	UIRouter.HandleFunc("/auth/token", apiService.GetTokenForCredentials).Methods("GET") // Get a token (from credentials)
-	UIRouter.HandleFunc("/system/overview", apiService.GetOverview).Methods("GET")       // Get system overview
-	UIRouter.HandleFunc("/system/search", apiService.Search).Methods("POST")             // Search the system
+	UIRouter.HandleFunc("/auth/token", apiService.GetTokenForCredentials).Methods("GET")   // Get a token (from credentials)
+	UIRouter.HandleFunc("/system/overview", apiService.GetOverview).Methods("GET")         // Get system overview
+	UIRouter.HandleFunc("/system/search", apiService.Search).Methods("POST")               // Search the system
+	UIRouter.HandleFunc("/system/recyclebin", apiService.GetRecycleBin).Methods("GET")     // Get deleted items
+	UIRouter.HandleFunc("/system/audit", apiService.GetAuditLog).Methods("GET")            // Get audit events
+	UIRouter.HandleFunc("/system/changes", apiService.GetChanges).Methods("GET")           // Get change notifications
+	UIRouter.HandleFunc("/system/changes/stream", apiService.StreamChanges).Methods("GET") // Stream change notifications (server-sent events)
 	//	-- 2FA enrollment
 	UIRouter.HandleFunc("/2fa", apiService.BeginTOTPEnrollment).Methods("POST")
 	UIRouter.HandleFunc("/2fa", apiService.FinishTOTPEnrollment).Methods("PUT")
 	UIRouter.HandleFunc("/2fa", apiService.GetTOTPEnrollmentImage).Methods("GET")
 	//	-- User
-	UIRouter.HandleFunc("/system/users", apiService.AddUser).Methods("POST")                              // Add a user
-	UIRouter.HandleFunc("/system/users", apiService.GetAllUsers).Methods("GET")                           // Get all users
-	UIRouter.HandleFunc("/system/user/{username}", apiService.GetUser).Methods("GET")                     // Get a user
-	UIRouter.HandleFunc("/system/user/{username}/policies", apiService.GetPoliciesForUser).Methods("GET") // Get policies for a user
+	UIRouter.HandleFunc("/system/users", apiService.AddUser).Methods("POST")                               // Add a user
+	UIRouter.HandleFunc("/system/users", apiService.GetAllUsers).Methods("GET")                            // Get all users
+	UIRouter.HandleFunc("/system/user/{username}", apiService.GetUser).Methods("GET")                      // Get a user
+	UIRouter.HandleFunc("/system/user/{username}", apiService.DeleteUser).Methods("DELETE")                // Delete a user
+	UIRouter.HandleFunc("/system/user/{username}/restore", apiService.RestoreUser).Methods("PUT")          // Restore a deleted user
+	UIRouter.HandleFunc("/system/user/{username}/attributes", apiService.SetUserAttributes).Methods("PUT") // Set the custom attributes of a user
+	UIRouter.HandleFunc("/system/user/{username}/policies", apiService.GetPoliciesForUser).Methods("GET")  // Get policies for a user
 	//	-- Group
 	UIRouter.HandleFunc("/system/groups", apiService.AddGroup).Methods("POST")                                   // Add a group
 	UIRouter.HandleFunc("/system/groups", apiService.GetAllGroups).Methods("GET")                                // Get all groups
 	UIRouter.HandleFunc("/system/group/{groupname}", apiService.GetGroup).Methods("GET")                         // Get a group
+	UIRouter.HandleFunc("/system/group/{groupname}", apiService.DeleteGroup).Methods("DELETE")                   // Delete a group
+	UIRouter.HandleFunc("/system/group/{groupname}/restore", apiService.RestoreGroup).Methods("PUT")             // Restore a deleted group
 	UIRouter.HandleFunc("/system/group/{groupname}/users/{userlist}", apiService.AddUsersToGroup).Methods("PUT") // Add users to a group
+	//	-- Webhook
+	UIRouter.HandleFunc("/system/webhooks", apiService.AddWebhook).Methods("POST")                   // Add a webhook
+	UIRouter.HandleFunc("/system/webhooks", apiService.GetAllWebhooks).Methods("GET")                // Get all webhooks
+	UIRouter.HandleFunc("/system/webhooks/outbox", apiService.GetWebhookOutbox).Methods("GET")       // Get the webhook deliveries waiting in the outbox
+	UIRouter.HandleFunc("/system/webhook/{webhookname}", apiService.GetWebhook).Methods("GET")       // Get a webhook
+	UIRouter.HandleFunc("/system/webhook/{webhookname}", apiService.DeleteWebhook).Methods("DELETE") // Delete a webhook
+	//	-- Grant
+	UIRouter.HandleFunc("/system/grants", apiService.GetGrants).Methods("GET") // Get the time-bound grants (and when they expire)
+	//	-- Campaign
+	UIRouter.HandleFunc("/system/campaigns", apiService.AddCampaign).Methods("POST")                                      // Start an access review campaign
+	UIRouter.HandleFunc("/system/campaigns", apiService.GetAllCampaigns).Methods("GET")                                   // Get all access review campaigns
+	UIRouter.HandleFunc("/system/campaign/{campaignname}", apiService.GetCampaign).Methods("GET")                         // Get an access review campaign
+	UIRouter.HandleFunc("/system/campaign/{campaignname}/items/{itemid}", apiService.RecordReviewDecision).Methods("PUT") // Record a review decision
+	UIRouter.HandleFunc("/system/campaign/{campaignname}/close", apiService.CloseCampaign).Methods("PUT")                 // Close a campaign (and apply the revocations)
+	UIRouter.HandleFunc("/system/campaign/{campaignname}/report", apiService.GetCampaignReport).Methods("GET")            // Export a campaign report (CSV or JSON)
 	//	-- Resource
 	UIRouter.HandleFunc("/system/resources", apiService.AddResource).Methods("POST")                                            // Add a resource
 	UIRouter.HandleFunc("/system/resources", apiService.GetAllResources).Methods("GET")                                         // Get all resources
@@ -154,21 +227,31 @@ func start(cmd *cobra.Command, args []string) {
 	//	-- Policy
 	UIRouter.HandleFunc("/system/policies", apiService.AddPolicy).Methods("POST")                                         // Add a policy
 	UIRouter.HandleFunc("/system/policies", apiService.GetAllPolicies).Methods("GET")                                     // Get all policies
+	UIRouter.HandleFunc("/system/policies/import", apiService.ImportPolicy).Methods("POST")                               // Import an AWS IAM policy document
+	UIRouter.HandleFunc("/system/policies/lint", apiService.LintPolicies).Methods("GET")                                  // Lint the policies
+	UIRouter.HandleFunc("/system/policies/simulate", apiService.SimulatePolicies).Methods("POST")                         // Simulate a change to a user's policies
+	UIRouter.HandleFunc("/system/policies/whocan", apiService.WhoCan).Methods("GET")                                      // Get the users allowed to perform an action on a resource
 	UIRouter.HandleFunc("/system/policy/{policyname}", apiService.GetPolicy).Methods("GET")                               // Get a policy
+	UIRouter.HandleFunc("/system/policy/{policyname}/export", apiService.ExportPolicy).Methods("GET")                     // Export a policy as an AWS IAM policy document
+	UIRouter.HandleFunc("/system/policy/{policyname}", apiService.DeletePolicy).Methods("DELETE")                         // Delete a policy
+	UIRouter.HandleFunc("/system/policy/{policyname}/restore", apiService.RestorePolicy).Methods("PUT")                   // Restore a deleted policy
 	UIRouter.HandleFunc("/system/policy/{policyname}/users/{userlist}", apiService.AttachPolicyToUsers).Methods("PUT")    // Attach policy to user(s)
 	UIRouter.HandleFunc("/system/policy/{policyname}/groups/{grouplist}", apiService.AttachPolicyToGroups).Methods("PUT") // Attach policy to group(s)
 	//	-- Role
 	UIRouter.HandleFunc("/system/roles", apiService.AddRole).Methods("POST")                                             // Add a role
 	UIRouter.HandleFunc("/system/roles", apiService.GetAllRoles).Methods("GET")                                          // Get all roles
 	UIRouter.HandleFunc("/system/role/{rolename}", apiService.GetRole).Methods("GET")                                    // Get a role
+	UIRouter.HandleFunc("/system/role/{rolename}", apiService.DeleteRole).Methods("DELETE")                              // Delete a role
+	UIRouter.HandleFunc("/system/role/{rolename}/restore", apiService.RestoreRole).Methods("PUT")                        // Restore a deleted role
 	UIRouter.HandleFunc("/system/role/{rolename}/policies/{policylist}", apiService.AttachPoliciesToRole).Methods("PUT") // Attach role to policy(s)
 	UIRouter.HandleFunc("/system/role/{rolename}/groups/{grouplist}", apiService.AttachRoleToGroups).Methods("PUT")      // Attach role to group(s)
 	UIRouter.HandleFunc("/system/role/{rolename}/users/{userlist}", apiService.AttachRoleToUsers).Methods("PUT")         // Attach role to user(s)
 
 	//	SERVICE ROUTES
 	//	-- Auth
-	APIRouter.HandleFunc("/auth/token", apiService.GetTokenForCredentials).Methods("GET")   // Get a token (from credentials)
-	APIRouter.HandleFunc("/auth/authorize", apiService.IsRequestAuthorized).Methods("POST") // Validate a request for a given token
+	APIRouter.HandleFunc("/auth/token", apiService.GetTokenForCredentials).Methods("GET")               // Get a token (from credentials)
+	APIRouter.HandleFunc("/auth/authorize", apiService.IsRequestAuthorized).Methods("POST")             // Validate a request for a given token
+	APIRouter.HandleFunc("/auth/actions/{resourcename}", apiService.GetPermittedActions).Methods("GET") // Get the permitted actions on a resource for a given token
 	//	-- OAuth
 	APIRouter.HandleFunc("/oauth/token/client", api.HelloWorld).Methods("POST")
 	APIRouter.HandleFunc("/oauth/authorize", api.HelloWorld).Methods("GET")
@@ -176,16 +259,43 @@ func start(cmd *cobra.Command, args []string) {
 	APIRouter.HandleFunc("/2fa", apiService.BeginTOTPEnrollment).Methods("POST")
 	APIRouter.HandleFunc("/2fa", apiService.FinishTOTPEnrollment).Methods("PUT")
 	APIRouter.HandleFunc("/2fa", apiService.GetTOTPEnrollmentImage).Methods("GET")
+	//	-- Recycle bin
+	APIRouter.HandleFunc("/system/recyclebin", apiService.GetRecycleBin).Methods("GET") // Get deleted items
+	//	-- Audit log
+	APIRouter.HandleFunc("/system/audit", apiService.GetAuditLog).Methods("GET") // Get audit events
+	//	-- Changes
+	APIRouter.HandleFunc("/system/changes", apiService.GetChanges).Methods("GET")           // Get change notifications
+	APIRouter.HandleFunc("/system/changes/stream", apiService.StreamChanges).Methods("GET") // Stream change notifications (server-sent events)
 	//	-- User
-	APIRouter.HandleFunc("/system/users", apiService.AddUser).Methods("POST")                              // Add a user
-	APIRouter.HandleFunc("/system/users", apiService.GetAllUsers).Methods("GET")                           // Get all users
-	APIRouter.HandleFunc("/system/user/{username}", apiService.GetUser).Methods("GET")                     // Get a user
-	APIRouter.HandleFunc("/system/user/{username}/policies", apiService.GetPoliciesForUser).Methods("GET") // Get policies for a user
+	APIRouter.HandleFunc("/system/users", apiService.AddUser).Methods("POST")                               // Add a user
+	APIRouter.HandleFunc("/system/users", apiService.GetAllUsers).Methods("GET")                            // Get all users
+	APIRouter.HandleFunc("/system/user/{username}", apiService.GetUser).Methods("GET")                      // Get a user
+	APIRouter.HandleFunc("/system/user/{username}", apiService.DeleteUser).Methods("DELETE")                // Delete a user
+	APIRouter.HandleFunc("/system/user/{username}/restore", apiService.RestoreUser).Methods("PUT")          // Restore a deleted user
+	APIRouter.HandleFunc("/system/user/{username}/attributes", apiService.SetUserAttributes).Methods("PUT") // Set the custom attributes of a user
+	APIRouter.HandleFunc("/system/user/{username}/policies", apiService.GetPoliciesForUser).Methods("GET")  // Get policies for a user
 	//	-- Group
 	APIRouter.HandleFunc("/system/groups", apiService.AddGroup).Methods("POST")                                   // Add a group
 	APIRouter.HandleFunc("/system/groups", apiService.GetAllGroups).Methods("GET")                                // Get all groups
 	APIRouter.HandleFunc("/system/group/{groupname}", apiService.GetGroup).Methods("GET")                         // Get a group
+	APIRouter.HandleFunc("/system/group/{groupname}", apiService.DeleteGroup).Methods("DELETE")                   // Delete a group
+	APIRouter.HandleFunc("/system/group/{groupname}/restore", apiService.RestoreGroup).Methods("PUT")             // Restore a deleted group
 	APIRouter.HandleFunc("/system/group/{groupname}/users/{userlist}", apiService.AddUsersToGroup).Methods("PUT") // Add users to a group
+	//	-- Webhook
+	APIRouter.HandleFunc("/system/webhooks", apiService.AddWebhook).Methods("POST")                   // Add a webhook
+	APIRouter.HandleFunc("/system/webhooks", apiService.GetAllWebhooks).Methods("GET")                // Get all webhooks
+	APIRouter.HandleFunc("/system/webhooks/outbox", apiService.GetWebhookOutbox).Methods("GET")       // Get the webhook deliveries waiting in the outbox
+	APIRouter.HandleFunc("/system/webhook/{webhookname}", apiService.GetWebhook).Methods("GET")       // Get a webhook
+	APIRouter.HandleFunc("/system/webhook/{webhookname}", apiService.DeleteWebhook).Methods("DELETE") // Delete a webhook
+	//	-- Grant
+	APIRouter.HandleFunc("/system/grants", apiService.GetGrants).Methods("GET") // Get the time-bound grants (and when they expire)
+	//	-- Campaign
+	APIRouter.HandleFunc("/system/campaigns", apiService.AddCampaign).Methods("POST")                                      // Start an access review campaign
+	APIRouter.HandleFunc("/system/campaigns", apiService.GetAllCampaigns).Methods("GET")                                   // Get all access review campaigns
+	APIRouter.HandleFunc("/system/campaign/{campaignname}", apiService.GetCampaign).Methods("GET")                         // Get an access review campaign
+	APIRouter.HandleFunc("/system/campaign/{campaignname}/items/{itemid}", apiService.RecordReviewDecision).Methods("PUT") // Record a review decision
+	APIRouter.HandleFunc("/system/campaign/{campaignname}/close", apiService.CloseCampaign).Methods("PUT")                 // Close a campaign (and apply the revocations)
+	APIRouter.HandleFunc("/system/campaign/{campaignname}/report", apiService.GetCampaignReport).Methods("GET")            // Export a campaign report (CSV or JSON)
 	//	-- Resource
 	APIRouter.HandleFunc("/system/resources", apiService.AddResource).Methods("POST")                                            // Add a resource
 	APIRouter.HandleFunc("/system/resources", apiService.GetAllResources).Methods("GET")                                         // Get all resources
@@ -194,13 +304,22 @@ func start(cmd *cobra.Command, args []string) {
 	//	-- Policy
 	APIRouter.HandleFunc("/system/policies", apiService.AddPolicy).Methods("POST")                                         // Add a policy
 	APIRouter.HandleFunc("/system/policies", apiService.GetAllPolicies).Methods("GET")                                     // Get all policies
+	APIRouter.HandleFunc("/system/policies/import", apiService.ImportPolicy).Methods("POST")                               // Import an AWS IAM policy document
+	APIRouter.HandleFunc("/system/policies/lint", apiService.LintPolicies).Methods("GET")                                  // Lint the policies
+	APIRouter.HandleFunc("/system/policies/simulate", apiService.SimulatePolicies).Methods("POST")                         // Simulate a change to a user's policies
+	APIRouter.HandleFunc("/system/policies/whocan", apiService.WhoCan).Methods("GET")                                      // Get the users allowed to perform an action on a resource
 	APIRouter.HandleFunc("/system/policy/{policyname}", apiService.GetPolicy).Methods("GET")                               // Get a policy
+	APIRouter.HandleFunc("/system/policy/{policyname}/export", apiService.ExportPolicy).Methods("GET")                     // Export a policy as an AWS IAM policy document
+	APIRouter.HandleFunc("/system/policy/{policyname}", apiService.DeletePolicy).Methods("DELETE")                         // Delete a policy
+	APIRouter.HandleFunc("/system/policy/{policyname}/restore", apiService.RestorePolicy).Methods("PUT")                   // Restore a deleted policy
 	APIRouter.HandleFunc("/system/policy/{policyname}/users/{userlist}", apiService.AttachPolicyToUsers).Methods("PUT")    // Attach policy to user(s)
 	APIRouter.HandleFunc("/system/policy/{policyname}/groups/{grouplist}", apiService.AttachPolicyToGroups).Methods("PUT") // Attach policy to group(s)
 	//	-- Role
 	APIRouter.HandleFunc("/system/roles", apiService.AddRole).Methods("POST")                                             // Add a role
 	APIRouter.HandleFunc("/system/roles", apiService.GetAllRoles).Methods("GET")                                          // Get all roles
 	APIRouter.HandleFunc("/system/role/{rolename}", apiService.GetRole).Methods("GET")                                    // Get a role
+	APIRouter.HandleFunc("/system/role/{rolename}", apiService.DeleteRole).Methods("DELETE")                              // Delete a role
+	APIRouter.HandleFunc("/system/role/{rolename}/restore", apiService.RestoreRole).Methods("PUT")                        // Restore a deleted role
 	APIRouter.HandleFunc("/system/role/{rolename}/policies/{policylist}", apiService.AttachPoliciesToRole).Methods("PUT") // Attach role to policy(s)
 	APIRouter.HandleFunc("/system/role/{rolename}/groups/{grouplist}", apiService.AttachRoleToGroups).Methods("PUT")      // Attach role to group(s)
 	APIRouter.HandleFunc("/system/role/{rolename}/users/{userlist}", apiService.AttachRoleToUsers).Methods("PUT")         // Attach role to user(s)
@@ -246,3 +365,34 @@ func init() {
 	startCmd.Flags().StringVarP(&uiDirectory, "ui-dir", "u", "", "Directory for the UI")
 	viper.BindPFlag("uiservice.ui-dir", startCmd.Flags().Lookup("ui-dir"))
 }
+
+// getDecisionLogger creates the authorization decision logger from the decisionlog config.
+// If no sinks are configured, decisions aren't logged (and nil is returned)
+func getDecisionLogger() (*decisionlog.Logger, error) {
+	sinks := []decisionlog.Sink{}
+
+	for _, sinkName := range strings.Split(viper.GetString("decisionlog.sinks"), ",") {
+		switch strings.TrimSpace(sinkName) {
+		case "":
+			continue
+		case "stdout":
+			sinks = append(sinks, decisionlog.NewStdoutSink())
+		case "file":
+			maxSize := viper.GetInt64("decisionlog.maxsize") * 1024 * 1024
+			fileSink, err := decisionlog.NewFileSink(viper.GetString("decisionlog.file"), maxSize, viper.GetInt("decisionlog.maxbackups"))
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, fileSink)
+			log.Printf("[INFO] Logging authorization decisions to: %s", viper.GetString("decisionlog.file"))
+		default:
+			return nil, fmt.Errorf("Unknown decision log sink '%s'.  Use 'file' and/or 'stdout'", sinkName)
+		}
+	}
+
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+
+	return decisionlog.NewLogger(viper.GetFloat64("decisionlog.allowsamplerate"), viper.GetFloat64("decisionlog.denysamplerate"), sinks...), nil
+}
@@ -0,0 +1,321 @@
+package data
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/danesparza/badger"
+	"github.com/rs/xid"
+)
+
+// AuditEvent represents a change made to the system.  An audit event is recorded (as part of
+// the same transaction) for every change made through the Manager.  Audit events are never
+// updated or removed.  Events are hash-chained:  each event includes the hash of the event
+// before it, so changing or removing an event breaks the chain
+type AuditEvent struct {
+	ID       string        `json:"id"`
+	Time     time.Time     `json:"time"`
+	Actor    string        `json:"actor"`
+	Action   string        `json:"action"`
+	Target   string        `json:"target"`
+	SourceIP string        `json:"source_ip"`
+	Changes  []AuditChange `json:"changes"`
+	PrevHash string        `json:"prev_hash"`
+	Hash     string        `json:"hash"`
+}
+
+// AuditChange represents a change to a single item as part of an audit event.  Before is
+// empty if the item was added.  Changed is the list of fields that are different
+type AuditChange struct {
+	Key     string                 `json:"key"`
+	Before  map[string]interface{} `json:"before"`
+	After   map[string]interface{} `json:"after"`
+	Changed []string               `json:"changed"`
+}
+
+// AuditQuery is used to find audit events.  Only events that match all of the (non-empty)
+// fields are returned, oldest first
+type AuditQuery struct {
+	//	Since / Until only include events in the given time range (inclusive)
+	Since time.Time
+	Until time.Time
+
+	//	Actor only includes events caused by the given user
+	Actor string
+
+	//	Action only includes events for the given action (like 'AttachPolicyToUsers')
+	Action string
+
+	//	Target only includes events that changed the given item (like 'User:bob')
+	Target string
+
+	//	Cursor is the next cursor returned by a previous call.  Limit is the maximum number of events to return (0 means no limit)
+	Cursor string
+	Limit  int
+}
+
+// auditedItemTypes are the types of items that have their changes recorded in audit events
+var auditedItemTypes = []string{"User", "Group", "Role", "Policy", "Resource", "Webhook"}
+
+// redactedFields are fields that are never recorded in audit events
+var redactedFields = []string{"secrethash", "totpsecret", "secret"}
+
+// reader is a transaction items can be read from
+type reader interface {
+	Get(key []byte) (*badger.Item, error)
+	NewIterator(opt badger.IteratorOptions) *badger.Iterator
+}
+
+// writeTxn is a read-write transaction that keeps track of the changes made
+// to items, so they can be recorded in an audit event
+type writeTxn struct {
+	*badger.Txn
+	changes []AuditChange
+}
+
+// recordChange tracks a change to the item with the given key.  The current value of the
+// item (if there is one) is read from the transaction, so this must be called before the item is set
+func (txn *writeTxn) recordChange(key, after []byte) error {
+	//	Only track changes to the items we audit
+	itemType := strings.SplitN(string(key), ":", 2)[0]
+	audited := false
+	for _, current := range auditedItemTypes {
+		if itemType == current {
+			audited = true
+		}
+	}
+	if !audited {
+		return nil
+	}
+
+	afterFields, err := auditFields(after)
+	if err != nil {
+		return err
+	}
+
+	//	If the item was already changed in this transaction, just track the new value
+	for i := range txn.changes {
+		if txn.changes[i].Key == string(key) {
+			txn.changes[i].After = afterFields
+			txn.changes[i].Changed = changedFields(txn.changes[i].Before, afterFields)
+			return nil
+		}
+	}
+
+	//	Otherwise, get the item as it was before the change
+	var beforeFields map[string]interface{}
+	if item, err := txn.Get(key); err == nil {
+		before, err := item.Value()
+		if err != nil {
+			return err
+		}
+
+		beforeFields, err = auditFields(before)
+		if err != nil {
+			return err
+		}
+	}
+
+	txn.changes = append(txn.changes, AuditChange{
+		Key:     string(key),
+		Before:  beforeFields,
+		After:   afterFields,
+		Changed: changedFields(beforeFields, afterFields),
+	})
+
+	return nil
+}
+
+// newAuditEvent creates an audit event for the given action on an item
+func newAuditEvent(actor, action, itemType, itemName string) AuditEvent {
+	return AuditEvent{
+		Actor:  actor,
+		Action: action,
+		Target: string(GetKey(itemType, itemName)),
+	}
+}
+
+// recordEvent completes the audit event with the changes made in the transaction, chains it to the
+// previous event and saves it (and adds it to the webhook outbox)
+func (txn *writeTxn) recordEvent(event AuditEvent) error {
+	event.Time = time.Now()
+	event.ID = xid.NewWithTime(event.Time).String()
+	event.Changes = txn.changes
+	if event.Changes == nil {
+		event.Changes = []AuditChange{}
+	}
+
+	if err := txn.chainEvent(&event); err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if err := txn.Set(GetKey("Audit", event.ID), encoded); err != nil {
+		return err
+	}
+
+	//	Let any interested webhooks know about the event
+	return txn.enqueueWebhooks(event)
+}
+
+// auditFields gets the fields of a serialized item, without the redacted fields
+func auditFields(val []byte) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	if len(val) == 0 {
+		return fields, nil
+	}
+
+	if err := json.Unmarshal(val, &fields); err != nil {
+		return fields, err
+	}
+
+	for _, field := range redactedFields {
+		if _, ok := fields[field]; ok {
+			fields[field] = "[redacted]"
+		}
+	}
+
+	return fields, nil
+}
+
+// changedFields returns the (sorted) names of the fields that are different between before and after
+func changedFields(before, after map[string]interface{}) []string {
+	retval := []string{}
+
+	for field, value := range after {
+		if previous, ok := before[field]; !ok || !reflect.DeepEqual(previous, value) {
+			retval = append(retval, field)
+		}
+	}
+
+	for field := range before {
+		if _, ok := after[field]; !ok {
+			retval = append(retval, field)
+		}
+	}
+
+	sort.Strings(retval)
+	return retval
+}
+
+// matches returns true if the event passes all filters of the query
+func (query AuditQuery) matches(event AuditEvent) bool {
+	if !query.Since.IsZero() && event.Time.Before(query.Since) {
+		return false
+	}
+
+	if query.Actor != "" && event.Actor != query.Actor {
+		return false
+	}
+
+	if query.Action != "" && event.Action != query.Action {
+		return false
+	}
+
+	if query.Target != "" && event.Target != query.Target {
+		changedTarget := false
+		for _, change := range event.Changes {
+			if change.Key == query.Target {
+				changedTarget = true
+			}
+		}
+
+		if !changedTarget {
+			return false
+		}
+	}
+
+	return true
+}
+
+// GetAuditLog gets the audit events that match the query (a page at a time, if the query has a limit).
+// Returns the events and the cursor for the next page (empty if this is the last page)
+func (store Manager) GetAuditLog(context User, query AuditQuery) ([]AuditEvent, string, error) {
+	//	Our return item
+	retval := []AuditEvent{}
+
+	//	Security check:  Are we authorized to perform this action?
+	if !store.IsUserRequestAuthorized(context, sysreqGetAuditLog) {
+		return retval, "", fmt.Errorf("User %s is not authorized to perform the action", context.Name)
+	}
+
+	if query.Limit < 0 {
+		return retval, "", fmt.Errorf("Limit can't be negative")
+	}
+
+	err := store.systemdb.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		//	Event ids are in time order -- so find where to start.  That's either
+		//	after the last event of the previous page, or the first id with the 'since' time
+		prefix := GetKey("Audit", "")
+		start := prefix
+		if query.Cursor != "" {
+			start = GetKey("Audit", query.Cursor)
+		} else if !query.Since.IsZero() {
+			var sinceID xid.ID
+			binary.BigEndian.PutUint32(sinceID[:4], uint32(query.Since.Unix()))
+			start = GetKey("Audit", sinceID.String())
+		}
+
+		for it.Seek(start); it.ValidForPrefix(prefix); it.Next() {
+			val, err := it.Item().Value()
+			if err != nil {
+				return err
+			}
+
+			event := AuditEvent{}
+			if err := json.Unmarshal(val, &event); err != nil {
+				return err
+			}
+
+			//	Skip the last event of the previous page:
+			if event.ID == query.Cursor {
+				continue
+			}
+
+			//	We're done once we're past the 'until' time
+			if !query.Until.IsZero() && event.Time.After(query.Until) {
+				break
+			}
+
+			if !query.matches(event) {
+				continue
+			}
+
+			//	We only need to read one event past the end of the page
+			//	to know if there is another page
+			retval = append(retval, event)
+			if query.Limit > 0 && len(retval) > query.Limit {
+				break
+			}
+		}
+
+		return nil
+	})
+
+	//	If there was an error, report it:
+	if err != nil {
+		return []AuditEvent{}, "", fmt.Errorf("Problem getting the audit log: %s", err)
+	}
+
+	//	Trim to the page size and get the cursor for the next page:
+	nextCursor := ""
+	if query.Limit > 0 && len(retval) > query.Limit {
+		retval = retval[:query.Limit]
+		nextCursor = retval[len(retval)-1].ID
+	}
+
+	//	Return our data:
+	return retval, nextCursor, nil
+}
@@ -0,0 +1,244 @@
+package data_test
+
+import (
+	"crypto/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/danesparza/iamserver/data"
+	"github.com/danesparza/iamserver/policy"
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestAudit_AttachPolicyToUsers_RecordsBeforeAndAfter(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	start := time.Now()
+
+	db.AddUser(contextUser, data.User{Name: "bob"}, "testpass")
+	db.AddResource(contextUser, "Someresource", "")
+	db.AddPolicy(contextUser, data.Policy{Name: "AdminPolicy", Effect: policy.Allow, Resources: []string{"Someresource"}, Actions: []string{"Someaction"}})
+
+	//	Act
+	db.WithSource("10.0.0.1").AttachPolicyToUsers(contextUser, "AdminPolicy", "bob")
+	events, _, err := db.GetAuditLog(contextUser, data.AuditQuery{Since: start, Target: "User:bob"})
+
+	//	Assert
+	if err != nil {
+		t.Fatalf("GetAuditLog - Should get the audit log without error, but got: %s", err)
+	}
+
+	if len(events) != 2 || events[0].Action != "AddUser" || events[1].Action != "AttachPolicyToUsers" {
+		t.Fatalf("GetAuditLog - Expected the AddUser and AttachPolicyToUsers events for bob, but got %+v", events)
+	}
+
+	attach := events[1]
+	if attach.Actor != "System" || attach.Target != "Policy:AdminPolicy" || attach.SourceIP != "10.0.0.1" {
+		t.Errorf("GetAuditLog - Unexpected actor, target or source: %+v", attach)
+	}
+
+	if len(attach.Changes) != 2 {
+		t.Fatalf("GetAuditLog - Expected changes to the policy and the user, but got %+v", attach.Changes)
+	}
+
+	for _, change := range attach.Changes {
+		if change.Key != "User:bob" {
+			continue
+		}
+
+		if len(change.Before["policies"].([]interface{})) != 0 || len(change.After["policies"].([]interface{})) != 1 {
+			t.Errorf("GetAuditLog - Expected the user's policies before and after the change, but got %+v", change)
+		}
+
+		if change.After["secrethash"] != "[redacted]" {
+			t.Errorf("GetAuditLog - Expected the secret hash to be redacted, but got %v", change.After["secrethash"])
+		}
+	}
+}
+
+func TestAudit_GetAuditLog_WithActorAndLimit_ReturnsPages(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+
+	db.AddGroup(contextUser, "Unittestgroup1", "")
+	db.AddGroup(contextUser, "Unittestgroup2", "")
+	db.AddGroup(contextUser, "Unittestgroup3", "")
+
+	//	Act
+	firstPage, nextCursor, err := db.GetAuditLog(contextUser, data.AuditQuery{Actor: "System", Limit: 2})
+	secondPage, lastCursor, _ := db.GetAuditLog(contextUser, data.AuditQuery{Actor: "System", Limit: 2, Cursor: nextCursor})
+
+	//	Assert
+	if err != nil {
+		t.Fatalf("GetAuditLog - Should get the audit log without error, but got: %s", err)
+	}
+
+	if len(firstPage) != 2 || firstPage[0].Target != "Group:Unittestgroup1" || firstPage[1].Target != "Group:Unittestgroup2" {
+		t.Errorf("GetAuditLog - Unexpected first page: %+v", firstPage)
+	}
+
+	if len(secondPage) != 1 || secondPage[0].Target != "Group:Unittestgroup3" || lastCursor != "" {
+		t.Errorf("GetAuditLog - Unexpected last page: %+v (next cursor '%s')", secondPage, lastCursor)
+	}
+}
+
+func TestAudit_VerifyAuditLog_EventsAreChained(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	db.AddUser(contextUser, data.User{Name: "bob"}, "testpass")
+	db.AddGroup(contextUser, "Crew", "")
+	db.AddUsersToGroup(contextUser, "Crew", "bob")
+
+	//	Act
+	events, _, err := db.GetAuditLog(contextUser, data.AuditQuery{})
+	if err != nil {
+		t.Fatalf("GetAuditLog - Should get the audit log without error, but got: %s", err)
+	}
+	result, err := db.VerifyAuditLog(contextUser)
+
+	//	Assert
+	if err != nil {
+		t.Fatalf("VerifyAuditLog - Should verify the chain without error, but got: %s", err)
+	}
+
+	if len(events) != 3 || events[0].PrevHash != "" || events[1].PrevHash != events[0].Hash || events[2].PrevHash != events[1].Hash {
+		t.Errorf("GetAuditLog - Expected 3 chained events, but got %+v", events)
+	}
+
+	if result.Events != 3 || result.Head != events[2].Hash {
+		t.Errorf("VerifyAuditLog - Expected 3 events with the last event as the head, but got %+v", result)
+	}
+}
+
+func TestAudit_VerifyAuditChain_ChangedOrRemovedEvent_ReturnsBrokenLink(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	db.AddUser(contextUser, data.User{Name: "bob"}, "testpass")
+	db.AddUser(contextUser, data.User{Name: "alice"}, "testpass")
+	db.AddUser(contextUser, data.User{Name: "eve"}, "testpass")
+	events, _, _ := db.GetAuditLog(contextUser, data.AuditQuery{})
+
+	changed := append([]data.AuditEvent{}, events...)
+	changed[1].Actor = "eve"
+
+	removed := []data.AuditEvent{events[0], events[2]}
+
+	//	Act
+	_, changedErr := data.VerifyAuditChain(changed, "")
+	_, removedErr := data.VerifyAuditChain(removed, "")
+
+	//	Assert
+	if chainErr, ok := changedErr.(data.AuditChainError); !ok || chainErr.EventID != events[1].ID {
+		t.Errorf("VerifyAuditChain - Expected the changed event to break the chain, but got %v", changedErr)
+	}
+
+	if chainErr, ok := removedErr.(data.AuditChainError); !ok || chainErr.EventID != events[2].ID {
+		t.Errorf("VerifyAuditChain - Expected the event after the removed event to break the chain, but got %v", removedErr)
+	}
+}
+
+func TestAudit_NewAuditBundle_VerifiesSignatureAndChain(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	db.AddUser(contextUser, data.User{Name: "bob"}, "testpass")
+	db.AddUser(contextUser, data.User{Name: "alice"}, "testpass")
+	db.AddUser(contextUser, data.User{Name: "eve"}, "testpass")
+	events, _, _ := db.GetAuditLog(contextUser, data.AuditQuery{})
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %s", err)
+	}
+
+	//	Act
+	bundle, err := data.NewAuditBundle(events[1:], privateKey)
+	if err != nil {
+		t.Fatalf("NewAuditBundle - Should sign the bundle without error, but got: %s", err)
+	}
+	publicKey, _ := data.ParsePublicKey(bundle.PublicKey)
+	result, verifyErr := bundle.Verify(publicKey)
+
+	tampered := bundle
+	tampered.Events = append([]data.AuditEvent{}, bundle.Events...)
+	tampered.Events[0].Target = "User:eve"
+	_, tamperedErr := tampered.Verify(publicKey)
+
+	//	Re-signing the tampered events with another key doesn't help
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	resigned, _ := data.NewAuditBundle(tampered.Events, otherKey)
+	_, resignedErr := resigned.Verify(publicKey)
+
+	//	Assert
+	if verifyErr != nil || result.Events != 2 {
+		t.Errorf("Verify - Expected the bundle of 2 events to verify, but got %+v (%v)", result, verifyErr)
+	}
+
+	if tamperedErr == nil {
+		t.Errorf("Verify - Expected the tampered bundle to fail verification, but it didn't")
+	}
+
+	if resignedErr == nil {
+		t.Errorf("Verify - Expected the bundle signed with another key to fail verification, but it didn't")
+	}
+}
@@ -0,0 +1,235 @@
+package data
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/danesparza/badger"
+	"golang.org/x/crypto/ed25519"
+)
+
+// AuditHead is the last event in the audit chain.  Each audit event commits to the event
+// before it (with PrevHash), so the head is what the next event will be chained to
+type AuditHead struct {
+	ID   string `json:"id"`
+	Hash string `json:"hash"`
+}
+
+// AuditChainError is returned when the audit chain is broken:  an event has been changed,
+// removed or inserted.  EventID is the first event that doesn't link to the event before it
+type AuditChainError struct {
+	EventID string
+	Reason  string
+}
+
+// Error returns the description of the broken link
+func (e AuditChainError) Error() string {
+	return fmt.Sprintf("The audit chain is broken at event %s: %s", e.EventID, e.Reason)
+}
+
+// AuditVerification is the result of verifying the audit chain
+type AuditVerification struct {
+	//	Events is the number of chained events that were verified
+	Events int `json:"events"`
+
+	//	Unchained is the number of events recorded before the audit chain existed (these can't be verified)
+	Unchained int `json:"unchained"`
+
+	//	Head is the hash of the last event in the chain
+	Head string `json:"head"`
+}
+
+// AuditBundle is a signed export of (part of) the audit chain, for auditors.  The signature
+// covers the bundle (without the signature).  The public key in the bundle only says which key
+// signed it:  auditors should check the signature with the public key they got from the exporter
+type AuditBundle struct {
+	Exported  time.Time    `json:"exported"`
+	Events    []AuditEvent `json:"events"`
+	Head      string       `json:"head"`
+	PublicKey string       `json:"public_key"`
+	Signature string       `json:"signature"`
+}
+
+// auditHeadKey is the key of the audit chain head
+var auditHeadKey = GetKey("AuditHead")
+
+// computeHash gets the hash of the event:  the SHA-256 of the event (without its hash) as JSON
+func (event AuditEvent) computeHash() (string, error) {
+	event.Hash = ""
+
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// chainEvent links the event to the current head of the audit chain and makes it the new head
+// (as part of the given transaction).  Because every audit event reads and writes the head,
+// events are always chained in the order they are committed
+func (txn *writeTxn) chainEvent(event *AuditEvent) error {
+	head := AuditHead{}
+	if err := getItem(txn, auditHeadKey, &head); err != nil && err != badger.ErrKeyNotFound {
+		return err
+	}
+
+	event.PrevHash = head.Hash
+	hash, err := event.computeHash()
+	if err != nil {
+		return err
+	}
+	event.Hash = hash
+
+	return setItem(txn, auditHeadKey, AuditHead{ID: event.ID, Hash: event.Hash})
+}
+
+// VerifyAuditChain checks that each event is unchanged and links to the event before it
+// (the first event must link to prevHash).  Events recorded before the audit chain existed
+// (without a hash) are only allowed at the start.  Returns an AuditChainError for the first broken link
+func VerifyAuditChain(events []AuditEvent, prevHash string) (AuditVerification, error) {
+	retval := AuditVerification{Head: prevHash}
+
+	for _, event := range events {
+		//	Skip events from before the chain existed
+		if event.Hash == "" && event.PrevHash == "" && retval.Events == 0 && retval.Head == "" {
+			retval.Unchained++
+			continue
+		}
+
+		if event.PrevHash != retval.Head {
+			return retval, AuditChainError{EventID: event.ID, Reason: "it doesn't link to the previous event (an event was removed or inserted)"}
+		}
+
+		hash, err := event.computeHash()
+		if err != nil {
+			return retval, err
+		}
+
+		if hash != event.Hash {
+			return retval, AuditChainError{EventID: event.ID, Reason: "its hash doesn't match its contents (the event was changed)"}
+		}
+
+		retval.Events++
+		retval.Head = event.Hash
+	}
+
+	return retval, nil
+}
+
+// VerifyAuditLog walks the audit chain (oldest event first) and checks every link.
+// Returns an AuditChainError for the first broken link
+func (store Manager) VerifyAuditLog(context User) (AuditVerification, error) {
+	//	Get the whole audit log (this checks that the user is authorized)
+	events, _, err := store.GetAuditLog(context, AuditQuery{})
+	if err != nil {
+		return AuditVerification{}, err
+	}
+
+	//	Get the head of the chain
+	head := AuditHead{}
+	err = store.systemdb.View(func(txn *badger.Txn) error {
+		return getItem(txn, auditHeadKey, &head)
+	})
+	if err != nil && err != badger.ErrKeyNotFound {
+		return AuditVerification{}, fmt.Errorf("Problem getting the audit chain head: %s", err)
+	}
+
+	retval, err := VerifyAuditChain(events, "")
+	if err != nil {
+		return retval, err
+	}
+
+	//	Make sure no events were removed from the end of the chain
+	if retval.Head != head.Hash {
+		return retval, AuditChainError{EventID: head.ID, Reason: "the last event in the chain is missing (events were removed)"}
+	}
+
+	return retval, nil
+}
+
+// NewAuditBundle creates a bundle of the given (consecutive) audit events and signs it with the private key
+func NewAuditBundle(events []AuditEvent, privateKey ed25519.PrivateKey) (AuditBundle, error) {
+	retval := AuditBundle{
+		Exported:  time.Now(),
+		Events:    events,
+		PublicKey: base64.StdEncoding.EncodeToString(privateKey.Public().(ed25519.PublicKey)),
+	}
+
+	if len(events) > 0 {
+		retval.Head = events[len(events)-1].Hash
+	}
+
+	encoded, err := retval.signedContent()
+	if err != nil {
+		return AuditBundle{}, err
+	}
+
+	retval.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, encoded))
+	return retval, nil
+}
+
+// Verify checks the bundle was signed with the expected public key, and checks the audit chain in the bundle.
+// The key must come from the exporter (not from the bundle), otherwise anyone could change the events and re-sign them
+func (bundle AuditBundle) Verify(publicKey ed25519.PublicKey) (AuditVerification, error) {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return AuditVerification{}, fmt.Errorf("The expected public key is invalid")
+	}
+
+	if bundle.PublicKey != base64.StdEncoding.EncodeToString(publicKey) {
+		return AuditVerification{}, fmt.Errorf("The bundle was signed with a different key than the expected public key")
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(bundle.Signature)
+	if err != nil {
+		return AuditVerification{}, fmt.Errorf("The bundle signature is invalid")
+	}
+
+	encoded, err := bundle.signedContent()
+	if err != nil {
+		return AuditVerification{}, err
+	}
+
+	if !ed25519.Verify(publicKey, encoded, signature) {
+		return AuditVerification{}, fmt.Errorf("The bundle signature doesn't match its contents")
+	}
+
+	//	The bundle may start part way through the chain
+	prevHash := ""
+	if len(bundle.Events) > 0 {
+		prevHash = bundle.Events[0].PrevHash
+	}
+
+	retval, err := VerifyAuditChain(bundle.Events, prevHash)
+	if err != nil {
+		return retval, err
+	}
+
+	if retval.Head != bundle.Head {
+		return retval, fmt.Errorf("The bundle head doesn't match the last event")
+	}
+
+	return retval, nil
+}
+
+// ParsePublicKey parses a base64 encoded ed25519 public key
+func ParsePublicKey(encoded string) (ed25519.PublicKey, error) {
+	publicKey, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("The public key should be a base64 encoded ed25519 public key")
+	}
+
+	return ed25519.PublicKey(publicKey), nil
+}
+
+// signedContent gets the part of the bundle that is signed:  the bundle (without the signature) as JSON
+func (bundle AuditBundle) signedContent() ([]byte, error) {
+	bundle.Signature = ""
+	return json.Marshal(bundle)
+}
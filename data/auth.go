@@ -1,39 +1,145 @@
 package data
 
 import (
+	"sort"
+	"time"
+
+	"github.com/danesparza/badger"
 	"github.com/danesparza/iamserver/policy"
 	"github.com/pkg/errors"
 )
 
+// Decision represents the outcome of an authorization request.  Policy is the name of the policy (and
+// Statement is the statement of the policy) that decided the outcome.  They are empty if the request was
+// denied because no policy matched
+type Decision struct {
+	Time      time.Time     `json:"time"`
+	User      string        `json:"user"`
+	Resource  string        `json:"resource"`
+	Action    string        `json:"action"`
+	Allowed   bool          `json:"allowed"`
+	Policy    string        `json:"policy"`
+	Statement string        `json:"statement"`
+	Reason    string        `json:"reason"`
+	Latency   time.Duration `json:"latency_ns"`
+}
+
+// PolicyStatement identifies a statement of a policy.  Statement is the sid of the
+// statement (or its index in the policy, if it doesn't have a sid)
+type PolicyStatement struct {
+	Policy    string `json:"policy"`
+	Statement string `json:"statement"`
+}
+
 // IsUserRequestAuthorized determines whether the given user is authorized to
 // execute the given request
 func (store Manager) IsUserRequestAuthorized(user User, request *Request) bool {
-	retval := false
+	return store.AuthorizeUserRequest(user, request).Allowed
+}
+
+// AuthorizeUserRequest determines whether the given user is authorized to
+// execute the given request, and returns the decision (and how it was made)
+func (store Manager) AuthorizeUserRequest(user User, request *Request) Decision {
+	start := time.Now()
+	retval := Decision{
+		Time:     start,
+		User:     user.Name,
+		Resource: request.Resource,
+		Action:   request.Action,
+	}
 
 	//	If:
 	//	- using the special system user
 	//	- it's for the system resource
 	//	Then: the request is allowed
 	if user.Name == SystemUser.Name && user.Created.IsZero() && request.Resource == "System" {
-		return true
+		retval.Allowed = true
+		retval.Reason = "The request was made by the system"
+		retval.Latency = time.Since(start)
+		return retval
 	}
 
 	//	First, get all policies for the user
-	pols, err := store.GetPoliciesForUser(user, user.Name)
+	pols, err := store.effectivePolicies(user.Name)
 	if err != nil {
+		retval.Reason = err.Error()
+		retval.Latency = time.Since(start)
 		return retval
 	}
 
 	//	Next, find out if the request is authorized based on the policies
 	//	that apply to the given user
-	err = store.DoPoliciesAllow(request, pols)
-	if err == nil {
-		retval = true
-	}
+	retval.setOutcome(store.decideUserRequest(pols, request))
 
+	retval.Latency = time.Since(start)
 	return retval
 }
 
+// AuthorizeUserActions determines which of the registered actions of the resource the given user is authorized
+// to execute (with the given request context), and returns the decision for each of them.  If the resource doesn't
+// exist, ErrNotFound is returned
+func (store Manager) AuthorizeUserActions(user User, resourceName string, context map[string]string) ([]Decision, error) {
+	//	Our return item
+	retval := []Decision{}
+
+	//	Get the resource (and its registered actions)
+	resource := Resource{}
+	err := store.systemdb.View(func(txn *badger.Txn) error {
+		return getItem(txn, GetKey("Resource", resourceName), &resource)
+	})
+	if err != nil || resource.Deleted.Valid {
+		return retval, ErrNotFound
+	}
+
+	//	Get all policies for the user (once, for all of the actions)
+	start := time.Now()
+	pols, err := store.effectivePolicies(user.Name)
+	if err != nil {
+		return retval, err
+	}
+
+	for _, action := range resource.Actions {
+		request := &Request{Resource: resource.Name, Action: action, Context: context}
+		decision := Decision{
+			Time:     start,
+			User:     user.Name,
+			Resource: request.Resource,
+			Action:   request.Action,
+		}
+
+		decision.setOutcome(store.decideUserRequest(pols, request))
+
+		decision.Latency = time.Since(start)
+		retval = append(retval, decision)
+		start = time.Now()
+	}
+
+	return retval, nil
+}
+
+// decideUserRequest decides the request with the effective policies of a user.  The compiled
+// policies are used if we have them (they are only compiled for the default matcher)
+func (store Manager) decideUserRequest(pols userPolicies, request *Request) (PolicyStatement, error) {
+	_, syntaxMatcher := store.matcher().(*SyntaxMatcher)
+	if pols.compiled != nil && syntaxMatcher {
+		return pols.compiled.Decide(request)
+	}
+
+	return store.DecidePolicies(request, expandPolicies(pols.policies, pols.variables))
+}
+
+// setOutcome sets the outcome of the decision from the statement that decided it (and the error, if the request was denied)
+func (d *Decision) setOutcome(decidedBy PolicyStatement, err error) {
+	d.Policy, d.Statement = decidedBy.Policy, decidedBy.Statement
+	if err != nil {
+		d.Reason = errors.Cause(err).Error()
+		return
+	}
+
+	d.Allowed = true
+	d.Reason = "The request was allowed by a policy"
+}
+
 // matcher gets the policy matcher (or gets the DefaultMatcher if one isn't specified)
 func (store Manager) matcher() matcher {
 	if store.Matcher == nil {
@@ -42,45 +148,87 @@ func (store Manager) matcher() matcher {
 	return store.Matcher
 }
 
+// matchesAny returns true if the needle matches any of the patterns in the haystack.  If the statement
+// has 'not_' patterns (instead of the haystack), it returns true if the needle matches none of them
+func (store Manager) matchesAny(p Policy, haystack, notHaystack []string, needle string) (bool, error) {
+	if len(notHaystack) > 0 {
+		matches, err := store.matcher().Matches(p, notHaystack, needle)
+		return !matches, err
+	}
+
+	return store.matcher().Matches(p, haystack, needle)
+}
+
 // DoPoliciesAllow checks to see if the request is allowed by policy
 func (store Manager) DoPoliciesAllow(r *Request, policies map[string]Policy) error {
-	allowed := false
-	deciders := []Policy{}
-
-	//	Iterate through the list of policies
-	for _, p := range policies {
-
-		//	Does the action match with this policy?
-		if pm, err := store.matcher().Matches(p, p.Actions, r.Action); err != nil {
-			return errors.WithStack(err)
-		} else if !pm {
-			//	Continue to the next policy
-			continue
-		}
+	_, err := store.DecidePolicies(r, policies)
+	return err
+}
 
-		//	Does the resource match with this policy?
-		if pm, err := store.matcher().Matches(p, p.Resources, r.Resource); err != nil {
-			return errors.WithStack(err)
-		} else if !pm {
-			//	Continue to the next policy
-			continue
-		}
+// DecidePolicies checks to see if the request is allowed by policy, and returns the policy (and the
+// statement of the policy) that made the decision.  A 'deny' statement overrides all 'allow' statements.
+// If more than one statement allows the request, the first one (by policy name, then by position in the
+// policy) is returned.
+//
+// Policy variables are resolved from the context of the request.  Any other variables (like
+// the user variables) have to be expanded before calling this, or their patterns won't match
+func (store Manager) DecidePolicies(r *Request, policies map[string]Policy) (PolicyStatement, error) {
+	allowedBy := PolicyStatement{}
+	variables := requestVariables(r)
 
-		//	Is the policy effect 'deny'?
-		//	If yes, then this overrides all allow policies.  Access is denied.
-		if p.Effect != policy.Allow {
-			deciders = append(deciders, p)
-			return errors.WithStack(ErrRequestForcefullyDenied)
-		}
+	//	Iterate through the list of policies (in name order, so the decision is always the same)
+	names := []string{}
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		p := policies[name]
 
-		//	Policy allows access
-		allowed = true
-		deciders = append(deciders, p)
+		for i, s := range p.statements() {
+			s = resolveStatement(s, p.Syntax, variables)
+
+			//	Does the action match with this statement?
+			if pm, err := store.matchesAny(p, s.Actions, s.NotActions, r.Action); err != nil {
+				return PolicyStatement{}, errors.WithStack(err)
+			} else if !pm {
+				//	Continue to the next statement
+				continue
+			}
+
+			//	Does the resource match with this statement?
+			if pm, err := store.matchesAny(p, s.Resources, s.NotResources, r.Resource); err != nil {
+				return PolicyStatement{}, errors.WithStack(err)
+			} else if !pm {
+				//	Continue to the next statement
+				continue
+			}
+
+			//	Are the conditions of this statement met?
+			if cm, err := conditionsMatch(s.Conditions, variables); err != nil {
+				return PolicyStatement{}, errors.WithStack(err)
+			} else if !cm {
+				//	Continue to the next statement
+				continue
+			}
+
+			//	Is the statement effect 'deny'?
+			//	If yes, then this overrides all allow statements.  Access is denied.
+			if s.Effect != policy.Allow {
+				return PolicyStatement{Policy: p.Name, Statement: statementID(s, i)}, errors.WithStack(ErrRequestForcefullyDenied)
+			}
+
+			//	Statement allows access
+			if allowedBy.Policy == "" {
+				allowedBy = PolicyStatement{Policy: p.Name, Statement: statementID(s, i)}
+			}
+		}
 	}
 
-	if !allowed {
-		return errors.WithStack(ErrRequestDenied)
+	if allowedBy.Policy == "" {
+		return allowedBy, errors.WithStack(ErrRequestDenied)
 	}
 
-	return nil
+	return allowedBy, nil
 }
@@ -364,7 +364,7 @@ func TestManager_IsUserRequestAuthorized_AuthorizedRequest_ReturnsTrue(t *testin
 	//	** Now we can actually start assigning stuff ...
 
 	//	-- Add role to all users
-	allUsers, _ := db.GetAllUsers(contextUser)
+	allUsers, _, _ := db.GetAllUsers(contextUser, data.ListOptions{})
 	allUserNames := []string{}
 	for _, user := range allUsers {
 		allUserNames = append(allUserNames, user.Name)
@@ -531,7 +531,7 @@ func TestManager_IsUserRequestAuthorized_NotAuthorized_ReturnsFalse(t *testing.T
 	//	** Now we can actually start assigning stuff ...
 
 	//	-- Add role to all users
-	allUsers, _ := db.GetAllUsers(contextUser)
+	allUsers, _, _ := db.GetAllUsers(contextUser, data.ListOptions{})
 	allUserNames := []string{}
 	for _, user := range allUsers {
 		allUserNames = append(allUserNames, user.Name)
@@ -627,3 +627,234 @@ func TestManager_IsUserRequestAuthorized_SystemAction_AuthorizedUser_ReturnsTrue
 	t.Logf("New unit test user: %+v", newUser1)
 
 }
+
+func TestManager_AuthorizeUserRequest_ReturnsDecidingPolicy(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	adminUser, _, err := db.SystemBootstrap()
+	if err != nil {
+		t.Fatalf("SystemBootstrap - Should bootstrap without error, but got: %s", err)
+	}
+
+	//	Act
+	allowed := db.AuthorizeUserRequest(adminUser, &data.Request{Resource: "System", Action: "AddUser"})
+	denied := db.AuthorizeUserRequest(data.User{Name: "BogusUser"}, &data.Request{Resource: "System", Action: "AddUser"})
+
+	//	Assert
+	if !allowed.Allowed || allowed.Policy != "Administer everything" || allowed.User != "admin" {
+		t.Errorf("AuthorizeUserRequest - Expected the admin request to be allowed by 'Administer everything', but got %+v", allowed)
+	}
+
+	if denied.Allowed || denied.Policy != "" || denied.Reason == "" {
+		t.Errorf("AuthorizeUserRequest - Expected the bogus user request to be denied (with a reason), but got %+v", denied)
+	}
+
+}
+
+func TestManager_AuthorizeUserRequest_GlobAndRegexpPolicies_Successful(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	db.AddUser(contextUser, data.User{Name: "bob"}, "testpass")
+
+	//	Glob resources don't have to exist (just like regexp resources)
+	_, err = db.AddPolicy(contextUser, data.Policy{Name: "Read orders", Effect: policy.Allow, Syntax: policy.Glob, Resources: []string{"arn:app:orders/*"}, Actions: []string{"Get?"}})
+	if err != nil {
+		t.Fatalf("AddPolicy - Should add a glob policy without error, but got: %s", err)
+	}
+
+	_, err = db.AddPolicy(contextUser, data.Policy{Name: "Deny archive", Effect: policy.Deny, Resources: []string{"arn:app:orders/<archive/.*>"}, Actions: []string{"<.*>"}})
+	if err != nil {
+		t.Fatalf("AddPolicy - Should add a regexp policy without error, but got: %s", err)
+	}
+
+	db.AttachPolicyToUsers(contextUser, "Read orders", "bob")
+	db.AttachPolicyToUsers(contextUser, "Deny archive", "bob")
+	bob := data.User{Name: "bob"}
+
+	//	Act
+	allowed := db.AuthorizeUserRequest(bob, &data.Request{Resource: "arn:app:orders/1234", Action: "GetA"})
+	wrongAction := db.AuthorizeUserRequest(bob, &data.Request{Resource: "arn:app:orders/1234", Action: "GetAll"})
+	denied := db.AuthorizeUserRequest(bob, &data.Request{Resource: "arn:app:orders/archive/1234", Action: "GetA"})
+	literal := db.WithoutPolicyCache().AuthorizeUserRequest(bob, &data.Request{Resource: "arn:app:orders/<archive/.*>", Action: "GetA"})
+
+	//	Assert
+	if !allowed.Allowed || allowed.Policy != "Read orders" {
+		t.Errorf("AuthorizeUserRequest - Expected the request to be allowed by the glob policy, but got %+v", allowed)
+	}
+
+	if wrongAction.Allowed {
+		t.Errorf("AuthorizeUserRequest - Expected '?' to only match a single character, but got %+v", wrongAction)
+	}
+
+	if denied.Allowed || denied.Policy != "Deny archive" {
+		t.Errorf("AuthorizeUserRequest - Expected the request to be denied by the regexp policy, but got %+v", denied)
+	}
+
+	if !literal.Allowed || literal.Policy != "Read orders" {
+		t.Errorf("AuthorizeUserRequest - Expected the glob policy to match '<' and '>' literally, but got %+v", literal)
+	}
+
+}
+
+func TestManager_DoPoliciesAllow_NotActionsAndNotResources_Successful(t *testing.T) {
+
+	//	Arrange
+	mgr := &data.Manager{}
+	pols := map[string]data.Policy{
+		"Orders except delete": {
+			Name:       "Orders except delete",
+			Effect:     policy.Allow,
+			Syntax:     policy.Glob,
+			Resources:  []string{"arn:app:orders/*"},
+			NotActions: []string{"Delete*"},
+		},
+		"Deny system except GetUser": {
+			Name:       "Deny system except GetUser",
+			Effect:     policy.Deny,
+			Resources:  []string{"System"},
+			NotActions: []string{"GetUser"},
+		},
+		"System access": {
+			Name:      "System access",
+			Effect:    policy.Allow,
+			Resources: []string{"System"},
+			Actions:   []string{"<.*>"},
+		},
+		"Read everything but billing": {
+			Name:         "Read everything but billing",
+			Effect:       policy.Allow,
+			NotResources: []string{"Billing<.*>"},
+			Actions:      []string{"Read"},
+		},
+	}
+
+	tests := []struct {
+		request *data.Request
+		want    bool
+	}{
+		{&data.Request{Resource: "arn:app:orders/1234", Action: "Update"}, true},
+		{&data.Request{Resource: "arn:app:orders/1234", Action: "DeleteOrder"}, false},
+		{&data.Request{Resource: "System", Action: "GetUser"}, true},
+		{&data.Request{Resource: "System", Action: "AddUser"}, false},
+		{&data.Request{Resource: "Serenity", Action: "Read"}, true},
+		{&data.Request{Resource: "BillingReports", Action: "Read"}, false},
+	}
+
+	compiled, err := data.CompilePolicies(pols)
+	if err != nil {
+		t.Fatalf("CompilePolicies - Should compile without error, but got: %s", err)
+	}
+
+	for _, test := range tests {
+		//	Act
+		err := mgr.DoPoliciesAllow(test.request, pols)
+		_, compiledErr := compiled.Decide(test.request)
+
+		//	Assert
+		if (err == nil) != test.want || (compiledErr == nil) != test.want {
+			t.Errorf("DoPoliciesAllow - Expected %+v to be allowed: %v, but got %v (compiled: %v)", test.request, test.want, err, compiledErr)
+		}
+	}
+
+}
+
+func TestManager_DoPoliciesAllow_UnresolvedNotVariables_FailClosed(t *testing.T) {
+
+	//	Arrange
+	mgr := &data.Manager{}
+	allowPols := map[string]data.Policy{
+		"Allow except own deck": {Name: "Allow except own deck", Effect: policy.Allow, NotResources: []string{"deck:${context.deck}"}, Actions: []string{"Visit"}},
+	}
+	denyPols := map[string]data.Policy{
+		"Allow decks":          {Name: "Allow decks", Effect: policy.Allow, Resources: []string{"deck:<.*>"}, Actions: []string{"Visit"}},
+		"Deny except own deck": {Name: "Deny except own deck", Effect: policy.Deny, NotResources: []string{"deck:${context.deck}"}, Actions: []string{"Visit"}},
+	}
+
+	//	Act
+	allowResolved := mgr.DoPoliciesAllow(&data.Request{Resource: "deck:Cargo", Action: "Visit", Context: map[string]string{"deck": "Engine"}}, allowPols)
+	allowUnresolved := mgr.DoPoliciesAllow(&data.Request{Resource: "deck:Cargo", Action: "Visit"}, allowPols)
+	denyResolved := mgr.DoPoliciesAllow(&data.Request{Resource: "deck:Cargo", Action: "Visit", Context: map[string]string{"deck": "Cargo"}}, denyPols)
+	denyUnresolved := mgr.DoPoliciesAllow(&data.Request{Resource: "deck:Cargo", Action: "Visit"}, denyPols)
+
+	//	Assert
+	if allowResolved != nil || allowUnresolved == nil {
+		t.Errorf("DoPoliciesAllow - Expected the allow policy to apply only when its variable is resolved, but got %v and %v", allowResolved, allowUnresolved)
+	}
+
+	if denyResolved != nil || denyUnresolved == nil {
+		t.Errorf("DoPoliciesAllow - Expected the deny policy to deny everything when its variable isn't resolved, but got %v and %v", denyResolved, denyUnresolved)
+	}
+
+}
+
+func TestManager_AuthorizeUserActions_ReturnsDecisionForEachAction(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	db.AddResource(contextUser, "Orders", "")
+	db.AddActionToResource(contextUser, "Orders", "Read", "Update", "Delete", "Approve")
+	db.AddUser(contextUser, data.User{Name: "bob"}, "testpass")
+	db.AddPolicy(contextUser, data.Policy{Name: "Edit orders", Effect: policy.Allow, Resources: []string{"Orders"}, Actions: []string{"Read", "Update", "Delete"}})
+	db.AddPolicy(contextUser, data.Policy{Name: "Keep orders", Effect: policy.Deny, Resources: []string{"Orders"}, Actions: []string{"Delete"}})
+	db.AttachPolicyToUsers(contextUser, "Edit orders", "bob")
+	db.AttachPolicyToUsers(contextUser, "Keep orders", "bob")
+
+	//	Act
+	decisions, err := db.AuthorizeUserActions(data.User{Name: "bob"}, "Orders", nil)
+	_, errMissing := db.AuthorizeUserActions(data.User{Name: "bob"}, "Missing", nil)
+
+	//	Assert
+	if err != nil {
+		t.Fatalf("AuthorizeUserActions - Should decide the actions without error, but got: %s", err)
+	}
+
+	expected := map[string]bool{"Read": true, "Update": true, "Delete": false, "Approve": false}
+	if len(decisions) != len(expected) {
+		t.Fatalf("AuthorizeUserActions - Expected a decision for each of the %v actions, but got %+v", len(expected), decisions)
+	}
+
+	for _, decision := range decisions {
+		if decision.Allowed != expected[decision.Action] || decision.User != "bob" || decision.Resource != "Orders" {
+			t.Errorf("AuthorizeUserActions - Expected action %s to be allowed: %v, but got %+v", decision.Action, expected[decision.Action], decision)
+		}
+	}
+
+	if errMissing != data.ErrNotFound {
+		t.Errorf("AuthorizeUserActions - Expected ErrNotFound for a resource that doesn't exist, but got: %v", errMissing)
+	}
+
+}
@@ -0,0 +1,424 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/danesparza/iamserver/policy"
+)
+
+// AWS IAM policy documents can be imported and exported.  The elements map like this:
+// - Id: The name of the policy (if a name isn't given when importing)
+// - Sid, Effect, Action, NotAction, Resource, NotResource: The statement fields of the same name
+// - Condition: The StringEquals, StringNotEquals, StringLike and StringNotLike operators
+//
+// Condition keys are request context keys (so 'aws:SourceIp' is 'context.aws:SourceIp').
+//
+// Imported policies use the glob syntax (the same wildcards as AWS).  Policy variables map like this:
+// - ${aws:username}: ${user.name}
+// - ${aws:PrincipalTag/<key>}: ${user.attributes.<key>}
+// - ${*}, ${?}, ${$}: The (escaped) literal characters
+// - ${<key>}: ${context.<key>}
+//
+// Anything else (like Principal, or other condition operators) isn't supported.  Rather than dropping it,
+// import and export fail with a list of everything that isn't supported
+const (
+	awsPolicyVersion = "2012-10-17"
+	awsUserName      = "aws:username"
+	awsPrincipalTag  = "aws:PrincipalTag/"
+)
+
+// AWSPolicyDocument is an AWS IAM policy document
+type AWSPolicyDocument struct {
+	Version   string         `json:"Version"`
+	ID        string         `json:"Id,omitempty"`
+	Statement []AWSStatement `json:"Statement"`
+}
+
+// AWSStatement is a statement of an AWS IAM policy document
+type AWSStatement struct {
+	Sid         string                          `json:"Sid,omitempty"`
+	Effect      string                          `json:"Effect"`
+	Action      AWSValues                       `json:"Action,omitempty"`
+	NotAction   AWSValues                       `json:"NotAction,omitempty"`
+	Resource    AWSValues                       `json:"Resource,omitempty"`
+	NotResource AWSValues                       `json:"NotResource,omitempty"`
+	Condition   map[string]map[string]AWSValues `json:"Condition,omitempty"`
+}
+
+// AWSValues are the values of an AWS policy element.  A single value can be given as a string
+type AWSValues []string
+
+// UnmarshalJSON reads either a string or a list of strings
+func (v *AWSValues) UnmarshalJSON(b []byte) error {
+	single := ""
+	if err := json.Unmarshal(b, &single); err == nil {
+		*v = AWSValues{single}
+		return nil
+	}
+
+	list := []string{}
+	if err := json.Unmarshal(b, &list); err != nil {
+		return fmt.Errorf("Expected a string or a list of strings, but got %s", b)
+	}
+
+	*v = list
+	return nil
+}
+
+// ImportAWSPolicy converts an AWS IAM policy document to a policy with the given name (or the Id of
+// the document, if the name is blank).  If the document has anything that isn't supported, an error
+// listing all of it is returned
+func ImportAWSPolicy(name string, document []byte) (Policy, error) {
+	//	Our return item
+	retval := Policy{}
+	problems := []string{}
+
+	elements := map[string]json.RawMessage{}
+	if err := json.Unmarshal(document, &elements); err != nil {
+		return retval, fmt.Errorf("Policy document is not valid JSON: %s", err)
+	}
+
+	rawStatements := []json.RawMessage{}
+	for _, key := range sortedKeys(elements) {
+		var err error
+		switch key {
+		case "Version":
+			version := ""
+			if err = json.Unmarshal(elements[key], &version); err == nil && version != awsPolicyVersion && version != "2008-10-17" {
+				problems = append(problems, fmt.Sprintf("Version '%s' is not supported", version))
+			}
+		case "Id":
+			err = json.Unmarshal(elements[key], &retval.Name)
+		case "Statement":
+			//	A single statement can be given without a list
+			if strings.HasPrefix(strings.TrimSpace(string(elements[key])), "{") {
+				rawStatements = append(rawStatements, elements[key])
+			} else {
+				err = json.Unmarshal(elements[key], &rawStatements)
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("'%s' is not supported", key))
+		}
+
+		if err != nil {
+			return retval, fmt.Errorf("Policy document element '%s' is not valid: %s", key, err)
+		}
+	}
+
+	for i, rawStatement := range rawStatements {
+		statement, statementProblems, err := importAWSStatement(rawStatement)
+		if err != nil {
+			return retval, fmt.Errorf("Statement[%v] is not valid: %s", i, err)
+		}
+
+		for _, problem := range statementProblems {
+			problems = append(problems, fmt.Sprintf("Statement[%v]: %s", i, problem))
+		}
+		retval.Statements = append(retval.Statements, statement)
+	}
+
+	if len(problems) > 0 {
+		return Policy{}, fmt.Errorf("Policy document has elements that aren't supported: %s", strings.Join(problems, "; "))
+	}
+
+	//	The given name wins over the Id of the document
+	if name != "" {
+		retval.Name = name
+	}
+
+	if retval.Name == "" {
+		return Policy{}, fmt.Errorf("Policy name is required (the document doesn't have an Id)")
+	}
+
+	retval.Syntax = policy.Glob
+	return retval, nil
+}
+
+// importAWSStatement converts a statement of an AWS IAM policy document.  It returns the
+// statement, along with descriptions of anything in the statement that isn't supported
+func importAWSStatement(rawStatement json.RawMessage) (Statement, []string, error) {
+	retval := Statement{}
+	problems := []string{}
+
+	elements := map[string]json.RawMessage{}
+	if err := json.Unmarshal(rawStatement, &elements); err != nil {
+		return retval, problems, err
+	}
+
+	awsStatement := AWSStatement{}
+	if err := json.Unmarshal(rawStatement, &awsStatement); err != nil {
+		return retval, problems, err
+	}
+
+	for _, key := range sortedKeys(elements) {
+		switch key {
+		case "Sid", "Effect", "Action", "NotAction", "Resource", "NotResource", "Condition":
+		default:
+			problems = append(problems, fmt.Sprintf("'%s' is not supported", key))
+		}
+	}
+
+	retval.Sid = awsStatement.Sid
+
+	switch awsStatement.Effect {
+	case "Allow":
+		retval.Effect = policy.Allow
+	case "Deny":
+		retval.Effect = policy.Deny
+	default:
+		problems = append(problems, fmt.Sprintf("Effect '%s' is not supported", awsStatement.Effect))
+	}
+
+	var fieldProblems []string
+	retval.Actions, fieldProblems = importAWSValues(awsStatement.Action)
+	problems = append(problems, fieldProblems...)
+	retval.NotActions, fieldProblems = importAWSValues(awsStatement.NotAction)
+	problems = append(problems, fieldProblems...)
+	retval.Resources, fieldProblems = importAWSValues(awsStatement.Resource)
+	problems = append(problems, fieldProblems...)
+	retval.NotResources, fieldProblems = importAWSValues(awsStatement.NotResource)
+	problems = append(problems, fieldProblems...)
+
+	for _, operator := range sortedKeys(awsStatement.Condition) {
+		switch operator {
+		case policy.StringEquals, policy.StringNotEquals, policy.StringLike, policy.StringNotLike:
+		default:
+			problems = append(problems, fmt.Sprintf("Condition operator '%s' is not supported", operator))
+			continue
+		}
+
+		if retval.Conditions == nil {
+			retval.Conditions = Conditions{}
+		}
+		retval.Conditions[operator] = map[string][]string{}
+		for key, values := range awsStatement.Condition[operator] {
+			retval.Conditions[operator]["context."+key], fieldProblems = importAWSValues(values)
+			problems = append(problems, fieldProblems...)
+		}
+	}
+
+	return retval, problems, nil
+}
+
+// importAWSValues converts each of the AWS values to a glob pattern.  See importAWSValue
+func importAWSValues(values AWSValues) ([]string, []string) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	retval := []string{}
+	problems := []string{}
+	for _, value := range values {
+		pattern, err := importAWSValue(value)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		retval = append(retval, pattern)
+	}
+
+	return retval, problems
+}
+
+// importAWSValue converts an AWS value (with AWS wildcards and policy variables) to a glob pattern
+func importAWSValue(value string) (string, error) {
+	retval := ""
+
+	for value != "" {
+		//	Policy variables
+		if strings.HasPrefix(value, variableStart) {
+			if end := strings.Index(value, variableEnd); end >= 0 {
+				name := value[len(variableStart):end]
+				switch {
+				case name == "*" || name == "?" || name == "$":
+					retval += `\` + name
+				case name == awsUserName:
+					retval += variableStart + "user.name" + variableEnd
+				case strings.HasPrefix(name, awsPrincipalTag):
+					retval += variableStart + "user.attributes." + strings.TrimPrefix(name, awsPrincipalTag) + variableEnd
+				case strings.ContainsAny(name, ", "):
+					return "", fmt.Errorf("Policy variable '%s' (with a default value) is not supported", value[:end+1])
+				default:
+					retval += variableStart + "context." + name + variableEnd
+				}
+
+				value = value[end+1:]
+				continue
+			}
+		}
+
+		//	AWS doesn't have an escape character, so a '\' only matches itself.  A '{' right
+		//	after an escaped '$' is escaped too, so the two don't look like a policy variable
+		switch {
+		case value[0] == '\\':
+			retval += `\\`
+		case value[0] == '{' && strings.HasSuffix(retval, "$"):
+			retval += `\{`
+		default:
+			retval += value[:1]
+		}
+		value = value[1:]
+	}
+
+	return retval, nil
+}
+
+// ExportAWSPolicy converts a policy to an AWS IAM policy document.  If the policy uses anything that
+// doesn't have an AWS equivalent (like regexp templates or ${user.groups}), an error listing all of it is returned
+func ExportAWSPolicy(p Policy) (AWSPolicyDocument, error) {
+	retval := AWSPolicyDocument{Version: awsPolicyVersion, ID: p.Name, Statement: []AWSStatement{}}
+	problems := []string{}
+
+	for i, s := range p.statements() {
+		awsStatement := AWSStatement{Sid: s.Sid}
+		statementProblems := []string{}
+
+		switch s.Effect {
+		case policy.Allow:
+			awsStatement.Effect = "Allow"
+		case policy.Deny:
+			awsStatement.Effect = "Deny"
+		}
+
+		var fieldProblems []string
+		awsStatement.Action, fieldProblems = exportPatterns(s.Actions, p.Syntax)
+		statementProblems = append(statementProblems, fieldProblems...)
+		awsStatement.NotAction, fieldProblems = exportPatterns(s.NotActions, p.Syntax)
+		statementProblems = append(statementProblems, fieldProblems...)
+		awsStatement.Resource, fieldProblems = exportPatterns(s.Resources, p.Syntax)
+		statementProblems = append(statementProblems, fieldProblems...)
+		awsStatement.NotResource, fieldProblems = exportPatterns(s.NotResources, p.Syntax)
+		statementProblems = append(statementProblems, fieldProblems...)
+
+		//	Condition values are escaped like globs (whatever the syntax of the policy)
+		for operator, keys := range s.Conditions {
+			if awsStatement.Condition == nil {
+				awsStatement.Condition = map[string]map[string]AWSValues{}
+			}
+			awsStatement.Condition[operator] = map[string]AWSValues{}
+			for key, values := range keys {
+				awsStatement.Condition[operator][strings.TrimPrefix(key, "context.")], fieldProblems = exportPatterns(values, policy.Glob)
+				statementProblems = append(statementProblems, fieldProblems...)
+			}
+		}
+
+		for _, problem := range statementProblems {
+			problems = append(problems, fmt.Sprintf("Statement[%s]: %s", statementID(s, i), problem))
+		}
+		retval.Statement = append(retval.Statement, awsStatement)
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return AWSPolicyDocument{}, fmt.Errorf("Policy has elements that can't be exported: %s", strings.Join(problems, "; "))
+	}
+
+	return retval, nil
+}
+
+// exportPatterns converts each of the patterns (in the given syntax) to an AWS value.  See exportPattern
+func exportPatterns(patterns []string, syntax string) (AWSValues, []string) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+
+	retval := AWSValues{}
+	problems := []string{}
+	for _, pattern := range patterns {
+		value, err := exportPattern(pattern, syntax)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		retval = append(retval, value)
+	}
+
+	return retval, problems
+}
+
+// exportPattern converts a pattern (in the given syntax) to an AWS value.  Regexp patterns can only be
+// exported if they don't have a template (so they only match themselves)
+func exportPattern(pattern, syntax string) (string, error) {
+	if syntax != policy.Glob && strings.IndexByte(pattern, regexpStartDelimeter) >= 0 {
+		return "", fmt.Errorf("Regexp pattern '%s' has no AWS equivalent", pattern)
+	}
+
+	retval := ""
+	for pattern != "" {
+		//	Policy variables
+		if strings.HasPrefix(pattern, variableStart) {
+			if end := strings.Index(pattern, variableEnd); end >= 0 {
+				variable, err := exportVariable(pattern[len(variableStart):end])
+				if err != nil {
+					return "", err
+				}
+
+				retval += variable
+				pattern = pattern[end+1:]
+				continue
+			}
+		}
+
+		char := pattern[:1]
+		switch {
+		case syntax == policy.Glob && char == `\` && len(pattern) > 1:
+			//	An escaped character (AWS uses ${*}, ${?} and ${$} for the special ones)
+			char = pattern[1:2]
+			pattern = pattern[1:]
+			if char == "*" || char == "?" || char == "$" {
+				char = variableStart + char + variableEnd
+			}
+		case syntax != policy.Glob && (char == "*" || char == "?"):
+			//	Regexp literals don't have wildcards
+			char = variableStart + char + variableEnd
+		case char == "$" && strings.HasPrefix(pattern, variableStart):
+			//	A '${' that isn't a policy variable (it doesn't end)
+			char = variableStart + char + variableEnd
+		}
+
+		retval += char
+		pattern = pattern[1:]
+	}
+
+	return retval, nil
+}
+
+// exportVariable converts the name of a policy variable to an AWS policy variable
+func exportVariable(name string) (string, error) {
+	switch {
+	case name == "user.name":
+		return variableStart + awsUserName + variableEnd, nil
+	case strings.HasPrefix(name, "user.attributes."):
+		return variableStart + awsPrincipalTag + strings.TrimPrefix(name, "user.attributes.") + variableEnd, nil
+	case strings.HasPrefix(name, "context."):
+		key := strings.TrimPrefix(name, "context.")
+
+		//	Context keys that look like another AWS variable would change meaning
+		if key != awsUserName && !strings.HasPrefix(key, awsPrincipalTag) && key != "*" && key != "?" && key != "$" {
+			return variableStart + key + variableEnd, nil
+		}
+	}
+
+	return "", fmt.Errorf("Policy variable '%s%s%s' has no AWS equivalent", variableStart, name, variableEnd)
+}
+
+// sortedKeys gets the keys of the map in order
+func sortedKeys(m interface{}) []string {
+	retval := []string{}
+	switch typed := m.(type) {
+	case map[string]json.RawMessage:
+		for key := range typed {
+			retval = append(retval, key)
+		}
+	case map[string]map[string]AWSValues:
+		for key := range typed {
+			retval = append(retval, key)
+		}
+	}
+
+	sort.Strings(retval)
+	return retval
+}
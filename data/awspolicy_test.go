@@ -0,0 +1,187 @@
+package data_test
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/danesparza/iamserver/data"
+	"github.com/danesparza/iamserver/policy"
+)
+
+func TestImportAWSPolicy_Document_DecidesLikeAWS(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	db.AddUser(contextUser, data.User{Name: "bob"}, "testpass")
+	db.SetUserAttributes(contextUser, "bob", map[string]string{"team": "engine"})
+
+	document := `{
+		"Version": "2012-10-17",
+		"Id": "Tickets",
+		"Statement": [
+			{
+				"Sid": "OwnTickets",
+				"Effect": "Allow",
+				"Action": ["tickets:Get*", "tickets:Update"],
+				"Resource": "tickets/${aws:PrincipalTag/team}/*",
+				"Condition": {"StringLike": {"aws:SourceIp": "10.*"}}
+			},
+			{
+				"Effect": "Deny",
+				"Action": "*",
+				"Resource": ["tickets/${aws:PrincipalTag/team}/${*}", "profile/${aws:username}"]
+			}
+		]
+	}`
+
+	//	Act
+	imported, err := data.ImportAWSPolicy("", []byte(document))
+	if err != nil {
+		t.Fatalf("ImportAWSPolicy - Should import the document without error, but got: %s", err)
+	}
+	_, err = db.AddPolicy(contextUser, imported)
+	if err != nil {
+		t.Fatalf("AddPolicy - Should add the imported policy without error, but got: %s", err)
+	}
+	db.AttachPolicyToUsers(contextUser, "Tickets", "bob")
+
+	//	Assert
+	if imported.Name != "Tickets" || imported.Syntax != policy.Glob || len(imported.Statements) != 2 {
+		t.Fatalf("ImportAWSPolicy - Expected a glob policy named after the Id with 2 statements, but got %+v", imported)
+	}
+
+	if !reflect.DeepEqual(imported.Statements[0].Conditions, data.Conditions{policy.StringLike: {"context.aws:SourceIp": {"10.*"}}}) {
+		t.Errorf("ImportAWSPolicy - Expected the condition key to be a request context key, but got %+v", imported.Statements[0].Conditions)
+	}
+
+	bob := data.User{Name: "bob"}
+	inside := map[string]string{"aws:SourceIp": "10.0.0.1"}
+	tests := []struct {
+		request *data.Request
+		want    bool
+	}{
+		{&data.Request{Resource: "tickets/engine/1234", Action: "tickets:GetComments", Context: inside}, true},
+		{&data.Request{Resource: "tickets/engine/1234", Action: "tickets:Delete", Context: inside}, false},
+		{&data.Request{Resource: "tickets/bridge/1234", Action: "tickets:Update", Context: inside}, false},
+		{&data.Request{Resource: "tickets/engine/1234", Action: "tickets:Update"}, false},
+		{&data.Request{Resource: "tickets/engine/*", Action: "tickets:Update", Context: inside}, false},
+	}
+
+	for _, test := range tests {
+		decision := db.AuthorizeUserRequest(bob, test.request)
+		if decision.Allowed != test.want {
+			t.Errorf("AuthorizeUserRequest - Expected %+v to be allowed: %v, but got %+v", test.request, test.want, decision)
+		}
+	}
+
+}
+
+func TestImportAWSPolicy_UnsupportedElements_ReturnsError(t *testing.T) {
+
+	//	Arrange
+	document := `{
+		"Version": "2012-10-17",
+		"Statement": {
+			"Effect": "Allow",
+			"Principal": {"AWS": "arn:aws:iam::123456789012:root"},
+			"Action": "s3:GetObject",
+			"Resource": "arn:aws:s3:::bucket/${aws:userid, 'nobody'}",
+			"Condition": {
+				"StringEquals": {"aws:SourceVpc": "vpc-1234"},
+				"NumericLessThan": {"s3:max-keys": "10"},
+				"StringLikeIfExists": {"aws:Referer": "*.example.com"}
+			}
+		}
+	}`
+
+	//	Act
+	_, err := data.ImportAWSPolicy("Unsupported", []byte(document))
+
+	//	Assert
+	if err == nil {
+		t.Fatalf("ImportAWSPolicy - Should not import a document with unsupported elements")
+	}
+
+	for _, unsupported := range []string{"'Principal'", "'NumericLessThan'", "'StringLikeIfExists'", "${aws:userid, 'nobody'}"} {
+		if !strings.Contains(err.Error(), unsupported) {
+			t.Errorf("ImportAWSPolicy - Expected the error to report %s, but got: %s", unsupported, err)
+		}
+	}
+
+}
+
+func TestExportAWSPolicy_ImportedAgain_SamePolicy(t *testing.T) {
+
+	//	Arrange
+	original := data.Policy{
+		Name:   "Crew",
+		Syntax: policy.Glob,
+		Statements: []data.Statement{
+			{Sid: "Fly", Effect: policy.Allow, Resources: []string{`ship:\*:${user.attributes.ship}`, `cargo\\*`}, Actions: []string{"Fly?"}},
+			{Effect: policy.Deny, NotResources: []string{"profile:${user.name}"}, NotActions: []string{"Get"}, Conditions: data.Conditions{policy.StringNotEquals: {"context.deck": {"${context.home}"}}}},
+		},
+	}
+
+	//	Act
+	document, err := data.ExportAWSPolicy(original)
+	if err != nil {
+		t.Fatalf("ExportAWSPolicy - Should export the policy without error, but got: %s", err)
+	}
+
+	encoded, _ := json.Marshal(document)
+	imported, err := data.ImportAWSPolicy("", encoded)
+
+	//	Assert
+	if err != nil {
+		t.Fatalf("ImportAWSPolicy - Should import the exported document without error, but got: %s", err)
+	}
+
+	if document.Statement[0].Resource[0] != "ship:${*}:${aws:PrincipalTag/ship}" || document.Statement[1].Effect != "Deny" {
+		t.Errorf("ExportAWSPolicy - Expected the AWS forms of escapes, variables and effects, but got %s", encoded)
+	}
+
+	if !reflect.DeepEqual(imported, original) {
+		t.Errorf("ImportAWSPolicy - Expected the exported policy to import as %+v, but got %+v", original, imported)
+	}
+
+}
+
+func TestExportAWSPolicy_NoAWSEquivalent_ReturnsError(t *testing.T) {
+
+	//	Arrange
+	tests := map[string]data.Policy{
+		"regexp template": {Name: "Regexp", Statements: []data.Statement{{Effect: policy.Allow, Resources: []string{"ship:<.*>"}, Actions: []string{"Fly"}}}},
+		"user groups":     {Name: "Groups", Syntax: policy.Glob, Statements: []data.Statement{{Effect: policy.Allow, Resources: []string{"group:${user.groups}"}, Actions: []string{"Get"}}}},
+	}
+
+	for name, testPolicy := range tests {
+		//	Act
+		_, err := data.ExportAWSPolicy(testPolicy)
+
+		//	Assert
+		if err == nil {
+			t.Errorf("ExportAWSPolicy - Should not export a policy with a %s", name)
+		}
+	}
+
+	//	Regexp literals only match themselves, so their wildcard characters are escaped
+	document, err := data.ExportAWSPolicy(data.Policy{Name: "Literal", Statements: []data.Statement{{Effect: policy.Allow, Resources: []string{"ship*"}, Actions: []string{"Fly"}}}})
+	if err != nil || document.Statement[0].Resource[0] != "ship${*}" {
+		t.Errorf("ExportAWSPolicy - Expected a regexp literal to be exported with escaped wildcards, but got %+v (%v)", document, err)
+	}
+
+}
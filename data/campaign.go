@@ -0,0 +1,548 @@
+package data
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danesparza/badger"
+	null "gopkg.in/guregu/null.v3"
+	"gopkg.in/guregu/null.v3/zero"
+)
+
+// Campaign statuses
+const (
+	CampaignOpen   = "open"
+	CampaignClosed = "closed"
+)
+
+// Review decisions
+const (
+	ReviewPending = "pending"
+	ReviewKeep    = "keep"
+	ReviewRevoke  = "revoke"
+)
+
+// Kinds of access that are reviewed:  a policy or role attached to a user, or a user's membership of a group
+const (
+	AccessPolicy = "policy"
+	AccessRole   = "role"
+	AccessGroup  = "group"
+)
+
+// Campaign represents an access review (certification) campaign.  When a campaign is started, the access of each
+// user is snapshotted:  each policy and role attached to a user, and each group the user is in (permanently or
+// through a time-bound grant), becomes a review item.  Reviewers decide to keep or revoke each item, and when the
+// campaign is closed the revocations are applied.
+//
+// Reviewers are the reviewers of every item, unless the item has owners.  Owners are the reviewers for the items of
+// a given group, role or policy (keyed like 'Group:crew').  A user never reviews their own access
+type Campaign struct {
+	Name        string              `json:"name"`
+	Description string              `json:"description"`
+	Reviewers   []string            `json:"reviewers"`
+	Owners      map[string][]string `json:"owners"`
+	Status      string              `json:"status"`
+	Users       []CampaignUser      `json:"users"`
+	Items       []ReviewItem        `json:"items"`
+	Version     int64               `json:"version"`
+	Created     time.Time           `json:"created"`
+	CreatedBy   string              `json:"created_by"`
+	Updated     time.Time           `json:"updated"`
+	UpdatedBy   string              `json:"updated_by"`
+	Closed      zero.Time           `json:"closed"`
+	ClosedBy    null.String         `json:"closed_by"`
+}
+
+// CampaignUser is the snapshot of a user's effective policies when the campaign was started
+type CampaignUser struct {
+	Name     string   `json:"name"`
+	Policies []string `json:"policies"`
+}
+
+// ReviewItem is a user's access to review.  Policies are the policies the user gets through the access (when the
+// campaign was started).  If the user only has the access through a grant, Expires is when the grant expires.
+// Revoked is set when the campaign is closed, if the revocation was applied
+type ReviewItem struct {
+	ID        int       `json:"id"`
+	User      string    `json:"user"`
+	Access    string    `json:"access"`
+	Name      string    `json:"name"`
+	Expires   zero.Time `json:"expires"`
+	Policies  []string  `json:"policies"`
+	Reviewers []string  `json:"reviewers"`
+	Decision  string    `json:"decision"`
+	Comment   string    `json:"comment"`
+	DecidedBy string    `json:"decided_by"`
+	Decided   zero.Time `json:"decided"`
+	Revoked   bool      `json:"revoked"`
+}
+
+// ReviewDecision is a reviewer's decision for a review item
+type ReviewDecision struct {
+	Decision string `json:"decision"`
+	Comment  string `json:"comment"`
+}
+
+// accessKeyType is the item type of each kind of access
+var accessKeyType = map[string]string{AccessPolicy: "Policy", AccessRole: "Role", AccessGroup: "Group"}
+
+// AddCampaign starts an access review campaign, with a snapshot of the access of each user
+func (store Manager) AddCampaign(context User, campaign Campaign) (Campaign, error) {
+	//	Our return item
+	retval := Campaign{}
+
+	//	Security check:  Are we authorized to perform this action?
+	if !store.IsUserRequestAuthorized(context, sysreqAddCampaign) {
+		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
+	}
+
+	//	Validate the campaign:
+	if campaign.Name == "" {
+		return retval, fmt.Errorf("Campaign name can't be blank")
+	}
+
+	if len(campaign.Reviewers) == 0 {
+		return retval, fmt.Errorf("Campaign must have at least one reviewer")
+	}
+
+	for key := range campaign.Owners {
+		parts := strings.SplitN(key, ":", 2)
+		if len(parts) != 2 || (parts[0] != "Group" && parts[0] != "Role" && parts[0] != "Policy") {
+			return retval, fmt.Errorf("Campaign owners should be keyed by 'Group:<name>', 'Role:<name>' or 'Policy:<name>', but got '%s'", key)
+		}
+	}
+
+	//	Update the status and created / updated fields:
+	campaign.Status = CampaignOpen
+	campaign.Created = time.Now()
+	campaign.Updated = time.Now()
+	campaign.CreatedBy = context.Name
+	campaign.UpdatedBy = context.Name
+	campaign.Closed = zero.Time{}
+	campaign.ClosedBy = null.String{}
+
+	err := store.update(newAuditEvent(context.Name, sysreqAddCampaign.Action, "Campaign", campaign.Name), func(txn *writeTxn) error {
+		//	First -- does the campaign exist already?
+		if _, err := txn.Get(GetKey("Campaign", campaign.Name)); err == nil {
+			return fmt.Errorf("Campaign already exists")
+		}
+
+		//	Next -- validate that each of the reviewers exist (and aren't deleted)
+		reviewers := append([]string{}, campaign.Reviewers...)
+		for _, owners := range campaign.Owners {
+			reviewers = append(reviewers, owners...)
+		}
+		for _, reviewer := range mergeItems(reviewers) {
+			user := User{}
+			if err := getItem(txn, GetKey("User", reviewer), &user); err != nil {
+				return fmt.Errorf("Reviewer %s doesn't exist", reviewer)
+			}
+			if user.Deleted.Valid {
+				return fmt.Errorf("Reviewer %s is deleted", reviewer)
+			}
+		}
+
+		//	Snapshot the access of each user
+		campaign.Users, campaign.Items = []CampaignUser{}, []ReviewItem{}
+		err := forEachItem(txn, GetKey("User"), func(val []byte) error {
+			user := User{}
+			if err := json.Unmarshal(val, &user); err != nil || user.Deleted.Valid {
+				return err
+			}
+
+			effective := resolveUserPolicies(txn, user, nil)
+			campaign.Users = append(campaign.Users, CampaignUser{Name: user.Name, Policies: sortedPolicyNames(effective.policies)})
+
+			for _, item := range getReviewItems(txn, user) {
+				item.ID = len(campaign.Items) + 1
+				item.Reviewers = campaign.reviewersFor(item)
+				item.Decision = ReviewPending
+				campaign.Items = append(campaign.Items, item)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		//	Save it to the database (as the first version):
+		campaign.Version = 1
+		return setItem(txn, GetKey("Campaign", campaign.Name), campaign)
+	})
+
+	//	If there was an error saving the data, report it:
+	if err != nil {
+		return retval, err
+	}
+
+	//	Set our retval:
+	retval = campaign
+
+	//	Return our data:
+	return retval, nil
+}
+
+// getReviewItems gets the access of the user to review:  the policies and roles attached to the user, the
+// groups the user is in (along with the policies the user gets through each of them), and the access of the
+// user's grants that haven't expired (unless the user has the same access permanently)
+func getReviewItems(txn reader, user User) []ReviewItem {
+	retval := []ReviewItem{}
+
+	for _, policyName := range user.Policies {
+		retval = append(retval, newReviewItem(txn, user, AccessPolicy, policyName))
+	}
+
+	for _, roleName := range user.Roles {
+		retval = append(retval, newReviewItem(txn, user, AccessRole, roleName))
+	}
+
+	for _, groupName := range user.Groups {
+		retval = append(retval, newReviewItem(txn, user, AccessGroup, groupName))
+	}
+
+	now := time.Now()
+	permanent := User{Policies: user.Policies, Roles: user.Roles, Groups: user.Groups}
+	for _, grant := range user.Grants {
+		if !now.Before(grant.Expires) || permanent.hasAccess(grant.Access, grant.Name) {
+			continue
+		}
+
+		item := newReviewItem(txn, user, grant.Access, grant.Name)
+		item.Expires = zero.TimeFrom(grant.Expires)
+		retval = append(retval, item)
+	}
+
+	return retval
+}
+
+// newReviewItem creates a review item for the user's access, with the policies the user gets through it
+func newReviewItem(txn reader, user User, access, name string) ReviewItem {
+	retval := ReviewItem{User: user.Name, Access: access, Name: name, Policies: []string{}}
+
+	switch access {
+	case AccessPolicy:
+		retval.Policies = []string{name}
+	case AccessRole:
+		role := Role{}
+		getItem(txn, GetKey("Role", name), &role)
+		retval.Policies = mergeItems(role.Policies)
+	case AccessGroup:
+		group := Group{}
+		getItem(txn, GetKey("Group", name), &group)
+
+		policies := append([]string{}, group.Policies...)
+		for _, roleName := range group.Roles {
+			role := Role{}
+			getItem(txn, GetKey("Role", roleName), &role)
+			policies = append(policies, role.Policies...)
+		}
+		retval.Policies = mergeItems(policies)
+	}
+
+	return retval
+}
+
+// reviewersFor gets the reviewers of the item:  the owners of the group, role or policy (if it has any),
+// otherwise the campaign reviewers.  The user the item is for is never one of its reviewers
+func (campaign Campaign) reviewersFor(item ReviewItem) []string {
+	reviewers := campaign.Owners[string(GetKey(accessKeyType[item.Access], item.Name))]
+	if len(reviewers) == 0 {
+		reviewers = campaign.Reviewers
+	}
+
+	return removeItem(mergeItems(reviewers), item.User)
+}
+
+// GetCampaign gets an access review campaign.  Users that aren't authorized to get campaigns can still get a
+// campaign they are a reviewer in -- with only the items they review
+func (store Manager) GetCampaign(context User, campaignName string) (Campaign, error) {
+	//	Our return item
+	retval := Campaign{}
+
+	//	Security check:  Are we authorized to perform this action?
+	authorized := store.IsUserRequestAuthorized(context, sysreqGetCampaign)
+
+	err := store.systemdb.View(func(txn *badger.Txn) error {
+		return getItem(txn, GetKey("Campaign", campaignName), &retval)
+	})
+
+	//	If there was an error, report it:
+	if err != nil && authorized {
+		return Campaign{}, fmt.Errorf("Campaign does not exist")
+	}
+
+	if authorized {
+		return retval, nil
+	}
+
+	//	Otherwise, only include the items the user reviews
+	items := []ReviewItem{}
+	for _, item := range retval.Items {
+		if containsItem(item.Reviewers, context.Name) {
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		return Campaign{}, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
+	}
+
+	retval.Users = []CampaignUser{}
+	retval.Items = items
+	return retval, nil
+}
+
+// GetAllCampaigns gets all access review campaigns in the system (without their snapshots and items)
+func (store Manager) GetAllCampaigns(context User) ([]Campaign, error) {
+	//	Our return item
+	retval := []Campaign{}
+
+	//	Security check:  Are we authorized to perform this action?
+	if !store.IsUserRequestAuthorized(context, sysreqGetAllCampaigns) {
+		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
+	}
+
+	err := store.systemdb.View(func(txn *badger.Txn) error {
+		return forEachItem(txn, GetKey("Campaign", ""), func(val []byte) error {
+			campaign := Campaign{}
+			if err := json.Unmarshal(val, &campaign); err != nil {
+				return err
+			}
+
+			campaign.Users, campaign.Items = nil, nil
+			retval = append(retval, campaign)
+			return nil
+		})
+	})
+
+	//	If there was an error, report it:
+	if err != nil {
+		return []Campaign{}, fmt.Errorf("Problem getting the list of items: %s", err)
+	}
+
+	//	Return our data:
+	return retval, nil
+}
+
+// RecordReviewDecision records a decision (keep or revoke) for an item of an open campaign.  The decision can be
+// made by one of the item's reviewers, or by a user that is authorized to record review decisions.  The decision
+// can be changed until the campaign is closed
+func (store Manager) RecordReviewDecision(context User, campaignName string, itemID int, decision ReviewDecision) (ReviewItem, error) {
+	//	Our return item
+	retval := ReviewItem{}
+
+	if decision.Decision != ReviewKeep && decision.Decision != ReviewRevoke {
+		return retval, fmt.Errorf("Review decision should be '%s' or '%s'", ReviewKeep, ReviewRevoke)
+	}
+
+	authorized := store.IsUserRequestAuthorized(context, sysreqRecordReviewDecision)
+
+	err := store.update(newAuditEvent(context.Name, sysreqRecordReviewDecision.Action, "Campaign", campaignName), func(txn *writeTxn) error {
+		//	First -- does the campaign (and the item) exist?
+		campaign := Campaign{}
+		if err := getItem(txn, GetKey("Campaign", campaignName), &campaign); err != nil {
+			return fmt.Errorf("Campaign does not exist")
+		}
+
+		if campaign.Status != CampaignOpen {
+			return fmt.Errorf("Campaign is closed")
+		}
+
+		if itemID < 1 || itemID > len(campaign.Items) {
+			return fmt.Errorf("Review item %v does not exist", itemID)
+		}
+		item := &campaign.Items[itemID-1]
+
+		//	Security check:  Is this one of the item's reviewers (and not the user the item is for)?
+		if item.User == context.Name {
+			return fmt.Errorf("User %s can't review their own access", context.Name)
+		}
+
+		if !authorized && !containsItem(item.Reviewers, context.Name) {
+			return fmt.Errorf("User %s is not authorized to perform the action", context.Name)
+		}
+
+		//	Make sure the campaign hasn't changed since the caller last read it
+		if err := store.checkVersion(campaign.Version); err != nil {
+			return err
+		}
+
+		//	Record the decision and update the updated fields:
+		item.Decision = decision.Decision
+		item.Comment = decision.Comment
+		item.DecidedBy = context.Name
+		item.Decided = zero.TimeFrom(time.Now())
+		retval = *item
+
+		campaign.Updated = time.Now()
+		campaign.UpdatedBy = context.Name
+		campaign.Version++
+		return setItem(txn, GetKey("Campaign", campaign.Name), campaign)
+	})
+
+	//	If there was an error saving the data, report it:
+	if err != nil {
+		return retval, err
+	}
+
+	//	Return our data:
+	return retval, nil
+}
+
+// CloseCampaign closes an access review campaign and applies the revocations (as part of the same transaction):
+// revoked policies and roles are detached from their users, and users are removed from revoked groups.  Pending
+// items are kept.  Access that was already removed since the campaign was started is skipped
+func (store Manager) CloseCampaign(context User, campaignName string) (Campaign, error) {
+	//	Our return item
+	retval := Campaign{}
+
+	//	Security check:  Are we authorized to perform this action?
+	if !store.IsUserRequestAuthorized(context, sysreqCloseCampaign) {
+		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
+	}
+
+	err := store.update(newAuditEvent(context.Name, sysreqCloseCampaign.Action, "Campaign", campaignName), func(txn *writeTxn) error {
+		//	First -- does the campaign exist (and is it open)?
+		retval = Campaign{}
+		if err := getItem(txn, GetKey("Campaign", campaignName), &retval); err != nil {
+			return fmt.Errorf("Campaign does not exist")
+		}
+
+		if retval.Status != CampaignOpen {
+			return fmt.Errorf("Campaign is already closed")
+		}
+
+		//	Make sure the campaign hasn't changed since the caller last read it
+		if err := store.checkVersion(retval.Version); err != nil {
+			return err
+		}
+
+		//	Apply each of the revocations
+		for i := range retval.Items {
+			item := &retval.Items[i]
+			if item.Decision != ReviewRevoke {
+				continue
+			}
+
+			revoked, err := revokeAccess(txn, context, *item)
+			if err != nil {
+				return err
+			}
+			item.Revoked = revoked
+		}
+
+		//	Close the campaign and update the updated fields:
+		retval.Status = CampaignClosed
+		retval.Closed = zero.TimeFrom(time.Now())
+		retval.ClosedBy = null.StringFrom(context.Name)
+		retval.Updated = time.Now()
+		retval.UpdatedBy = context.Name
+		retval.Version++
+		return setItem(txn, GetKey("Campaign", retval.Name), retval)
+	})
+
+	//	If there was an error saving the data, report it:
+	if err != nil {
+		return retval, err
+	}
+
+	//	Return our data:
+	return retval, nil
+}
+
+// revokeAccess removes the user's access for the review item (from both the user and the policy, role or group).
+// The access is removed whether the user has it permanently or through a grant (or both).  Returns false if the
+// user doesn't have the access anymore
+func revokeAccess(txn *writeTxn, context User, item ReviewItem) (bool, error) {
+	user := User{}
+	if err := getItem(txn, GetKey("User", item.User), &user); err != nil || user.Deleted.Valid {
+		return false, nil
+	}
+
+	//	Remove the access from the user
+	var attached *[]string
+	switch item.Access {
+	case AccessPolicy:
+		attached = &user.Policies
+	case AccessRole:
+		attached = &user.Roles
+	case AccessGroup:
+		attached = &user.Groups
+	default:
+		return false, fmt.Errorf("Review item %v has an unknown kind of access: %s", item.ID, item.Access)
+	}
+
+	grants := removeGrant(user.Grants, item.Access, item.Name)
+	if !containsItem(*attached, item.Name) && len(grants) == len(user.Grants) {
+		return false, nil
+	}
+	*attached = removeItem(*attached, item.Name)
+	user.Grants = grants
+
+	user.Updated = time.Now()
+	user.UpdatedBy = context.Name
+	user.Version++
+	if err := setItem(txn, GetKey("User", user.Name), user); err != nil {
+		return false, err
+	}
+
+	//	Remove the user from the policy, role or group
+	return true, detachUser(txn, user, item.Access, item.Name)
+}
+
+// WriteCSV writes the campaign report as CSV:  a header row, then a row for each review item.  Cells that a
+// spreadsheet would treat as a formula (starting with =, +, - or @) are prefixed with a ' so they're shown as text
+func (campaign Campaign) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+
+	rows := [][]string{{"campaign", "id", "user", "access", "name", "expires", "policies", "reviewers", "decision", "comment", "decided_by", "decided", "revoked"}}
+	for _, item := range campaign.Items {
+		decided, expires := "", ""
+		if item.Decided.Valid {
+			decided = item.Decided.Time.Format(time.RFC3339)
+		}
+		if item.Expires.Valid {
+			expires = item.Expires.Time.Format(time.RFC3339)
+		}
+
+		row := []string{
+			campaign.Name,
+			strconv.Itoa(item.ID),
+			item.User,
+			item.Access,
+			item.Name,
+			expires,
+			strings.Join(item.Policies, ";"),
+			strings.Join(item.Reviewers, ";"),
+			item.Decision,
+			item.Comment,
+			item.DecidedBy,
+			decided,
+			strconv.FormatBool(item.Revoked),
+		}
+		for i := range row {
+			row[i] = neutralizeCSVFormula(row[i])
+		}
+
+		rows = append(rows, row)
+	}
+
+	if err := writer.WriteAll(rows); err != nil {
+		return fmt.Errorf("Problem writing the campaign report: %s", err)
+	}
+
+	return nil
+}
+
+// neutralizeCSVFormula prefixes the value with a ' if a spreadsheet would treat it as a formula
+func neutralizeCSVFormula(value string) string {
+	if value != "" && strings.ContainsAny(value[:1], "=+-@") {
+		return "'" + value
+	}
+
+	return value
+}
@@ -0,0 +1,272 @@
+package data_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/danesparza/iamserver/data"
+	"github.com/danesparza/iamserver/policy"
+)
+
+func TestManager_Campaign_ReviewAndClose_RevokesAccess(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	db.AddResource(contextUser, "Serenity", "")
+	for _, name := range []string{"alice", "bob", "carol", "jayne"} {
+		db.AddUser(contextUser, data.User{Name: name}, "testpass")
+	}
+	db.AddPolicy(contextUser, data.Policy{Name: "Fly", Effect: policy.Allow, Resources: []string{"Serenity"}, Actions: []string{"Fly"}})
+	db.AddPolicy(contextUser, data.Policy{Name: "Land", Effect: policy.Allow, Resources: []string{"Serenity"}, Actions: []string{"Land"}})
+	db.AttachPolicyToUsers(contextUser, "Fly", "bob")
+	db.AttachPolicyToUsers(contextUser, "Land", "alice")
+	db.AddRole(contextUser, "pilot", "")
+	db.AttachPoliciesToRole(contextUser, "pilot", "Land")
+	db.AddGroup(contextUser, "crew", "")
+	db.AttachRoleToGroups(contextUser, "pilot", "crew")
+	db.AddUsersToGroup(contextUser, "crew", "jayne")
+
+	//	Act
+	campaign, err := db.AddCampaign(contextUser, data.Campaign{
+		Name:      "Q3",
+		Reviewers: []string{"alice", "carol"},
+		Owners:    map[string][]string{"Group:crew": {"carol"}},
+	})
+
+	//	Assert
+	if err != nil {
+		t.Fatalf("AddCampaign - Should start the campaign without error, but got: %s", err)
+	}
+
+	items := map[string]data.ReviewItem{}
+	for _, item := range campaign.Items {
+		items[item.User+" "+item.Access+" "+item.Name] = item
+	}
+
+	aliceLand, bobFly, jayneCrew := items["alice policy Land"], items["bob policy Fly"], items["jayne group crew"]
+	if len(campaign.Items) != 3 || campaign.Status != data.CampaignOpen || bobFly.Decision != data.ReviewPending {
+		t.Fatalf("AddCampaign - Expected 3 pending items in an open campaign, but got %+v", campaign)
+	}
+
+	if len(aliceLand.Reviewers) != 1 || aliceLand.Reviewers[0] != "carol" || len(jayneCrew.Reviewers) != 1 || jayneCrew.Reviewers[0] != "carol" || len(bobFly.Reviewers) != 2 {
+		t.Errorf("AddCampaign - Expected the group owner to review the group, and nobody to review their own access, but got %+v", campaign.Items)
+	}
+
+	if len(jayneCrew.Policies) != 1 || jayneCrew.Policies[0] != "Land" {
+		t.Errorf("AddCampaign - Expected the group item to have the policies of the group roles, but got %+v", jayneCrew)
+	}
+
+	//	Only reviewers can decide (and never for their own access)
+	if _, err := db.RecordReviewDecision(data.User{Name: "alice"}, "Q3", aliceLand.ID, data.ReviewDecision{Decision: data.ReviewKeep}); err == nil {
+		t.Errorf("RecordReviewDecision - Should not let a user review their own access")
+	}
+
+	if _, err := db.RecordReviewDecision(data.User{Name: "alice"}, "Q3", jayneCrew.ID, data.ReviewDecision{Decision: data.ReviewRevoke}); err == nil {
+		t.Errorf("RecordReviewDecision - Should not let a user review an item they aren't a reviewer of")
+	}
+
+	if _, err := db.RecordReviewDecision(data.User{Name: "carol"}, "Q3", jayneCrew.ID, data.ReviewDecision{Decision: "maybe"}); err == nil {
+		t.Errorf("RecordReviewDecision - Should not record a decision that isn't keep or revoke")
+	}
+
+	decided, err := db.RecordReviewDecision(data.User{Name: "alice"}, "Q3", bobFly.ID, data.ReviewDecision{Decision: data.ReviewRevoke, Comment: "Left the crew"})
+	if err != nil || decided.Decision != data.ReviewRevoke || decided.DecidedBy != "alice" || !decided.Decided.Valid {
+		t.Errorf("RecordReviewDecision - Should record the reviewer's decision, but got %+v (%v)", decided, err)
+	}
+	db.RecordReviewDecision(data.User{Name: "carol"}, "Q3", jayneCrew.ID, data.ReviewDecision{Decision: data.ReviewRevoke})
+	db.RecordReviewDecision(data.User{Name: "carol"}, "Q3", aliceLand.ID, data.ReviewDecision{Decision: data.ReviewKeep})
+
+	//	Reviewers only see the items they review
+	reviewed, err := db.GetCampaign(data.User{Name: "alice"}, "Q3")
+	if err != nil || len(reviewed.Items) != 1 || reviewed.Items[0].ID != bobFly.ID {
+		t.Errorf("GetCampaign - Expected the reviewer to get the item they review, but got %+v (%v)", reviewed.Items, err)
+	}
+
+	//	Closing the campaign applies the revocations
+	closed, err := db.CloseCampaign(contextUser, "Q3")
+	if err != nil {
+		t.Fatalf("CloseCampaign - Should close the campaign without error, but got: %s", err)
+	}
+
+	if closed.Status != data.CampaignClosed || !closed.Closed.Valid || !closed.Items[bobFly.ID-1].Revoked || closed.Items[aliceLand.ID-1].Revoked {
+		t.Errorf("CloseCampaign - Expected a closed campaign with the revoked items marked, but got %+v", closed)
+	}
+
+	bob, _ := db.GetUser(contextUser, "bob")
+	fly, _ := db.GetPolicy(contextUser, "Fly")
+	jayne, _ := db.GetUser(contextUser, "jayne")
+	crew, _ := db.GetGroup(contextUser, "crew")
+	alice, _ := db.GetUser(contextUser, "alice")
+	if len(bob.Policies) != 0 || len(fly.Users) != 0 || len(jayne.Groups) != 0 || len(crew.Users) != 0 || len(alice.Policies) != 1 {
+		t.Errorf("CloseCampaign - Expected the revoked access to be removed (and the kept access to stay), but got %+v, %+v, %+v, %+v", bob, fly, jayne, crew)
+	}
+
+	if _, err := db.RecordReviewDecision(data.User{Name: "carol"}, "Q3", aliceLand.ID, data.ReviewDecision{Decision: data.ReviewRevoke}); err == nil {
+		t.Errorf("RecordReviewDecision - Should not record a decision for a closed campaign")
+	}
+
+	//	The report has a row for each item
+	report := bytes.Buffer{}
+	if err := closed.WriteCSV(&report); err != nil {
+		t.Fatalf("WriteCSV - Should write the report without error, but got: %s", err)
+	}
+
+	rows, err := csv.NewReader(&report).ReadAll()
+	if err != nil || len(rows) != 4 || rows[bobFly.ID][9] != "Left the crew" || rows[bobFly.ID][12] != "true" {
+		t.Errorf("WriteCSV - Expected a header and a row for each item, but got %v (%v)", rows, err)
+	}
+
+}
+
+func TestCampaign_WriteCSV_NeutralizesFormulas(t *testing.T) {
+
+	//	Arrange
+	campaign := data.Campaign{Name: "Q3", Items: []data.ReviewItem{
+		{ID: 1, User: "bob", Access: "Group", Name: "Crew", Comment: "=HYPERLINK(\"http://evil.example.com\")", DecidedBy: "@carol"},
+		{ID: 2, User: "+jayne", Access: "Role", Name: "-pilot", Comment: "Still flies"},
+	}}
+
+	//	Act
+	report := bytes.Buffer{}
+	err := campaign.WriteCSV(&report)
+
+	//	Assert
+	if err != nil {
+		t.Fatalf("WriteCSV - Should write the report without error, but got: %s", err)
+	}
+
+	rows, err := csv.NewReader(&report).ReadAll()
+	if err != nil || len(rows) != 3 {
+		t.Fatalf("WriteCSV - Expected a header and a row for each item, but got %v (%v)", rows, err)
+	}
+
+	if rows[1][9] != "'=HYPERLINK(\"http://evil.example.com\")" || rows[1][10] != "'@carol" || rows[2][2] != "'+jayne" || rows[2][4] != "'-pilot" || rows[2][9] != "Still flies" {
+		t.Errorf("WriteCSV - Expected the formula cells to be prefixed with a ', but got %v", rows)
+	}
+
+}
+
+func TestManager_Campaign_Grants_AreReviewedAndRevoked(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	for _, name := range []string{"bob", "carol", "jayne"} {
+		db.AddUser(contextUser, data.User{Name: name}, "testpass")
+	}
+	db.AddRole(contextUser, "pilot", "")
+	db.AddGroup(contextUser, "crew", "")
+
+	expires := time.Now().Add(time.Hour)
+	db.During(time.Time{}, expires).AttachRoleToUsers(contextUser, "pilot", "bob")
+	db.AddUsersToGroup(contextUser, "crew", "jayne")
+	db.During(time.Time{}, expires).AddUsersToGroup(contextUser, "crew", "jayne")
+
+	//	Act
+	campaign, err := db.AddCampaign(contextUser, data.Campaign{Name: "JIT", Reviewers: []string{"carol"}})
+	if err != nil {
+		t.Fatalf("AddCampaign - Should start the campaign without error, but got: %s", err)
+	}
+
+	//	Assert
+	if len(campaign.Items) != 2 {
+		t.Fatalf("AddCampaign - Expected an item for the grant and one for the (permanent and granted) group, but got %+v", campaign.Items)
+	}
+
+	bobPilot, jayneCrew := campaign.Items[0], campaign.Items[1]
+	if bobPilot.User != "bob" || bobPilot.Name != "pilot" || !bobPilot.Expires.Valid || !bobPilot.Expires.Time.Equal(expires) {
+		t.Errorf("AddCampaign - Expected the grant to be reviewed (with its expiry), but got %+v", bobPilot)
+	}
+
+	if jayneCrew.User != "jayne" || jayneCrew.Expires.Valid {
+		t.Errorf("AddCampaign - Expected the permanent group membership to be reviewed, but got %+v", jayneCrew)
+	}
+
+	db.RecordReviewDecision(data.User{Name: "carol"}, "JIT", bobPilot.ID, data.ReviewDecision{Decision: data.ReviewRevoke})
+	db.RecordReviewDecision(data.User{Name: "carol"}, "JIT", jayneCrew.ID, data.ReviewDecision{Decision: data.ReviewRevoke})
+
+	closed, err := db.CloseCampaign(contextUser, "JIT")
+	if err != nil || !closed.Items[0].Revoked || !closed.Items[1].Revoked {
+		t.Fatalf("CloseCampaign - Expected both revocations to be applied, but got %+v (%v)", closed.Items, err)
+	}
+
+	bob, _ := db.GetUser(contextUser, "bob")
+	jayne, _ := db.GetUser(contextUser, "jayne")
+	pilot, _ := db.GetRole(contextUser, "pilot")
+	crew, _ := db.GetGroup(contextUser, "crew")
+	if len(bob.Grants) != 0 || len(jayne.Grants) != 0 || len(jayne.Groups) != 0 || len(pilot.Users) != 0 || len(crew.Users) != 0 {
+		t.Errorf("CloseCampaign - Expected the grants to be removed along with the access, but got %+v, %+v, %+v, %+v", bob, jayne, pilot, crew)
+	}
+
+}
+
+func TestManager_AddCampaign_InvalidCampaign_ReturnsError(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	db.AddUser(contextUser, data.User{Name: "alice"}, "testpass")
+	db.AddUser(contextUser, data.User{Name: "wash"}, "testpass")
+	db.DeleteUser(contextUser, "wash")
+	db.AddCampaign(contextUser, data.Campaign{Name: "Existing", Reviewers: []string{"alice"}})
+
+	tests := map[string]data.Campaign{
+		"no name":          {Reviewers: []string{"alice"}},
+		"no reviewers":     {Name: "Q3"},
+		"missing reviewer": {Name: "Q3", Reviewers: []string{"nobody"}},
+		"missing owner":    {Name: "Q3", Reviewers: []string{"alice"}, Owners: map[string][]string{"Group:crew": {"nobody"}}},
+		"deleted reviewer": {Name: "Q3", Reviewers: []string{"wash"}},
+		"deleted owner":    {Name: "Q3", Reviewers: []string{"alice"}, Owners: map[string][]string{"Group:crew": {"wash"}}},
+		"invalid owners":   {Name: "Q3", Reviewers: []string{"alice"}, Owners: map[string][]string{"crew": {"alice"}}},
+		"existing name":    {Name: "Existing", Reviewers: []string{"alice"}},
+	}
+
+	for name, campaign := range tests {
+		//	Act
+		_, err := db.AddCampaign(contextUser, campaign)
+
+		//	Assert
+		if err == nil {
+			t.Errorf("AddCampaign - Should not start a campaign with %s", name)
+		}
+	}
+
+	if _, err := db.AddCampaign(data.User{Name: "alice"}, data.Campaign{Name: "Q4", Reviewers: []string{"alice"}}); err == nil {
+		t.Errorf("AddCampaign - Should not start a campaign for a user without access")
+	}
+
+}
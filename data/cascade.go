@@ -0,0 +1,29 @@
+package data
+
+import "time"
+
+// defaultDeletedRetention is how long a deleted item is kept around (by default) before it is removed from the system
+const defaultDeletedRetention = 168 * time.Hour // 1 week
+
+// CascadeResult represents the items that were updated as part of a delete operation.
+// When a user, group, role or policy is deleted, any item that refers to it has that
+// reference removed (as part of the same transaction).  The names of those items are tracked here
+type CascadeResult struct {
+	Users    []string `json:"users"`
+	Groups   []string `json:"groups"`
+	Roles    []string `json:"roles"`
+	Policies []string `json:"policies"`
+}
+
+// removeItem returns the given list without any instances of item
+func removeItem(list []string, item string) []string {
+	retval := []string{}
+
+	for _, current := range list {
+		if current != item {
+			retval = append(retval, current)
+		}
+	}
+
+	return retval
+}
@@ -0,0 +1,213 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/danesparza/badger"
+)
+
+// ChangeNotification tells services that cache authorization data what changed.  There is a
+// notification for each audit event (and the ID of the event is the cursor to resume from).
+// Items is the list of items that changed (like 'Policy:Y') and Users is the list of users
+// whose effective policies may have changed
+type ChangeNotification struct {
+	ID     string   `json:"id"`
+	Action string   `json:"action"`
+	Items  []string `json:"items"`
+	Users  []string `json:"users"`
+}
+
+// grantFields are the fields (for each type of item) that change what the item grants.  A change to
+// one of these fields affects every user the item applies to.  A change to the users, groups or roles
+// an item is attached to only affects the users that were attached or detached
+var grantFields = map[string][]string{
+	"User":   {"enabled", "deleted", "groups", "roles", "policies", "attributes", "grants"},
+	"Group":  {"deleted", "roles", "policies"},
+	"Role":   {"deleted", "policies"},
+	"Policy": {"deleted", "statements", "effect", "resources", "actions", "not_resources", "not_actions", "syntax"},
+}
+
+// GetLatestChangeCursor gets the cursor of the most recent change.  Getting changes with
+// this cursor only returns changes made after this call
+func (store Manager) GetLatestChangeCursor(context User) (string, error) {
+	//	Security check:  Are we authorized to perform this action?
+	if !store.IsUserRequestAuthorized(context, sysreqGetChanges) {
+		return "", fmt.Errorf("User %s is not authorized to perform the action", context.Name)
+	}
+
+	head := AuditHead{}
+	err := store.systemdb.View(func(txn *badger.Txn) error {
+		return getItem(txn, auditHeadKey, &head)
+	})
+	if err != nil && err != badger.ErrKeyNotFound {
+		return "", fmt.Errorf("Problem getting the latest change: %s", err)
+	}
+
+	return head.ID, nil
+}
+
+// GetChanges gets (up to limit) change notifications for the changes made after the cursor.  An empty
+// cursor starts with the first change.  Returns the notifications and the cursor to use for the next
+// call (this is the same cursor if there are no new changes)
+func (store Manager) GetChanges(context User, cursor string, limit int) ([]ChangeNotification, string, error) {
+	//	Our return item
+	retval := []ChangeNotification{}
+
+	//	Security check:  Are we authorized to perform this action?
+	if !store.IsUserRequestAuthorized(context, sysreqGetChanges) {
+		return retval, cursor, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
+	}
+
+	//	Get the audit events after the cursor
+	events, _, err := store.GetAuditLog(SystemUser, AuditQuery{Cursor: cursor, Limit: limit})
+	if err != nil {
+		return retval, cursor, err
+	}
+
+	err = store.systemdb.View(func(txn *badger.Txn) error {
+		for _, event := range events {
+			notification := ChangeNotification{ID: event.ID, Action: event.Action, Items: []string{}}
+
+			users, err := affectedUsers(txn, event.Changes)
+			if err != nil {
+				return err
+			}
+			notification.Users = users
+
+			for _, change := range event.Changes {
+				notification.Items = append(notification.Items, change.Key)
+			}
+
+			retval = append(retval, notification)
+		}
+
+		return nil
+	})
+
+	//	If there was an error, report it:
+	if err != nil {
+		return []ChangeNotification{}, cursor, fmt.Errorf("Problem getting the changes: %s", err)
+	}
+
+	//	The next cursor is the last change we returned
+	if len(retval) > 0 {
+		cursor = retval[len(retval)-1].ID
+	}
+
+	//	Return our data:
+	return retval, cursor, nil
+}
+
+// affectedUsers gets the (sorted) list of users whose effective policies may have been
+// changed by the given changes.  Group and role memberships are looked up in the transaction
+func affectedUsers(txn reader, changes []AuditChange) ([]string, error) {
+	users := []string{}
+
+	for _, change := range changes {
+		parts := strings.SplitN(change.Key, ":", 2)
+		itemType, name := parts[0], parts[len(parts)-1]
+
+		//	Did the change affect what the item grants?
+		granted := false
+		for _, field := range grantFields[itemType] {
+			if containsItem(change.Changed, field) {
+				granted = true
+			}
+		}
+
+		switch itemType {
+		case "User":
+			if granted {
+				users = append(users, name)
+			}
+
+		case "Group":
+			users = append(users, attachedList(change, "users", granted)...)
+
+		case "Role":
+			members, err := roleUsers(txn, attachedList(change, "users", granted), attachedList(change, "groups", granted))
+			if err != nil {
+				return users, err
+			}
+			users = append(users, members...)
+
+		case "Policy":
+			members, err := roleUsers(txn, attachedList(change, "users", granted), attachedList(change, "groups", granted))
+			if err != nil {
+				return users, err
+			}
+			users = append(users, members...)
+
+			for _, roleName := range attachedList(change, "roles", granted) {
+				role := Role{}
+				if err := getItem(txn, GetKey("Role", roleName), &role); err != nil && err != badger.ErrKeyNotFound {
+					return users, err
+				}
+
+				members, err := roleUsers(txn, role.Users, role.Groups)
+				if err != nil {
+					return users, err
+				}
+				users = append(users, members...)
+			}
+		}
+	}
+
+	return mergeItems(users), nil
+}
+
+// roleUsers gets the given users along with the members of the given groups
+func roleUsers(txn reader, users, groups []string) ([]string, error) {
+	retval := append([]string{}, users...)
+
+	for _, groupName := range groups {
+		group := Group{}
+		if err := getItem(txn, GetKey("Group", groupName), &group); err != nil && err != badger.ErrKeyNotFound {
+			return retval, err
+		}
+
+		retval = append(retval, group.Users...)
+	}
+
+	return retval, nil
+}
+
+// attachedList gets the items in the given list field that are affected by the change:  all
+// items (before and after the change) if all is true, otherwise only the items that were added or removed
+func attachedList(change AuditChange, field string, all bool) []string {
+	before := listField(change.Before, field)
+	after := listField(change.After, field)
+
+	if all {
+		return append(before, after...)
+	}
+
+	retval := []string{}
+	for _, item := range before {
+		if !containsItem(after, item) {
+			retval = append(retval, item)
+		}
+	}
+	for _, item := range after {
+		if !containsItem(before, item) {
+			retval = append(retval, item)
+		}
+	}
+
+	return retval
+}
+
+// listField gets the items in a list field of an audited item
+func listField(fields map[string]interface{}, field string) []string {
+	retval := []string{}
+
+	items, _ := fields[field].([]interface{})
+	for _, item := range items {
+		if name, ok := item.(string); ok {
+			retval = append(retval, name)
+		}
+	}
+
+	return retval
+}
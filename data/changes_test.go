@@ -0,0 +1,122 @@
+package data_test
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/danesparza/iamserver/data"
+	"github.com/danesparza/iamserver/policy"
+)
+
+func TestChanges_GetChanges_ReturnsAffectedUsers(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	db.AddUser(contextUser, data.User{Name: "bob"}, "testpass")
+	db.AddUser(contextUser, data.User{Name: "alice"}, "testpass")
+	db.AddUser(contextUser, data.User{Name: "eve"}, "testpass")
+	db.AddGroup(contextUser, "Crew", "")
+	db.AddUsersToGroup(contextUser, "Crew", "bob", "alice")
+	db.AddResource(contextUser, "Serenity", "")
+	db.AddPolicy(contextUser, data.Policy{Name: "Fly", Effect: policy.Allow, Resources: []string{"Serenity"}, Actions: []string{"Fly"}})
+	db.AddRole(contextUser, "Pilot", "")
+	db.AttachPoliciesToRole(contextUser, "Pilot", "Fly")
+
+	cursor, err := db.GetLatestChangeCursor(contextUser)
+	if err != nil {
+		t.Fatalf("GetLatestChangeCursor - Should get the cursor without error, but got: %s", err)
+	}
+
+	//	Act
+	db.AttachRoleToGroups(contextUser, "Pilot", "Crew")
+	db.AttachRoleToUsers(contextUser, "Pilot", "eve")
+	changes, nextCursor, err := db.GetChanges(contextUser, cursor, 0)
+
+	//	Assert
+	if err != nil {
+		t.Fatalf("GetChanges - Should get the changes without error, but got: %s", err)
+	}
+
+	if len(changes) != 2 || nextCursor != changes[len(changes)-1].ID {
+		t.Fatalf("GetChanges - Expected only the 2 changes after the cursor, but got %+v (next cursor %s)", changes, nextCursor)
+	}
+
+	if !reflect.DeepEqual(changes[0].Users, []string{"alice", "bob"}) || len(changes[0].Items) != 2 {
+		t.Errorf("GetChanges - Expected the group members to be affected by the role change, but got %+v", changes[0])
+	}
+
+	if !reflect.DeepEqual(changes[1].Users, []string{"eve"}) {
+		t.Errorf("GetChanges - Expected eve to be affected by the role change, but got %+v", changes[1])
+	}
+}
+
+func TestChanges_GetChanges_WithCursor_Resumes(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	db.AddUser(contextUser, data.User{Name: "bob"}, "testpass")
+	db.AddUser(contextUser, data.User{Name: "alice"}, "testpass")
+
+	//	Act
+	first, cursor, err := db.GetChanges(contextUser, "", 1)
+	if err != nil {
+		t.Fatalf("GetChanges - Should get the changes without error, but got: %s", err)
+	}
+	second, cursor, _ := db.GetChanges(contextUser, cursor, 1)
+	none, lastCursor, _ := db.GetChanges(contextUser, cursor, 1)
+
+	//	Assert
+	if len(first) != 1 || first[0].Users[0] != "bob" || len(second) != 1 || second[0].Users[0] != "alice" {
+		t.Errorf("GetChanges - Expected a change for bob and then alice, but got %+v and %+v", first, second)
+	}
+
+	if len(none) != 0 || lastCursor != cursor {
+		t.Errorf("GetChanges - Expected no more changes (and the same cursor), but got %+v (%s)", none, lastCursor)
+	}
+}
+
+func TestChanges_GetChanges_NotAuthorized_ReturnsError(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	//	Act
+	_, _, err = db.GetChanges(data.User{Name: "BogusUser"}, "", 0)
+
+	//	Assert
+	if err == nil {
+		t.Errorf("GetChanges - Should return an error for a bogus user, but didn't")
+	}
+}
@@ -29,6 +29,14 @@ var (
 		code:   http.StatusNotFound,
 		status: http.StatusText(http.StatusNotFound),
 	}
+
+	// ErrVersionMismatch is returned when an item has been changed since the caller last read it.
+	ErrVersionMismatch = &errorWithContext{
+		error:  errors.New("Item has been changed by someone else"),
+		code:   http.StatusPreconditionFailed,
+		status: http.StatusText(http.StatusPreconditionFailed),
+		reason: "The request was not applied because the item's version didn't match the expected version.",
+	}
 )
 
 type errorWithContext struct {
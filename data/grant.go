@@ -0,0 +1,398 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/danesparza/badger"
+)
+
+// Grant is a time-bound attachment of a policy, role or group (the access) to a user.  The user only
+// gets the access from NotBefore until Expires -- after that, the grant is removed from the user (and
+// an audit event is recorded) the next time expired grants are swept.  Started is set once the start of
+// the grant has been reported:  right away for a grant that starts when it's made, otherwise by the first
+// sweep after NotBefore
+type Grant struct {
+	Access    string    `json:"access"`
+	Name      string    `json:"name"`
+	NotBefore time.Time `json:"not_before"`
+	Expires   time.Time `json:"expires"`
+	Granted   time.Time `json:"granted"`
+	GrantedBy string    `json:"granted_by"`
+	Started   bool      `json:"started"`
+}
+
+// UserGrant is a grant, along with the user it was made to
+type UserGrant struct {
+	User string `json:"user"`
+	Grant
+}
+
+// activeAt returns true if the grant is in effect at the given time
+func (grant Grant) activeAt(now time.Time) bool {
+	return !now.Before(grant.NotBefore) && now.Before(grant.Expires)
+}
+
+// During returns a copy of the manager that makes time-bound attachments:  AttachPolicyToUsers, AttachRoleToUsers
+// and AddUsersToGroup give the users a grant from notBefore (or now, if it's zero) until expires, instead of
+// attaching them permanently.  An expires of zero makes permanent attachments.  Authorization decisions follow
+// notBefore and expires exactly, but the start and expiry of a grant are only recorded (and reported to webhooks
+// and change streams) by the next sweep -- up to the grants.interval after the time
+func (store Manager) During(notBefore, expires time.Time) Manager {
+	store.grantNotBefore = notBefore
+	store.grantExpires = expires
+	return store
+}
+
+// timeBound returns true if the manager makes time-bound attachments
+func (store Manager) timeBound() bool {
+	return !store.grantExpires.IsZero()
+}
+
+// newGrant creates a grant of the access for the manager's time period, and checks the period is valid
+func (store Manager) newGrant(context User, access, name string) (Grant, error) {
+	now := time.Now()
+	retval := Grant{
+		Access:    access,
+		Name:      name,
+		NotBefore: store.grantNotBefore,
+		Expires:   store.grantExpires,
+		Granted:   now,
+		GrantedBy: context.Name,
+	}
+
+	if retval.NotBefore.IsZero() {
+		retval.NotBefore = now
+	}
+	retval.Started = !retval.NotBefore.After(now)
+
+	if !retval.Expires.After(retval.NotBefore) || !retval.Expires.After(now) {
+		return retval, fmt.Errorf("Grant must expire in the future (and after it starts)")
+	}
+
+	return retval, nil
+}
+
+// addGrant returns the grants with the given grant added.  It replaces an existing grant for the same access
+func addGrant(grants []Grant, grant Grant) []Grant {
+	return append(removeGrant(grants, grant.Access, grant.Name), grant)
+}
+
+// removeGrant returns the grants without the grant for the given access
+func removeGrant(grants []Grant, access, name string) []Grant {
+	retval := []Grant{}
+	for _, grant := range grants {
+		if grant.Access != access || grant.Name != name {
+			retval = append(retval, grant)
+		}
+	}
+
+	return retval
+}
+
+// withGrants returns a copy of the user with the access of the grants that are active at the given time
+// added to the user's groups, roles and policies
+func (user User) withGrants(now time.Time) User {
+	groups, roles, policies := []string{}, []string{}, []string{}
+	for _, grant := range user.Grants {
+		if !grant.activeAt(now) {
+			continue
+		}
+
+		switch grant.Access {
+		case AccessGroup:
+			groups = append(groups, grant.Name)
+		case AccessRole:
+			roles = append(roles, grant.Name)
+		case AccessPolicy:
+			policies = append(policies, grant.Name)
+		}
+	}
+
+	if len(groups) > 0 {
+		user.Groups = mergeItems(user.Groups, groups...)
+	}
+	if len(roles) > 0 {
+		user.Roles = mergeItems(user.Roles, roles...)
+	}
+	if len(policies) > 0 {
+		user.Policies = mergeItems(user.Policies, policies...)
+	}
+
+	return user
+}
+
+// nextGrantChange gets the next time (after now) one of the user's grants starts or expires.  It returns
+// zero if none of the grants will change
+func (user User) nextGrantChange(now time.Time) time.Time {
+	retval := time.Time{}
+	for _, grant := range user.Grants {
+		for _, change := range []time.Time{grant.NotBefore, grant.Expires} {
+			if change.After(now) && (retval.IsZero() || change.Before(retval)) {
+				retval = change
+			}
+		}
+	}
+
+	return retval
+}
+
+// hasAccess returns true if the user has the access permanently (or through a grant)
+func (user User) hasAccess(access, name string) bool {
+	switch access {
+	case AccessGroup:
+		if containsItem(user.Groups, name) {
+			return true
+		}
+	case AccessRole:
+		if containsItem(user.Roles, name) {
+			return true
+		}
+	case AccessPolicy:
+		if containsItem(user.Policies, name) {
+			return true
+		}
+	}
+
+	for _, grant := range user.Grants {
+		if grant.Access == access && grant.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// detachUser removes the user from the users of the policy, role or group, unless the user still has the access
+// (permanently or through a grant)
+func detachUser(txn *writeTxn, user User, access, name string) error {
+	if user.hasAccess(access, name) {
+		return nil
+	}
+
+	key := GetKey(accessKeyType[access], name)
+	switch access {
+	case AccessPolicy:
+		pol := Policy{}
+		if err := getItem(txn, key, &pol); err == nil {
+			pol.Users = removeItem(pol.Users, user.Name)
+			pol.Version++
+			return setItem(txn, key, pol)
+		}
+	case AccessRole:
+		role := Role{}
+		if err := getItem(txn, key, &role); err == nil {
+			role.Users = removeItem(role.Users, user.Name)
+			role.Version++
+			return setItem(txn, key, role)
+		}
+	case AccessGroup:
+		group := Group{}
+		if err := getItem(txn, key, &group); err == nil {
+			group.Users = removeItem(group.Users, user.Name)
+			group.Version++
+			return setItem(txn, key, group)
+		}
+	}
+
+	return nil
+}
+
+// GetGrants gets the time-bound grants of all users, soonest to expire first.  If within isn't zero, only
+// the grants that expire within that duration (from now) are returned
+func (store Manager) GetGrants(context User, within time.Duration) ([]UserGrant, error) {
+	//	Our return item
+	retval := []UserGrant{}
+
+	//	Security check:  Are we authorized to perform this action?
+	if !store.IsUserRequestAuthorized(context, sysreqGetGrants) {
+		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
+	}
+
+	cutoff := time.Now().Add(within)
+	err := store.systemdb.View(func(txn *badger.Txn) error {
+		return forEachItem(txn, GetKey("User"), func(val []byte) error {
+			user := User{}
+			if err := json.Unmarshal(val, &user); err != nil || user.Deleted.Valid {
+				return err
+			}
+
+			for _, grant := range user.Grants {
+				if within == 0 || grant.Expires.Before(cutoff) {
+					retval = append(retval, UserGrant{User: user.Name, Grant: grant})
+				}
+			}
+			return nil
+		})
+	})
+
+	//	If there was an error, report it:
+	if err != nil {
+		return []UserGrant{}, fmt.Errorf("Problem getting the list of grants: %s", err)
+	}
+
+	sort.SliceStable(retval, func(i, j int) bool {
+		return retval[i].Expires.Before(retval[j].Expires)
+	})
+
+	//	Return our data:
+	return retval, nil
+}
+
+// errNoGrantChanges is returned (and ignored) when a user no longer has grants to expire (or start) by the time they're updated
+var errNoGrantChanges = errors.New("User has no grants to expire or start")
+
+// ExpireGrants removes the grants that have expired at the given time from their users (and removes the users
+// from the policies, roles and groups they no longer have access to).  An audit event is recorded for each user
+// with expired grants -- and for each user with scheduled grants that have started since the last sweep, so the
+// change is reported to webhooks and change streams.  A problem with one user's grants is logged and doesn't stop
+// the other users' grants from being swept.  Returns the number of grants that were removed
+func (store Manager) ExpireGrants(now time.Time) (int, error) {
+	expired := 0
+
+	//	Find the users with expired (or newly started) grants
+	userNames := []string{}
+	err := store.systemdb.View(func(txn *badger.Txn) error {
+		return forEachItem(txn, GetKey("User"), func(val []byte) error {
+			user := User{}
+			if err := json.Unmarshal(val, &user); err != nil || user.Deleted.Valid {
+				return err
+			}
+
+			for _, grant := range user.Grants {
+				if !now.Before(grant.Expires) || (grant.activeAt(now) && !grant.Started) {
+					userNames = append(userNames, user.Name)
+					break
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return expired, err
+	}
+
+	//	Remove the expired grants from each user (and report the started grants)
+	failed := 0
+	for _, userName := range userNames {
+		removed, err := store.expireUserGrants(userName, now)
+		if err == nil || err == errNoGrantChanges {
+			err = store.startUserGrants(userName, now)
+		}
+		if err != nil && err != errNoGrantChanges {
+			log.Printf("[ERROR] Problem sweeping grants for user %s: %s", userName, err)
+			failed++
+		}
+
+		expired += removed
+	}
+
+	if failed > 0 {
+		return expired, fmt.Errorf("Problem sweeping grants for %d of %d users", failed, len(userNames))
+	}
+
+	return expired, nil
+}
+
+// expireUserGrants removes the user's grants that have expired at the given time (recording an ExpireGrants event).
+// Returns the number of grants that were removed
+func (store Manager) expireUserGrants(userName string, now time.Time) (int, error) {
+	removed := 0
+	err := store.update(newAuditEvent(SystemUser.Name, sysreqExpireGrants.Action, "User", userName), func(txn *writeTxn) error {
+		removed = 0
+
+		user, err := getSweptUser(txn, userName)
+		if err != nil {
+			return err
+		}
+
+		expiredGrants := []Grant{}
+		for _, grant := range user.Grants {
+			if !now.Before(grant.Expires) {
+				expiredGrants = append(expiredGrants, grant)
+				user.Grants = removeGrant(user.Grants, grant.Access, grant.Name)
+			}
+		}
+		if len(expiredGrants) == 0 {
+			return errNoGrantChanges
+		}
+
+		user.Version++
+		if err := setItem(txn, GetKey("User", user.Name), user); err != nil {
+			return err
+		}
+
+		for _, grant := range expiredGrants {
+			if err := detachUser(txn, user, grant.Access, grant.Name); err != nil {
+				return err
+			}
+		}
+
+		removed = len(expiredGrants)
+		return nil
+	})
+
+	return removed, err
+}
+
+// startUserGrants marks the user's scheduled grants that are active at the given time as started (recording a
+// StartGrants event), so each grant's start is reported once
+func (store Manager) startUserGrants(userName string, now time.Time) error {
+	return store.update(newAuditEvent(SystemUser.Name, "StartGrants", "User", userName), func(txn *writeTxn) error {
+		user, err := getSweptUser(txn, userName)
+		if err != nil {
+			return err
+		}
+
+		started := 0
+		for i := range user.Grants {
+			if user.Grants[i].activeAt(now) && !user.Grants[i].Started {
+				user.Grants[i].Started = true
+				started++
+			}
+		}
+		if started == 0 {
+			return errNoGrantChanges
+		}
+
+		user.Version++
+		return setItem(txn, GetKey("User", user.Name), user)
+	})
+}
+
+// getSweptUser gets the user whose grants are being swept.  If the user was removed (or deleted)
+// since the sweep looked, there's nothing to do
+func getSweptUser(txn *writeTxn, userName string) (User, error) {
+	user := User{}
+	if err := getItem(txn, GetKey("User", userName), &user); err == badger.ErrKeyNotFound {
+		return user, errNoGrantChanges
+	} else if err != nil {
+		return user, err
+	}
+	if user.Deleted.Valid {
+		return user, errNoGrantChanges
+	}
+
+	return user, nil
+}
+
+// RunGrantExpiry sweeps expired (and started) grants every interval, until stop is closed
+func (store Manager) RunGrantExpiry(stop <-chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := store.ExpireGrants(time.Now()); err != nil {
+				log.Printf("[ERROR] Problem expiring grants: %s", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package data_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/danesparza/iamserver/data"
+	"github.com/danesparza/iamserver/policy"
+)
+
+func TestManager_During_GrantsExpire(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	db.AddResource(contextUser, "Prod", "")
+	db.AddUser(contextUser, data.User{Name: "bob"}, "testpass")
+	db.AddPolicy(contextUser, data.Policy{Name: "Administer prod", Effect: policy.Allow, Resources: []string{"Prod"}, Actions: []string{"Deploy"}})
+	db.AddPolicy(contextUser, data.Policy{Name: "Read prod", Effect: policy.Allow, Resources: []string{"Prod"}, Actions: []string{"Read"}})
+	db.AddRole(contextUser, "ProdAdmin", "")
+	db.AttachPoliciesToRole(contextUser, "ProdAdmin", "Administer prod")
+
+	bob := data.User{Name: "bob"}
+	deploy := &data.Request{Resource: "Prod", Action: "Deploy"}
+	read := &data.Request{Resource: "Prod", Action: "Read"}
+
+	//	Act
+	now := time.Now()
+	_, errRole := db.During(time.Time{}, now.Add(500*time.Millisecond)).AttachRoleToUsers(contextUser, "ProdAdmin", "bob")
+	_, errPolicy := db.During(now.Add(time.Hour), now.Add(2*time.Hour)).AttachPolicyToUsers(contextUser, "Read prod", "bob")
+
+	//	Assert
+	if errRole != nil || errPolicy != nil {
+		t.Fatalf("During - Should make the time-bound attachments without error, but got: %v / %v", errRole, errPolicy)
+	}
+
+	if !db.IsUserRequestAuthorized(bob, deploy) || db.IsUserRequestAuthorized(bob, read) {
+		t.Errorf("During - Expected the active grant to be in effect (and the future grant not to be)")
+	}
+
+	grants, err := db.GetGrants(contextUser, time.Minute)
+	if err != nil || len(grants) != 1 || grants[0].User != "bob" || grants[0].Name != "ProdAdmin" || grants[0].GrantedBy != "System" {
+		t.Errorf("GetGrants - Expected the grant that expires within a minute, but got %+v (%v)", grants, err)
+	}
+
+	role, _ := db.GetRole(contextUser, "ProdAdmin")
+	user, _ := db.GetUser(contextUser, "bob")
+	if len(role.Users) != 1 || len(user.Roles) != 0 || len(user.Grants) != 2 {
+		t.Errorf("During - Expected a grant (instead of a permanent attachment), but got %+v and %+v", user, role)
+	}
+
+	//	The grant drops out on its own (even before it's swept)
+	time.Sleep(time.Until(now.Add(600 * time.Millisecond)))
+	if db.IsUserRequestAuthorized(bob, deploy) {
+		t.Errorf("During - Expected the expired grant not to be in effect")
+	}
+
+	expired, err := db.ExpireGrants(time.Now())
+	if err != nil || expired != 1 {
+		t.Errorf("ExpireGrants - Expected 1 expired grant to be removed, but got %v (%v)", expired, err)
+	}
+
+	role, _ = db.GetRole(contextUser, "ProdAdmin")
+	user, _ = db.GetUser(contextUser, "bob")
+	if len(role.Users) != 0 || len(user.Grants) != 1 || user.Grants[0].Name != "Read prod" {
+		t.Errorf("ExpireGrants - Expected the expired grant to be removed from the user and the role, but got %+v and %+v", user, role)
+	}
+
+	events, _, err := db.GetAuditLog(contextUser, data.AuditQuery{Action: "ExpireGrants", Target: "User:bob"})
+	if err != nil || len(events) != 1 || events[0].Actor != "System" {
+		t.Errorf("ExpireGrants - Expected an audit event for the expired grant, but got %+v (%v)", events, err)
+	}
+
+	//	Sweeping again has nothing to do
+	expired, err = db.ExpireGrants(time.Now())
+	events, _, _ = db.GetAuditLog(contextUser, data.AuditQuery{Action: "ExpireGrants", Target: "User:bob"})
+	if err != nil || expired != 0 || len(events) != 1 {
+		t.Errorf("ExpireGrants - Expected nothing more to be expired, but got %v (%v) and %+v", expired, err, events)
+	}
+
+}
+
+func TestManager_ExpireGrants_StartedGrants_AreReportedOnce(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	db.AddUser(contextUser, data.User{Name: "bob"}, "testpass")
+	db.AddUser(contextUser, data.User{Name: "jayne"}, "testpass")
+	db.AddRole(contextUser, "ProdAdmin", "")
+
+	now := time.Now()
+	db.During(now.Add(300*time.Millisecond), now.Add(time.Hour)).AttachRoleToUsers(contextUser, "ProdAdmin", "bob")
+	db.During(time.Time{}, now.Add(time.Hour)).AttachRoleToUsers(contextUser, "ProdAdmin", "jayne")
+
+	//	Act
+	db.ExpireGrants(time.Now())
+	beforeStart, _, _ := db.GetAuditLog(contextUser, data.AuditQuery{Action: "StartGrants"})
+
+	time.Sleep(time.Until(now.Add(400 * time.Millisecond)))
+	db.ExpireGrants(time.Now())
+	db.ExpireGrants(time.Now())
+	afterStart, _, err := db.GetAuditLog(contextUser, data.AuditQuery{Action: "StartGrants"})
+
+	//	Assert
+	if len(beforeStart) != 0 {
+		t.Errorf("ExpireGrants - Expected no start to be reported before the grants start, but got %+v", beforeStart)
+	}
+
+	if err != nil || len(afterStart) != 1 || afterStart[0].Target != "User:bob" {
+		t.Errorf("ExpireGrants - Expected the scheduled grant's start to be reported once, but got %+v (%v)", afterStart, err)
+	}
+
+	bob, _ := db.GetUser(contextUser, "bob")
+	jayne, _ := db.GetUser(contextUser, "jayne")
+	if len(bob.Grants) != 1 || !bob.Grants[0].Started || len(jayne.Grants) != 1 || !jayne.Grants[0].Started {
+		t.Errorf("ExpireGrants - Expected both grants to be marked as started, but got %+v and %+v", bob.Grants, jayne.Grants)
+	}
+
+}
+
+func TestManager_During_InvalidPeriod_ReturnsError(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	db.AddUser(contextUser, data.User{Name: "bob"}, "testpass")
+	db.AddGroup(contextUser, "oncall", "")
+
+	now := time.Now()
+	tests := map[string]data.Manager{
+		"an expiry in the past":      db.During(time.Time{}, now.Add(-time.Minute)),
+		"an expiry before it starts": db.During(now.Add(2*time.Hour), now.Add(time.Hour)),
+		"an empty period":            db.During(now.Add(time.Hour), now.Add(time.Hour)),
+	}
+
+	for name, manager := range tests {
+		//	Act
+		_, err := manager.AddUsersToGroup(contextUser, "oncall", "bob")
+
+		//	Assert
+		if err == nil {
+			t.Errorf("AddUsersToGroup - Should not make a time-bound attachment with %s", name)
+		}
+	}
+
+	//	Deleting the group drops the grants for it
+	db.During(time.Time{}, now.Add(time.Hour)).AddUsersToGroup(contextUser, "oncall", "bob")
+	db.DeleteGroup(contextUser, "oncall")
+
+	bob, _ := db.GetUser(contextUser, "bob")
+	if len(bob.Grants) != 0 {
+		t.Errorf("DeleteGroup - Expected the grants for the group to be dropped, but got %+v", bob.Grants)
+	}
+
+}
@@ -3,11 +3,9 @@ package data
 import (
 	"encoding/json"
 	"fmt"
-	"sort"
 	"time"
 
 	"github.com/danesparza/badger"
-	"github.com/xtgo/set"
 	null "gopkg.in/guregu/null.v3"
 	"gopkg.in/guregu/null.v3/zero"
 )
@@ -16,6 +14,7 @@ import (
 type Group struct {
 	Name        string      `json:"name"`
 	Description string      `json:"description"`
+	Version     int64       `json:"version"`
 	Created     time.Time   `json:"created"`
 	CreatedBy   string      `json:"created_by"`
 	Updated     time.Time   `json:"updated"`
@@ -47,32 +46,20 @@ func (store Manager) AddGroup(context User, groupName string, groupDescription s
 		UpdatedBy:   context.Name,
 	}
 
-	//	First -- does the group exist already?
-	err := store.systemdb.View(func(txn *badger.Txn) error {
-		_, err := txn.Get(GetKey("Group", group.Name))
-		return err
-	})
-
-	//	If we didn't get an error, we have a problem:
-	if err == nil {
-		return retval, fmt.Errorf("Group already exists")
-	}
-
-	//	Serialize to JSON format
-	encoded, err := json.Marshal(group)
-	if err != nil {
-		return retval, fmt.Errorf("Problem serializing the data: %s", err)
-	}
+	err := store.update(newAuditEvent(context.Name, sysreqAddGroup.Action, "Group", group.Name), func(txn *writeTxn) error {
+		//	First -- does the group exist already?
+		if _, err := txn.Get(GetKey("Group", group.Name)); err == nil {
+			return fmt.Errorf("Group already exists")
+		}
 
-	//	Save it to the database:
-	err = store.systemdb.Update(func(txn *badger.Txn) error {
-		err := txn.Set(GetKey("Group", group.Name), encoded)
-		return err
+		//	Save it to the database (as the first version):
+		group.Version = 1
+		return setItem(txn, GetKey("Group", group.Name), group)
 	})
 
 	//	If there was an error saving the data, report it:
 	if err != nil {
-		return retval, fmt.Errorf("Problem saving the data: %s", err)
+		return retval, err
 	}
 
 	//	Set our retval:
@@ -82,7 +69,7 @@ func (store Manager) AddGroup(context User, groupName string, groupDescription s
 	return retval, nil
 }
 
-// GetGroup gets a user from the system
+// GetGroup gets a group from the system.  A deleted group is only returned if the manager gets deleted items (see WithDeleted)
 func (store Manager) GetGroup(context User, groupName string) (Group, error) {
 	//	Our return item
 	retval := Group{}
@@ -109,6 +96,11 @@ func (store Manager) GetGroup(context User, groupName string) (Group, error) {
 			}
 		}
 
+		//	Deleted items are filtered out (unless they're asked for)
+		if retval.Deleted.Valid && !store.includeDeleted {
+			return badger.ErrKeyNotFound
+		}
+
 		return nil
 	})
 
@@ -121,195 +113,317 @@ func (store Manager) GetGroup(context User, groupName string) (Group, error) {
 	return retval, nil
 }
 
-// GetAllGroups gets all groups in the system
-func (store Manager) GetAllGroups(context User) ([]Group, error) {
+// GetAllGroups gets the groups in the system that match the list options (a page at a time, if the
+// options have a limit).  Returns the groups and the cursor for the next page (empty if this is the last page)
+func (store Manager) GetAllGroups(context User, options ListOptions) ([]Group, string, error) {
 	//	Our return item
 	retval := []Group{}
 
 	//	Security check:  Are we authorized to perform this action?
 	if !store.IsUserRequestAuthorized(context, sysreqGetAllGroups) {
-		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
+		return retval, "", fmt.Errorf("User %s is not authorized to perform the action", context.Name)
 	}
 
-	err := store.systemdb.View(func(txn *badger.Txn) error {
-
-		//	Get an iterator
-		it := txn.NewIterator(badger.DefaultIteratorOptions)
-		defer it.Close()
-
-		//	Set our prefix
-		prefix := GetKey("Group")
-
-		//	Iterate over our values:
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-
-			//	Get the item
-			item := it.Item()
-
-			//	Get the item key
-			// k := item.Key()
-
-			//	Get the item value
-			val, err := item.Value()
-			if err != nil {
-				return err
-			}
-
-			if len(val) > 0 {
-				//	Create our item:
-				item := Group{}
-
-				//	Unmarshal data into our item
-				if err := json.Unmarshal(val, &item); err != nil {
-					return err
-				}
-
-				//	Add to the array of returned users:
-				retval = append(retval, item)
-			}
+	entries, nextCursor, err := store.listItems("Group", options, func(val []byte) (listEntry, error) {
+		//	Unmarshal data into our item
+		item := Group{}
+		if err := json.Unmarshal(val, &item); err != nil {
+			return listEntry{}, err
 		}
-		return nil
+
+		return listEntry{item: item, name: item.Name, created: item.Created, updated: item.Updated, deleted: item.Deleted.Valid,
+			enabled: !item.Deleted.Valid, users: item.Users, roles: item.Roles, policies: item.Policies}, nil
 	})
 
 	//	If there was an error, report it:
 	if err != nil {
-		return retval, fmt.Errorf("Problem getting the list of items: %s", err)
+		return retval, "", err
+	}
+
+	//	Add to the array of returned groups:
+	for _, entry := range entries {
+		retval = append(retval, entry.item.(Group))
 	}
 
 	//	Return our data:
-	return retval, nil
+	return retval, nextCursor, nil
 }
 
 // AddUsersToGroup adds user(s) to a group -- and tracks that relationship
-// at the group level and at the user level
+// at the group level and at the user level.  If the manager makes time-bound
+// attachments (see During), the users get a grant for the time period instead
 func (store Manager) AddUsersToGroup(context User, groupName string, users ...string) (Group, error) {
 	//	Our return item
 	retval := Group{}
-	affectedUsers := []User{}
 
 	//	Security check:  Are we authorized to perform this action?
 	if !store.IsUserRequestAuthorized(context, sysreqAddUsersToGroup) {
 		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
 	}
 
-	//	First -- validate that the group exists
-	err := store.systemdb.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(GetKey("Group", groupName))
-		if err != nil {
-			return err
+	//	If the attachment is time-bound, check the time period
+	grant, grantErr := store.newGrant(context, AccessGroup, groupName)
+	if store.timeBound() && grantErr != nil {
+		return retval, grantErr
+	}
+
+	err := store.update(newAuditEvent(context.Name, sysreqAddUsersToGroup.Action, "Group", groupName), func(txn *writeTxn) error {
+		//	First -- validate that the group exists
+		retval = Group{}
+		if err := getItem(txn, GetKey("Group", groupName), &retval); err != nil {
+			return fmt.Errorf("Group does not exist")
 		}
-		val, err := item.Value()
-		if err != nil {
+		if retval.Deleted.Valid {
+			return fmt.Errorf("Group is deleted")
+		}
+
+		//	Make sure the group hasn't changed since the caller last read it
+		if err := store.checkVersion(retval.Version); err != nil {
 			return err
 		}
 
-		if len(val) > 0 {
-			//	Unmarshal data into our item
-			if err := json.Unmarshal(val, &retval); err != nil {
+		//	Next -- validate that each of the users exist and add the group to each user
+		for _, currentuser := range users {
+			affectedUser := User{}
+			if err := getItem(txn, GetKey("User", currentuser), &affectedUser); err != nil {
+				return fmt.Errorf("User %s doesn't exist", currentuser)
+			}
+			if affectedUser.Deleted.Valid {
+				return fmt.Errorf("User %s is deleted", currentuser)
+			}
+
+			if store.timeBound() {
+				affectedUser.Grants = addGrant(affectedUser.Grants, grant)
+			} else {
+				affectedUser.Groups = mergeItems(affectedUser.Groups, groupName)
+			}
+			affectedUser.Version++
+			if err := setItem(txn, GetKey("User", affectedUser.Name), affectedUser); err != nil {
 				return err
 			}
 		}
 
-		return err
+		//	Get the group's new list of users from a merged (and deduped) list of:
+		//	- The existing group users
+		// 	- The list of users passed in
+		retval.Users = mergeItems(retval.Users, users...)
+
+		retval.Version++
+		return setItem(txn, GetKey("Group", retval.Name), retval)
 	})
 
+	//	If there was an error saving the data, report it:
 	if err != nil {
-		return retval, fmt.Errorf("Group does not exist")
+		return retval, err
 	}
 
-	//	Next:
-	//	- Validate that each of the users exist
-	//	- Track each user in 'affectedUsers'
-	for _, currentuser := range users {
-		err := store.systemdb.View(func(txn *badger.Txn) error {
-			item, err := txn.Get(GetKey("User", currentuser))
+	//	Return our data:
+	return retval, nil
+}
+
+// DeleteGroup removes a group from the system.  The group is removed from any users, roles and
+// policies it is attached to (as part of the same transaction) and the list of affected items is returned
+func (store Manager) DeleteGroup(context User, groupName string) (CascadeResult, error) {
+	//	Our return item
+	retval := CascadeResult{}
 
-			if err != nil {
-				return err
+	//	Security check:  Are we authorized to perform this action?
+	if !store.IsUserRequestAuthorized(context, sysreqDeleteGroup) {
+		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
+	}
+
+	err := store.update(newAuditEvent(context.Name, sysreqDeleteGroup.Action, "Group", groupName), func(txn *writeTxn) error {
+		//	Start with an empty list of affected items
+		retval = CascadeResult{}
+
+		//	First -- does the group exist?
+		group := Group{}
+		if err := getItem(txn, GetKey("Group", groupName), &group); err != nil {
+			return fmt.Errorf("Group does not exist")
+		}
+
+		//	Make sure the group hasn't changed since the caller last read it
+		if err := store.checkVersion(group.Version); err != nil {
+			return err
+		}
+
+		//	If it's already deleted, there is nothing to do
+		if group.Deleted.Valid {
+			return fmt.Errorf("Group is already deleted")
+		}
+
+		//	Remove the group from users / roles / policies
+		for _, userName := range group.Users {
+			user := User{}
+			if err := getItem(txn, GetKey("User", userName), &user); err != nil {
+				continue // The user is already gone
 			}
 
-			//	Deserialize the user and add to the list of affected users
-			val, err := item.Value()
-			if err != nil {
+			//	Grants for the group are dropped (and aren't restored with the group)
+			attached := containsItem(user.Groups, group.Name)
+			user.Groups = removeItem(user.Groups, group.Name)
+			user.Grants = removeGrant(user.Grants, AccessGroup, group.Name)
+			user.Version++
+			if err := setItem(txn, GetKey("User", user.Name), user); err != nil {
 				return err
 			}
+			if attached {
+				retval.Users = append(retval.Users, user.Name)
+			}
+		}
 
-			if len(val) > 0 {
-				currentuserObject := User{}
-
-				//	Unmarshal data into our item
-				if err := json.Unmarshal(val, &currentuserObject); err != nil {
-					return err
-				}
+		for _, roleName := range group.Roles {
+			role := Role{}
+			if err := getItem(txn, GetKey("Role", roleName), &role); err != nil {
+				continue // The role is already gone
+			}
 
-				//	Add the object to our list of affected users:
-				affectedUsers = append(affectedUsers, currentuserObject)
+			role.Groups = removeItem(role.Groups, group.Name)
+			role.Version++
+			if err := setItem(txn, GetKey("Role", role.Name), role); err != nil {
+				return err
 			}
+			retval.Roles = append(retval.Roles, role.Name)
+		}
 
-			return err
-		})
+		for _, policyName := range group.Policies {
+			policy := Policy{}
+			if err := getItem(txn, GetKey("Policy", policyName), &policy); err != nil {
+				continue // The policy is already gone
+			}
 
-		if err != nil {
-			return retval, fmt.Errorf("User %s doesn't exist", currentuser)
+			policy.Groups = removeItem(policy.Groups, group.Name)
+			policy.Version++
+			if err := setItem(txn, GetKey("Policy", policy.Name), policy); err != nil {
+				return err
+			}
+			retval.Policies = append(retval.Policies, policy.Name)
 		}
-	}
 
-	//	Get the group's new list of users from a merged (and deduped) list of:
-	//	- The existing group users
-	// 	- The list of users passed in
-	allGroupUsers := append(retval.Users, users...)
-	allUniqueGroupUsers := sort.StringSlice(allGroupUsers)
+		//	Reset the users / roles / policies collections:
+		group.Users = []string{}
+		group.Roles = []string{}
+		group.Policies = []string{}
 
-	sort.Sort(allUniqueGroupUsers)                // sort the data first
-	n := set.Uniq(allUniqueGroupUsers)            // Uniq returns the size of the set
-	allUniqueGroupUsers = allUniqueGroupUsers[:n] // trim the duplicate elements
+		//	Update the updated / deleted fields:
+		group.Deleted = zero.TimeFrom(time.Now())
+		group.Updated = time.Now()
+		group.DeletedBy = null.StringFrom(context.Name)
+		group.UpdatedBy = context.Name
+		group.Version++
 
-	//	Then add each of users to both ...
-	//	the usergroup
-	retval.Users = allUniqueGroupUsers
+		//	Keep track of the items it was removed from (so it can be restored later):
+		if err := setItemWithTTL(txn, GetKey("RecycleBin", "Group", group.Name), retval, store.DeletedRetention); err != nil {
+			return err
+		}
+
+		//	Save it to the database with a TTL:
+		return setItemWithTTL(txn, GetKey("Group", group.Name), group, store.DeletedRetention)
+	})
 
-	//	Serialize the group to JSON format
-	encoded, err := json.Marshal(retval)
+	//	If there was an error deleting the data, report it:
 	if err != nil {
-		return retval, fmt.Errorf("Problem serializing the data: %s", err)
+		return retval, err
 	}
 
-	//	Save it to the database:
-	err = store.systemdb.Update(func(txn *badger.Txn) error {
-		err := txn.Set(GetKey("Group", retval.Name), encoded)
-		if err != nil {
+	//	Return our data:
+	return retval, nil
+}
+
+// RestoreGroup restores a deleted group (if it hasn't been removed from the system yet).  The group
+// is added back to the users, roles and policies it was removed from when it was deleted (if they still exist)
+func (store Manager) RestoreGroup(context User, groupName string) (Group, error) {
+	//	Our return item
+	retval := Group{}
+
+	//	Security check:  Are we authorized to perform this action?
+	if !store.IsUserRequestAuthorized(context, sysreqRestoreGroup) {
+		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
+	}
+
+	err := store.update(newAuditEvent(context.Name, sysreqRestoreGroup.Action, "Group", groupName), func(txn *writeTxn) error {
+		//	First -- does the group exist?
+		retval = Group{}
+		if err := getItem(txn, GetKey("Group", groupName), &retval); err != nil {
+			return fmt.Errorf("Group does not exist")
+		}
+
+		//	Make sure the group hasn't changed since the caller last read it
+		if err := store.checkVersion(retval.Version); err != nil {
 			return err
 		}
 
-		//	Save each affected user to the database (as part of the same transaction):
-		for _, affectedUser := range affectedUsers {
+		//	We can only restore deleted items
+		if !retval.Deleted.Valid {
+			return fmt.Errorf("Group is not deleted")
+		}
+
+		//	Get the items the group was removed from when it was deleted
+		removed := CascadeResult{}
+		getItem(txn, GetKey("RecycleBin", "Group", retval.Name), &removed)
+
+		//	Add the group back to users, roles and policies
+		for _, userName := range removed.Users {
+			user := User{}
+			if err := getItem(txn, GetKey("User", userName), &user); err != nil || user.Deleted.Valid {
+				continue // The user is gone
+			}
 
-			//	and add the group to each user
-			currentGroups := append(affectedUser.Groups, groupName)
-			allUniqueCurrentGroups := sort.StringSlice(currentGroups)
+			user.Groups = mergeItems(user.Groups, retval.Name)
+			user.Version++
+			if err := setItem(txn, GetKey("User", user.Name), user); err != nil {
+				return err
+			}
+			retval.Users = mergeItems(retval.Users, user.Name)
+		}
 
-			sort.Sort(allUniqueCurrentGroups)                    // sort the data first
-			cn := set.Uniq(allUniqueCurrentGroups)               // Uniq returns the size of the set
-			allUniqueCurrentGroups = allUniqueCurrentGroups[:cn] // trim the duplicate elements
-			affectedUser.Groups = allUniqueCurrentGroups
+		for _, roleName := range removed.Roles {
+			role := Role{}
+			if err := getItem(txn, GetKey("Role", roleName), &role); err != nil || role.Deleted.Valid {
+				continue // The role is gone
+			}
 
-			encoded, err := json.Marshal(affectedUser)
-			if err != nil {
+			role.Groups = mergeItems(role.Groups, retval.Name)
+			role.Version++
+			if err := setItem(txn, GetKey("Role", role.Name), role); err != nil {
 				return err
 			}
+			retval.Roles = mergeItems(retval.Roles, role.Name)
+		}
+
+		for _, policyName := range removed.Policies {
+			policy := Policy{}
+			if err := getItem(txn, GetKey("Policy", policyName), &policy); err != nil || policy.Deleted.Valid {
+				continue // The policy is gone
+			}
 
-			err = txn.Set(GetKey("User", affectedUser.Name), encoded)
-			if err != nil {
+			policy.Groups = mergeItems(policy.Groups, retval.Name)
+			policy.Version++
+			if err := setItem(txn, GetKey("Policy", policy.Name), policy); err != nil {
 				return err
 			}
+			retval.Policies = mergeItems(retval.Policies, policy.Name)
 		}
 
-		return nil
+		//	Reset the deleted fields and update the updated fields:
+		retval.Deleted = zero.Time{}
+		retval.DeletedBy = null.String{}
+		retval.Updated = time.Now()
+		retval.UpdatedBy = context.Name
+		retval.Version++
+
+		//	We don't need to keep track of the removed items anymore:
+		if err := txn.Delete(GetKey("RecycleBin", "Group", retval.Name)); err != nil {
+			return err
+		}
+
+		//	Save it to the database (without a TTL):
+		return setItem(txn, GetKey("Group", retval.Name), retval)
 	})
 
-	//	If there was an error saving the data, report it:
+	//	If there was an error restoring the data, report it:
 	if err != nil {
-		return retval, fmt.Errorf("Problem completing the group updates: %s", err)
+		return retval, err
 	}
 
 	//	Return our data:
@@ -1,10 +1,13 @@
 package data_test
 
 import (
+	"fmt"
 	"os"
+	"sync"
 	"testing"
 
 	"github.com/danesparza/iamserver/data"
+	"github.com/danesparza/iamserver/policy"
 )
 
 func TestGroup_AddGroup_ValidGroup_Successful(t *testing.T) {
@@ -277,3 +280,150 @@ func TestGroup_AddUsersToGroup_GroupAndUsersExist_Successful(t *testing.T) {
 	}
 
 }
+
+func TestGroup_DeleteGroup_GroupIsAttached_RemovesReferences(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+
+	db.AddUser(contextUser, data.User{Name: "Unittestuser1"}, "testpass")
+	db.AddGroup(contextUser, "Unittestgroup1", "")
+	db.AddRole(contextUser, "Unittestrole1", "")
+	db.AddResource(contextUser, "Someresource", "")
+	db.AddPolicy(contextUser, data.Policy{Name: "Unittestpolicy1", Effect: policy.Allow, Resources: []string{"Someresource"}, Actions: []string{"Someaction"}})
+
+	db.AddUsersToGroup(contextUser, "Unittestgroup1", "Unittestuser1")
+	db.AttachRoleToGroups(contextUser, "Unittestrole1", "Unittestgroup1")
+	db.AttachPolicyToGroups(contextUser, "Unittestpolicy1", "Unittestgroup1")
+
+	//	Act
+	cascade, err := db.DeleteGroup(contextUser, "Unittestgroup1")
+
+	//	Assert
+	if err != nil {
+		t.Errorf("DeleteGroup - Should delete group without error, but got: %s", err)
+	}
+
+	if len(cascade.Users) != 1 || len(cascade.Roles) != 1 || len(cascade.Policies) != 1 {
+		t.Errorf("DeleteGroup - Should have reported 1 affected user, role and policy, but got: %+v", cascade)
+	}
+
+	user1, _ := db.GetUser(contextUser, "Unittestuser1")
+	if len(user1.Groups) != 0 {
+		t.Errorf("DeleteGroup - Should have removed the group from the user, but user groups are: %v", user1.Groups)
+	}
+
+	role1, _ := db.GetRole(contextUser, "Unittestrole1")
+	if len(role1.Groups) != 0 {
+		t.Errorf("DeleteGroup - Should have removed the group from the role, but role groups are: %v", role1.Groups)
+	}
+
+	policy1, _ := db.GetPolicy(contextUser, "Unittestpolicy1")
+	if len(policy1.Groups) != 0 {
+		t.Errorf("DeleteGroup - Should have removed the group from the policy, but policy groups are: %v", policy1.Groups)
+	}
+}
+
+func TestGroup_AddUsersToGroup_Concurrent_AddsAllUsers(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	userCount := 5
+
+	db.AddGroup(contextUser, "Unittestgroup1", "")
+
+	for i := 0; i < userCount; i++ {
+		db.AddUser(contextUser, data.User{Name: fmt.Sprintf("Unittestuser%v", i)}, "testpass")
+	}
+
+	//	Act
+	var wg sync.WaitGroup
+	errs := make(chan error, userCount)
+	for i := 0; i < userCount; i++ {
+		wg.Add(1)
+		go func(userName string) {
+			defer wg.Done()
+			_, err := db.AddUsersToGroup(contextUser, "Unittestgroup1", userName)
+			errs <- err
+		}(fmt.Sprintf("Unittestuser%v", i))
+	}
+	wg.Wait()
+	close(errs)
+
+	//	Assert
+	for err := range errs {
+		if err != nil {
+			t.Errorf("AddUsersToGroup - Should add users concurrently without error, but got: %s", err)
+		}
+	}
+
+	group1, _ := db.GetGroup(contextUser, "Unittestgroup1")
+	if len(group1.Users) != userCount {
+		t.Errorf("AddUsersToGroup - Expected %v users in the group, but got %v", userCount, group1.Users)
+	}
+}
+
+func TestGroup_RestoreGroup_GroupIsDeleted_RestoresReferences(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+
+	db.AddUser(contextUser, data.User{Name: "Unittestuser1"}, "testpass")
+	db.AddUser(contextUser, data.User{Name: "Unittestuser2"}, "testpass")
+	db.AddGroup(contextUser, "Unittestgroup1", "")
+	db.AddUsersToGroup(contextUser, "Unittestgroup1", "Unittestuser1", "Unittestuser2")
+	db.DeleteGroup(contextUser, "Unittestgroup1")
+
+	//	One of the users is deleted in the meantime
+	db.DeleteUser(contextUser, "Unittestuser2")
+
+	//	Act
+	group1, err := db.RestoreGroup(contextUser, "Unittestgroup1")
+
+	//	Assert
+	if err != nil {
+		t.Errorf("RestoreGroup - Should restore group without error, but got: %s", err)
+	}
+
+	if len(group1.Users) != 1 || group1.Users[0] != "Unittestuser1" {
+		t.Errorf("RestoreGroup - Should have only added the group back to the user that still exists, but group users are: %v", group1.Users)
+	}
+
+	user1, _ := db.GetUser(contextUser, "Unittestuser1")
+	if len(user1.Groups) != 1 {
+		t.Errorf("RestoreGroup - Should have added the group back to the user, but user groups are: %v", user1.Groups)
+	}
+}
@@ -0,0 +1,601 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/danesparza/badger"
+	"github.com/danesparza/iamserver/policy"
+)
+
+// Lint severities (from most to least severe)
+const (
+	LintError   = "error"
+	LintWarning = "warning"
+	LintInfo    = "info"
+)
+
+// Lint rules:
+// - no-match: A statement doesn't match any registered resource and action
+// - shadowed: An allow statement only allows requests that are always denied (for each of the users it applies to)
+// - duplicate: A policy has the same statements as another policy
+// - over-broad: An allow statement matches every resource and/or every action, and is granted to a large group
+const (
+	LintNoMatch   = "no-match"
+	LintShadowed  = "shadowed"
+	LintDuplicate = "duplicate"
+	LintOverBroad = "over-broad"
+)
+
+// defaultLargeGroupSize is the number of users a group needs to be a large group (if it isn't set in the lint options)
+const defaultLargeGroupSize = 10
+
+// lintProbes are the names a pattern has to match to be considered a pattern that matches everything
+var lintProbes = []string{"x", "System", "orders:1234/items", "~ Z_9"}
+
+// LintOptions are the options for linting policies:
+// - LargeGroupSize: The number of users a group needs to be a large group (for the over-broad rule)
+type LintOptions struct {
+	LargeGroupSize int `json:"large_group_size"`
+}
+
+// LintFinding is a problem found with a policy (or one of its statements)
+type LintFinding struct {
+	Rule      string   `json:"rule"`
+	Severity  string   `json:"severity"`
+	Policy    string   `json:"policy"`
+	Statement string   `json:"statement,omitempty"`
+	Message   string   `json:"message"`
+	Related   []string `json:"related,omitempty"`
+}
+
+// LintReport is the result of linting the policies in the system (with the number of findings at each severity)
+type LintReport struct {
+	Findings []LintFinding `json:"findings"`
+	Errors   int           `json:"errors"`
+	Warnings int           `json:"warnings"`
+	Infos    int           `json:"infos"`
+}
+
+// HasFindings returns true if the report has any findings at (or above) the given severity
+func (r LintReport) HasFindings(severity string) bool {
+	for _, finding := range r.Findings {
+		if severityRank(finding.Severity) >= severityRank(severity) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// severityRank gets the rank of a severity (higher is more severe).  Unknown severities have a rank above all others
+func severityRank(severity string) int {
+	switch severity {
+	case LintInfo:
+		return 1
+	case LintWarning:
+		return 2
+	case LintError:
+		return 3
+	}
+
+	return 4
+}
+
+// lintSnapshot is everything the lint rules look at, read in a single transaction
+type lintSnapshot struct {
+	policies    map[string]Policy
+	names       []string
+	requests    []*Request
+	groups      map[string]Group
+	roles       map[string]Role
+	users       map[string]userPolicies
+	userNames   []string
+	policyUsers map[string][]string
+}
+
+// LintPolicies checks the policies in the system for statements that never match a registered resource and action,
+// allows that are shadowed by denies, duplicate policies and over-broad patterns granted to large groups.
+// Findings are sorted by severity (most severe first), then by policy
+func (store Manager) LintPolicies(context User, options LintOptions) (LintReport, error) {
+	//	Our return item
+	retval := LintReport{Findings: []LintFinding{}}
+
+	//	Security check:  Are we authorized to perform this action?
+	if !store.IsUserRequestAuthorized(context, sysreqLintPolicies) {
+		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
+	}
+
+	if options.LargeGroupSize <= 0 {
+		options.LargeGroupSize = defaultLargeGroupSize
+	}
+
+	snapshot, err := store.getLintSnapshot()
+	if err != nil {
+		return retval, fmt.Errorf("Problem reading the policies to lint: %s", err)
+	}
+
+	retval.Findings = append(retval.Findings, lintNoMatch(snapshot)...)
+	retval.Findings = append(retval.Findings, lintShadowed(snapshot)...)
+	retval.Findings = append(retval.Findings, lintDuplicates(snapshot)...)
+	retval.Findings = append(retval.Findings, lintOverBroad(snapshot, options.LargeGroupSize)...)
+
+	sort.SliceStable(retval.Findings, func(i, j int) bool {
+		a, b := retval.Findings[i], retval.Findings[j]
+		if severityRank(a.Severity) != severityRank(b.Severity) {
+			return severityRank(a.Severity) > severityRank(b.Severity)
+		}
+		if a.Policy != b.Policy {
+			return a.Policy < b.Policy
+		}
+		if a.Statement != b.Statement {
+			return a.Statement < b.Statement
+		}
+		return a.Rule < b.Rule
+	})
+
+	for _, finding := range retval.Findings {
+		switch finding.Severity {
+		case LintError:
+			retval.Errors++
+		case LintWarning:
+			retval.Warnings++
+		case LintInfo:
+			retval.Infos++
+		}
+	}
+
+	return retval, nil
+}
+
+// getLintSnapshot reads the (not deleted) policies, registered resources and actions, groups, roles and users,
+// along with the effective policies of each user
+func (store Manager) getLintSnapshot() (lintSnapshot, error) {
+	retval := lintSnapshot{
+		policies:    map[string]Policy{},
+		groups:      map[string]Group{},
+		roles:       map[string]Role{},
+		users:       map[string]userPolicies{},
+		policyUsers: map[string][]string{},
+	}
+
+	err := store.systemdb.View(func(txn *badger.Txn) error {
+		err := forEachItem(txn, GetKey("Policy"), func(val []byte) error {
+			item := Policy{}
+			if err := json.Unmarshal(val, &item); err != nil || item.Deleted.Valid {
+				return err
+			}
+			retval.policies[item.Name] = item
+			retval.names = append(retval.names, item.Name)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = forEachItem(txn, GetKey("Resource"), func(val []byte) error {
+			item := Resource{}
+			if err := json.Unmarshal(val, &item); err != nil || item.Deleted.Valid {
+				return err
+			}
+			for _, action := range item.Actions {
+				retval.requests = append(retval.requests, &Request{Resource: item.Name, Action: action})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = forEachItem(txn, GetKey("Group"), func(val []byte) error {
+			item := Group{}
+			if err := json.Unmarshal(val, &item); err != nil || item.Deleted.Valid {
+				return err
+			}
+			retval.groups[item.Name] = item
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = forEachItem(txn, GetKey("Role"), func(val []byte) error {
+			item := Role{}
+			if err := json.Unmarshal(val, &item); err != nil || item.Deleted.Valid {
+				return err
+			}
+			retval.roles[item.Name] = item
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = forEachItem(txn, GetKey("User"), func(val []byte) error {
+			item := User{}
+			if err := json.Unmarshal(val, &item); err != nil || item.Deleted.Valid {
+				return err
+			}
+			retval.userNames = append(retval.userNames, item.Name)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		//	Get the effective policies of each user
+		for _, userName := range retval.userNames {
+			effective, err := resolvePolicies(txn, userName)
+			if err != nil {
+				return err
+			}
+			retval.users[userName] = effective
+
+			for policyName := range effective.policies {
+				retval.policyUsers[policyName] = append(retval.policyUsers[policyName], userName)
+			}
+		}
+
+		return nil
+	})
+
+	sort.Strings(retval.names)
+	sort.Strings(retval.userNames)
+	for _, users := range retval.policyUsers {
+		sort.Strings(users)
+	}
+
+	return retval, err
+}
+
+// lintMatches checks which of the requests the statement (of a policy with the given syntax) matches, with
+// the given (user) variables.  Conditions are ignored.  If the resources or actions of the statement have
+// variables that only a request can resolve, it can't be known what the statement matches and ok is false
+func lintMatches(s Statement, syntax string, variables map[string][]string, requests []*Request) (matches []bool, ok bool) {
+	for _, list := range [][]string{s.Resources, s.Actions, s.NotResources, s.NotActions} {
+		for _, pattern := range list {
+			if strings.Contains(pattern, variableStart+"context.") {
+				return nil, false
+			}
+		}
+	}
+
+	rule, err := compileRule(resolveStatement(s, syntax, variables), syntax, 0)
+	if err != nil {
+		return nil, false
+	}
+
+	matches = make([]bool, len(requests))
+	for i, r := range requests {
+		matches[i] = rule.matchesAction(r.Action) && rule.matchesResource(r.Resource)
+	}
+
+	return matches, true
+}
+
+// anyTrue returns true if any of the values are true
+func anyTrue(values []bool) bool {
+	for _, value := range values {
+		if value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lintNoMatch finds statements that don't match any registered resource and action.  Statements with
+// variables are checked for each of the users they apply to
+func lintNoMatch(snapshot lintSnapshot) []LintFinding {
+	retval := []LintFinding{}
+
+	for _, name := range snapshot.names {
+		p := snapshot.policies[name]
+		for i, s := range p.statements() {
+			known, matched := false, false
+
+			if !statementHasVariables(Statement{Resources: s.Resources, Actions: s.Actions, NotResources: s.NotResources, NotActions: s.NotActions}) {
+				matches, ok := lintMatches(s, p.Syntax, nil, snapshot.requests)
+				known, matched = ok, anyTrue(matches)
+			} else {
+				for _, userName := range snapshot.policyUsers[name] {
+					matches, ok := lintMatches(s, p.Syntax, snapshot.users[userName].variables, snapshot.requests)
+					if ok {
+						known, matched = true, anyTrue(matches)
+					}
+					if matched {
+						break
+					}
+				}
+			}
+
+			if known && !matched {
+				retval = append(retval, LintFinding{
+					Rule:      LintNoMatch,
+					Severity:  LintWarning,
+					Policy:    name,
+					Statement: statementID(s, i),
+					Message:   fmt.Sprintf("Statement '%s' doesn't match any registered resource and action", statementID(s, i)),
+				})
+			}
+		}
+	}
+
+	return retval
+}
+
+// lintShadowed finds allow statements where each request the statement allows (of the registered resources
+// and actions) is denied by a statement without conditions -- for each of the users the statement applies to
+func lintShadowed(snapshot lintSnapshot) []LintFinding {
+	type shadowState struct {
+		matched   bool
+		uncovered bool
+		unknown   bool
+		deniedBy  []string
+	}
+	states := map[string]*shadowState{}
+
+	type denyRule struct {
+		policy  string
+		matches []bool
+	}
+
+	for _, userName := range snapshot.userNames {
+		effective := snapshot.users[userName]
+
+		//	Find what the unconditional denies of the user match
+		denies := []denyRule{}
+		for _, name := range snapshot.names {
+			p, ok := effective.policies[name]
+			if !ok {
+				continue
+			}
+			for _, s := range p.statements() {
+				if s.Effect != policy.Deny || len(s.Conditions) > 0 {
+					continue
+				}
+				if matches, ok := lintMatches(s, p.Syntax, effective.variables, snapshot.requests); ok {
+					denies = append(denies, denyRule{policy: name, matches: matches})
+				}
+			}
+		}
+
+		//	Check each of the requests the allows of the user match
+		for _, name := range snapshot.names {
+			p, ok := effective.policies[name]
+			if !ok {
+				continue
+			}
+			for i, s := range p.statements() {
+				if s.Effect != policy.Allow {
+					continue
+				}
+
+				key := name + "\x00" + statementID(s, i)
+				if states[key] == nil {
+					states[key] = &shadowState{}
+				}
+				state := states[key]
+
+				matches, ok := lintMatches(s, p.Syntax, effective.variables, snapshot.requests)
+				if !ok {
+					state.unknown = true
+					continue
+				}
+
+				for j, matched := range matches {
+					if !matched {
+						continue
+					}
+					state.matched = true
+
+					covered := false
+					for _, deny := range denies {
+						if deny.matches[j] {
+							covered = true
+							state.deniedBy = mergeItems(state.deniedBy, deny.policy)
+							break
+						}
+					}
+					if !covered {
+						state.uncovered = true
+					}
+				}
+			}
+		}
+	}
+
+	retval := []LintFinding{}
+	for _, name := range snapshot.names {
+		for i, s := range snapshot.policies[name].statements() {
+			state := states[name+"\x00"+statementID(s, i)]
+			if state == nil || !state.matched || state.uncovered || state.unknown {
+				continue
+			}
+
+			retval = append(retval, LintFinding{
+				Rule:      LintShadowed,
+				Severity:  LintWarning,
+				Policy:    name,
+				Statement: statementID(s, i),
+				Message:   fmt.Sprintf("Statement '%s' only allows requests that are always denied (by %s)", statementID(s, i), quoteItems(state.deniedBy)),
+				Related:   state.deniedBy,
+			})
+		}
+	}
+
+	return retval
+}
+
+// lintDuplicates finds policies with the same syntax and statements as another policy.  The order of
+// the statements, the order of their patterns and their sids don't matter
+func lintDuplicates(snapshot lintSnapshot) []LintFinding {
+	retval := []LintFinding{}
+	first := map[string]string{}
+
+	for _, name := range snapshot.names {
+		p := snapshot.policies[name]
+
+		statements := []string{}
+		for _, s := range p.statements() {
+			canonical := Statement{
+				Effect:       s.Effect,
+				Resources:    sortedCopy(s.Resources),
+				Actions:      sortedCopy(s.Actions),
+				NotResources: sortedCopy(s.NotResources),
+				NotActions:   sortedCopy(s.NotActions),
+				Conditions:   Conditions{},
+			}
+			for operator, keys := range s.Conditions {
+				canonical.Conditions[operator] = map[string][]string{}
+				for key, values := range keys {
+					canonical.Conditions[operator][key] = sortedCopy(values)
+				}
+			}
+
+			encoded, _ := json.Marshal(canonical)
+			statements = append(statements, string(encoded))
+		}
+		sort.Strings(statements)
+
+		key := p.Syntax + "\n" + strings.Join(mergeItems(statements), "\n")
+		if original, ok := first[key]; ok {
+			retval = append(retval, LintFinding{
+				Rule:     LintDuplicate,
+				Severity: LintInfo,
+				Policy:   name,
+				Message:  fmt.Sprintf("Policy has the same statements as '%s'", original),
+				Related:  []string{original},
+			})
+			continue
+		}
+		first[key] = name
+	}
+
+	return retval
+}
+
+// lintOverBroad finds allow statements that match every resource and/or every action, in policies granted to
+// a large group (directly or through a role).  Matching everything is an error, matching every resource
+// (or every action) is a warning
+func lintOverBroad(snapshot lintSnapshot, largeGroupSize int) []LintFinding {
+	retval := []LintFinding{}
+
+	for _, name := range snapshot.names {
+		p := snapshot.policies[name]
+
+		//	Find the large groups the policy is granted to
+		groups := append([]string{}, p.Groups...)
+		for _, roleName := range p.Roles {
+			groups = append(groups, snapshot.roles[roleName].Groups...)
+		}
+
+		large := []string{}
+		described := []string{}
+		for _, groupName := range mergeItems(groups) {
+			group, ok := snapshot.groups[groupName]
+			if ok && len(group.Users) >= largeGroupSize {
+				large = append(large, groupName)
+				described = append(described, fmt.Sprintf("'%s' (%v users)", groupName, len(group.Users)))
+			}
+		}
+		if len(large) == 0 {
+			continue
+		}
+
+		for i, s := range p.statements() {
+			if s.Effect != policy.Allow {
+				continue
+			}
+
+			//	A statement that excludes everything doesn't allow anything
+			if anyMatchesEverything(s.NotResources, p.Syntax) || anyMatchesEverything(s.NotActions, p.Syntax) {
+				continue
+			}
+
+			resources, everyResource := describeScope(s.Resources, s.NotResources, p.Syntax, "resource")
+			actions, everyAction := describeScope(s.Actions, s.NotActions, p.Syntax, "action")
+			if !everyResource && !everyAction {
+				continue
+			}
+
+			finding := LintFinding{
+				Rule:      LintOverBroad,
+				Severity:  LintWarning,
+				Policy:    name,
+				Statement: statementID(s, i),
+				Related:   large,
+			}
+
+			if everyResource && everyAction {
+				finding.Severity = LintError
+			}
+
+			finding.Message = fmt.Sprintf("Statement '%s' allows %s on %s to large groups: %s", finding.Statement, actions, resources, strings.Join(described, ", "))
+			retval = append(retval, finding)
+		}
+	}
+
+	return retval
+}
+
+// anyMatchesEverything returns true if any of the patterns (in the given syntax) match every name
+func anyMatchesEverything(patterns []string, syntax string) bool {
+	for _, pattern := range patterns {
+		if strings.Contains(pattern, variableStart) {
+			continue
+		}
+
+		compiled, err := compilePattern(pattern, syntax, 0)
+		if err != nil || compiled.reg == nil {
+			continue
+		}
+
+		matchesAll := true
+		for _, probe := range lintProbes {
+			if !compiled.matches(probe) {
+				matchesAll = false
+				break
+			}
+		}
+		if matchesAll {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sortedCopy returns a sorted copy of the list
+func sortedCopy(list []string) []string {
+	retval := append([]string{}, list...)
+	sort.Strings(retval)
+	return retval
+}
+
+// describeScope describes the resources (or actions) a statement applies to:  every one of them, all but the
+// excluded ones (for not_resources and not_actions) or just the listed ones.  Also returns whether the scope
+// is (nearly) everything
+func describeScope(patterns []string, excluded []string, syntax string, kind string) (string, bool) {
+	if len(excluded) > 0 {
+		return fmt.Sprintf("every %s but %s", kind, quoteItems(excluded)), true
+	}
+
+	if anyMatchesEverything(patterns, syntax) {
+		return "every " + kind, true
+	}
+
+	return quoteItems(patterns), false
+}
+
+// quoteItems formats the items as a quoted list (like 'a', 'b')
+func quoteItems(items []string) string {
+	quoted := []string{}
+	for _, item := range items {
+		quoted = append(quoted, "'"+item+"'")
+	}
+
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,174 @@
+package data_test
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/danesparza/iamserver/data"
+	"github.com/danesparza/iamserver/policy"
+)
+
+func TestManager_LintPolicies_ReportsFindings(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	db.AddResource(contextUser, "Serenity", "")
+	db.AddActionToResource(contextUser, "Serenity", "Fly", "Land")
+	db.AddResource(contextUser, "Cargo", "")
+	db.AddActionToResource(contextUser, "Cargo", "Move")
+
+	db.AddGroup(contextUser, "crew", "")
+	for _, name := range []string{"bob", "jayne", "kaylee"} {
+		db.AddUser(contextUser, data.User{Name: name}, "testpass")
+	}
+	db.AddUsersToGroup(contextUser, "crew", "bob", "jayne", "kaylee")
+
+	testPolicies := []struct {
+		policy   data.Policy
+		attached bool
+	}{
+		{data.Policy{Name: "Fly ship", Statements: []data.Statement{{Effect: policy.Allow, Resources: []string{"Serenity"}, Actions: []string{"Fly"}}}}, true},
+		{data.Policy{Name: "Fly ship copy", Statements: []data.Statement{{Sid: "Copy", Effect: policy.Allow, Resources: []string{"Serenity"}, Actions: []string{"Fly"}}}}, false},
+		{data.Policy{Name: "No flying", Statements: []data.Statement{{Effect: policy.Deny, Resources: []string{"Serenity"}, Actions: []string{"Fly"}}}}, true},
+		{data.Policy{Name: "Submarine", Statements: []data.Statement{{Effect: policy.Allow, Resources: []string{"Submarine:<.*>"}, Actions: []string{"Dive"}}}}, false},
+		{data.Policy{Name: "Everything", Statements: []data.Statement{{Effect: policy.Allow, Resources: []string{"<.*>"}, Actions: []string{"<.*>"}}}}, true},
+		{data.Policy{Name: "Cargo", Syntax: policy.Glob, Statements: []data.Statement{{Effect: policy.Allow, Resources: []string{"Cargo"}, Actions: []string{"*"}}}}, true},
+		{data.Policy{Name: "Own profile", Statements: []data.Statement{{Effect: policy.Allow, Resources: []string{"Profile:${context.name}"}, Actions: []string{"Get"}}}}, true},
+	}
+	for _, test := range testPolicies {
+		if _, err := db.AddPolicy(contextUser, test.policy); err != nil {
+			t.Fatalf("AddPolicy failed: %s", err)
+		}
+		if test.attached {
+			db.AttachPolicyToGroups(contextUser, test.policy.Name, "crew")
+		}
+	}
+
+	//	Act
+	report, err := db.LintPolicies(contextUser, data.LintOptions{LargeGroupSize: 3})
+
+	//	Assert
+	if err != nil {
+		t.Fatalf("LintPolicies - Should lint without error, but got: %s", err)
+	}
+
+	found := []string{}
+	for _, finding := range report.Findings {
+		found = append(found, finding.Severity+" "+finding.Rule+" "+finding.Policy)
+	}
+	sort.Strings(found)
+
+	expected := []string{
+		"error over-broad Everything",
+		"info duplicate Fly ship copy",
+		"warning no-match Submarine",
+		"warning over-broad Cargo",
+		"warning shadowed Fly ship",
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("LintPolicies - Expected findings %v, but got %v", expected, found)
+	}
+
+	if len(report.Findings) > 0 && report.Findings[0].Severity != data.LintError {
+		t.Errorf("LintPolicies - Expected the most severe findings first, but got %+v", report.Findings[0])
+	}
+
+	if report.Errors != 1 || report.Warnings != 3 || report.Infos != 1 {
+		t.Errorf("LintPolicies - Expected the findings to be counted by severity, but got %+v", report)
+	}
+
+	if !report.HasFindings(data.LintWarning) || report.HasFindings("none") {
+		t.Errorf("LintPolicies - Expected the report to have warnings (and nothing above 'none')")
+	}
+
+}
+
+func TestManager_LintPolicies_NotResources_ReportsAllBut(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	db.AddResource(contextUser, "Serenity", "")
+	db.AddActionToResource(contextUser, "Serenity", "Fly", "Land")
+
+	db.AddGroup(contextUser, "crew", "")
+	for _, name := range []string{"bob", "jayne", "kaylee"} {
+		db.AddUser(contextUser, data.User{Name: name}, "testpass")
+	}
+	db.AddUsersToGroup(contextUser, "crew", "bob", "jayne", "kaylee")
+
+	db.AddPolicy(contextUser, data.Policy{Name: "Not the ship", Statements: []data.Statement{{Sid: "Ground", Effect: policy.Allow, NotResources: []string{"Serenity"}, Actions: []string{"Land"}}}})
+	db.AddPolicy(contextUser, data.Policy{Name: "Nothing", Statements: []data.Statement{{Effect: policy.Allow, NotResources: []string{"<.*>"}, Actions: []string{"Fly"}}}})
+	db.AttachPolicyToGroups(contextUser, "Not the ship", "crew")
+	db.AttachPolicyToGroups(contextUser, "Nothing", "crew")
+
+	//	Act
+	report, err := db.LintPolicies(contextUser, data.LintOptions{LargeGroupSize: 3})
+
+	//	Assert
+	if err != nil {
+		t.Fatalf("LintPolicies - Should lint without error, but got: %s", err)
+	}
+
+	overBroad := []data.LintFinding{}
+	for _, finding := range report.Findings {
+		if finding.Rule == data.LintOverBroad {
+			overBroad = append(overBroad, finding)
+		}
+	}
+
+	expected := "Statement 'Ground' allows 'Land' on every resource but 'Serenity' to large groups: 'crew' (3 users)"
+	if len(overBroad) != 1 || overBroad[0].Policy != "Not the ship" || overBroad[0].Severity != data.LintWarning || overBroad[0].Message != expected {
+		t.Errorf("LintPolicies - Expected the not_resources statement to be reported as all but the excluded resource, but got %+v", overBroad)
+	}
+
+}
+
+func TestManager_LintPolicies_NotAuthorized_ReturnsError(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	db.AddUser(data.User{Name: "System"}, data.User{Name: "bob"}, "testpass")
+
+	//	Act
+	_, err = db.LintPolicies(data.User{Name: "bob"}, data.LintOptions{})
+
+	//	Assert
+	if err == nil {
+		t.Errorf("LintPolicies - Should not lint for a user without access")
+	}
+
+}
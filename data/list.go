@@ -0,0 +1,256 @@
+package data
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/danesparza/badger"
+	null "gopkg.in/guregu/null.v3"
+)
+
+// The fields items can be sorted by when they are listed
+const (
+	SortByName    = "name"
+	SortByCreated = "created"
+	SortByUpdated = "updated"
+)
+
+// ListOptions are the paging, filtering and sorting options used when listing items.
+// The zero value lists all (non-deleted) items sorted by name
+type ListOptions struct {
+	//	Cursor is the next cursor returned by a previous call.  Listing continues after the last item of that call
+	Cursor string
+
+	//	Limit is the maximum number of items to return (0 means no limit)
+	Limit int
+
+	//	IncludeDeleted includes deleted items
+	IncludeDeleted bool
+
+	//	Enabled only includes users that are enabled (or disabled).  Other items are always enabled
+	Enabled null.Bool
+
+	//	CreatedAfter / CreatedBefore only include items created in the given range (inclusive / exclusive)
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+
+	//	User / Group / Role / Policy only include items that are attached to the given user / group / role / policy
+	User   string
+	Group  string
+	Role   string
+	Policy string
+
+	//	SortBy is the field to sort by:  name (the default), created or updated
+	SortBy string
+
+	//	Descending sorts in descending order
+	Descending bool
+}
+
+// listEntry is an item found while listing items, along with the fields
+// used to filter and sort it
+type listEntry struct {
+	item     interface{}
+	name     string
+	created  time.Time
+	updated  time.Time
+	deleted  bool
+	enabled  bool
+	users    []string
+	groups   []string
+	roles    []string
+	policies []string
+}
+
+// matches returns true if the entry passes all filters of the list options
+func (options ListOptions) matches(entry listEntry) bool {
+	if entry.deleted && !options.IncludeDeleted {
+		return false
+	}
+
+	if options.Enabled.Valid && entry.enabled != options.Enabled.Bool {
+		return false
+	}
+
+	if !options.CreatedAfter.IsZero() && entry.created.Before(options.CreatedAfter) {
+		return false
+	}
+
+	if !options.CreatedBefore.IsZero() && !entry.created.Before(options.CreatedBefore) {
+		return false
+	}
+
+	if options.User != "" && !containsItem(entry.users, options.User) {
+		return false
+	}
+
+	if options.Group != "" && !containsItem(entry.groups, options.Group) {
+		return false
+	}
+
+	if options.Role != "" && !containsItem(entry.roles, options.Role) {
+		return false
+	}
+
+	if options.Policy != "" && !containsItem(entry.policies, options.Policy) {
+		return false
+	}
+
+	return true
+}
+
+// sortKey returns the key the entry is sorted by.  Sort keys compare as strings, and are used as cursors
+func (options ListOptions) sortKey(entry listEntry) string {
+	const sortableTime = "2006-01-02T15:04:05.000000000Z"
+
+	switch options.SortBy {
+	case SortByCreated:
+		return entry.created.UTC().Format(sortableTime) + "\x00" + entry.name
+	case SortByUpdated:
+		return entry.updated.UTC().Format(sortableTime) + "\x00" + entry.name
+	default:
+		return entry.name
+	}
+}
+
+// after returns true if the given sort key comes after the cursor sort key (in the sort direction)
+func (options ListOptions) after(key, cursor string) bool {
+	if options.Descending {
+		return key < cursor
+	}
+
+	return key > cursor
+}
+
+// listItems lists the items of the given entity type using the list options.  Each stored item is
+// turned into a list entry with decode.  Returns the entries and the cursor for the next page
+// (or an empty cursor if this is the last page).
+//
+// When sorting by name, the items are read in badger key order -- so only the items up to
+// the end of the page are read.  Sorting by any other field reads all items of the entity type
+func (store Manager) listItems(entityType string, options ListOptions, decode func(val []byte) (listEntry, error)) ([]listEntry, string, error) {
+	retval := []listEntry{}
+
+	//	Validate the options:
+	if options.SortBy == "" {
+		options.SortBy = SortByName
+	}
+	if options.SortBy != SortByName && options.SortBy != SortByCreated && options.SortBy != SortByUpdated {
+		return retval, "", fmt.Errorf("Can't sort by '%s'.  Sort by %s, %s or %s", options.SortBy, SortByName, SortByCreated, SortByUpdated)
+	}
+	if options.Limit < 0 {
+		return retval, "", fmt.Errorf("Limit can't be negative")
+	}
+
+	cursor := ""
+	if options.Cursor != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(options.Cursor)
+		if err != nil {
+			return retval, "", fmt.Errorf("Invalid cursor")
+		}
+		cursor = string(decoded)
+	}
+
+	//	If we're sorting by name, key order is sort order
+	inKeyOrder := options.SortBy == SortByName
+
+	err := store.systemdb.View(func(txn *badger.Txn) error {
+		retval = []listEntry{}
+
+		//	Get an iterator (in the sort direction if we're going in key order)
+		opts := badger.DefaultIteratorOptions
+		opts.Reverse = inKeyOrder && options.Descending
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		//	Set our prefix and find where to start:
+		prefix := GetKey(entityType, "")
+		start := prefix
+		if inKeyOrder && cursor != "" {
+			start = GetKey(entityType, cursor)
+		} else if opts.Reverse {
+			start = append(GetKey(entityType, ""), 0xFF)
+		}
+
+		//	Iterate over our values:
+		for it.Seek(start); it.ValidForPrefix(prefix); it.Next() {
+			val, err := it.Item().Value()
+			if err != nil {
+				return err
+			}
+
+			if len(val) == 0 {
+				continue
+			}
+
+			entry, err := decode(val)
+			if err != nil {
+				return err
+			}
+
+			if !options.matches(entry) {
+				continue
+			}
+
+			if inKeyOrder {
+				//	Skip the last item of the previous page:
+				if cursor != "" && !options.after(entry.name, cursor) {
+					continue
+				}
+
+				//	We only need to read one item past the end of the page
+				//	to know if there is another page
+				retval = append(retval, entry)
+				if options.Limit > 0 && len(retval) > options.Limit {
+					break
+				}
+				continue
+			}
+
+			retval = append(retval, entry)
+		}
+
+		return nil
+	})
+
+	//	If there was an error, report it:
+	if err != nil {
+		return []listEntry{}, "", fmt.Errorf("Problem getting the list of items: %s", err)
+	}
+
+	//	If we're not in key order, sort the items and find the start of the page
+	if !inKeyOrder {
+		sort.SliceStable(retval, func(i, j int) bool {
+			return options.after(options.sortKey(retval[j]), options.sortKey(retval[i]))
+		})
+
+		if cursor != "" {
+			pageStart := sort.Search(len(retval), func(i int) bool {
+				return options.after(options.sortKey(retval[i]), cursor)
+			})
+			retval = retval[pageStart:]
+		}
+	}
+
+	//	Trim to the page size and get the cursor for the next page:
+	nextCursor := ""
+	if options.Limit > 0 && len(retval) > options.Limit {
+		retval = retval[:options.Limit]
+		nextCursor = base64.RawURLEncoding.EncodeToString([]byte(options.sortKey(retval[len(retval)-1])))
+	}
+
+	return retval, nextCursor, nil
+}
+
+// containsItem returns true if the list contains the item
+func containsItem(list []string, item string) bool {
+	for _, current := range list {
+		if current == item {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,162 @@
+package data_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/danesparza/iamserver/data"
+)
+
+func TestList_GetAllUsers_WithLimit_ReturnsPages(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+
+	for i := 0; i < 5; i++ {
+		db.AddUser(contextUser, data.User{Name: fmt.Sprintf("Unittestuser%d", i)}, "testpass")
+	}
+
+	//	Act
+	names := []string{}
+	pages := 0
+	options := data.ListOptions{Limit: 2}
+	for {
+		users, nextCursor, err := db.GetAllUsers(contextUser, options)
+		if err != nil {
+			t.Fatalf("GetAllUsers - Should get a page of users without error, but got: %s", err)
+		}
+
+		pages++
+		for _, user := range users {
+			names = append(names, user.Name)
+		}
+
+		if nextCursor == "" || pages > 5 {
+			break
+		}
+		options.Cursor = nextCursor
+	}
+
+	//	Assert
+	if pages != 3 {
+		t.Errorf("GetAllUsers - Expected 3 pages of users, but got %v", pages)
+	}
+
+	expected := "[Unittestuser0 Unittestuser1 Unittestuser2 Unittestuser3 Unittestuser4]"
+	if fmt.Sprintf("%v", names) != expected {
+		t.Errorf("GetAllUsers - Expected users %s, but got %v", expected, names)
+	}
+}
+
+func TestList_GetAllUsers_SortDescending_ReturnsPagesInOrder(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+
+	for i := 0; i < 3; i++ {
+		db.AddUser(contextUser, data.User{Name: fmt.Sprintf("Unittestuser%d", i)}, "testpass")
+	}
+
+	for _, sortBy := range []string{data.SortByName, data.SortByCreated} {
+		//	Act
+		firstPage, nextCursor, _ := db.GetAllUsers(contextUser, data.ListOptions{Limit: 2, SortBy: sortBy, Descending: true})
+		secondPage, lastCursor, _ := db.GetAllUsers(contextUser, data.ListOptions{Limit: 2, SortBy: sortBy, Descending: true, Cursor: nextCursor})
+
+		//	Assert
+		if len(firstPage) != 2 || firstPage[0].Name != "Unittestuser2" || firstPage[1].Name != "Unittestuser1" {
+			t.Errorf("GetAllUsers - Expected the first page sorted by %s to be Unittestuser2 and Unittestuser1, but got %+v", sortBy, firstPage)
+		}
+
+		if len(secondPage) != 1 || secondPage[0].Name != "Unittestuser0" || lastCursor != "" {
+			t.Errorf("GetAllUsers - Expected the last page sorted by %s to be Unittestuser0, but got %+v (next cursor '%s')", sortBy, secondPage, lastCursor)
+		}
+	}
+}
+
+func TestList_GetAllUsers_WithFilters_ReturnsMatchingUsers(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+
+	db.AddUser(contextUser, data.User{Name: "Unittestuser1"}, "testpass")
+	user2, _ := db.AddUser(contextUser, data.User{Name: "Unittestuser2"}, "testpass")
+	db.AddUser(contextUser, data.User{Name: "Unittestuser3"}, "testpass")
+	db.AddGroup(contextUser, "Unittestgroup1", "")
+	db.AddUsersToGroup(contextUser, "Unittestgroup1", "Unittestuser1", "Unittestuser3")
+
+	//	Act
+	groupUsers, _, err := db.GetAllUsers(contextUser, data.ListOptions{Group: "Unittestgroup1"})
+	newUsers, _, _ := db.GetAllUsers(contextUser, data.ListOptions{CreatedAfter: user2.Created})
+
+	//	Assert
+	if err != nil {
+		t.Errorf("GetAllUsers - Should get users without error, but got: %s", err)
+	}
+
+	if len(groupUsers) != 2 || groupUsers[0].Name != "Unittestuser1" || groupUsers[1].Name != "Unittestuser3" {
+		t.Errorf("GetAllUsers - Expected only the users in the group, but got %+v", groupUsers)
+	}
+
+	if len(newUsers) != 2 || newUsers[0].Name != "Unittestuser2" {
+		t.Errorf("GetAllUsers - Expected only the users created since Unittestuser2, but got %+v", newUsers)
+	}
+}
+
+func TestList_GetAllUsers_InvalidSort_ReturnsError(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+
+	//	Act
+	_, _, err = db.GetAllUsers(contextUser, data.ListOptions{SortBy: "secrethash"})
+
+	//	Assert
+	if err == nil {
+		t.Errorf("GetAllUsers - Should return an error for an invalid sort field")
+	}
+}
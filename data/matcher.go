@@ -1,8 +1,34 @@
 package data
 
+import "github.com/danesparza/iamserver/policy"
+
 type matcher interface {
 	Matches(p Policy, haystack []string, needle string) (matches bool, error error)
 }
 
 // DefaultMatcher is the default matcher
-var DefaultMatcher = NewRegexpMatcher(512)
+var DefaultMatcher = NewSyntaxMatcher(512)
+
+// NewSyntaxMatcher creates and returns a new SyntaxMatcher (with caches of the given size)
+func NewSyntaxMatcher(size int) *SyntaxMatcher {
+	return &SyntaxMatcher{
+		Regexp: NewRegexpMatcher(size),
+		Glob:   NewGlobMatcher(size),
+	}
+}
+
+// SyntaxMatcher matches each policy with the matcher for the policy's syntax, so
+// policies with regexp patterns and policies with glob patterns can be used side by side
+type SyntaxMatcher struct {
+	Regexp *RegexpMatcher
+	Glob   *GlobMatcher
+}
+
+// Matches a needle with the patterns in the haystack (using the syntax of the policy) and returns true if a match was found.
+func (m *SyntaxMatcher) Matches(p Policy, haystack []string, needle string) (bool, error) {
+	if p.Syntax == policy.Glob {
+		return m.Glob.Matches(p, haystack, needle)
+	}
+
+	return m.Regexp.Matches(p, haystack, needle)
+}
@@ -0,0 +1,99 @@
+package data
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/pkg/errors"
+)
+
+// globMetaChars are the characters that make a glob pattern (the wildcards and the escape character)
+const globMetaChars = `*?\`
+
+// NewGlobMatcher creates and returns a new GlobMatcher
+func NewGlobMatcher(size int) *GlobMatcher {
+	if size <= 0 {
+		size = 512
+	}
+
+	// golang-lru only returns an error if the cache's size is 0. This, we can safely ignore this error.
+	cache, _ := lru.New(size)
+	return &GlobMatcher{
+		Cache: cache,
+	}
+}
+
+// GlobMatcher represents a glob (wildcard) matcher.  A '*' matches any run of characters
+// (including none) and a '?' matches any single character.  A '\' escapes the character
+// after it (so '\*' matches a '*').  Everything else matches itself
+type GlobMatcher struct {
+	*lru.Cache
+}
+
+// Matches a needle with an array of glob patterns and returns true if a match was found.
+func (m *GlobMatcher) Matches(p Policy, haystack []string, needle string) (bool, error) {
+	for _, h := range haystack {
+
+		// This means that the current haystack item does not contain a wildcard
+		if !strings.ContainsAny(h, globMetaChars) {
+			if h == needle {
+				return true, nil
+			}
+			continue
+		}
+
+		var reg *regexp.Regexp
+		if val, ok := m.Cache.Get(h); ok {
+			reg = val.(*regexp.Regexp)
+		} else {
+			var err error
+			if reg, err = compileGlob(h); err != nil {
+				return false, err
+			}
+			m.Cache.Add(h, reg)
+		}
+
+		if reg.MatchString(needle) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// compileGlob compiles a glob pattern to an (anchored) regular expression
+func compileGlob(pattern string) (*regexp.Regexp, error) {
+	expr := "(?s)^"
+	for {
+		i := strings.IndexAny(pattern, globMetaChars)
+		if i < 0 {
+			break
+		}
+
+		expr += regexp.QuoteMeta(pattern[:i])
+		switch {
+		case pattern[i] == '*':
+			expr += ".*"
+		case pattern[i] == '?':
+			expr += "."
+		case i+1 < len(pattern):
+			//	An escaped character (which may take more than one byte)
+			_, size := utf8.DecodeRuneInString(pattern[i+1:])
+			expr += regexp.QuoteMeta(pattern[i+1 : i+1+size])
+			i += size
+		default:
+			//	A trailing escape character matches itself
+			expr += regexp.QuoteMeta(pattern[i:])
+		}
+		pattern = pattern[i+1:]
+	}
+	expr += regexp.QuoteMeta(pattern) + "$"
+
+	reg, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return reg, nil
+}
@@ -0,0 +1,73 @@
+package data_test
+
+import (
+	"testing"
+
+	"github.com/danesparza/iamserver/data"
+	"github.com/danesparza/iamserver/policy"
+)
+
+func TestGlobMatcher_Matches(t *testing.T) {
+
+	//	Arrange
+	matcher := data.NewGlobMatcher(0)
+	tests := []struct {
+		pattern string
+		value   string
+		want    bool
+	}{
+		{"arn:app:orders/*", "arn:app:orders/1234", true},
+		{"arn:app:orders/*", "arn:app:orders/", true},
+		{"arn:app:orders/*", "arn:app:orders/1234/items/5", true},
+		{"arn:app:orders/*", "arn:app:invoices/1234", false},
+		{"arn:app:*/1234", "arn:app:orders/1234", true},
+		{"Get?", "GetA", true},
+		{"Get?", "Get", false},
+		{"Get?", "GetAll", false},
+		{"*", "", true},
+		{"Serenity.Bridge", "Serenity.Bridge", true},
+		{"Serenity.*", "SerenityXBridge", false},
+		{"<.*>", "Serenity", false},
+		{"<.*>", "<.*>", true},
+		{`orders/\*`, "orders/*", true},
+		{`orders/\*`, "orders/1234", false},
+		{`orders\`, `orders\`, true},
+	}
+
+	for _, test := range tests {
+		//	Act
+		got, err := matcher.Matches(data.Policy{Syntax: policy.Glob}, []string{test.pattern}, test.value)
+
+		//	Assert
+		if err != nil {
+			t.Errorf("Matches - Should match %s without error, but got: %s", test.pattern, err)
+		}
+
+		if got != test.want {
+			t.Errorf("Matches - Expected %s to match %s: %v, but got %v", test.pattern, test.value, test.want, got)
+		}
+	}
+
+}
+
+func TestSyntaxMatcher_Matches_UsesPolicySyntax(t *testing.T) {
+
+	//	Arrange
+	matcher := data.NewSyntaxMatcher(0)
+	haystack := []string{"Serenity:*", "Serenity:<.*>"}
+
+	//	Act
+	regexpMatch, _ := matcher.Matches(data.Policy{}, haystack, "Serenity:Bridge")
+	globMatch, _ := matcher.Matches(data.Policy{Syntax: policy.Glob}, haystack, "Serenity:Bridge")
+	literalMatch, _ := matcher.Matches(data.Policy{Syntax: policy.Regexp}, haystack, "Serenity:*")
+
+	//	Assert
+	if !regexpMatch || !globMatch {
+		t.Errorf("Matches - Expected both syntaxes to match, but got regexp: %v glob: %v", regexpMatch, globMatch)
+	}
+
+	if !literalMatch {
+		t.Errorf("Matches - Expected regexp policies to match '*' literally, but didn't")
+	}
+
+}
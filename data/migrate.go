@@ -0,0 +1,205 @@
+package data
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/danesparza/badger"
+)
+
+// migratePolicies moves the top-level statement of policies stored before policies had statements
+// to their statements.  Deleted policies keep their remaining time in the recycle bin.  Policies that
+// are already migrated are left alone, so this only records an audit event if there was something to migrate
+func (store Manager) migratePolicies() error {
+	//	Find the policies that need to be migrated
+	pending := 0
+	err := store.systemdb.View(func(txn *badger.Txn) error {
+		return forEachItem(txn, GetKey("Policy"), func(val []byte) error {
+			p := Policy{}
+			if err := json.Unmarshal(val, &p); err != nil {
+				return err
+			}
+
+			if p.hasTopLevelStatement() {
+				pending++
+			}
+			return nil
+		})
+	})
+	if err != nil || pending == 0 {
+		return err
+	}
+
+	return store.update(newAuditEvent(SystemUser.Name, "MigratePolicies", "Policy", ""), func(txn *writeTxn) error {
+		type migration struct {
+			key       []byte
+			policy    Policy
+			expiresAt uint64
+		}
+		migrations := []migration{}
+
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		prefix := GetKey("Policy")
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			val, err := item.Value()
+			if err != nil {
+				it.Close()
+				return err
+			}
+
+			p := Policy{}
+			if err := json.Unmarshal(val, &p); err != nil {
+				it.Close()
+				return err
+			}
+
+			if p.hasTopLevelStatement() {
+				migrations = append(migrations, migration{key: item.KeyCopy(nil), policy: p, expiresAt: item.ExpiresAt()})
+			}
+		}
+		it.Close()
+
+		for _, m := range migrations {
+			if err := setMigratedItem(txn, m.key, m.policy.withStatements(m.policy.statements()), m.expiresAt); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// migrateSystemActions adds the system actions that are missing from the System resource (the actions added since
+// the system was bootstrapped).  If the system hasn't been bootstrapped yet, or the System resource already has every
+// action, nothing is changed (and no audit event is recorded)
+func (store Manager) migrateSystemActions() error {
+	//	Find out if there are actions that need to be added
+	missing := []string{}
+	err := store.systemdb.View(func(txn *badger.Txn) error {
+		resource := Resource{}
+		if err := getItem(txn, GetKey("Resource", "System"), &resource); err != nil {
+			return err
+		}
+
+		for _, action := range systemActions() {
+			if !containsItem(resource.Actions, action) {
+				missing = append(missing, action)
+			}
+		}
+		return nil
+	})
+	if err == badger.ErrKeyNotFound || len(missing) == 0 {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return store.update(newAuditEvent(SystemUser.Name, "MigrateSystemActions", "Resource", "System"), func(txn *writeTxn) error {
+		resource := Resource{}
+		if err := getItem(txn, GetKey("Resource", "System"), &resource); err != nil {
+			return err
+		}
+
+		resource.Actions = mergeItems(resource.Actions, missing...)
+		resource.Version++
+		return setItem(txn, GetKey("Resource", resource.Name), resource)
+	})
+}
+
+// versionedItemTypes are the types of items that were stored before items had versions
+var versionedItemTypes = []string{"User", "Group", "Role", "Policy", "Resource"}
+
+// migrateVersions sets the version of items stored before items had versions to 1, so they can be
+// updated with If-Match like any other item.  Deleted items keep their remaining time in the recycle bin.
+// Items that already have a version are left alone, so this only records an audit event if there was something to migrate
+func (store Manager) migrateVersions() error {
+	//	Find out if there are items that need to be migrated
+	pending := 0
+	err := store.systemdb.View(func(txn *badger.Txn) error {
+		for _, itemType := range versionedItemTypes {
+			if err := forEachItem(txn, GetKey(itemType, ""), func(val []byte) error {
+				if _, ok := unversionedItem(val); ok {
+					pending++
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil || pending == 0 {
+		return err
+	}
+
+	return store.update(newAuditEvent(SystemUser.Name, "MigrateVersions", "System", ""), func(txn *writeTxn) error {
+		type migration struct {
+			key       []byte
+			item      map[string]json.RawMessage
+			expiresAt uint64
+		}
+		migrations := []migration{}
+
+		for _, itemType := range versionedItemTypes {
+			it := txn.NewIterator(badger.DefaultIteratorOptions)
+			prefix := GetKey(itemType, "")
+			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+				item := it.Item()
+				val, err := item.Value()
+				if err != nil {
+					it.Close()
+					return err
+				}
+
+				if fields, ok := unversionedItem(val); ok {
+					migrations = append(migrations, migration{key: item.KeyCopy(nil), item: fields, expiresAt: item.ExpiresAt()})
+				}
+			}
+			it.Close()
+		}
+
+		for _, m := range migrations {
+			m.item["version"] = json.RawMessage("1")
+			if err := setMigratedItem(txn, m.key, m.item, m.expiresAt); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// unversionedItem returns the fields of the (JSON) item, and true if the item doesn't have a version yet.
+// The fields are kept as they are stored, so only the version changes when the item is migrated
+func unversionedItem(val []byte) (map[string]json.RawMessage, bool) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(val, &fields); err != nil {
+		return fields, false
+	}
+
+	version := int64(0)
+	if raw, ok := fields["version"]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return fields, false
+		}
+	}
+
+	return fields, version == 0
+}
+
+// setMigratedItem saves a migrated item (as part of the given transaction).  An item that expires (a deleted
+// item in the recycle bin) keeps its remaining time.  If it has expired in the meantime, it isn't saved
+func setMigratedItem(txn *writeTxn, key []byte, item interface{}, expiresAt uint64) error {
+	if expiresAt == 0 {
+		return setItem(txn, key, item)
+	}
+
+	remaining := time.Until(time.Unix(int64(expiresAt), 0))
+	if remaining <= 0 {
+		return nil
+	}
+
+	return setItemWithTTL(txn, key, item, remaining)
+}
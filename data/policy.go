@@ -3,12 +3,10 @@ package data
 import (
 	"encoding/json"
 	"fmt"
-	"sort"
 	"strings"
 	"time"
 
 	"github.com/danesparza/iamserver/policy"
-	"github.com/xtgo/set"
 
 	"github.com/danesparza/badger"
 	null "gopkg.in/guregu/null.v3"
@@ -16,25 +14,32 @@ import (
 )
 
 // Policy is an AWS style policy document.  They wrap up the following ideas:
-// - Resources: The things in a system that users would need permissions to
-// - Actions: The interactions users have with those resources
-// - Effect: The permissive effect of a policy (allow or deny)
-// - Conditions: Additional information to take into account when evaluating a policy
-// Policies can be attached to a user or user group.  They can also be grouped in a role
+// - Statements: The rules of the policy (see Statement).  Each has an effect, resources, actions and conditions
+// - Syntax: How resources and actions are matched ('regexp' templates or 'glob' wildcards)
+// Policies can be attached to a user or user group.  They can also be grouped in a role.
+//
+// The top-level Effect, Resources, Actions, NotResources and NotActions are a shorthand for a policy with
+// a single statement (and are how policies were stored before they had statements).  AddPolicy moves
+// them to a statement, and policies stored the old way are migrated when the system starts
 type Policy struct {
-	Name      string      `json:"sid"`
-	Effect    string      `json:"effect"`
-	Resources []string    `json:"resources"`
-	Actions   []string    `json:"actions"`
-	Roles     []string    `json:"roles"`
-	Users     []string    `json:"users"`
-	Groups    []string    `json:"groups"`
-	Created   time.Time   `json:"created"`
-	CreatedBy string      `json:"created_by"`
-	Updated   time.Time   `json:"updated"`
-	UpdatedBy string      `json:"updated_by"`
-	Deleted   zero.Time   `json:"deleted"`
-	DeletedBy null.String `json:"deleted_by"`
+	Name         string      `json:"sid"`
+	Statements   []Statement `json:"statements"`
+	Syntax       string      `json:"syntax"`
+	Roles        []string    `json:"roles"`
+	Users        []string    `json:"users"`
+	Groups       []string    `json:"groups"`
+	Version      int64       `json:"version"`
+	Created      time.Time   `json:"created"`
+	CreatedBy    string      `json:"created_by"`
+	Updated      time.Time   `json:"updated"`
+	UpdatedBy    string      `json:"updated_by"`
+	Deleted      zero.Time   `json:"deleted"`
+	DeletedBy    null.String `json:"deleted_by"`
+	Effect       string      `json:"effect,omitempty"`
+	Resources    []string    `json:"resources,omitempty"`
+	Actions      []string    `json:"actions,omitempty"`
+	NotResources []string    `json:"not_resources,omitempty"`
+	NotActions   []string    `json:"not_actions,omitempty"`
 }
 
 // AddPolicy adds a policy to the system
@@ -47,53 +52,10 @@ func (store Manager) AddPolicy(context User, newPolicy Policy) (Policy, error) {
 		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
 	}
 
-	//	First -- does the policy exist already?
-	err := store.systemdb.View(func(txn *badger.Txn) error {
-		_, err := txn.Get(GetKey("Policy", newPolicy.Name))
-		return err
-	})
-
-	//	If we didn't get an error, we have a problem:
-	if err == nil {
-		return retval, fmt.Errorf("Policy already exists")
-	}
-
-	//	Check Effect
-	if (newPolicy.Effect != policy.Allow) && (newPolicy.Effect != policy.Deny) {
-		return retval, fmt.Errorf("Policy must have 'allow' or 'deny' effect")
-	}
-
-	// 	Check Resources / Actions (they can't be blank or empty)
-	if len(newPolicy.Resources) == 0 || len(newPolicy.Actions) == 0 {
-		return retval, fmt.Errorf("Policy must have 'resources' and 'actions' associated with it")
-	}
-
-	//	Policy must have at least one resource
-	if len(newPolicy.Resources) == 0 {
-		return retval, fmt.Errorf("Policy must have associated resources (but currently doesn't have any)")
-	}
-
-	//	Associated resources have to exist
-	for _, currentResource := range newPolicy.Resources {
-
-		//	If the resource name appears to be a regex...
-		if strings.ContainsAny(currentResource, "<>") {
-			continue // Just go to the next resource
-		}
-
-		err := store.systemdb.View(func(txn *badger.Txn) error {
-			_, err := txn.Get(GetKey("Resource", currentResource))
-			return err
-		})
-
-		if err != nil {
-			return retval, fmt.Errorf("Resource %s doesn't exist", currentResource)
-		}
-	}
-
-	//	Policy must have at least one action
-	if len(newPolicy.Actions) == 0 {
-		return retval, fmt.Errorf("Policy must have associated actions (but currently doesn't have any)")
+	//	Check the policy
+	newPolicy, err := normalizePolicy(newPolicy)
+	if err != nil {
+		return retval, err
 	}
 
 	//	Make sure when adding a new policy, users / roles / groups are empty:
@@ -107,21 +69,35 @@ func (store Manager) AddPolicy(context User, newPolicy Policy) (Policy, error) {
 	newPolicy.CreatedBy = context.Name
 	newPolicy.UpdatedBy = context.Name
 
-	//	Serialize to JSON format
-	encoded, err := json.Marshal(newPolicy)
-	if err != nil {
-		return retval, fmt.Errorf("Problem serializing the data: %s", err)
-	}
+	err = store.update(newAuditEvent(context.Name, sysreqAddPolicy.Action, "Policy", newPolicy.Name), func(txn *writeTxn) error {
+		//	First -- does the policy exist already?
+		if _, err := txn.Get(GetKey("Policy", newPolicy.Name)); err == nil {
+			return fmt.Errorf("Policy already exists")
+		}
 
-	//	Save it to the database:
-	err = store.systemdb.Update(func(txn *badger.Txn) error {
-		err := txn.Set(GetKey("Policy", newPolicy.Name), encoded)
-		return err
+		//	Associated resources have to exist
+		for _, statement := range newPolicy.Statements {
+			for _, currentResource := range append(statement.Resources, statement.NotResources...) {
+
+				//	If the resource name appears to be a pattern...
+				if isPattern(newPolicy.Syntax, currentResource) {
+					continue // Just go to the next resource
+				}
+
+				if _, err := txn.Get(GetKey("Resource", currentResource)); err != nil {
+					return fmt.Errorf("Resource %s doesn't exist", currentResource)
+				}
+			}
+		}
+
+		//	Save it to the database (as the first version):
+		newPolicy.Version = 1
+		return setItem(txn, GetKey("Policy", newPolicy.Name), newPolicy)
 	})
 
 	//	If there was an error saving the data, report it:
 	if err != nil {
-		return retval, fmt.Errorf("Problem saving the data: %s", err)
+		return retval, err
 	}
 
 	//	Set our retval:
@@ -131,7 +107,52 @@ func (store Manager) AddPolicy(context User, newPolicy Policy) (Policy, error) {
 	return retval, nil
 }
 
-// GetPolicy gets a policy from the system
+// normalizePolicy moves the top-level statement of the policy to its statements, sets the default syntax
+// and checks the statements, syntax and patterns of the policy
+func normalizePolicy(p Policy) (Policy, error) {
+	//	Move a top-level statement to the statements (a policy can't have both)
+	if len(p.Statements) > 0 && p.hasTopLevelStatement() {
+		return p, fmt.Errorf("Policy can't have both 'statements' and a top-level 'effect', 'resources' or 'actions'")
+	}
+	p = p.withStatements(p.statements())
+
+	//	Check Statements
+	if err := validateStatements(p.Statements); err != nil {
+		return p, err
+	}
+
+	//	Check Syntax (regexp is the default)
+	if p.Syntax == "" {
+		p.Syntax = policy.Regexp
+	}
+
+	if (p.Syntax != policy.Regexp) && (p.Syntax != policy.Glob) {
+		return p, fmt.Errorf("Policy must have 'regexp' or 'glob' syntax")
+	}
+
+	//	Check Patterns (so they don't fail when requests are authorized)
+	if err := validatePatterns(p); err != nil {
+		return p, err
+	}
+
+	return p, nil
+}
+
+// isPattern returns true if the value is a pattern (and not a plain name) in the given syntax.
+// Values with policy variables are patterns as well
+func isPattern(syntax, value string) bool {
+	if strings.Contains(value, variableStart) {
+		return true
+	}
+
+	if syntax == policy.Glob {
+		return strings.ContainsAny(value, globMetaChars)
+	}
+
+	return strings.ContainsAny(value, "<>")
+}
+
+// GetPolicy gets a policy from the system.  A deleted policy is only returned if the manager gets deleted items (see WithDeleted)
 func (store Manager) GetPolicy(context User, policyName string) (Policy, error) {
 	//	Our return item
 	retval := Policy{}
@@ -158,6 +179,11 @@ func (store Manager) GetPolicy(context User, policyName string) (Policy, error)
 			}
 		}
 
+		//	Deleted items are filtered out (unless they're asked for)
+		if retval.Deleted.Valid && !store.includeDeleted {
+			return badger.ErrKeyNotFound
+		}
+
 		return nil
 	})
 
@@ -170,333 +196,171 @@ func (store Manager) GetPolicy(context User, policyName string) (Policy, error)
 	return retval, nil
 }
 
-// GetAllPolicies gets all policies in the system
-func (store Manager) GetAllPolicies(context User) ([]Policy, error) {
+// GetAllPolicies gets the policies in the system that match the list options (a page at a time, if the
+// options have a limit).  Returns the policies and the cursor for the next page (empty if this is the last page)
+func (store Manager) GetAllPolicies(context User, options ListOptions) ([]Policy, string, error) {
 	//	Our return item
 	retval := []Policy{}
 
 	//	Security check:  Are we authorized to perform this action?
 	if !store.IsUserRequestAuthorized(context, sysreqGetAllPolicies) {
-		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
+		return retval, "", fmt.Errorf("User %s is not authorized to perform the action", context.Name)
 	}
 
-	err := store.systemdb.View(func(txn *badger.Txn) error {
-
-		//	Get an iterator
-		it := txn.NewIterator(badger.DefaultIteratorOptions)
-		defer it.Close()
-
-		//	Set our prefix
-		prefix := GetKey("Policy")
-
-		//	Iterate over our values:
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-
-			//	Get the item
-			item := it.Item()
-
-			//	Get the item key
-			// k := item.Key()
-
-			//	Get the item value
-			val, err := item.Value()
-			if err != nil {
-				return err
-			}
-
-			if len(val) > 0 {
-				//	Create our item:
-				item := Policy{}
-
-				//	Unmarshal data into our item
-				if err := json.Unmarshal(val, &item); err != nil {
-					return err
-				}
-
-				//	Add to the array of returned users:
-				retval = append(retval, item)
-			}
+	entries, nextCursor, err := store.listItems("Policy", options, func(val []byte) (listEntry, error) {
+		//	Unmarshal data into our item
+		item := Policy{}
+		if err := json.Unmarshal(val, &item); err != nil {
+			return listEntry{}, err
 		}
-		return nil
+
+		return listEntry{item: item, name: item.Name, created: item.Created, updated: item.Updated, deleted: item.Deleted.Valid,
+			enabled: !item.Deleted.Valid, users: item.Users, groups: item.Groups, roles: item.Roles}, nil
 	})
 
 	//	If there was an error, report it:
 	if err != nil {
-		return retval, fmt.Errorf("Problem getting the list of items: %s", err)
+		return retval, "", err
+	}
+
+	//	Add to the array of returned policies:
+	for _, entry := range entries {
+		retval = append(retval, entry.item.(Policy))
 	}
 
 	//	Return our data:
-	return retval, nil
+	return retval, nextCursor, nil
 }
 
-// AttachPolicyToUsers attaches a policy to the given user(s)
+// AttachPolicyToUsers attaches a policy to the given user(s).  If the manager makes time-bound
+// attachments (see During), the users get a grant for the time period instead
 func (store Manager) AttachPolicyToUsers(context User, policyName string, users ...string) (Policy, error) {
 	//	Our return item
 	retval := Policy{}
-	affectedUsers := []User{}
 
 	//	Security check:  Are we authorized to perform this action?
 	if !store.IsUserRequestAuthorized(context, sysreqAttachPolicyToUsers) {
 		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
 	}
 
-	//	First -- validate that the policy exists
-	err := store.systemdb.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(GetKey("Policy", policyName))
-		if err != nil {
-			return err
-		}
-		val, err := item.Value()
-		if err != nil {
-			return err
-		}
-
-		if len(val) > 0 {
-			//	Unmarshal data into our item
-			if err := json.Unmarshal(val, &retval); err != nil {
-				return err
-			}
-		}
-
-		return err
-	})
-
-	if err != nil {
-		return retval, fmt.Errorf("Policy does not exist")
+	//	If the attachment is time-bound, check the time period
+	grant, grantErr := store.newGrant(context, AccessPolicy, policyName)
+	if store.timeBound() && grantErr != nil {
+		return retval, grantErr
 	}
 
-	//	Next:
-	//	- Validate that each of the users exist
-	//	- Track each user in 'affectedUsers'
-	for _, currentuser := range users {
-		err := store.systemdb.View(func(txn *badger.Txn) error {
-			item, err := txn.Get(GetKey("User", currentuser))
-
-			if err != nil {
-				return err
-			}
-
-			//	Deserialize the user and add to the list of affected users
-			val, err := item.Value()
-			if err != nil {
-				return err
-			}
-
-			if len(val) > 0 {
-				currentuserObject := User{}
-
-				//	Unmarshal data into our item
-				if err := json.Unmarshal(val, &currentuserObject); err != nil {
-					return err
-				}
-
-				//	Add the object to our list of affected users:
-				affectedUsers = append(affectedUsers, currentuserObject)
-			}
-
-			return err
-		})
-
-		if err != nil {
-			return retval, fmt.Errorf("User %s doesn't exist", currentuser)
+	err := store.update(newAuditEvent(context.Name, sysreqAttachPolicyToUsers.Action, "Policy", policyName), func(txn *writeTxn) error {
+		//	First -- validate that the policy exists
+		retval = Policy{}
+		if err := getItem(txn, GetKey("Policy", policyName), &retval); err != nil {
+			return fmt.Errorf("Policy does not exist")
+		}
+		if retval.Deleted.Valid {
+			return fmt.Errorf("Policy is deleted")
 		}
-	}
-
-	//	Get the policy's new list of users from a merged (and deduped) list of:
-	//	- The existing policy users
-	// 	- The list of users passed in
-	allPolicyUsers := append(retval.Users, users...)
-	allUniquePolicyUsers := sort.StringSlice(allPolicyUsers)
-
-	sort.Sort(allUniquePolicyUsers)                 // sort the data first
-	n := set.Uniq(allUniquePolicyUsers)             // Uniq returns the size of the set
-	allUniquePolicyUsers = allUniquePolicyUsers[:n] // trim the duplicate elements
-
-	//	Then add each of users to the policy
-	retval.Users = allUniquePolicyUsers
-
-	//	Serialize the policy to JSON format
-	encoded, err := json.Marshal(retval)
-	if err != nil {
-		return retval, fmt.Errorf("Problem serializing the data: %s", err)
-	}
 
-	//	Save it to the database:
-	err = store.systemdb.Update(func(txn *badger.Txn) error {
-		err := txn.Set(GetKey("Policy", retval.Name), encoded)
-		if err != nil {
+		//	Make sure the policy hasn't changed since the caller last read it
+		if err := store.checkVersion(retval.Version); err != nil {
 			return err
 		}
 
-		//	Save each affected user to the database (as part of the same transaction):
-		for _, affectedUser := range affectedUsers {
-
-			//	and add the group to each user
-			currentPolicies := append(affectedUser.Policies, policyName)
-			allUniqueCurrentPolicies := sort.StringSlice(currentPolicies)
-
-			sort.Sort(allUniqueCurrentPolicies)                      // sort the data first
-			cn := set.Uniq(allUniqueCurrentPolicies)                 // Uniq returns the size of the set
-			allUniqueCurrentPolicies = allUniqueCurrentPolicies[:cn] // trim the duplicate elements
-			affectedUser.Policies = allUniqueCurrentPolicies
-
-			encoded, err := json.Marshal(affectedUser)
-			if err != nil {
-				return err
+		//	Next -- validate that each of the users exist and add the policy to each user
+		for _, currentuser := range users {
+			affectedUser := User{}
+			if err := getItem(txn, GetKey("User", currentuser), &affectedUser); err != nil {
+				return fmt.Errorf("User %s doesn't exist", currentuser)
+			}
+			if affectedUser.Deleted.Valid {
+				return fmt.Errorf("User %s is deleted", currentuser)
 			}
 
-			err = txn.Set(GetKey("User", affectedUser.Name), encoded)
-			if err != nil {
+			if store.timeBound() {
+				affectedUser.Grants = addGrant(affectedUser.Grants, grant)
+			} else {
+				affectedUser.Policies = mergeItems(affectedUser.Policies, policyName)
+			}
+			affectedUser.Version++
+			if err := setItem(txn, GetKey("User", affectedUser.Name), affectedUser); err != nil {
 				return err
 			}
 		}
 
-		return nil
+		//	Get the policy's new list of users from a merged (and deduped) list of:
+		//	- The existing policy users
+		// 	- The list of users passed in
+		retval.Users = mergeItems(retval.Users, users...)
+
+		retval.Version++
+		return setItem(txn, GetKey("Policy", retval.Name), retval)
 	})
 
 	//	If there was an error saving the data, report it:
 	if err != nil {
-		return retval, fmt.Errorf("Problem completing the policy updates: %s", err)
+		return retval, err
 	}
 
 	//	Return our data:
 	return retval, nil
-
 }
 
 // AttachPolicyToGroups attaches a policy to the given group(s)
 func (store Manager) AttachPolicyToGroups(context User, policyName string, groups ...string) (Policy, error) {
 	//	Our return item
 	retval := Policy{}
-	affectedGroups := []Group{}
 
 	//	Security check:  Are we authorized to perform this action?
 	if !store.IsUserRequestAuthorized(context, sysreqAttachPolicyToGroups) {
 		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
 	}
 
-	//	First -- validate that the policy exists
-	err := store.systemdb.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(GetKey("Policy", policyName))
-		if err != nil {
-			return err
+	err := store.update(newAuditEvent(context.Name, sysreqAttachPolicyToGroups.Action, "Policy", policyName), func(txn *writeTxn) error {
+		//	First -- validate that the policy exists
+		retval = Policy{}
+		if err := getItem(txn, GetKey("Policy", policyName), &retval); err != nil {
+			return fmt.Errorf("Policy does not exist")
 		}
-		val, err := item.Value()
-		if err != nil {
-			return err
-		}
-
-		if len(val) > 0 {
-			//	Unmarshal data into our item
-			if err := json.Unmarshal(val, &retval); err != nil {
-				return err
-			}
-		}
-
-		return err
-	})
-
-	if err != nil {
-		return retval, fmt.Errorf("Policy does not exist")
-	}
-
-	//	Next:
-	//	- Validate that each of the groups exist
-	//	- Track each group in 'affectedGroups'
-	for _, currentgroup := range groups {
-		err := store.systemdb.View(func(txn *badger.Txn) error {
-			item, err := txn.Get(GetKey("Group", currentgroup))
-
-			if err != nil {
-				return err
-			}
-
-			//	Deserialize the group and add to the list of affected groups
-			val, err := item.Value()
-			if err != nil {
-				return err
-			}
-
-			if len(val) > 0 {
-				currentgroupObject := Group{}
-
-				//	Unmarshal data into our item
-				if err := json.Unmarshal(val, &currentgroupObject); err != nil {
-					return err
-				}
-
-				//	Add the object to our list of affected groups:
-				affectedGroups = append(affectedGroups, currentgroupObject)
-			}
-
-			return err
-		})
-
-		if err != nil {
-			return retval, fmt.Errorf("Group %s doesn't exist", currentgroup)
+		if retval.Deleted.Valid {
+			return fmt.Errorf("Policy is deleted")
 		}
-	}
-
-	//	Get the policy's new list of groups from a merged (and deduped) list of:
-	//	- The existing policy groups
-	// 	- The list of groups passed in
-	allPolicyGroups := append(retval.Groups, groups...)
-	allUniquePolicyGroups := sort.StringSlice(allPolicyGroups)
-
-	sort.Sort(allUniquePolicyGroups)                  // sort the data first
-	n := set.Uniq(allUniquePolicyGroups)              // Uniq returns the size of the set
-	allUniquePolicyGroups = allUniquePolicyGroups[:n] // trim the duplicate elements
-
-	//	Then add each of the groups to the policy
-	retval.Groups = allUniquePolicyGroups
-
-	//	Serialize the policy to JSON format
-	encoded, err := json.Marshal(retval)
-	if err != nil {
-		return retval, fmt.Errorf("Problem serializing the data: %s", err)
-	}
 
-	//	Save it to the database:
-	err = store.systemdb.Update(func(txn *badger.Txn) error {
-		err := txn.Set(GetKey("Policy", retval.Name), encoded)
-		if err != nil {
+		//	Make sure the policy hasn't changed since the caller last read it
+		if err := store.checkVersion(retval.Version); err != nil {
 			return err
 		}
 
-		//	Save each affected group to the database (as part of the same transaction):
-		for _, affectedGroup := range affectedGroups {
-
-			//	and add the group to each user
-			currentPolicies := append(affectedGroup.Policies, policyName)
-			allUniqueCurrentPolicies := sort.StringSlice(currentPolicies)
-
-			sort.Sort(allUniqueCurrentPolicies)                      // sort the data first
-			cn := set.Uniq(allUniqueCurrentPolicies)                 // Uniq returns the size of the set
-			allUniqueCurrentPolicies = allUniqueCurrentPolicies[:cn] // trim the duplicate elements
-			affectedGroup.Policies = allUniqueCurrentPolicies
-
-			encoded, err := json.Marshal(affectedGroup)
-			if err != nil {
-				return err
+		//	Next -- validate that each of the groups exist and add the policy to each group
+		for _, currentgroup := range groups {
+			affectedGroup := Group{}
+			if err := getItem(txn, GetKey("Group", currentgroup), &affectedGroup); err != nil {
+				return fmt.Errorf("Group %s doesn't exist", currentgroup)
+			}
+			if affectedGroup.Deleted.Valid {
+				return fmt.Errorf("Group %s is deleted", currentgroup)
 			}
 
-			err = txn.Set(GetKey("Group", affectedGroup.Name), encoded)
-			if err != nil {
+			affectedGroup.Policies = mergeItems(affectedGroup.Policies, policyName)
+			affectedGroup.Version++
+			if err := setItem(txn, GetKey("Group", affectedGroup.Name), affectedGroup); err != nil {
 				return err
 			}
 		}
 
-		return nil
+		//	Get the policy's new list of groups from a merged (and deduped) list of:
+		//	- The existing policy groups
+		// 	- The list of groups passed in
+		retval.Groups = mergeItems(retval.Groups, groups...)
+
+		retval.Version++
+		return setItem(txn, GetKey("Policy", retval.Name), retval)
 	})
 
 	//	If there was an error saving the data, report it:
 	if err != nil {
-		return retval, fmt.Errorf("Problem completing the policy updates: %s", err)
+		return retval, err
 	}
 
 	//	Return our data:
 	return retval, nil
-
 }
 
 // GetPoliciesForUser gets policies for a user.  Chains include:
@@ -504,167 +368,332 @@ func (store Manager) AttachPolicyToGroups(context User, policyName string, group
 // User -> Role -> Policies
 // User -> Group -> Policies
 // User -> Group -> Role -> Policies
+//
+// The resolved policies are cached (until a change affects the user's effective policies)
 func (store Manager) GetPoliciesForUser(context User, userName string) (map[string]Policy, error) {
-	//	Our return item
-	retval := make(map[string]Policy)
-	user := User{}
-	policiesInEffect := []string{}
-	rolesInEffect := []string{}
+	retval, err := store.effectivePolicies(userName)
+	if err != nil {
+		return make(map[string]Policy), err
+	}
 
-	//	First -- validate that the user exists
-	err := store.systemdb.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(GetKey("User", userName))
-		if err != nil {
-			return err
-		}
-		val, err := item.Value()
-		if err != nil {
-			return err
-		}
+	//	Return a copy of the list
+	return copyPolicies(retval.policies), nil
+}
 
-		if len(val) > 0 {
-			//	Unmarshal data into our item
-			if err := json.Unmarshal(val, &user); err != nil {
-				return err
-			}
-		}
+// effectivePolicies gets the effective policies for a user (from the cache, if they are cached).
+// When the policies are cached, they are compiled as well
+func (store Manager) effectivePolicies(userName string) (userPolicies, error) {
+	//	If we have the user's policies cached, use them
+	cache := store.policies
+	if store.bypassPolicyCache {
+		cache = nil
+	}
 
+	retval, generation, ok := cache.get(userName)
+	if ok {
+		return retval, nil
+	}
+
+	//	Otherwise, resolve them (in a single transaction)
+	err := store.systemdb.View(func(txn *badger.Txn) error {
+		var err error
+		retval, err = resolvePolicies(txn, userName)
 		return err
 	})
 
 	if err != nil {
-		return retval, fmt.Errorf("User does not exist")
+		return userPolicies{}, err
 	}
 
-	//	Add the user policies to policiesInEffect
-	for _, currentPolicy := range user.Policies {
-		policiesInEffect = append(policiesInEffect, currentPolicy)
+	//	Compile (with the user's variables expanded) and cache them for next time.  If the policies can't
+	//	be compiled (because a pattern isn't valid), decisions are made without the compiled policies
+	if cache != nil {
+		retval.compiled, _ = CompilePolicies(expandPolicies(retval.policies, retval.variables))
+		cache.add(userName, retval, generation)
 	}
 
-	//	Add user roles to rolesInEffect
-	for _, currentRole := range user.Roles {
-		rolesInEffect = append(rolesInEffect, currentRole)
+	return retval, nil
+}
+
+// resolvePolicies gets the effective policies (and the policy variables) for a user (as part of the given transaction)
+func resolvePolicies(txn reader, userName string) (userPolicies, error) {
+	//	First -- validate that the user exists
+	user := User{}
+	if err := getItem(txn, GetKey("User", userName), &user); err != nil || user.Deleted.Valid {
+		return userPolicies{policies: make(map[string]Policy)}, fmt.Errorf("User does not exist")
 	}
 
-	//	Find the groups this user is in
+	return resolveUserPolicies(txn, user, nil), nil
+}
+
+// resolveUserPolicies gets the effective policies (and the policy variables) for the given user record (as part
+// of the given transaction), including the access of the grants in effect now.  Deleted groups, roles and policies
+// (in the recycle bin) aren't in effect.  If skip is given, the roles and policies it returns true for aren't in effect either
+func resolveUserPolicies(txn reader, user User, skip func(entityType, name string) bool) userPolicies {
+	//	Our return item
+	retval := userPolicies{policies: make(map[string]Policy)}
+	policiesInEffect := []string{}
+	rolesInEffect := []string{}
+
+	//	Include the access of the user's grants that are in effect now
+	now := time.Now()
+	user = user.withGrants(now)
+	retval.expires = user.nextGrantChange(now)
+	retval.variables = userVariables(user)
+
+	//	Add the user policies and roles
+	policiesInEffect = append(policiesInEffect, user.Policies...)
+	rolesInEffect = append(rolesInEffect, user.Roles...)
+
+	//	Find the groups this user is in (and add the group policies and roles)
 	for _, currentGroup := range user.Groups {
+		group := Group{}
+		if err := getItem(txn, GetKey("Group", currentGroup), &group); err != nil || group.Deleted.Valid {
+			continue
+		}
 
-		store.systemdb.View(func(txn *badger.Txn) error {
-			item, err := txn.Get(GetKey("Group", currentGroup))
+		policiesInEffect = append(policiesInEffect, group.Policies...)
+		rolesInEffect = append(rolesInEffect, group.Roles...)
+	}
 
-			if err != nil {
-				return err
-			}
+	//	For each role in effect (compressed and sorted), add the role policies
+	for _, currentRole := range mergeItems(rolesInEffect) {
+		if skip != nil && skip("Role", currentRole) {
+			continue
+		}
 
-			//	Deserialize the group to get the lists of policies and roles
-			val, err := item.Value()
-			if err != nil {
-				return err
-			}
+		role := Role{}
+		if err := getItem(txn, GetKey("Role", currentRole), &role); err != nil || role.Deleted.Valid {
+			continue
+		}
 
-			if len(val) > 0 {
-				group := Group{}
+		policiesInEffect = append(policiesInEffect, role.Policies...)
+	}
 
-				//	Unmarshal data into our item
-				if err := json.Unmarshal(val, &group); err != nil {
-					return err
-				}
+	//	Get the actual policies for each of the (compressed and sorted) policy names
+	for _, currentPolicy := range mergeItems(policiesInEffect) {
+		if skip != nil && skip("Policy", currentPolicy) {
+			continue
+		}
 
-				//	Add the group policies to policiesInEffect
-				for _, currentPolicy := range group.Policies {
-					policiesInEffect = append(policiesInEffect, currentPolicy)
-				}
+		policy := Policy{}
+		if err := getItem(txn, GetKey("Policy", currentPolicy), &policy); err != nil || policy.Deleted.Valid {
+			continue
+		}
 
-				//	Add group roles to rolesInEffect
-				for _, currentRole := range group.Roles {
-					rolesInEffect = append(rolesInEffect, currentRole)
-				}
-			}
+		//	Add the policy to the return value:
+		retval.policies[policy.Name] = policy
+	}
 
-			return err
-		})
+	//	Return the list
+	return retval
+}
+
+// DeletePolicy removes a policy from the system.  The policy is removed from any users, groups and
+// roles it is attached to (as part of the same transaction) and the list of affected items is returned
+func (store Manager) DeletePolicy(context User, policyName string) (CascadeResult, error) {
+	//	Our return item
+	retval := CascadeResult{}
+
+	//	Security check:  Are we authorized to perform this action?
+	if !store.IsUserRequestAuthorized(context, sysreqDeletePolicy) {
+		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
 	}
 
-	//	Compress and sort the role list
-	allUniquerolesInEffect := sort.StringSlice(rolesInEffect)
+	err := store.update(newAuditEvent(context.Name, sysreqDeletePolicy.Action, "Policy", policyName), func(txn *writeTxn) error {
+		//	Start with an empty list of affected items
+		retval = CascadeResult{}
+
+		//	First -- does the policy exist?
+		pol := Policy{}
+		if err := getItem(txn, GetKey("Policy", policyName), &pol); err != nil {
+			return fmt.Errorf("Policy does not exist")
+		}
 
-	sort.Sort(allUniquerolesInEffect)          // sort the data first
-	n := set.Uniq(allUniquerolesInEffect)      // Uniq returns the size of the set
-	rolesInEffect = allUniquerolesInEffect[:n] // trim the duplicate elements
+		//	Make sure the policy hasn't changed since the caller last read it
+		if err := store.checkVersion(pol.Version); err != nil {
+			return err
+		}
 
-	//	For each role in rolesInEffect
-	for _, currentRole := range rolesInEffect {
+		//	If it's already deleted, there is nothing to do
+		if pol.Deleted.Valid {
+			return fmt.Errorf("Policy is already deleted")
+		}
 
-		store.systemdb.View(func(txn *badger.Txn) error {
-			item, err := txn.Get(GetKey("Role", currentRole))
+		//	Remove the policy from users / groups / roles
+		for _, userName := range pol.Users {
+			user := User{}
+			if err := getItem(txn, GetKey("User", userName), &user); err != nil {
+				continue // The user is already gone
+			}
 
-			if err != nil {
+			//	Grants for the policy are dropped (and aren't restored with the policy)
+			attached := containsItem(user.Policies, pol.Name)
+			user.Policies = removeItem(user.Policies, pol.Name)
+			user.Grants = removeGrant(user.Grants, AccessPolicy, pol.Name)
+			user.Version++
+			if err := setItem(txn, GetKey("User", user.Name), user); err != nil {
 				return err
 			}
+			if attached {
+				retval.Users = append(retval.Users, user.Name)
+			}
+		}
 
-			//	Deserialize the role to get the list of policies
-			val, err := item.Value()
-			if err != nil {
-				return err
+		for _, groupName := range pol.Groups {
+			group := Group{}
+			if err := getItem(txn, GetKey("Group", groupName), &group); err != nil {
+				continue // The group is already gone
 			}
 
-			if len(val) > 0 {
-				role := Role{}
+			group.Policies = removeItem(group.Policies, pol.Name)
+			group.Version++
+			if err := setItem(txn, GetKey("Group", group.Name), group); err != nil {
+				return err
+			}
+			retval.Groups = append(retval.Groups, group.Name)
+		}
 
-				//	Unmarshal data into our item
-				if err := json.Unmarshal(val, &role); err != nil {
-					return err
-				}
+		for _, roleName := range pol.Roles {
+			role := Role{}
+			if err := getItem(txn, GetKey("Role", roleName), &role); err != nil {
+				continue // The role is already gone
+			}
 
-				//	Add the role policies to policiesInEffect
-				for _, currentPolicy := range role.Policies {
-					policiesInEffect = append(policiesInEffect, currentPolicy)
-				}
+			role.Policies = removeItem(role.Policies, pol.Name)
+			role.Version++
+			if err := setItem(txn, GetKey("Role", role.Name), role); err != nil {
+				return err
 			}
+			retval.Roles = append(retval.Roles, role.Name)
+		}
 
+		//	Reset the users / groups / roles collections:
+		pol.Users = []string{}
+		pol.Groups = []string{}
+		pol.Roles = []string{}
+
+		//	Update the updated / deleted fields:
+		pol.Deleted = zero.TimeFrom(time.Now())
+		pol.Updated = time.Now()
+		pol.DeletedBy = null.StringFrom(context.Name)
+		pol.UpdatedBy = context.Name
+		pol.Version++
+
+		//	Keep track of the items it was removed from (so it can be restored later):
+		if err := setItemWithTTL(txn, GetKey("RecycleBin", "Policy", pol.Name), retval, store.DeletedRetention); err != nil {
 			return err
-		})
+		}
+
+		//	Save it to the database with a TTL:
+		return setItemWithTTL(txn, GetKey("Policy", pol.Name), pol, store.DeletedRetention)
+	})
+
+	//	If there was an error deleting the data, report it:
+	if err != nil {
+		return retval, err
 	}
 
-	//	Compress and sort the policy list
-	allUniquepoliciesInEffect := sort.StringSlice(policiesInEffect)
+	//	Return our data:
+	return retval, nil
+}
 
-	sort.Sort(allUniquepoliciesInEffect)             // sort the data first
-	n = set.Uniq(allUniquepoliciesInEffect)          // Uniq returns the size of the set
-	policiesInEffect = allUniquepoliciesInEffect[:n] // trim the duplicate elements
+// RestorePolicy restores a deleted policy (if it hasn't been removed from the system yet).  The policy
+// is added back to the users, groups and roles it was removed from when it was deleted (if they still exist)
+func (store Manager) RestorePolicy(context User, policyName string) (Policy, error) {
+	//	Our return item
+	retval := Policy{}
 
-	//	Get the actual policies for each of the policy names
-	for _, currentPolicy := range policiesInEffect {
+	//	Security check:  Are we authorized to perform this action?
+	if !store.IsUserRequestAuthorized(context, sysreqRestorePolicy) {
+		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
+	}
 
-		store.systemdb.View(func(txn *badger.Txn) error {
-			item, err := txn.Get(GetKey("Policy", currentPolicy))
+	err := store.update(newAuditEvent(context.Name, sysreqRestorePolicy.Action, "Policy", policyName), func(txn *writeTxn) error {
+		//	First -- does the policy exist?
+		retval = Policy{}
+		if err := getItem(txn, GetKey("Policy", policyName), &retval); err != nil {
+			return fmt.Errorf("Policy does not exist")
+		}
 
-			if err != nil {
-				return err
+		//	Make sure the policy hasn't changed since the caller last read it
+		if err := store.checkVersion(retval.Version); err != nil {
+			return err
+		}
+
+		//	We can only restore deleted items
+		if !retval.Deleted.Valid {
+			return fmt.Errorf("Policy is not deleted")
+		}
+
+		//	Get the items the policy was removed from when it was deleted
+		removed := CascadeResult{}
+		getItem(txn, GetKey("RecycleBin", "Policy", retval.Name), &removed)
+
+		//	Add the policy back to users, groups and roles
+		for _, userName := range removed.Users {
+			user := User{}
+			if err := getItem(txn, GetKey("User", userName), &user); err != nil || user.Deleted.Valid {
+				continue // The user is gone
 			}
 
-			//	Deserialize the role to get the list of policies
-			val, err := item.Value()
-			if err != nil {
+			user.Policies = mergeItems(user.Policies, retval.Name)
+			user.Version++
+			if err := setItem(txn, GetKey("User", user.Name), user); err != nil {
 				return err
 			}
+			retval.Users = mergeItems(retval.Users, user.Name)
+		}
 
-			if len(val) > 0 {
-				policy := Policy{}
+		for _, groupName := range removed.Groups {
+			group := Group{}
+			if err := getItem(txn, GetKey("Group", groupName), &group); err != nil || group.Deleted.Valid {
+				continue // The group is gone
+			}
 
-				//	Unmarshal data into our item
-				if err := json.Unmarshal(val, &policy); err != nil {
-					return err
-				}
+			group.Policies = mergeItems(group.Policies, retval.Name)
+			group.Version++
+			if err := setItem(txn, GetKey("Group", group.Name), group); err != nil {
+				return err
+			}
+			retval.Groups = mergeItems(retval.Groups, group.Name)
+		}
+
+		for _, roleName := range removed.Roles {
+			role := Role{}
+			if err := getItem(txn, GetKey("Role", roleName), &role); err != nil || role.Deleted.Valid {
+				continue // The role is gone
+			}
 
-				//	Add the policy to the return value:
-				retval[policy.Name] = policy
+			role.Policies = mergeItems(role.Policies, retval.Name)
+			role.Version++
+			if err := setItem(txn, GetKey("Role", role.Name), role); err != nil {
+				return err
 			}
+			retval.Roles = mergeItems(retval.Roles, role.Name)
+		}
+
+		//	Reset the deleted fields and update the updated fields:
+		retval.Deleted = zero.Time{}
+		retval.DeletedBy = null.String{}
+		retval.Updated = time.Now()
+		retval.UpdatedBy = context.Name
+		retval.Version++
 
+		//	We don't need to keep track of the removed items anymore:
+		if err := txn.Delete(GetKey("RecycleBin", "Policy", retval.Name)); err != nil {
 			return err
-		})
+		}
+
+		//	Save it to the database (without a TTL):
+		return setItem(txn, GetKey("Policy", retval.Name), retval)
+	})
+
+	//	If there was an error restoring the data, report it:
+	if err != nil {
+		return retval, err
 	}
 
-	//	Return the list
+	//	Return our data:
 	return retval, nil
 }
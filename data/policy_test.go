@@ -1,7 +1,9 @@
 package data_test
 
 import (
+	"fmt"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
@@ -94,11 +96,11 @@ func TestPolicy_GetPolicy_ValidPolicy_Successful(t *testing.T) {
 
 	newPolicy2, err := db.GetPolicy(contextUser, testPolicy.Name)
 
-	if newPolicy2.Resources[0] != newPolicy.Resources[0] {
+	if len(newPolicy2.Statements) != 1 || newPolicy2.Statements[0].Resources[0] != testPolicy.Resources[0] {
 		t.Errorf("GetPolicy failed: Should have gotten an item with the correct 'resources': %+v", newPolicy)
 	}
 
-	if newPolicy2.Actions[0] != newPolicy.Actions[0] {
+	if len(newPolicy2.Statements) != 1 || newPolicy2.Statements[0].Actions[0] != testPolicy.Actions[0] {
 		t.Errorf("GetPolicy failed: Should have gotten an item with the correct 'actions': %+v", newPolicy)
 	}
 }
@@ -155,7 +157,7 @@ func TestPolicy_GetAllPolicies_ValidPolicies_Successful(t *testing.T) {
 	})
 
 	//	Act
-	allPolicies, err := db.GetAllPolicies(contextUser)
+	allPolicies, _, err := db.GetAllPolicies(contextUser, data.ListOptions{})
 
 	//	Assert
 	if err != nil {
@@ -690,7 +692,7 @@ func TestPolicy_GetPoliciesForUser_ValidParams_ReturnsPolicies(t *testing.T) {
 	//	** Now we can actually start assigning stuff ...
 
 	//	-- Add role to all users
-	allUsers, _ := db.GetAllUsers(contextUser)
+	allUsers, _, _ := db.GetAllUsers(contextUser, data.ListOptions{})
 	allUserNames := []string{}
 	for _, user := range allUsers {
 		allUserNames = append(allUserNames, user.Name)
@@ -749,3 +751,307 @@ func TestPolicy_GetPoliciesForUser_ValidParams_ReturnsPolicies(t *testing.T) {
 	}
 
 }
+
+func TestPolicy_DeletePolicy_PolicyIsAttached_RemovesReferences(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+
+	db.AddUser(contextUser, data.User{Name: "Unittestuser1"}, "testpass")
+	db.AddGroup(contextUser, "Unittestgroup1", "")
+	db.AddRole(contextUser, "Unittestrole1", "")
+	db.AddResource(contextUser, "Someresource", "")
+	db.AddPolicy(contextUser, data.Policy{Name: "Unittestpolicy1", Effect: policy.Allow, Resources: []string{"Someresource"}, Actions: []string{"Someaction"}})
+
+	db.AttachPolicyToUsers(contextUser, "Unittestpolicy1", "Unittestuser1")
+	db.AttachPolicyToGroups(contextUser, "Unittestpolicy1", "Unittestgroup1")
+	db.AttachPoliciesToRole(contextUser, "Unittestrole1", "Unittestpolicy1")
+
+	//	Act
+	cascade, err := db.DeletePolicy(contextUser, "Unittestpolicy1")
+
+	//	Assert
+	if err != nil {
+		t.Errorf("DeletePolicy - Should delete policy without error, but got: %s", err)
+	}
+
+	if len(cascade.Users) != 1 || len(cascade.Groups) != 1 || len(cascade.Roles) != 1 {
+		t.Errorf("DeletePolicy - Should have reported 1 affected user, group and role, but got: %+v", cascade)
+	}
+
+	policies, _ := db.GetPoliciesForUser(contextUser, "Unittestuser1")
+	if len(policies) != 0 {
+		t.Errorf("DeletePolicy - Should have removed the policy from the user, but user policies are: %v", policies)
+	}
+
+	group1, _ := db.GetGroup(contextUser, "Unittestgroup1")
+	if len(group1.Policies) != 0 {
+		t.Errorf("DeletePolicy - Should have removed the policy from the group, but group policies are: %v", group1.Policies)
+	}
+
+	role1, _ := db.GetRole(contextUser, "Unittestrole1")
+	if len(role1.Policies) != 0 {
+		t.Errorf("DeletePolicy - Should have removed the policy from the role, but role policies are: %v", role1.Policies)
+	}
+}
+
+func TestPolicy_AttachPolicy_PolicyOrTargetIsDeleted_ReturnsError(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+
+	db.AddUser(contextUser, data.User{Name: "Unittestuser1"}, "testpass")
+	db.AddUser(contextUser, data.User{Name: "Unittestuser2"}, "testpass")
+	db.AddGroup(contextUser, "Unittestgroup1", "")
+	db.AddRole(contextUser, "Unittestrole1", "")
+	db.AddResource(contextUser, "Someresource", "")
+	db.AddPolicy(contextUser, data.Policy{Name: "Unittestpolicy1", Effect: policy.Allow, Resources: []string{"Someresource"}, Actions: []string{"Someaction"}})
+	db.AddPolicy(contextUser, data.Policy{Name: "Unittestpolicy2", Effect: policy.Allow, Resources: []string{"Someresource"}, Actions: []string{"Otheraction"}})
+
+	db.DeletePolicy(contextUser, "Unittestpolicy1")
+	db.DeleteUser(contextUser, "Unittestuser2")
+	db.DeleteGroup(contextUser, "Unittestgroup1")
+	db.DeleteRole(contextUser, "Unittestrole1")
+
+	//	Act
+	_, errUsers := db.AttachPolicyToUsers(contextUser, "Unittestpolicy1", "Unittestuser1")
+	_, errGroups := db.AttachPolicyToGroups(contextUser, "Unittestpolicy2", "Unittestgroup1")
+	_, errRole := db.AttachPoliciesToRole(contextUser, "Unittestrole1", "Unittestpolicy2")
+	_, errDeletedUser := db.AttachPolicyToUsers(contextUser, "Unittestpolicy2", "Unittestuser2")
+
+	//	Assert
+	if errUsers == nil || errGroups == nil || errRole == nil || errDeletedUser == nil {
+		t.Errorf("AttachPolicy - Should not attach deleted items, but got: %v / %v / %v / %v", errUsers, errGroups, errRole, errDeletedUser)
+	}
+
+	if db.IsUserRequestAuthorized(data.User{Name: "Unittestuser1"}, &data.Request{Resource: "Someresource", Action: "Someaction"}) {
+		t.Errorf("AttachPolicyToUsers - Should not authorize a request through a deleted policy")
+	}
+
+	if _, err := db.GetPolicy(contextUser, "Unittestpolicy1"); err == nil {
+		t.Errorf("GetPolicy - Should not return a deleted policy (unless it's asked for)")
+	}
+
+	deleted, err := db.WithDeleted(true).GetPolicy(contextUser, "Unittestpolicy1")
+	if err != nil || !deleted.Deleted.Valid || len(deleted.Users) != 0 {
+		t.Errorf("GetPolicy - Expected the deleted policy to be unchanged, but got %+v (%v)", deleted, err)
+	}
+}
+
+func TestPolicy_AttachPolicyToUsers_Concurrent_AttachesAllUsers(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	userCount := 5
+
+	db.AddResource(contextUser, "Someresource", "")
+	db.AddPolicy(contextUser, data.Policy{Name: "Unittestpolicy1", Effect: policy.Allow, Resources: []string{"Someresource"}, Actions: []string{"Someaction"}})
+
+	for i := 0; i < userCount; i++ {
+		db.AddUser(contextUser, data.User{Name: fmt.Sprintf("Unittestuser%v", i)}, "testpass")
+	}
+
+	//	Act
+	var wg sync.WaitGroup
+	errs := make(chan error, userCount)
+	for i := 0; i < userCount; i++ {
+		wg.Add(1)
+		go func(userName string) {
+			defer wg.Done()
+			_, err := db.AttachPolicyToUsers(contextUser, "Unittestpolicy1", userName)
+			errs <- err
+		}(fmt.Sprintf("Unittestuser%v", i))
+	}
+	wg.Wait()
+	close(errs)
+
+	//	Assert
+	for err := range errs {
+		if err != nil {
+			t.Errorf("AttachPolicyToUsers - Should attach policy concurrently without error, but got: %s", err)
+		}
+	}
+
+	policy1, _ := db.GetPolicy(contextUser, "Unittestpolicy1")
+	if len(policy1.Users) != userCount {
+		t.Errorf("AttachPolicyToUsers - Expected %v users attached to the policy, but got %v", userCount, policy1.Users)
+	}
+
+	for i := 0; i < userCount; i++ {
+		user, _ := db.GetUser(contextUser, fmt.Sprintf("Unittestuser%v", i))
+		if len(user.Policies) != 1 {
+			t.Errorf("AttachPolicyToUsers - Expected the policy to be attached to %s, but user policies are %v", user.Name, user.Policies)
+		}
+	}
+}
+
+func TestPolicy_AddPolicy_InvalidSyntax_ReturnsError(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Errorf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	testPolicy := data.Policy{
+		Name:   "UnitTest1",
+		Effect: policy.Allow,
+		Syntax: "someweirdsyntax",
+		Resources: []string{
+			"Someresource",
+		},
+		Actions: []string{
+			"Someaction",
+		},
+	}
+	_, err = db.AddResource(contextUser, "Someresource", "")
+
+	//	Act
+	_, err = db.AddPolicy(contextUser, testPolicy)
+
+	//	Assert
+	if err == nil {
+		t.Errorf("AddPolicy - Should not add policy with invalid syntax")
+	}
+
+}
+
+func TestPolicy_AddPolicy_InvalidPatterns_ReturnsPatternError(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Errorf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	tests := []struct {
+		statement data.Statement
+		syntax    string
+		field     string
+		pattern   string
+	}{
+		{data.Statement{Sid: "Orders", Effect: policy.Allow, Resources: []string{"orders:<[0-9]+>", "orders:<[0-9]+"}, Actions: []string{"Get"}}, policy.Regexp, "resources", "orders:<[0-9]+"},
+		{data.Statement{Effect: policy.Allow, Resources: []string{"<.*>"}, Actions: []string{"<(Get|Put>"}}, policy.Regexp, "actions", "<(Get|Put>"},
+		{data.Statement{Effect: policy.Deny, NotResources: []string{"profile:<${user.name}|${user.nmae}>"}, Actions: []string{"Get"}}, policy.Regexp, "not_resources", "profile:<${user.name}|${user.nmae}>"},
+		{data.Statement{Effect: policy.Allow, Resources: []string{"*"}, Actions: []string{"Get"}, Conditions: data.Conditions{policy.StringLike: {"context.team": {"${user.attributes.}"}}}}, policy.Glob, "conditions.StringLike.context.team", "${user.attributes.}"},
+	}
+
+	for i, test := range tests {
+		name := fmt.Sprintf("UnitTest%v", i)
+
+		//	Act
+		_, err := db.AddPolicy(contextUser, data.Policy{Name: name, Syntax: test.syntax, Statements: []data.Statement{test.statement}})
+		_, getErr := db.GetPolicy(contextUser, name)
+
+		//	Assert
+		patternErr, ok := err.(*data.PatternError)
+		if !ok {
+			t.Errorf("AddPolicy - Expected a pattern error for '%s', but got: %v", test.pattern, err)
+			continue
+		}
+
+		if patternErr.Field != test.field || patternErr.Pattern != test.pattern || patternErr.StatusCode() != 400 {
+			t.Errorf("AddPolicy - Expected the error to report '%s' in '%s', but got: %s", test.pattern, test.field, patternErr)
+		}
+
+		if getErr == nil {
+			t.Errorf("AddPolicy - Should not save a policy with an invalid pattern")
+		}
+	}
+
+	//	Variables inside templates are valid
+	_, err = db.AddPolicy(contextUser, data.Policy{Name: "Valid", Statements: []data.Statement{{Effect: policy.Allow, Resources: []string{"profile:<${user.name}|x>"}, Actions: []string{"Get"}}}})
+	if err != nil {
+		t.Errorf("AddPolicy - Should add a policy with a variable in a template without error, but got: %s", err)
+	}
+
+}
+
+func TestPolicy_AddPolicy_ActionsAndNotActions_ReturnsError(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Errorf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	db.AddResource(contextUser, "Someresource", "")
+
+	//	Act
+	_, bothActionsErr := db.AddPolicy(contextUser, data.Policy{Name: "UnitTest1", Effect: policy.Allow, Resources: []string{"Someresource"}, Actions: []string{"Someaction"}, NotActions: []string{"Otheraction"}})
+	_, bothResourcesErr := db.AddPolicy(contextUser, data.Policy{Name: "UnitTest2", Effect: policy.Allow, Resources: []string{"Someresource"}, NotResources: []string{"Someresource"}, Actions: []string{"Someaction"}})
+	_, missingResourceErr := db.AddPolicy(contextUser, data.Policy{Name: "UnitTest3", Effect: policy.Allow, NotResources: []string{"Missingresource"}, Actions: []string{"Someaction"}})
+	notPolicy, notErr := db.AddPolicy(contextUser, data.Policy{Name: "UnitTest4", Effect: policy.Deny, NotResources: []string{"Someresource"}, NotActions: []string{"Someaction"}})
+
+	//	Assert
+	if bothActionsErr == nil || bothResourcesErr == nil {
+		t.Errorf("AddPolicy - Should not add a policy with both positive and 'not_' actions or resources")
+	}
+
+	if missingResourceErr == nil {
+		t.Errorf("AddPolicy - Should not add a policy with 'not_resources' that don't exist")
+	}
+
+	if notErr != nil {
+		t.Errorf("AddPolicy - Should add a policy with only 'not_' actions and resources, but got: %s", notErr)
+	}
+
+	if len(notPolicy.Statements) != 1 || len(notPolicy.Statements[0].NotActions) != 1 || len(notPolicy.Statements[0].NotResources) != 1 {
+		t.Errorf("AddPolicy - Expected the 'not_' actions and resources to be saved, but got %+v", notPolicy)
+	}
+
+}
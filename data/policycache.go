@@ -0,0 +1,104 @@
+package data
+
+import (
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// defaultPolicyCacheSize is the number of users the effective policy cache holds
+const defaultPolicyCacheSize = 1024
+
+// policyCache caches the effective policies of users (as resolved by GetPoliciesForUser),
+// along with the compiled policies.  Entries are invalidated when a change affects the
+// user's effective policies.
+//
+// The generation is bumped on every invalidation.  A lookup only adds its result if no
+// invalidation happened since it started -- so a lookup that raced with a change can't
+// put stale policies back in the cache
+type policyCache struct {
+	mu         sync.Mutex
+	users      *lru.Cache
+	generation uint64
+}
+
+// newPolicyCache creates an effective policy cache for the given number of users
+func newPolicyCache(size int) *policyCache {
+	if size <= 0 {
+		size = defaultPolicyCacheSize
+	}
+
+	// golang-lru only returns an error if the cache's size is 0. This, we can safely ignore this error.
+	users, _ := lru.New(size)
+	return &policyCache{users: users}
+}
+
+// userPolicies are the effective policies of a user (and the user's policy variables).  Compiled
+// is nil if the policies couldn't be compiled (or weren't, because they aren't cached).  Expires is
+// when one of the user's grants starts or expires (zero if none will), so the policies have to be resolved again
+type userPolicies struct {
+	policies  map[string]Policy
+	variables map[string][]string
+	compiled  *CompiledPolicies
+	expires   time.Time
+}
+
+// get gets the cached policies for the user (if they are cached).  Along with the policies, it returns
+// the current generation -- pass this to add when caching policies resolved after a miss
+func (cache *policyCache) get(userName string) (userPolicies, uint64, bool) {
+	if cache == nil {
+		return userPolicies{}, 0, false
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if val, ok := cache.users.Get(userName); ok {
+		if expires := val.(userPolicies).expires; expires.IsZero() || time.Now().Before(expires) {
+			return val.(userPolicies), cache.generation, true
+		}
+		cache.users.Remove(userName)
+	}
+
+	return userPolicies{}, cache.generation, false
+}
+
+// add caches the policies for the user, unless the cache has been invalidated since the given generation
+func (cache *policyCache) add(userName string, policies userPolicies, generation uint64) {
+	if cache == nil {
+		return
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if generation == cache.generation {
+		cache.users.Add(userName, policies)
+	}
+}
+
+// invalidate removes the given users from the cache
+func (cache *policyCache) invalidate(userNames []string) {
+	if cache == nil || len(userNames) == 0 {
+		return
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.generation++
+	for _, userName := range userNames {
+		cache.users.Remove(userName)
+	}
+}
+
+// copyPolicies returns a copy of the policy map (so callers can't change cached policies)
+func copyPolicies(policies map[string]Policy) map[string]Policy {
+	retval := make(map[string]Policy, len(policies))
+	for name, policy := range policies {
+		retval[name] = policy
+	}
+
+	return retval
+}
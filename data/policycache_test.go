@@ -0,0 +1,154 @@
+package data_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/danesparza/iamserver/data"
+	"github.com/danesparza/iamserver/policy"
+)
+
+func TestPolicyCache_GetPoliciesForUser_RoleChange_InvalidatesGroupMembers(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	db.AddUser(contextUser, data.User{Name: "bob"}, "testpass")
+	db.AddResource(contextUser, "Serenity", "")
+	db.AddGroup(contextUser, "Crew", "")
+	db.AddUsersToGroup(contextUser, "Crew", "bob")
+	db.AddPolicy(contextUser, data.Policy{Name: "Fly", Effect: policy.Allow, Resources: []string{"Serenity"}, Actions: []string{"Fly"}})
+	db.AddRole(contextUser, "Pilot", "")
+	db.AttachRoleToGroups(contextUser, "Pilot", "Crew")
+
+	//	Act
+	before, _ := db.GetPoliciesForUser(contextUser, "bob")
+	db.AttachPoliciesToRole(contextUser, "Pilot", "Fly")
+	after, _ := db.GetPoliciesForUser(contextUser, "bob")
+	db.DeletePolicy(contextUser, "Fly")
+	deleted, _ := db.GetPoliciesForUser(contextUser, "bob")
+
+	//	Assert
+	if len(before) != 0 {
+		t.Errorf("GetPoliciesForUser - Expected no policies before the role change, but got %v", before)
+	}
+
+	if _, ok := after["Fly"]; !ok || len(after) != 1 {
+		t.Errorf("GetPoliciesForUser - Expected the role policy after the role change, but got %v", after)
+	}
+
+	if len(deleted) != 0 {
+		t.Errorf("GetPoliciesForUser - Expected no policies after the policy was deleted, but got %v", deleted)
+	}
+}
+
+func TestPolicyCache_GetPoliciesForUser_ReturnsCopy(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	db.AddUser(contextUser, data.User{Name: "bob"}, "testpass")
+	db.AddResource(contextUser, "Serenity", "")
+	db.AddPolicy(contextUser, data.Policy{Name: "Fly", Effect: policy.Allow, Resources: []string{"Serenity"}, Actions: []string{"Fly"}})
+	db.AttachPolicyToUsers(contextUser, "Fly", "bob")
+
+	//	Act
+	first, _ := db.GetPoliciesForUser(contextUser, "bob")
+	delete(first, "Fly")
+	second, _ := db.GetPoliciesForUser(contextUser, "bob")
+
+	//	Assert
+	if _, ok := second["Fly"]; !ok {
+		t.Errorf("GetPoliciesForUser - Changing the returned policies should not change the cached policies, but got %v", second)
+	}
+}
+
+// setupAuthorizeBenchmark creates a user in 3 groups.  Each group has 2 roles, and each role has 3 policies
+func setupAuthorizeBenchmark(b *testing.B) (*data.Manager, data.User, func()) {
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		b.Fatalf("NewManager failed: %s", err)
+	}
+
+	contextUser := data.User{Name: "System"}
+	user, err := db.AddUser(contextUser, data.User{Name: "bob"}, "testpass")
+	if err != nil {
+		b.Fatalf("AddUser failed: %s", err)
+	}
+	db.AddResource(contextUser, "Serenity", "")
+
+	for g := 0; g < 3; g++ {
+		groupName := fmt.Sprintf("Group%v", g)
+		db.AddGroup(contextUser, groupName, "")
+		db.AddUsersToGroup(contextUser, groupName, "bob")
+
+		for r := 0; r < 2; r++ {
+			roleName := fmt.Sprintf("%s-Role%v", groupName, r)
+			db.AddRole(contextUser, roleName, "")
+			db.AttachRoleToGroups(contextUser, roleName, groupName)
+
+			for p := 0; p < 3; p++ {
+				policyName := fmt.Sprintf("%s-Policy%v", roleName, p)
+				db.AddPolicy(contextUser, data.Policy{Name: policyName, Effect: policy.Allow, Resources: []string{"Serenity"}, Actions: []string{policyName}})
+				db.AttachPoliciesToRole(contextUser, roleName, policyName)
+			}
+		}
+	}
+
+	return db, user, func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}
+}
+
+func BenchmarkAuthorizeUserRequest_Uncached(b *testing.B) {
+	db, user, cleanup := setupAuthorizeBenchmark(b)
+	defer cleanup()
+
+	uncached := db.WithoutPolicyCache()
+	request := &data.Request{Resource: "Serenity", Action: "Group2-Role1-Policy2"}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !uncached.AuthorizeUserRequest(user, request).Allowed {
+			b.Fatalf("AuthorizeUserRequest - Expected the request to be allowed")
+		}
+	}
+}
+
+func BenchmarkAuthorizeUserRequest_Cached(b *testing.B) {
+	db, user, cleanup := setupAuthorizeBenchmark(b)
+	defer cleanup()
+
+	request := &data.Request{Resource: "Serenity", Action: "Group2-Role1-Policy2"}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !db.AuthorizeUserRequest(user, request).Allowed {
+			b.Fatalf("AuthorizeUserRequest - Expected the request to be allowed")
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package data
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/danesparza/iamserver/compiler"
+	"github.com/danesparza/iamserver/policy"
+	"github.com/pkg/errors"
+)
+
+// CompiledPolicies is a set of policies compiled for fast decisions.  Each statement of each policy is
+// compiled to a rule.  Instead of checking every rule, the rules that match an action are found with an
+// exact match map (for plain names) and a prefix trie (for patterns, keyed by the literal text before the
+// first '<' -- or the first wildcard for glob policies).  Only the patterns whose prefix matches are run --
+// and only the rules that match the action have their resources (and conditions) checked.
+//
+// Some rules can't be found by their actions and are checked for every request:  statements with
+// 'not_actions' and statements with variables (that are left after expanding the user variables).  The
+// variables are resolved (and the patterns compiled) for each request.
+//
+// Decisions are the same as DecidePolicies with the default matcher:  a 'deny' statement overrides
+// all 'allow' statements, and the deciding statement is the first one (by policy name, then by position)
+type CompiledPolicies struct {
+	policies  []Policy
+	rules     []compiledRule
+	actions   *patternIndex
+	unindexed []int
+}
+
+// compiledRule is a compiled statement of a policy.  If notActions (or notResources) is true, the patterns
+// are the statement's 'not_actions' (or 'not_resources') and match when none of them do.  Dynamic rules
+// (with variables) only have the statement -- they are compiled for each request
+type compiledRule struct {
+	policy       int
+	statement    Statement
+	id           string
+	actions      []compiledPattern
+	resources    []compiledPattern
+	notActions   bool
+	notResources bool
+	dynamic      bool
+}
+
+// patternIndex finds the rules (by their index) with a pattern that matches a value
+type patternIndex struct {
+	exact map[string][]int
+	root  *trieNode
+}
+
+// trieNode is a node in the prefix trie.  Patterns are stored at the node for their literal prefix
+type trieNode struct {
+	children map[byte]*trieNode
+	patterns []compiledPattern
+}
+
+// compiledPattern is a compiled pattern of a rule.  Reg is nil for plain names
+type compiledPattern struct {
+	rule   int
+	name   string
+	prefix string
+	reg    *regexp.Regexp
+}
+
+// CompilePolicies compiles the policies for fast decisions.  Returns an error
+// if any of the policies has a pattern that isn't valid
+func CompilePolicies(policies map[string]Policy) (*CompiledPolicies, error) {
+	retval := &CompiledPolicies{
+		policies:  []Policy{},
+		rules:     []compiledRule{},
+		actions:   newPatternIndex(),
+		unindexed: []int{},
+	}
+
+	//	Policies are kept in name order (and rules in policy and statement order, so
+	//	the index of a rule is its position in the order decisions are made in)
+	names := []string{}
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		p := policies[name]
+		retval.policies = append(retval.policies, p)
+
+		for j, s := range p.statements() {
+			index := len(retval.rules)
+
+			//	Statements with variables are compiled for each request
+			if statementHasVariables(s) {
+				retval.rules = append(retval.rules, compiledRule{policy: i, statement: s, id: statementID(s, j), dynamic: true})
+				retval.unindexed = append(retval.unindexed, index)
+				continue
+			}
+
+			rule, err := compileRule(s, p.Syntax, index)
+			if err != nil {
+				return nil, err
+			}
+			rule.policy, rule.id = i, statementID(s, j)
+			retval.rules = append(retval.rules, rule)
+
+			//	Statements with 'not_actions' can't be found by their actions
+			if rule.notActions {
+				retval.unindexed = append(retval.unindexed, index)
+				continue
+			}
+
+			for _, action := range rule.actions {
+				retval.actions.add(action)
+			}
+		}
+	}
+
+	return retval, nil
+}
+
+// Decide checks to see if the request is allowed by the policies, and returns the policy (and the
+// statement of the policy) that made the decision (or an error if the request isn't allowed)
+func (compiled *CompiledPolicies) Decide(r *Request) (PolicyStatement, error) {
+	//	Find the first allow and deny rules that match the action, the resource and the conditions
+	allowedBy, deniedBy := -1, -1
+	var variables map[string][]string
+	var conditionErr error
+
+	decide := func(i int, rule compiledRule) {
+		isDeny := rule.statement.Effect != policy.Allow
+
+		//	Skip rules that can't change the decision
+		if (isDeny && deniedBy != -1 && deniedBy < i) || (!isDeny && allowedBy != -1 && allowedBy < i) {
+			return
+		}
+
+		if !rule.matchesResource(r.Resource) {
+			return
+		}
+
+		if len(rule.statement.Conditions) > 0 {
+			if variables == nil {
+				variables = requestVariables(r)
+			}
+
+			matched, err := conditionsMatch(rule.statement.Conditions, variables)
+			if err != nil {
+				conditionErr = err
+			}
+			if !matched {
+				return
+			}
+		}
+
+		if isDeny {
+			deniedBy = i
+		} else {
+			allowedBy = i
+		}
+	}
+
+	compiled.actions.match(r.Action, func(i int) {
+		decide(i, compiled.rules[i])
+	})
+
+	//	Check the rules that can't be found by their actions.  The variables of the rules that have
+	//	them are resolved (their patterns aren't cached, because they can be different for every request)
+	for _, i := range compiled.unindexed {
+		rule := compiled.rules[i]
+		if rule.dynamic {
+			if variables == nil {
+				variables = requestVariables(r)
+			}
+
+			syntax := compiled.policies[rule.policy].Syntax
+			resolved, err := compileRule(resolveStatement(rule.statement, syntax, variables), syntax, i)
+			if err != nil {
+				return PolicyStatement{}, err
+			}
+			resolved.policy, resolved.id = rule.policy, rule.id
+			rule = resolved
+		}
+
+		if rule.matchesAction(r.Action) {
+			decide(i, rule)
+		}
+	}
+
+	if conditionErr != nil {
+		return PolicyStatement{}, errors.WithStack(conditionErr)
+	}
+
+	//	A 'deny' statement overrides all allow statements
+	if deniedBy != -1 {
+		return compiled.decidedBy(deniedBy), errors.WithStack(ErrRequestForcefullyDenied)
+	}
+
+	if allowedBy == -1 {
+		return PolicyStatement{}, errors.WithStack(ErrRequestDenied)
+	}
+
+	return compiled.decidedBy(allowedBy), nil
+}
+
+// decidedBy gets the policy and statement of the rule with the given index
+func (compiled *CompiledPolicies) decidedBy(rule int) PolicyStatement {
+	return PolicyStatement{
+		Policy:    compiled.policies[compiled.rules[rule].policy].Name,
+		Statement: compiled.rules[rule].id,
+	}
+}
+
+// compileRule compiles the actions and resources (or 'not_actions' and 'not_resources') of a statement
+// (of a policy with the given syntax) to the rule with the given index
+func compileRule(s Statement, syntax string, index int) (compiledRule, error) {
+	retval := compiledRule{statement: s}
+
+	actions := s.Actions
+	if len(s.NotActions) > 0 {
+		actions = s.NotActions
+		retval.notActions = true
+	}
+
+	resources := s.Resources
+	if len(s.NotResources) > 0 {
+		resources = s.NotResources
+		retval.notResources = true
+	}
+
+	var err error
+	if retval.actions, err = compilePatterns(actions, syntax, index); err != nil {
+		return retval, err
+	}
+
+	if retval.resources, err = compilePatterns(resources, syntax, index); err != nil {
+		return retval, err
+	}
+
+	return retval, nil
+}
+
+// matchesAction returns true if the rule matches the action
+func (rule compiledRule) matchesAction(action string) bool {
+	return anyMatches(rule.actions, action) != rule.notActions
+}
+
+// matchesResource returns true if the rule matches the resource
+func (rule compiledRule) matchesResource(resource string) bool {
+	return anyMatches(rule.resources, resource) != rule.notResources
+}
+
+// anyMatches returns true if any of the patterns match the value
+func anyMatches(patterns []compiledPattern, value string) bool {
+	for _, pattern := range patterns {
+		if pattern.matches(value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compilePattern compiles a pattern (in the given syntax) of the rule with the given index.  Patterns
+// without a '<' (or without a wildcard for glob policies) are plain names (just like the matchers)
+func compilePattern(pattern, syntax string, index int) (compiledPattern, error) {
+	retval := compiledPattern{rule: index, name: pattern}
+
+	if syntax == policy.Glob {
+		start := strings.IndexAny(pattern, globMetaChars)
+		if start < 0 {
+			return retval, nil
+		}
+
+		reg, err := compileGlob(pattern)
+		if err != nil {
+			return retval, err
+		}
+
+		retval.prefix = pattern[:start]
+		retval.reg = reg
+		return retval, nil
+	}
+
+	start := strings.IndexByte(pattern, regexpStartDelimeter)
+	if start < 0 {
+		return retval, nil
+	}
+
+	reg, err := compiler.CompileRegex(pattern, regexpStartDelimeter, regexpEndDelimeter)
+	if err != nil {
+		return retval, errors.WithStack(err)
+	}
+
+	retval.prefix = pattern[:start]
+	retval.reg = reg
+	return retval, nil
+}
+
+// compilePatterns compiles the patterns (in the given syntax) of the rule with the given index
+func compilePatterns(patterns []string, syntax string, index int) ([]compiledPattern, error) {
+	retval := []compiledPattern{}
+	for _, pattern := range patterns {
+		compiled, err := compilePattern(pattern, syntax, index)
+		if err != nil {
+			return nil, err
+		}
+		retval = append(retval, compiled)
+	}
+
+	return retval, nil
+}
+
+// matches returns true if the pattern matches the value
+func (pattern compiledPattern) matches(value string) bool {
+	if pattern.reg == nil {
+		return pattern.name == value
+	}
+
+	return pattern.reg.MatchString(value)
+}
+
+// newPatternIndex creates an empty pattern index
+func newPatternIndex() *patternIndex {
+	return &patternIndex{
+		exact: make(map[string][]int),
+		root:  &trieNode{},
+	}
+}
+
+// add adds a compiled pattern (of a rule) to the index
+func (index *patternIndex) add(compiled compiledPattern) {
+	if compiled.reg == nil {
+		index.exact[compiled.name] = append(index.exact[compiled.name], compiled.rule)
+		return
+	}
+
+	//	Find (or create) the node for the literal prefix
+	node := index.root
+	prefix := compiled.prefix
+	for i := 0; i < len(prefix); i++ {
+		if node.children == nil {
+			node.children = make(map[byte]*trieNode)
+		}
+
+		child, ok := node.children[prefix[i]]
+		if !ok {
+			child = &trieNode{}
+			node.children[prefix[i]] = child
+		}
+		node = child
+	}
+
+	node.patterns = append(node.patterns, compiled)
+}
+
+// match calls fn with the index of each rule that has a pattern matching the value
+// (fn may be called more than once for the same rule)
+func (index *patternIndex) match(value string, fn func(rule int)) {
+	for _, rule := range index.exact[value] {
+		fn(rule)
+	}
+
+	//	Walk the trie along the value -- only patterns whose prefix matches the value are run
+	node := index.root
+	for i := 0; node != nil; i++ {
+		for _, pattern := range node.patterns {
+			if pattern.matches(value) {
+				fn(pattern.rule)
+			}
+		}
+
+		if i >= len(value) {
+			break
+		}
+		node = node.children[value[i]]
+	}
+}
@@ -0,0 +1,221 @@
+package data_test
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+
+	"github.com/danesparza/iamserver/data"
+	"github.com/danesparza/iamserver/policy"
+	"github.com/pkg/errors"
+)
+
+// The names, patterns and condition operators used to generate random policies and requests.  The patterns
+// overlap (and share prefixes) so requests often match more than one policy.  Some
+// patterns have a variable from the request context (which requests may or may not have)
+var (
+	quickNames     = []string{"", "Read", "ReadAll", "Re", "Write", "List", "System", "Serenity", "Serenity:Bridge", "s3:bucket"}
+	quickPatterns  = []string{"<.*>", "Read<.*>", "Re<a|b>d", "<Read|Write>", "Serenity<.*>", "Serenity:<[A-Z][a-z]+>", "s3:<.+>", "<[a-z0-9]+>:bucket", "<.*>All", "<R.*>", "Serenity:${context.deck}", "<${context.deck}|s3>:bucket"}
+	quickGlobs     = []string{"*", "Read*", "Re?d", "Serenity*", "Serenity:*", "s3:*", "*:bucket", "*All", "R*", "?", "S*:B*", "${context.deck}*"}
+	quickOperators = []string{policy.StringEquals, policy.StringNotEquals, policy.StringLike, policy.StringNotLike}
+)
+
+// policyScenario is a random set of policies and requests
+type policyScenario struct {
+	Policies map[string]data.Policy
+	Requests []data.Request
+}
+
+// Generate creates a random policy scenario (for testing/quick)
+func (policyScenario) Generate(random *rand.Rand, size int) reflect.Value {
+	scenario := policyScenario{Policies: map[string]data.Policy{}}
+
+	for i := random.Intn(size + 1); i >= 0; i-- {
+		p := data.Policy{Name: fmt.Sprintf("Policy%02d", random.Intn(50))}
+
+		//	Some policies use glob patterns
+		patterns := quickPatterns
+		if random.Intn(3) == 0 {
+			p.Syntax = policy.Glob
+			patterns = quickGlobs
+		}
+
+		//	Pick a random name or pattern
+		pick := func() string {
+			if random.Intn(2) == 0 {
+				return quickNames[random.Intn(len(quickNames))]
+			}
+			return patterns[random.Intn(len(patterns))]
+		}
+
+		for k := random.Intn(3); k >= 0; k-- {
+			s := data.Statement{Effect: policy.Allow}
+			if random.Intn(4) == 0 {
+				s.Effect = policy.Deny
+			}
+
+			for j := random.Intn(3); j >= 0; j-- {
+				s.Actions = append(s.Actions, pick())
+				s.Resources = append(s.Resources, pick())
+			}
+
+			//	Some statements match everything except their actions or resources
+			if random.Intn(4) == 0 {
+				s.Actions, s.NotActions = nil, s.Actions
+			}
+			if random.Intn(4) == 0 {
+				s.Resources, s.NotResources = nil, s.Resources
+			}
+
+			//	Some statements have conditions on the request context
+			if random.Intn(4) == 0 {
+				operator := quickOperators[random.Intn(len(quickOperators))]
+				s.Conditions = data.Conditions{operator: {"context.deck": {pick()}}}
+			}
+
+			p.Statements = append(p.Statements, s)
+		}
+
+		//	Some policies have a single top-level statement (the way policies were stored before statements)
+		if len(p.Statements) == 1 && len(p.Statements[0].Conditions) == 0 && random.Intn(2) == 0 {
+			s := p.Statements[0]
+			p.Statements = nil
+			p.Effect, p.Actions, p.Resources, p.NotActions, p.NotResources = s.Effect, s.Actions, s.Resources, s.NotActions, s.NotResources
+		}
+
+		scenario.Policies[p.Name] = p
+	}
+
+	for i := 0; i < 20; i++ {
+		request := data.Request{
+			Resource: quickNames[random.Intn(len(quickNames))] + quickNames[random.Intn(len(quickNames))],
+			Action:   quickNames[random.Intn(len(quickNames))],
+		}
+		if random.Intn(2) == 0 {
+			request.Context = map[string]string{"deck": quickNames[random.Intn(len(quickNames))]}
+		}
+		scenario.Requests = append(scenario.Requests, request)
+	}
+
+	return reflect.ValueOf(scenario)
+}
+
+func TestCompiledPolicies_Decide_SameAsDecidePolicies(t *testing.T) {
+	//	Arrange
+	mgr := &data.Manager{}
+
+	//	Act
+	err := quick.Check(func(scenario policyScenario) bool {
+		compiled, err := data.CompilePolicies(scenario.Policies)
+		if err != nil {
+			t.Logf("CompilePolicies failed: %s", err)
+			return false
+		}
+
+		for _, request := range scenario.Requests {
+			request := request
+			expectedPolicy, expectedErr := mgr.DecidePolicies(&request, scenario.Policies)
+			actualPolicy, actualErr := compiled.Decide(&request)
+
+			if actualPolicy != expectedPolicy || errors.Cause(actualErr) != errors.Cause(expectedErr) {
+				t.Logf("Request %+v: expected %s (%v) but got %s (%v) for policies %+v", request, expectedPolicy, expectedErr, actualPolicy, actualErr, scenario.Policies)
+				return false
+			}
+		}
+
+		return true
+	}, &quick.Config{MaxCount: 500})
+
+	//	Assert
+	if err != nil {
+		t.Errorf("Decide - Compiled policies should make the same decisions as DecidePolicies: %s", err)
+	}
+}
+
+func TestCompiledPolicies_Decide_ExplicitDeny_ReturnsDenyingPolicy(t *testing.T) {
+	//	Arrange
+	compiled, err := data.CompilePolicies(map[string]data.Policy{
+		"Crew ship access": {Name: "Crew ship access", Effect: policy.Allow, Resources: []string{"Serenity"}, Actions: []string{"Open", "Embark"}},
+		"Deny ship access": {Name: "Deny ship access", Effect: policy.Deny, Resources: []string{"Seren<.*>"}, Actions: []string{"<.*>"}},
+		"Healthcare":       {Name: "Healthcare", Effect: policy.Allow, Resources: []string{"Healthcare"}, Actions: []string{"<Present.*>"}},
+	})
+	if err != nil {
+		t.Fatalf("CompilePolicies - Should compile without error, but got: %s", err)
+	}
+
+	//	Act
+	deniedBy, deniedErr := compiled.Decide(&data.Request{Resource: "Serenity", Action: "Open"})
+	allowedBy, allowedErr := compiled.Decide(&data.Request{Resource: "Healthcare", Action: "PresentHMOcard"})
+	_, defaultErr := compiled.Decide(&data.Request{Resource: "Healthcare", Action: "Leave"})
+
+	//	Assert
+	if deniedBy.Policy != "Deny ship access" || errors.Cause(deniedErr) != data.ErrRequestForcefullyDenied {
+		t.Errorf("Decide - Expected the deny policy to override, but got %s (%v)", deniedBy, deniedErr)
+	}
+
+	if allowedBy.Policy != "Healthcare" || allowedErr != nil {
+		t.Errorf("Decide - Expected the request to be allowed by 'Healthcare', but got %s (%v)", allowedBy, allowedErr)
+	}
+
+	if errors.Cause(defaultErr) != data.ErrRequestDenied {
+		t.Errorf("Decide - Expected the request to be denied by default, but got %v", defaultErr)
+	}
+}
+
+func TestCompilePolicies_InvalidPattern_ReturnsError(t *testing.T) {
+	//	Act
+	_, err := data.CompilePolicies(map[string]data.Policy{
+		"Broken": {Name: "Broken", Effect: policy.Allow, Resources: []string{"<Serenity"}, Actions: []string{"Open"}},
+	})
+
+	//	Assert
+	if err == nil {
+		t.Errorf("CompilePolicies - Should return an error for an unbalanced pattern, but didn't")
+	}
+}
+
+func BenchmarkDecidePolicies_Linear(b *testing.B) {
+	mgr := &data.Manager{}
+	policies := benchmarkPolicies(500)
+	request := &data.Request{Resource: "Serenity:Bridge", Action: "Action499"}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := mgr.DecidePolicies(request, policies); err != nil {
+			b.Fatalf("DecidePolicies - Expected the request to be allowed, but got %s", err)
+		}
+	}
+}
+
+func BenchmarkDecidePolicies_Compiled(b *testing.B) {
+	policies := benchmarkPolicies(500)
+	request := &data.Request{Resource: "Serenity:Bridge", Action: "Action499"}
+	compiled, err := data.CompilePolicies(policies)
+	if err != nil {
+		b.Fatalf("CompilePolicies failed: %s", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := compiled.Decide(request); err != nil {
+			b.Fatalf("Decide - Expected the request to be allowed, but got %s", err)
+		}
+	}
+}
+
+// benchmarkPolicies creates the given number of policies, each for its own action
+// (half of them with a pattern for the resource)
+func benchmarkPolicies(count int) map[string]data.Policy {
+	retval := map[string]data.Policy{}
+	for i := 0; i < count; i++ {
+		p := data.Policy{Name: fmt.Sprintf("Policy%03d", i), Effect: policy.Allow, Actions: []string{fmt.Sprintf("Action%v", i)}, Resources: []string{"Serenity:Bridge"}}
+		if i%2 == 0 {
+			p.Resources = []string{fmt.Sprintf("Serenity:<Deck%v|Bridge>", i)}
+		}
+		retval[p.Name] = p
+	}
+
+	return retval
+}
@@ -0,0 +1,338 @@
+package data
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// PolicyTestSuite is a set of fixtures (users, groups, roles, policies and resources) and the assertions to check
+// against them.  Suites are written in YAML:
+//
+//	policies:
+//	  - name: Read orders
+//	    syntax: glob
+//	    statements:
+//	      - effect: allow
+//	        resources: ["orders/*"]
+//	        actions: ["orders:Read"]
+//	groups:
+//	  - name: support
+//	    policies: [Read orders]
+//	users:
+//	  - name: alice
+//	    groups: [support]
+//	  - name: bob
+//	tests:
+//	  - alice CAN orders:Read on orders/42
+//	  - bob CANNOT System:AddUser
+//	  - assert: alice CAN orders:Read on orders/42
+//	    context: {network: internal}
+//
+// Resources used by the policies (that aren't patterns) are added for the suite if they aren't in its fixtures
+type PolicyTestSuite struct {
+	Name      string               `yaml:"-"`
+	Resources []PolicyTestResource `yaml:"resources"`
+	Policies  []PolicyTestPolicy   `yaml:"policies"`
+	Roles     []PolicyTestRole     `yaml:"roles"`
+	Groups    []PolicyTestGroup    `yaml:"groups"`
+	Users     []PolicyTestUser     `yaml:"users"`
+	Tests     []PolicyTestCase     `yaml:"tests"`
+}
+
+// PolicyTestResource is a resource (and its actions) in a policy test suite
+type PolicyTestResource struct {
+	Name    string   `yaml:"name"`
+	Actions []string `yaml:"actions"`
+}
+
+// PolicyTestPolicy is a policy in a policy test suite.  Like a Policy, it can have statements or
+// a single top-level statement
+type PolicyTestPolicy struct {
+	Name         string      `yaml:"name"`
+	Syntax       string      `yaml:"syntax"`
+	Statements   []Statement `yaml:"statements"`
+	Effect       string      `yaml:"effect"`
+	Resources    []string    `yaml:"resources"`
+	Actions      []string    `yaml:"actions"`
+	NotResources []string    `yaml:"not_resources"`
+	NotActions   []string    `yaml:"not_actions"`
+}
+
+// PolicyTestRole is a role (and the policies attached to it) in a policy test suite
+type PolicyTestRole struct {
+	Name     string   `yaml:"name"`
+	Policies []string `yaml:"policies"`
+}
+
+// PolicyTestGroup is a group (and the policies and roles attached to it) in a policy test suite
+type PolicyTestGroup struct {
+	Name     string   `yaml:"name"`
+	Policies []string `yaml:"policies"`
+	Roles    []string `yaml:"roles"`
+}
+
+// PolicyTestUser is a user (with the user's attributes, groups, and the policies and roles attached to the user)
+// in a policy test suite
+type PolicyTestUser struct {
+	Name       string            `yaml:"name"`
+	Attributes map[string]string `yaml:"attributes"`
+	Groups     []string          `yaml:"groups"`
+	Policies   []string          `yaml:"policies"`
+	Roles      []string          `yaml:"roles"`
+}
+
+// PolicyTestCase is an assertion in a policy test suite (with the context of the request).  Assertions look like:
+// - '<user> CAN <action> on <resource>' (or CANNOT)
+// - '<user> CAN <resource>:<action>' (or CANNOT)
+//
+// A test case can be written as just the assertion, if the request doesn't have a context
+type PolicyTestCase struct {
+	Assert  string            `yaml:"assert"`
+	Context map[string]string `yaml:"context"`
+}
+
+// UnmarshalYAML reads either an assertion or a test case with an assertion and context
+func (c *PolicyTestCase) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&c.Assert); err == nil {
+		return nil
+	}
+
+	type plain PolicyTestCase
+	return unmarshal((*plain)(c))
+}
+
+// PolicyTestResult is the result of a test case.  If the test case failed, Reason says why
+type PolicyTestResult struct {
+	Assert  string            `json:"assert"`
+	Context map[string]string `json:"context,omitempty"`
+	Passed  bool              `json:"passed"`
+	Reason  string            `json:"reason,omitempty"`
+}
+
+// PolicyTestSuiteResult is the result of each of the test cases of a suite
+type PolicyTestSuiteResult struct {
+	Name    string             `json:"name"`
+	Results []PolicyTestResult `json:"results"`
+	Passed  int                `json:"passed"`
+	Failed  int                `json:"failed"`
+}
+
+// ParsePolicyTestSuite reads a policy test suite (with the given name) from a YAML document
+func ParsePolicyTestSuite(name string, document []byte) (PolicyTestSuite, error) {
+	retval := PolicyTestSuite{}
+	if err := yaml.UnmarshalStrict(document, &retval); err != nil {
+		return retval, fmt.Errorf("Policy test suite '%s' is not valid: %s", name, err)
+	}
+
+	retval.Name = name
+	return retval, nil
+}
+
+// RunPolicyTestSuite loads the fixtures of the suite into a temporary store (which is removed afterwards) and
+// checks each of the test cases with the policies of the user (from GetPoliciesForUser and DoPoliciesAllow).
+// An error is returned if the fixtures can't be loaded
+func RunPolicyTestSuite(suite PolicyTestSuite) (PolicyTestSuiteResult, error) {
+	retval := PolicyTestSuiteResult{Name: suite.Name, Results: []PolicyTestResult{}}
+
+	//	Create the temporary store
+	root, err := ioutil.TempDir("", "iamserver-policytest")
+	if err != nil {
+		return retval, fmt.Errorf("Problem creating the temporary store: %s", err)
+	}
+	defer os.RemoveAll(root)
+
+	store, err := NewManager(filepath.Join(root, "system"), filepath.Join(root, "token"))
+	if err != nil {
+		return retval, fmt.Errorf("Problem creating the temporary store: %s", err)
+	}
+	defer store.Close()
+
+	if err := store.loadPolicyTestFixtures(suite); err != nil {
+		return retval, fmt.Errorf("Problem loading the fixtures of policy test suite '%s': %s", suite.Name, err)
+	}
+
+	//	Check each of the test cases
+	for _, test := range suite.Tests {
+		result := store.runPolicyTestCase(test)
+		if result.Passed {
+			retval.Passed++
+		} else {
+			retval.Failed++
+		}
+		retval.Results = append(retval.Results, result)
+	}
+
+	return retval, nil
+}
+
+// loadPolicyTestFixtures adds the fixtures of the suite (and the resources used by its policies)
+func (store Manager) loadPolicyTestFixtures(suite PolicyTestSuite) error {
+	for _, resource := range suite.Resources {
+		if _, err := store.AddResource(SystemUser, resource.Name, ""); err != nil {
+			return fmt.Errorf("Resource '%s': %s", resource.Name, err)
+		}
+		if len(resource.Actions) > 0 {
+			if _, err := store.AddActionToResource(SystemUser, resource.Name, resource.Actions...); err != nil {
+				return fmt.Errorf("Resource '%s': %s", resource.Name, err)
+			}
+		}
+	}
+
+	for _, fixture := range suite.Policies {
+		p := Policy{
+			Name:         fixture.Name,
+			Syntax:       fixture.Syntax,
+			Statements:   fixture.Statements,
+			Effect:       fixture.Effect,
+			Resources:    fixture.Resources,
+			Actions:      fixture.Actions,
+			NotResources: fixture.NotResources,
+			NotActions:   fixture.NotActions,
+		}
+
+		//	Add the resources the policy uses (so they don't all have to be in the fixtures)
+		for _, s := range p.statements() {
+			for _, resource := range append(s.Resources, s.NotResources...) {
+				if isPattern(p.Syntax, resource) {
+					continue
+				}
+				if _, err := store.GetResource(SystemUser, resource); err != nil {
+					store.AddResource(SystemUser, resource, "")
+				}
+			}
+		}
+
+		if _, err := store.AddPolicy(SystemUser, p); err != nil {
+			return fmt.Errorf("Policy '%s': %s", fixture.Name, err)
+		}
+	}
+
+	for _, fixture := range suite.Roles {
+		if _, err := store.AddRole(SystemUser, fixture.Name, ""); err != nil {
+			return fmt.Errorf("Role '%s': %s", fixture.Name, err)
+		}
+		if len(fixture.Policies) > 0 {
+			if _, err := store.AttachPoliciesToRole(SystemUser, fixture.Name, fixture.Policies...); err != nil {
+				return fmt.Errorf("Role '%s': %s", fixture.Name, err)
+			}
+		}
+	}
+
+	for _, fixture := range suite.Groups {
+		if _, err := store.AddGroup(SystemUser, fixture.Name, ""); err != nil {
+			return fmt.Errorf("Group '%s': %s", fixture.Name, err)
+		}
+		for _, policyName := range fixture.Policies {
+			if _, err := store.AttachPolicyToGroups(SystemUser, policyName, fixture.Name); err != nil {
+				return fmt.Errorf("Group '%s': %s", fixture.Name, err)
+			}
+		}
+		for _, roleName := range fixture.Roles {
+			if _, err := store.AttachRoleToGroups(SystemUser, roleName, fixture.Name); err != nil {
+				return fmt.Errorf("Group '%s': %s", fixture.Name, err)
+			}
+		}
+	}
+
+	for _, fixture := range suite.Users {
+		if _, err := store.AddUser(SystemUser, User{Name: fixture.Name, Enabled: true}, "policytest"); err != nil {
+			return fmt.Errorf("User '%s': %s", fixture.Name, err)
+		}
+		if len(fixture.Attributes) > 0 {
+			if _, err := store.SetUserAttributes(SystemUser, fixture.Name, fixture.Attributes); err != nil {
+				return fmt.Errorf("User '%s': %s", fixture.Name, err)
+			}
+		}
+		for _, groupName := range fixture.Groups {
+			if _, err := store.AddUsersToGroup(SystemUser, groupName, fixture.Name); err != nil {
+				return fmt.Errorf("User '%s': %s", fixture.Name, err)
+			}
+		}
+		for _, policyName := range fixture.Policies {
+			if _, err := store.AttachPolicyToUsers(SystemUser, policyName, fixture.Name); err != nil {
+				return fmt.Errorf("User '%s': %s", fixture.Name, err)
+			}
+		}
+		for _, roleName := range fixture.Roles {
+			if _, err := store.AttachRoleToUsers(SystemUser, roleName, fixture.Name); err != nil {
+				return fmt.Errorf("User '%s': %s", fixture.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// runPolicyTestCase checks a test case:  the policies of the user (with the user's variables expanded) have
+// to allow the request for a CAN assertion, and deny it for a CANNOT assertion
+func (store Manager) runPolicyTestCase(test PolicyTestCase) PolicyTestResult {
+	retval := PolicyTestResult{Assert: test.Assert, Context: test.Context}
+
+	userName, can, request, err := parsePolicyAssertion(test.Assert)
+	if err != nil {
+		retval.Reason = err.Error()
+		return retval
+	}
+	request.Context = test.Context
+
+	user, err := store.GetUser(SystemUser, userName)
+	if err != nil {
+		retval.Reason = fmt.Sprintf("User '%s' doesn't exist", userName)
+		return retval
+	}
+
+	policies, err := store.GetPoliciesForUser(SystemUser, userName)
+	if err != nil {
+		retval.Reason = err.Error()
+		return retval
+	}
+
+	err = store.DoPoliciesAllow(request, expandPolicies(policies, userVariables(user)))
+	switch {
+	case can && err != nil:
+		retval.Reason = fmt.Sprintf("Expected the request to be allowed, but it was denied (%s)", errors.Cause(err))
+	case !can && err == nil:
+		retval.Reason = "Expected the request to be denied, but it was allowed"
+	default:
+		retval.Passed = true
+	}
+
+	return retval
+}
+
+// parsePolicyAssertion reads the user, whether the user CAN (or CANNOT) make the request, and the request from an assertion
+func parsePolicyAssertion(assertion string) (string, bool, *Request, error) {
+	fields := strings.Fields(assertion)
+	invalid := fmt.Errorf("Assertion '%s' should look like '<user> CAN <action> on <resource>' or '<user> CANNOT <resource>:<action>'", assertion)
+
+	if (len(fields) != 3 && len(fields) != 5) || (len(fields) == 5 && !strings.EqualFold(fields[3], "on")) {
+		return "", false, nil, invalid
+	}
+
+	can := false
+	switch strings.ToUpper(fields[1]) {
+	case "CAN":
+		can = true
+	case "CANNOT":
+	default:
+		return "", false, nil, invalid
+	}
+
+	if len(fields) == 5 {
+		return fields[0], can, &Request{Resource: fields[4], Action: fields[2]}, nil
+	}
+
+	//	The action is after the last ':' (so resources can have a ':')
+	separator := strings.LastIndex(fields[2], ":")
+	if separator <= 0 || separator == len(fields[2])-1 {
+		return "", false, nil, invalid
+	}
+
+	return fields[0], can, &Request{Resource: fields[2][:separator], Action: fields[2][separator+1:]}, nil
+}
@@ -0,0 +1,116 @@
+package data_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/danesparza/iamserver/data"
+)
+
+func TestRunPolicyTestSuite_Assertions_ReportsResults(t *testing.T) {
+
+	//	Arrange
+	document := `
+policies:
+  - name: Read orders
+    syntax: glob
+    statements:
+      - sid: Read
+        effect: allow
+        resources: ["orders/*"]
+        actions: ["orders:Read"]
+      - effect: deny
+        resources: ["orders/secret"]
+        actions: ["*"]
+  - name: Own team
+    effect: allow
+    resources: ["tickets:<.*>"]
+    actions: [Update]
+    not_actions: []
+  - name: Admin
+    effect: allow
+    resources: [System]
+    actions: [AddUser]
+roles:
+  - name: support
+    policies: [Read orders]
+groups:
+  - name: helpdesk
+    roles: [support]
+    policies: [Own team]
+users:
+  - name: alice
+    groups: [helpdesk]
+  - name: bob
+    policies: [Admin]
+tests:
+  - alice CAN orders:Read on orders/42
+  - alice CANNOT orders:Read on orders/secret
+  - bob CANNOT System:AddUser
+  - bob CAN System:AddUser
+  - assert: alice CAN tickets:1234:Update
+    context: {team: engine}
+  - alice MIGHT System:AddUser
+  - nobody CAN System:AddUser
+`
+
+	//	Act
+	suite, err := data.ParsePolicyTestSuite("orders.yaml", []byte(document))
+	if err != nil {
+		t.Fatalf("ParsePolicyTestSuite - Should parse the suite without error, but got: %s", err)
+	}
+
+	result, err := data.RunPolicyTestSuite(suite)
+
+	//	Assert
+	if err != nil {
+		t.Fatalf("RunPolicyTestSuite - Should run the suite without error, but got: %s", err)
+	}
+
+	expected := []bool{true, true, false, true, true, false, false}
+	if len(result.Results) != len(expected) {
+		t.Fatalf("RunPolicyTestSuite - Expected %v results, but got %+v", len(expected), result.Results)
+	}
+
+	for i, want := range expected {
+		if result.Results[i].Passed != want {
+			t.Errorf("RunPolicyTestSuite - Expected '%s' to pass: %v, but got %+v", result.Results[i].Assert, want, result.Results[i])
+		}
+		if !want && result.Results[i].Reason == "" {
+			t.Errorf("RunPolicyTestSuite - Expected a reason for the failure of '%s'", result.Results[i].Assert)
+		}
+	}
+
+	if result.Passed != 4 || result.Failed != 3 || result.Name != "orders.yaml" {
+		t.Errorf("RunPolicyTestSuite - Expected 4 passed and 3 failed in 'orders.yaml', but got %+v", result)
+	}
+
+	if result.Results[4].Context["team"] != "engine" {
+		t.Errorf("RunPolicyTestSuite - Expected the test case to keep its context, but got %+v", result.Results[4])
+	}
+
+}
+
+func TestRunPolicyTestSuite_InvalidFixtures_ReturnsError(t *testing.T) {
+
+	//	Arrange
+	tests := map[string]string{
+		"unknown field":     "users:\n  - name: alice\n    password: secret\n",
+		"missing policy":    "users:\n  - name: alice\n    policies: [Missing]\n",
+		"invalid statement": "policies:\n  - name: Bad\n    effect: maybe\n    resources: [x]\n    actions: [y]\n",
+	}
+
+	for name, document := range tests {
+		//	Act
+		suite, err := data.ParsePolicyTestSuite(name, []byte(document))
+		if err == nil {
+			_, err = data.RunPolicyTestSuite(suite)
+		}
+
+		//	Assert
+		if err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("RunPolicyTestSuite - Expected an error (naming the suite) for the %s, but got: %v", name, err)
+		}
+	}
+
+}
@@ -0,0 +1,94 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/danesparza/badger"
+)
+
+// RecycleBin represents the deleted items that can still be restored.  Deleted items
+// are kept around for the DeletedRetention period of the Manager
+type RecycleBin struct {
+	Users    []User   `json:"users"`
+	Groups   []Group  `json:"groups"`
+	Roles    []Role   `json:"roles"`
+	Policies []Policy `json:"policies"`
+}
+
+// GetRecycleBin gets all deleted users, groups, roles and policies in the system
+func (store Manager) GetRecycleBin(context User) (RecycleBin, error) {
+	//	Our return item
+	retval := RecycleBin{}
+
+	//	Security check:  Are we authorized to perform this action?
+	if !store.IsUserRequestAuthorized(context, sysreqGetRecycleBin) {
+		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
+	}
+
+	err := store.systemdb.View(func(txn *badger.Txn) error {
+		retval = RecycleBin{Users: []User{}, Groups: []Group{}, Roles: []Role{}, Policies: []Policy{}}
+
+		//	Deleted users
+		if err := forEachItem(txn, GetKey("User"), func(val []byte) error {
+			item := User{}
+			if err := json.Unmarshal(val, &item); err != nil {
+				return err
+			}
+			if item.Deleted.Valid {
+				retval.Users = append(retval.Users, item)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		//	Deleted groups
+		if err := forEachItem(txn, GetKey("Group"), func(val []byte) error {
+			item := Group{}
+			if err := json.Unmarshal(val, &item); err != nil {
+				return err
+			}
+			if item.Deleted.Valid {
+				retval.Groups = append(retval.Groups, item)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		//	Deleted roles
+		if err := forEachItem(txn, GetKey("Role"), func(val []byte) error {
+			item := Role{}
+			if err := json.Unmarshal(val, &item); err != nil {
+				return err
+			}
+			if item.Deleted.Valid {
+				retval.Roles = append(retval.Roles, item)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		//	Deleted policies
+		return forEachItem(txn, GetKey("Policy"), func(val []byte) error {
+			item := Policy{}
+			if err := json.Unmarshal(val, &item); err != nil {
+				return err
+			}
+			if item.Deleted.Valid {
+				retval.Policies = append(retval.Policies, item)
+			}
+			return nil
+		})
+	})
+
+	//	If there was an error, report it:
+	if err != nil {
+		return retval, fmt.Errorf("Problem getting the list of deleted items: %s", err)
+	}
+
+	//	Return our data:
+	return retval, nil
+}
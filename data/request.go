@@ -1,7 +1,9 @@
 package data
 
-// Request represents a request to be validated
+// Request represents a request to be validated.  The context is used to resolve
+// '${context.<key>}' policy variables
 type Request struct {
-	Resource string `json:"resource"`
-	Action   string `json:"action"`
+	Resource string            `json:"resource"`
+	Action   string            `json:"action"`
+	Context  map[string]string `json:"context,omitempty"`
 }
@@ -3,11 +3,9 @@ package data
 import (
 	"encoding/json"
 	"fmt"
-	"sort"
 	"time"
 
 	"github.com/danesparza/badger"
-	"github.com/xtgo/set"
 	null "gopkg.in/guregu/null.v3"
 	"gopkg.in/guregu/null.v3/zero"
 )
@@ -17,6 +15,7 @@ import (
 type Resource struct {
 	Name        string      `json:"name"`
 	Description string      `json:"description"`
+	Version     int64       `json:"version"`
 	Created     time.Time   `json:"created"`
 	CreatedBy   string      `json:"created_by"`
 	Updated     time.Time   `json:"updated"`
@@ -37,38 +36,26 @@ func (store Manager) AddResource(context User, name, description string) (Resour
 		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
 	}
 
-	//	First -- does the resource exist already?
-	err := store.systemdb.View(func(txn *badger.Txn) error {
-		_, err := txn.Get(GetKey("Resource", newResource.Name))
-		return err
-	})
-
-	//	If we didn't get an error, we have a problem:
-	if err == nil {
-		return retval, fmt.Errorf("Resource already exists")
-	}
-
 	//	Update the created / updated fields:
 	newResource.Created = time.Now()
 	newResource.Updated = time.Now()
 	newResource.CreatedBy = context.Name
 	newResource.UpdatedBy = context.Name
 
-	//	Serialize to JSON format
-	encoded, err := json.Marshal(newResource)
-	if err != nil {
-		return retval, fmt.Errorf("Problem serializing the data: %s", err)
-	}
+	err := store.update(newAuditEvent(context.Name, sysreqAddResource.Action, "Resource", newResource.Name), func(txn *writeTxn) error {
+		//	First -- does the resource exist already?
+		if _, err := txn.Get(GetKey("Resource", newResource.Name)); err == nil {
+			return fmt.Errorf("Resource already exists")
+		}
 
-	//	Save it to the database:
-	err = store.systemdb.Update(func(txn *badger.Txn) error {
-		err := txn.Set(GetKey("Resource", newResource.Name), encoded)
-		return err
+		//	Save it to the database (as the first version):
+		newResource.Version = 1
+		return setItem(txn, GetKey("Resource", newResource.Name), newResource)
 	})
 
 	//	If there was an error saving the data, report it:
 	if err != nil {
-		return retval, fmt.Errorf("Problem saving the data: %s", err)
+		return retval, err
 	}
 
 	//	Set our retval:
@@ -117,63 +104,40 @@ func (store Manager) GetResource(context User, resourceName string) (Resource, e
 	return retval, nil
 }
 
-// GetAllResources gets all resources in the system
-func (store Manager) GetAllResources(context User) ([]Resource, error) {
+// GetAllResources gets the resources in the system that match the list options (a page at a time, if the
+// options have a limit).  Returns the resources and the cursor for the next page (empty if this is the last page)
+func (store Manager) GetAllResources(context User, options ListOptions) ([]Resource, string, error) {
 	//	Our return item
 	retval := []Resource{}
 
 	//	Security check:  Are we authorized to perform this action?
 	if !store.IsUserRequestAuthorized(context, sysreqGetAllResources) {
-		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
+		return retval, "", fmt.Errorf("User %s is not authorized to perform the action", context.Name)
 	}
 
-	err := store.systemdb.View(func(txn *badger.Txn) error {
-
-		//	Get an iterator
-		it := txn.NewIterator(badger.DefaultIteratorOptions)
-		defer it.Close()
-
-		//	Set our prefix
-		prefix := GetKey("Resource")
-
-		//	Iterate over our values:
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-
-			//	Get the item
-			item := it.Item()
-
-			//	Get the item key
-			// k := item.Key()
-
-			//	Get the item value
-			val, err := item.Value()
-			if err != nil {
-				return err
-			}
-
-			if len(val) > 0 {
-				//	Create our item:
-				item := Resource{}
-
-				//	Unmarshal data into our item
-				if err := json.Unmarshal(val, &item); err != nil {
-					return err
-				}
-
-				//	Add to the array of returned users:
-				retval = append(retval, item)
-			}
+	entries, nextCursor, err := store.listItems("Resource", options, func(val []byte) (listEntry, error) {
+		//	Unmarshal data into our item
+		item := Resource{}
+		if err := json.Unmarshal(val, &item); err != nil {
+			return listEntry{}, err
 		}
-		return nil
+
+		return listEntry{item: item, name: item.Name, created: item.Created, updated: item.Updated, deleted: item.Deleted.Valid,
+			enabled: !item.Deleted.Valid}, nil
 	})
 
 	//	If there was an error, report it:
 	if err != nil {
-		return retval, fmt.Errorf("Problem getting the list of items: %s", err)
+		return retval, "", err
+	}
+
+	//	Add to the array of returned resources:
+	for _, entry := range entries {
+		retval = append(retval, entry.item.(Resource))
 	}
 
 	//	Return our data:
-	return retval, nil
+	return retval, nextCursor, nil
 }
 
 // AddActionToResource adds action(s) to a resource
@@ -186,59 +150,30 @@ func (store Manager) AddActionToResource(context User, resourceName string, acti
 		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
 	}
 
-	//	First -- validate that the resource exists
-	err := store.systemdb.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(GetKey("Resource", resourceName))
-		if err != nil {
-			return err
-		}
-		val, err := item.Value()
-		if err != nil {
-			return err
+	err := store.update(newAuditEvent(context.Name, sysreqAddActionToResource.Action, "Resource", resourceName), func(txn *writeTxn) error {
+		//	First -- validate that the resource exists
+		retval = Resource{}
+		if err := getItem(txn, GetKey("Resource", resourceName), &retval); err != nil {
+			return fmt.Errorf("Resource does not exist")
 		}
 
-		if len(val) > 0 {
-			//	Unmarshal data into our item
-			if err := json.Unmarshal(val, &retval); err != nil {
-				return err
-			}
+		//	Make sure the resource hasn't changed since the caller last read it
+		if err := store.checkVersion(retval.Version); err != nil {
+			return err
 		}
 
-		return err
-	})
-
-	if err != nil {
-		return retval, fmt.Errorf("Resource does not exist")
-	}
-
-	//	Get the resources's new list of actions from a merged (and deduped) list of:
-	//	- The existing actions
-	// 	- The list of actions passed in
-	allActions := append(retval.Actions, actions...)
-	allUniqueActions := sort.StringSlice(allActions)
-
-	sort.Sort(allUniqueActions)             // sort the data first
-	n := set.Uniq(allUniqueActions)         // Uniq returns the size of the set
-	allUniqueActions = allUniqueActions[:n] // trim the duplicate elements
-
-	//	Then add actions the resource
-	retval.Actions = allUniqueActions
-
-	//	Serialize the group to JSON format
-	encoded, err := json.Marshal(retval)
-	if err != nil {
-		return retval, fmt.Errorf("Problem serializing the data: %s", err)
-	}
+		//	Get the resources's new list of actions from a merged (and deduped) list of:
+		//	- The existing actions
+		// 	- The list of actions passed in
+		retval.Actions = mergeItems(retval.Actions, actions...)
 
-	//	Save it to the database:
-	err = store.systemdb.Update(func(txn *badger.Txn) error {
-		err := txn.Set(GetKey("Resource", retval.Name), encoded)
-		return err
+		retval.Version++
+		return setItem(txn, GetKey("Resource", retval.Name), retval)
 	})
 
 	//	If there was an error saving the data, report it:
 	if err != nil {
-		return retval, fmt.Errorf("Problem completing the resource updates: %s", err)
+		return retval, err
 	}
 
 	//	Return our data:
@@ -109,3 +109,53 @@ func TestResource_AddActionsToResource_ResourceDoesntExist_ReturnsError(t *testi
 	}
 
 }
+
+func TestNewManager_SystemResourceIsMissingActions_AddsActions(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	defer func() {
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	//	Set up the System resource the way an older version bootstrapped it
+	contextUser := data.User{Name: "System"}
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	db.AddResource(contextUser, "System", "The system resource")
+	db.AddActionToResource(contextUser, "System", "AddUser", "GetUser")
+	db.Close()
+
+	//	Act
+	db, err = data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager - Should add the missing system actions without error, but got: %s", err)
+	}
+	db.Close()
+
+	db, err = data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer db.Close()
+
+	system, _ := db.GetResource(contextUser, "System")
+	events, _, _ := db.GetAuditLog(contextUser, data.AuditQuery{Action: "MigrateSystemActions"})
+
+	//	Assert
+	actions := map[string]bool{}
+	for _, action := range system.Actions {
+		actions[action] = true
+	}
+
+	if !actions["AddUser"] || !actions["WhoCan"] || !actions["GetGrants"] || !actions["RestorePolicy"] {
+		t.Errorf("NewManager - Expected the System resource to have the current system actions, but got %v", system.Actions)
+	}
+
+	if len(events) != 1 {
+		t.Errorf("NewManager - Expected the missing actions to be added once, but got %v audit events", len(events))
+	}
+}
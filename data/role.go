@@ -3,11 +3,9 @@ package data
 import (
 	"encoding/json"
 	"fmt"
-	"sort"
 	"time"
 
 	"github.com/danesparza/badger"
-	"github.com/xtgo/set"
 	null "gopkg.in/guregu/null.v3"
 	"gopkg.in/guregu/null.v3/zero"
 )
@@ -17,6 +15,7 @@ import (
 type Role struct {
 	Name        string      `json:"name"`
 	Description string      `json:"description"`
+	Version     int64       `json:"version"`
 	Created     time.Time   `json:"created"`
 	CreatedBy   string      `json:"created_by"`
 	Updated     time.Time   `json:"updated"`
@@ -48,32 +47,20 @@ func (store Manager) AddRole(context User, roleName string, roleDescription stri
 		UpdatedBy:   context.Name,
 	}
 
-	//	First -- does the role exist already?
-	err := store.systemdb.View(func(txn *badger.Txn) error {
-		_, err := txn.Get(GetKey("Role", role.Name))
-		return err
-	})
-
-	//	If we didn't get an error, we have a problem:
-	if err == nil {
-		return retval, fmt.Errorf("Role already exists")
-	}
-
-	//	Serialize to JSON format
-	encoded, err := json.Marshal(role)
-	if err != nil {
-		return retval, fmt.Errorf("Problem serializing the data: %s", err)
-	}
+	err := store.update(newAuditEvent(context.Name, sysreqAddRole.Action, "Role", role.Name), func(txn *writeTxn) error {
+		//	First -- does the role exist already?
+		if _, err := txn.Get(GetKey("Role", role.Name)); err == nil {
+			return fmt.Errorf("Role already exists")
+		}
 
-	//	Save it to the database:
-	err = store.systemdb.Update(func(txn *badger.Txn) error {
-		err := txn.Set(GetKey("Role", role.Name), encoded)
-		return err
+		//	Save it to the database (as the first version):
+		role.Version = 1
+		return setItem(txn, GetKey("Role", role.Name), role)
 	})
 
 	//	If there was an error saving the data, report it:
 	if err != nil {
-		return retval, fmt.Errorf("Problem saving the data: %s", err)
+		return retval, err
 	}
 
 	//	Set our retval:
@@ -83,7 +70,7 @@ func (store Manager) AddRole(context User, roleName string, roleDescription stri
 	return retval, nil
 }
 
-// GetRole gets a user from the system
+// GetRole gets a role from the system.  A deleted role is only returned if the manager gets deleted items (see WithDeleted)
 func (store Manager) GetRole(context User, roleName string) (Role, error) {
 	//	Our return item
 	retval := Role{}
@@ -110,6 +97,11 @@ func (store Manager) GetRole(context User, roleName string) (Role, error) {
 			}
 		}
 
+		//	Deleted items are filtered out (unless they're asked for)
+		if retval.Deleted.Valid && !store.includeDeleted {
+			return badger.ErrKeyNotFound
+		}
+
 		return nil
 	})
 
@@ -122,63 +114,40 @@ func (store Manager) GetRole(context User, roleName string) (Role, error) {
 	return retval, nil
 }
 
-// GetAllRoles gets all roles in the system
-func (store Manager) GetAllRoles(context User) ([]Role, error) {
+// GetAllRoles gets the roles in the system that match the list options (a page at a time, if the
+// options have a limit).  Returns the roles and the cursor for the next page (empty if this is the last page)
+func (store Manager) GetAllRoles(context User, options ListOptions) ([]Role, string, error) {
 	//	Our return item
 	retval := []Role{}
 
 	//	Security check:  Are we authorized to perform this action?
 	if !store.IsUserRequestAuthorized(context, sysreqGetAllRoles) {
-		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
+		return retval, "", fmt.Errorf("User %s is not authorized to perform the action", context.Name)
 	}
 
-	err := store.systemdb.View(func(txn *badger.Txn) error {
-
-		//	Get an iterator
-		it := txn.NewIterator(badger.DefaultIteratorOptions)
-		defer it.Close()
-
-		//	Set our prefix
-		prefix := GetKey("Role")
-
-		//	Iterate over our values:
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-
-			//	Get the item
-			item := it.Item()
-
-			//	Get the item key
-			// k := item.Key()
-
-			//	Get the item value
-			val, err := item.Value()
-			if err != nil {
-				return err
-			}
-
-			if len(val) > 0 {
-				//	Create our item:
-				item := Role{}
-
-				//	Unmarshal data into our item
-				if err := json.Unmarshal(val, &item); err != nil {
-					return err
-				}
-
-				//	Add to the array of returned users:
-				retval = append(retval, item)
-			}
+	entries, nextCursor, err := store.listItems("Role", options, func(val []byte) (listEntry, error) {
+		//	Unmarshal data into our item
+		item := Role{}
+		if err := json.Unmarshal(val, &item); err != nil {
+			return listEntry{}, err
 		}
-		return nil
+
+		return listEntry{item: item, name: item.Name, created: item.Created, updated: item.Updated, deleted: item.Deleted.Valid,
+			enabled: !item.Deleted.Valid, users: item.Users, groups: item.Groups, policies: item.Policies}, nil
 	})
 
 	//	If there was an error, report it:
 	if err != nil {
-		return retval, fmt.Errorf("Problem getting the list of items: %s", err)
+		return retval, "", err
+	}
+
+	//	Add to the array of returned roles:
+	for _, entry := range entries {
+		retval = append(retval, entry.item.(Role))
 	}
 
 	//	Return our data:
-	return retval, nil
+	return retval, nextCursor, nil
 }
 
 // AttachPoliciesToRole attaches policies to a role -- and tracks that relationship
@@ -186,403 +155,398 @@ func (store Manager) GetAllRoles(context User) ([]Role, error) {
 func (store Manager) AttachPoliciesToRole(context User, roleName string, policies ...string) (Role, error) {
 	//	Our return item
 	retval := Role{}
-	affectedPolicies := []Policy{}
 
 	//	Security check:  Are we authorized to perform this action?
 	if !store.IsUserRequestAuthorized(context, sysreqAttachPoliciesToRole) {
 		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
 	}
 
-	//	First -- validate that the role exists
-	err := store.systemdb.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(GetKey("Role", roleName))
-		if err != nil {
-			return err
+	err := store.update(newAuditEvent(context.Name, sysreqAttachPoliciesToRole.Action, "Role", roleName), func(txn *writeTxn) error {
+		//	First -- validate that the role exists
+		retval = Role{}
+		if err := getItem(txn, GetKey("Role", roleName), &retval); err != nil {
+			return fmt.Errorf("Role does not exist")
 		}
-		val, err := item.Value()
-		if err != nil {
-			return err
+		if retval.Deleted.Valid {
+			return fmt.Errorf("Role is deleted")
 		}
 
-		if len(val) > 0 {
-			//	Unmarshal data into our item
-			if err := json.Unmarshal(val, &retval); err != nil {
-				return err
-			}
+		//	Make sure the role hasn't changed since the caller last read it
+		if err := store.checkVersion(retval.Version); err != nil {
+			return err
 		}
 
-		return err
-	})
-
-	if err != nil {
-		return retval, fmt.Errorf("Role does not exist")
-	}
-
-	//	Next:
-	//	- Validate that each of the policies exist
-	//	- Track each user in 'affectedPolicies'
-	for _, currentpolicy := range policies {
-		err := store.systemdb.View(func(txn *badger.Txn) error {
-			item, err := txn.Get(GetKey("Policy", currentpolicy))
-
-			if err != nil {
-				return err
+		//	Next -- validate that each of the policies exist and add the role to each policy
+		for _, currentpolicy := range policies {
+			affectedPolicy := Policy{}
+			if err := getItem(txn, GetKey("Policy", currentpolicy), &affectedPolicy); err != nil {
+				return fmt.Errorf("Policy %s doesn't exist", currentpolicy)
+			}
+			if affectedPolicy.Deleted.Valid {
+				return fmt.Errorf("Policy %s is deleted", currentpolicy)
 			}
 
-			//	Deserialize the user and add to the list of affected users
-			val, err := item.Value()
-			if err != nil {
+			affectedPolicy.Roles = mergeItems(affectedPolicy.Roles, roleName)
+			affectedPolicy.Version++
+			if err := setItem(txn, GetKey("Policy", affectedPolicy.Name), affectedPolicy); err != nil {
 				return err
 			}
+		}
 
-			if len(val) > 0 {
-				currentpolicyObject := Policy{}
-
-				//	Unmarshal data into our item
-				if err := json.Unmarshal(val, &currentpolicyObject); err != nil {
-					return err
-				}
-
-				//	Add the object to our list of affected policies:
-				affectedPolicies = append(affectedPolicies, currentpolicyObject)
-			}
+		//	Get the roles's new list of policies from a merged (and deduped) list of:
+		//	- The existing role policies
+		// 	- The list of policies passed in
+		retval.Policies = mergeItems(retval.Policies, policies...)
 
-			return err
-		})
+		retval.Version++
+		return setItem(txn, GetKey("Role", retval.Name), retval)
+	})
 
-		if err != nil {
-			return retval, fmt.Errorf("Policy %s doesn't exist", currentpolicy)
-		}
+	//	If there was an error saving the data, report it:
+	if err != nil {
+		return retval, err
 	}
 
-	//	Get the roles's new list of policies from a merged (and deduped) list of:
-	//	- The existing role policies
-	// 	- The list of policies passed in
-	allRolePolicies := append(retval.Policies, policies...)
-	allUniqueRolePolicies := sort.StringSlice(allRolePolicies)
+	//	Return our data:
+	return retval, nil
+}
 
-	sort.Sort(allUniqueRolePolicies)                  // sort the data first
-	n := set.Uniq(allUniqueRolePolicies)              // Uniq returns the size of the set
-	allUniqueRolePolicies = allUniqueRolePolicies[:n] // trim the duplicate elements
+// AttachRoleToUsers attaches a role to the given user(s).  If the manager makes time-bound
+// attachments (see During), the users get a grant for the time period instead
+func (store Manager) AttachRoleToUsers(context User, roleName string, users ...string) (Role, error) {
+	//	Our return item
+	retval := Role{}
 
-	//	Then add each of policies to both ...
-	//	the role
-	retval.Policies = allUniqueRolePolicies
+	//	Security check:  Are we authorized to perform this action?
+	if !store.IsUserRequestAuthorized(context, sysreqAttachRoleToUsers) {
+		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
+	}
 
-	//	Serialize the role to JSON format
-	encoded, err := json.Marshal(retval)
-	if err != nil {
-		return retval, fmt.Errorf("Problem serializing the data: %s", err)
+	//	If the attachment is time-bound, check the time period
+	grant, grantErr := store.newGrant(context, AccessRole, roleName)
+	if store.timeBound() && grantErr != nil {
+		return retval, grantErr
 	}
 
-	//	Save it to the database:
-	err = store.systemdb.Update(func(txn *badger.Txn) error {
-		err := txn.Set(GetKey("Role", retval.Name), encoded)
-		if err != nil {
-			return err
+	err := store.update(newAuditEvent(context.Name, sysreqAttachRoleToUsers.Action, "Role", roleName), func(txn *writeTxn) error {
+		//	First -- validate that the role exists
+		retval = Role{}
+		if err := getItem(txn, GetKey("Role", roleName), &retval); err != nil {
+			return fmt.Errorf("Role does not exist")
+		}
+		if retval.Deleted.Valid {
+			return fmt.Errorf("Role is deleted")
 		}
 
-		//	Save each affected policy to the database (as part of the same transaction):
-		for _, affectedPolicy := range affectedPolicies {
-
-			//	and add the role to each policy
-			currentRoles := append(affectedPolicy.Roles, roleName)
-			allUniqueCurrentRoles := sort.StringSlice(currentRoles)
-
-			sort.Sort(allUniqueCurrentRoles)                   // sort the data first
-			cn := set.Uniq(allUniqueCurrentRoles)              // Uniq returns the size of the set
-			allUniqueCurrentRoles = allUniqueCurrentRoles[:cn] // trim the duplicate elements
-			affectedPolicy.Roles = allUniqueCurrentRoles
+		//	Make sure the role hasn't changed since the caller last read it
+		if err := store.checkVersion(retval.Version); err != nil {
+			return err
+		}
 
-			encoded, err := json.Marshal(affectedPolicy)
-			if err != nil {
-				return err
+		//	Next -- validate that each of the users exist and add the role to each user
+		for _, currentuser := range users {
+			affectedUser := User{}
+			if err := getItem(txn, GetKey("User", currentuser), &affectedUser); err != nil {
+				return fmt.Errorf("User %s doesn't exist", currentuser)
+			}
+			if affectedUser.Deleted.Valid {
+				return fmt.Errorf("User %s is deleted", currentuser)
 			}
 
-			err = txn.Set(GetKey("Policy", affectedPolicy.Name), encoded)
-			if err != nil {
+			if store.timeBound() {
+				affectedUser.Grants = addGrant(affectedUser.Grants, grant)
+			} else {
+				affectedUser.Roles = mergeItems(affectedUser.Roles, roleName)
+			}
+			affectedUser.Version++
+			if err := setItem(txn, GetKey("User", affectedUser.Name), affectedUser); err != nil {
 				return err
 			}
 		}
 
-		return nil
+		//	Get the role's new list of users from a merged (and deduped) list of:
+		//	- The existing role users
+		// 	- The list of users passed in
+		retval.Users = mergeItems(retval.Users, users...)
+
+		retval.Version++
+		return setItem(txn, GetKey("Role", retval.Name), retval)
 	})
 
 	//	If there was an error saving the data, report it:
 	if err != nil {
-		return retval, fmt.Errorf("Problem completing the role updates: %s", err)
+		return retval, err
 	}
 
 	//	Return our data:
 	return retval, nil
 }
 
-// AttachRoleToUsers attaches a role to the given user(s)
-func (store Manager) AttachRoleToUsers(context User, roleName string, users ...string) (Role, error) {
+// AttachRoleToGroups attaches a role to the given group(s)
+func (store Manager) AttachRoleToGroups(context User, roleName string, groups ...string) (Role, error) {
 	//	Our return item
 	retval := Role{}
-	affectedUsers := []User{}
 
 	//	Security check:  Are we authorized to perform this action?
-	if !store.IsUserRequestAuthorized(context, sysreqAttachRoleToUsers) {
+	if !store.IsUserRequestAuthorized(context, sysreqAttachRoleToGroups) {
 		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
 	}
 
-	//	First -- validate that the role exists
-	err := store.systemdb.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(GetKey("Role", roleName))
-		if err != nil {
-			return err
+	err := store.update(newAuditEvent(context.Name, sysreqAttachRoleToGroups.Action, "Role", roleName), func(txn *writeTxn) error {
+		//	First -- validate that the role exists
+		retval = Role{}
+		if err := getItem(txn, GetKey("Role", roleName), &retval); err != nil {
+			return fmt.Errorf("Role does not exist")
 		}
-		val, err := item.Value()
-		if err != nil {
+		if retval.Deleted.Valid {
+			return fmt.Errorf("Role is deleted")
+		}
+
+		//	Make sure the role hasn't changed since the caller last read it
+		if err := store.checkVersion(retval.Version); err != nil {
 			return err
 		}
 
-		if len(val) > 0 {
-			//	Unmarshal data into our item
-			if err := json.Unmarshal(val, &retval); err != nil {
+		//	Next -- validate that each of the groups exist and add the role to each group
+		for _, currentgroup := range groups {
+			affectedGroup := Group{}
+			if err := getItem(txn, GetKey("Group", currentgroup), &affectedGroup); err != nil {
+				return fmt.Errorf("Group %s doesn't exist", currentgroup)
+			}
+			if affectedGroup.Deleted.Valid {
+				return fmt.Errorf("Group %s is deleted", currentgroup)
+			}
+
+			affectedGroup.Roles = mergeItems(affectedGroup.Roles, roleName)
+			affectedGroup.Version++
+			if err := setItem(txn, GetKey("Group", affectedGroup.Name), affectedGroup); err != nil {
 				return err
 			}
 		}
 
-		return err
+		//	Get the role's new list of groups from a merged (and deduped) list of:
+		//	- The existing role groups
+		// 	- The list of groups passed in
+		retval.Groups = mergeItems(retval.Groups, groups...)
+
+		retval.Version++
+		return setItem(txn, GetKey("Role", retval.Name), retval)
 	})
 
+	//	If there was an error saving the data, report it:
 	if err != nil {
-		return retval, fmt.Errorf("Role does not exist")
+		return retval, err
 	}
 
-	//	Next:
-	//	- Validate that each of the users exist
-	//	- Track each user in 'affectedUsers'
-	for _, currentuser := range users {
-		err := store.systemdb.View(func(txn *badger.Txn) error {
-			item, err := txn.Get(GetKey("User", currentuser))
-
-			if err != nil {
-				return err
-			}
+	//	Return our data:
+	return retval, nil
+}
 
-			//	Deserialize the user and add to the list of affected users
-			val, err := item.Value()
-			if err != nil {
-				return err
-			}
+// DeleteRole removes a role from the system.  The role is removed from any users, groups and
+// policies it is attached to (as part of the same transaction) and the list of affected items is returned
+func (store Manager) DeleteRole(context User, roleName string) (CascadeResult, error) {
+	//	Our return item
+	retval := CascadeResult{}
 
-			if len(val) > 0 {
-				currentuserObject := User{}
+	//	Security check:  Are we authorized to perform this action?
+	if !store.IsUserRequestAuthorized(context, sysreqDeleteRole) {
+		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
+	}
 
-				//	Unmarshal data into our item
-				if err := json.Unmarshal(val, &currentuserObject); err != nil {
-					return err
-				}
+	err := store.update(newAuditEvent(context.Name, sysreqDeleteRole.Action, "Role", roleName), func(txn *writeTxn) error {
+		//	Start with an empty list of affected items
+		retval = CascadeResult{}
 
-				//	Add the object to our list of affected users:
-				affectedUsers = append(affectedUsers, currentuserObject)
-			}
+		//	First -- does the role exist?
+		role := Role{}
+		if err := getItem(txn, GetKey("Role", roleName), &role); err != nil {
+			return fmt.Errorf("Role does not exist")
+		}
 
+		//	Make sure the role hasn't changed since the caller last read it
+		if err := store.checkVersion(role.Version); err != nil {
 			return err
-		})
-
-		if err != nil {
-			return retval, fmt.Errorf("User %s doesn't exist", currentuser)
 		}
-	}
-
-	//	Get the role's new list of users from a merged (and deduped) list of:
-	//	- The existing role users
-	// 	- The list of users passed in
-	allRoleUsers := append(retval.Users, users...)
-	allUniqueRoleUsers := sort.StringSlice(allRoleUsers)
-
-	sort.Sort(allUniqueRoleUsers)               // sort the data first
-	n := set.Uniq(allUniqueRoleUsers)           // Uniq returns the size of the set
-	allUniqueRoleUsers = allUniqueRoleUsers[:n] // trim the duplicate elements
-
-	//	Then add each of users to the role
-	retval.Users = allUniqueRoleUsers
 
-	//	Serialize the role to JSON format
-	encoded, err := json.Marshal(retval)
-	if err != nil {
-		return retval, fmt.Errorf("Problem serializing the data: %s", err)
-	}
-
-	//	Save it to the database:
-	err = store.systemdb.Update(func(txn *badger.Txn) error {
-		err := txn.Set(GetKey("Role", retval.Name), encoded)
-		if err != nil {
-			return err
+		//	If it's already deleted, there is nothing to do
+		if role.Deleted.Valid {
+			return fmt.Errorf("Role is already deleted")
 		}
 
-		//	Save each affected user to the database (as part of the same transaction):
-		for _, affectedUser := range affectedUsers {
+		//	Remove the role from users / groups / policies
+		for _, userName := range role.Users {
+			user := User{}
+			if err := getItem(txn, GetKey("User", userName), &user); err != nil {
+				continue // The user is already gone
+			}
 
-			//	and add the group to each user
-			currentRoles := append(affectedUser.Roles, roleName)
-			allUniqueCurrentRoles := sort.StringSlice(currentRoles)
+			//	Grants for the role are dropped (and aren't restored with the role)
+			attached := containsItem(user.Roles, role.Name)
+			user.Roles = removeItem(user.Roles, role.Name)
+			user.Grants = removeGrant(user.Grants, AccessRole, role.Name)
+			user.Version++
+			if err := setItem(txn, GetKey("User", user.Name), user); err != nil {
+				return err
+			}
+			if attached {
+				retval.Users = append(retval.Users, user.Name)
+			}
+		}
 
-			sort.Sort(allUniqueCurrentRoles)                   // sort the data first
-			cn := set.Uniq(allUniqueCurrentRoles)              // Uniq returns the size of the set
-			allUniqueCurrentRoles = allUniqueCurrentRoles[:cn] // trim the duplicate elements
-			affectedUser.Roles = allUniqueCurrentRoles
+		for _, groupName := range role.Groups {
+			group := Group{}
+			if err := getItem(txn, GetKey("Group", groupName), &group); err != nil {
+				continue // The group is already gone
+			}
 
-			encoded, err := json.Marshal(affectedUser)
-			if err != nil {
+			group.Roles = removeItem(group.Roles, role.Name)
+			group.Version++
+			if err := setItem(txn, GetKey("Group", group.Name), group); err != nil {
 				return err
 			}
+			retval.Groups = append(retval.Groups, group.Name)
+		}
+
+		for _, policyName := range role.Policies {
+			policy := Policy{}
+			if err := getItem(txn, GetKey("Policy", policyName), &policy); err != nil {
+				continue // The policy is already gone
+			}
 
-			err = txn.Set(GetKey("User", affectedUser.Name), encoded)
-			if err != nil {
+			policy.Roles = removeItem(policy.Roles, role.Name)
+			policy.Version++
+			if err := setItem(txn, GetKey("Policy", policy.Name), policy); err != nil {
 				return err
 			}
+			retval.Policies = append(retval.Policies, policy.Name)
 		}
 
-		return nil
+		//	Reset the users / groups / policies collections:
+		role.Users = []string{}
+		role.Groups = []string{}
+		role.Policies = []string{}
+
+		//	Update the updated / deleted fields:
+		role.Deleted = zero.TimeFrom(time.Now())
+		role.Updated = time.Now()
+		role.DeletedBy = null.StringFrom(context.Name)
+		role.UpdatedBy = context.Name
+		role.Version++
+
+		//	Keep track of the items it was removed from (so it can be restored later):
+		if err := setItemWithTTL(txn, GetKey("RecycleBin", "Role", role.Name), retval, store.DeletedRetention); err != nil {
+			return err
+		}
+
+		//	Save it to the database with a TTL:
+		return setItemWithTTL(txn, GetKey("Role", role.Name), role, store.DeletedRetention)
 	})
 
-	//	If there was an error saving the data, report it:
+	//	If there was an error deleting the data, report it:
 	if err != nil {
-		return retval, fmt.Errorf("Problem completing the role updates: %s", err)
+		return retval, err
 	}
 
 	//	Return our data:
 	return retval, nil
-
 }
 
-// AttachRoleToGroups attaches a role to the given group(s)
-func (store Manager) AttachRoleToGroups(context User, roleName string, groups ...string) (Role, error) {
+// RestoreRole restores a deleted role (if it hasn't been removed from the system yet).  The role
+// is added back to the users, groups and policies it was removed from when it was deleted (if they still exist)
+func (store Manager) RestoreRole(context User, roleName string) (Role, error) {
 	//	Our return item
 	retval := Role{}
-	affectedGroups := []Group{}
 
 	//	Security check:  Are we authorized to perform this action?
-	if !store.IsUserRequestAuthorized(context, sysreqAttachRoleToGroups) {
+	if !store.IsUserRequestAuthorized(context, sysreqRestoreRole) {
 		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
 	}
 
-	//	First -- validate that the role exists
-	err := store.systemdb.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(GetKey("Role", roleName))
-		if err != nil {
-			return err
+	err := store.update(newAuditEvent(context.Name, sysreqRestoreRole.Action, "Role", roleName), func(txn *writeTxn) error {
+		//	First -- does the role exist?
+		retval = Role{}
+		if err := getItem(txn, GetKey("Role", roleName), &retval); err != nil {
+			return fmt.Errorf("Role does not exist")
 		}
-		val, err := item.Value()
-		if err != nil {
+
+		//	Make sure the role hasn't changed since the caller last read it
+		if err := store.checkVersion(retval.Version); err != nil {
 			return err
 		}
 
-		if len(val) > 0 {
-			//	Unmarshal data into our item
-			if err := json.Unmarshal(val, &retval); err != nil {
-				return err
-			}
+		//	We can only restore deleted items
+		if !retval.Deleted.Valid {
+			return fmt.Errorf("Role is not deleted")
 		}
 
-		return err
-	})
-
-	if err != nil {
-		return retval, fmt.Errorf("Role does not exist")
-	}
-
-	//	Next:
-	//	- Validate that each of the groups exist
-	//	- Track each group in 'affectedGroups'
-	for _, currentgroup := range groups {
-		err := store.systemdb.View(func(txn *badger.Txn) error {
-			item, err := txn.Get(GetKey("Group", currentgroup))
+		//	Get the items the role was removed from when it was deleted
+		removed := CascadeResult{}
+		getItem(txn, GetKey("RecycleBin", "Role", retval.Name), &removed)
 
-			if err != nil {
-				return err
+		//	Add the role back to users, groups and policies
+		for _, userName := range removed.Users {
+			user := User{}
+			if err := getItem(txn, GetKey("User", userName), &user); err != nil || user.Deleted.Valid {
+				continue // The user is gone
 			}
 
-			//	Deserialize the group and add to the list of affected groups
-			val, err := item.Value()
-			if err != nil {
+			user.Roles = mergeItems(user.Roles, retval.Name)
+			user.Version++
+			if err := setItem(txn, GetKey("User", user.Name), user); err != nil {
 				return err
 			}
-
-			if len(val) > 0 {
-				currentgroupObject := Group{}
-
-				//	Unmarshal data into our item
-				if err := json.Unmarshal(val, &currentgroupObject); err != nil {
-					return err
-				}
-
-				//	Add the object to our list of affected groups:
-				affectedGroups = append(affectedGroups, currentgroupObject)
-			}
-
-			return err
-		})
-
-		if err != nil {
-			return retval, fmt.Errorf("Group %s doesn't exist", currentgroup)
+			retval.Users = mergeItems(retval.Users, user.Name)
 		}
-	}
-
-	//	Get the role's new list of groups from a merged (and deduped) list of:
-	//	- The existing role groups
-	// 	- The list of groups passed in
-	allRoleGroups := append(retval.Groups, groups...)
-	allUniqueRoleGroups := sort.StringSlice(allRoleGroups)
-
-	sort.Sort(allUniqueRoleGroups)                // sort the data first
-	n := set.Uniq(allUniqueRoleGroups)            // Uniq returns the size of the set
-	allUniqueRoleGroups = allUniqueRoleGroups[:n] // trim the duplicate elements
-
-	//	Then add each of the groups to the role
-	retval.Groups = allUniqueRoleGroups
 
-	//	Serialize the role to JSON format
-	encoded, err := json.Marshal(retval)
-	if err != nil {
-		return retval, fmt.Errorf("Problem serializing the data: %s", err)
-	}
+		for _, groupName := range removed.Groups {
+			group := Group{}
+			if err := getItem(txn, GetKey("Group", groupName), &group); err != nil || group.Deleted.Valid {
+				continue // The group is gone
+			}
 
-	//	Save it to the database:
-	err = store.systemdb.Update(func(txn *badger.Txn) error {
-		err := txn.Set(GetKey("Role", retval.Name), encoded)
-		if err != nil {
-			return err
+			group.Roles = mergeItems(group.Roles, retval.Name)
+			group.Version++
+			if err := setItem(txn, GetKey("Group", group.Name), group); err != nil {
+				return err
+			}
+			retval.Groups = mergeItems(retval.Groups, group.Name)
 		}
 
-		//	Save each affected group to the database (as part of the same transaction):
-		for _, affectedGroup := range affectedGroups {
-
-			//	and add the group to each role
-			currentRoles := append(affectedGroup.Roles, roleName)
-			allUniqueCurrentRoles := sort.StringSlice(currentRoles)
-
-			sort.Sort(allUniqueCurrentRoles)                   // sort the data first
-			cn := set.Uniq(allUniqueCurrentRoles)              // Uniq returns the size of the set
-			allUniqueCurrentRoles = allUniqueCurrentRoles[:cn] // trim the duplicate elements
-			affectedGroup.Roles = allUniqueCurrentRoles
-
-			encoded, err := json.Marshal(affectedGroup)
-			if err != nil {
-				return err
+		for _, policyName := range removed.Policies {
+			policy := Policy{}
+			if err := getItem(txn, GetKey("Policy", policyName), &policy); err != nil || policy.Deleted.Valid {
+				continue // The policy is gone
 			}
 
-			err = txn.Set(GetKey("Group", affectedGroup.Name), encoded)
-			if err != nil {
+			policy.Roles = mergeItems(policy.Roles, retval.Name)
+			policy.Version++
+			if err := setItem(txn, GetKey("Policy", policy.Name), policy); err != nil {
 				return err
 			}
+			retval.Policies = mergeItems(retval.Policies, policy.Name)
 		}
 
-		return nil
+		//	Reset the deleted fields and update the updated fields:
+		retval.Deleted = zero.Time{}
+		retval.DeletedBy = null.String{}
+		retval.Updated = time.Now()
+		retval.UpdatedBy = context.Name
+		retval.Version++
+
+		//	We don't need to keep track of the removed items anymore:
+		if err := txn.Delete(GetKey("RecycleBin", "Role", retval.Name)); err != nil {
+			return err
+		}
+
+		//	Save it to the database (without a TTL):
+		return setItem(txn, GetKey("Role", retval.Name), retval)
 	})
 
-	//	If there was an error saving the data, report it:
+	//	If there was an error restoring the data, report it:
 	if err != nil {
-		return retval, fmt.Errorf("Problem completing the role updates: %s", err)
+		return retval, err
 	}
 
 	//	Return our data:
 	return retval, nil
-
 }
@@ -1,10 +1,15 @@
 package data_test
 
 import (
+	"fmt"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/danesparza/badger"
 	"github.com/danesparza/iamserver/data"
+	"github.com/danesparza/iamserver/policy"
 )
 
 func TestRole_AddRole_ValidRole_Successful(t *testing.T) {
@@ -472,3 +477,256 @@ func TestRole_AttachRoleToGroup_ValidParams_ReturnsRole(t *testing.T) {
 		t.Errorf("AttachRoleToGroups - Should have attached role to Unittestgroup1, but role is not attached")
 	}
 }
+
+func TestRole_DeleteRole_RoleIsAttached_RemovesReferences(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+
+	db.AddUser(contextUser, data.User{Name: "Unittestuser1"}, "testpass")
+	db.AddGroup(contextUser, "Unittestgroup1", "")
+	db.AddRole(contextUser, "Unittestrole1", "")
+	db.AddResource(contextUser, "Someresource", "")
+	db.AddPolicy(contextUser, data.Policy{Name: "Unittestpolicy1", Effect: policy.Allow, Resources: []string{"Someresource"}, Actions: []string{"Someaction"}})
+
+	db.AttachRoleToUsers(contextUser, "Unittestrole1", "Unittestuser1")
+	db.AttachRoleToGroups(contextUser, "Unittestrole1", "Unittestgroup1")
+	db.AttachPoliciesToRole(contextUser, "Unittestrole1", "Unittestpolicy1")
+
+	//	Act
+	cascade, err := db.DeleteRole(contextUser, "Unittestrole1")
+
+	//	Assert
+	if err != nil {
+		t.Errorf("DeleteRole - Should delete role without error, but got: %s", err)
+	}
+
+	if len(cascade.Users) != 1 || len(cascade.Groups) != 1 || len(cascade.Policies) != 1 {
+		t.Errorf("DeleteRole - Should have reported 1 affected user, group and policy, but got: %+v", cascade)
+	}
+
+	user1, _ := db.GetUser(contextUser, "Unittestuser1")
+	if len(user1.Roles) != 0 {
+		t.Errorf("DeleteRole - Should have removed the role from the user, but user roles are: %v", user1.Roles)
+	}
+
+	group1, _ := db.GetGroup(contextUser, "Unittestgroup1")
+	if len(group1.Roles) != 0 {
+		t.Errorf("DeleteRole - Should have removed the role from the group, but group roles are: %v", group1.Roles)
+	}
+
+	policy1, _ := db.GetPolicy(contextUser, "Unittestpolicy1")
+	if len(policy1.Roles) != 0 {
+		t.Errorf("DeleteRole - Should have removed the role from the policy, but policy roles are: %v", policy1.Roles)
+	}
+}
+
+func TestRole_AttachRoleToUsers_ConcurrentRolesForSameUser_AttachesAllRoles(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	roleCount := 5
+
+	db.AddUser(contextUser, data.User{Name: "Unittestuser1"}, "testpass")
+
+	for i := 0; i < roleCount; i++ {
+		db.AddRole(contextUser, fmt.Sprintf("Unittestrole%v", i), "")
+	}
+
+	//	Act
+	var wg sync.WaitGroup
+	errs := make(chan error, roleCount)
+	for i := 0; i < roleCount; i++ {
+		wg.Add(1)
+		go func(roleName string) {
+			defer wg.Done()
+			_, err := db.AttachRoleToUsers(contextUser, roleName, "Unittestuser1")
+			errs <- err
+		}(fmt.Sprintf("Unittestrole%v", i))
+	}
+	wg.Wait()
+	close(errs)
+
+	//	Assert
+	for err := range errs {
+		if err != nil {
+			t.Errorf("AttachRoleToUsers - Should attach roles concurrently without error, but got: %s", err)
+		}
+	}
+
+	//	Every role should be tracked on the user (no lost updates)
+	user1, _ := db.GetUser(contextUser, "Unittestuser1")
+	if len(user1.Roles) != roleCount {
+		t.Errorf("AttachRoleToUsers - Expected %v roles attached to the user, but got %v", roleCount, user1.Roles)
+	}
+
+	for i := 0; i < roleCount; i++ {
+		role, _ := db.GetRole(contextUser, fmt.Sprintf("Unittestrole%v", i))
+		if len(role.Users) != 1 {
+			t.Errorf("AttachRoleToUsers - Expected %s to be attached to the user, but role users are %v", role.Name, role.Users)
+		}
+	}
+}
+
+func TestRole_AttachRoleToUsers_IfMatchCurrentVersion_UpdatesVersion(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Errorf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+
+	db.AddUser(contextUser, data.User{Name: "Unittestuser1"}, "testpass")
+	newRole, _ := db.AddRole(contextUser, "UnitTest1", "")
+
+	//	Act
+	retrole, err := db.IfMatch(newRole.Version).AttachRoleToUsers(contextUser, newRole.Name, "Unittestuser1")
+
+	//	Assert
+	if newRole.Version != 1 {
+		t.Errorf("AddRole - Expected a new role to be at version 1, but got %v", newRole.Version)
+	}
+
+	if err != nil {
+		t.Errorf("AttachRoleToUsers - Should attach role with the current version without an error, but got %s", err)
+	}
+
+	if retrole.Version != newRole.Version+1 {
+		t.Errorf("AttachRoleToUsers - Expected the role version to be %v, but got %v", newRole.Version+1, retrole.Version)
+	}
+
+	user1, _ := db.GetUser(contextUser, "Unittestuser1")
+	if user1.Version != 2 {
+		t.Errorf("AttachRoleToUsers - Expected the user version to be 2, but got %v", user1.Version)
+	}
+}
+
+func TestRole_AttachRoleToUsers_IfMatchStaleVersion_ReturnsError(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Errorf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+
+	db.AddUser(contextUser, data.User{Name: "Unittestuser1"}, "testpass")
+	db.AddUser(contextUser, data.User{Name: "Unittestuser2"}, "testpass")
+	newRole, _ := db.AddRole(contextUser, "UnitTest1", "")
+
+	//	Someone else updates the role first
+	db.IfMatch(newRole.Version).AttachRoleToUsers(contextUser, newRole.Name, "Unittestuser1")
+
+	//	Act
+	_, err = db.IfMatch(newRole.Version).AttachRoleToUsers(contextUser, newRole.Name, "Unittestuser2")
+
+	//	Assert
+	if err != data.ErrVersionMismatch {
+		t.Errorf("AttachRoleToUsers - Should return ErrVersionMismatch for a stale version, but got %v", err)
+	}
+
+	retrole, _ := db.GetRole(contextUser, newRole.Name)
+	if len(retrole.Users) != 1 {
+		t.Errorf("AttachRoleToUsers - Should not have applied the stale update, but role users are %v", retrole.Users)
+	}
+
+	user2, _ := db.GetUser(contextUser, "Unittestuser2")
+	if len(user2.Roles) != 0 {
+		t.Errorf("AttachRoleToUsers - Should not have attached the role to Unittestuser2, but user roles are %v", user2.Roles)
+	}
+}
+
+func TestNewManager_UnversionedItems_AreMigrated(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	defer func() {
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	//	Store items the way they were stored before items had versions
+	opts := badger.DefaultOptions
+	opts.Dir = systemdb
+	opts.ValueDir = systemdb
+	rawdb, err := badger.Open(opts)
+	if err != nil {
+		t.Fatalf("badger.Open failed: %s", err)
+	}
+	err = rawdb.Update(func(txn *badger.Txn) error {
+		if err := txn.Set(data.GetKey("Role", "Old role"), []byte(`{"name":"Old role","users":[]}`)); err != nil {
+			return err
+		}
+		if err := txn.Set(data.GetKey("Role", "New role"), []byte(`{"name":"New role","version":4}`)); err != nil {
+			return err
+		}
+		return txn.SetWithTTL(data.GetKey("Role", "Deleted role"), []byte(`{"name":"Deleted role","deleted":"2018-09-01T00:00:00Z"}`), time.Hour)
+	})
+	rawdb.Close()
+	if err != nil {
+		t.Fatalf("Storing the old roles failed: %s", err)
+	}
+
+	//	Act
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager - Should migrate item versions without error, but got: %s", err)
+	}
+	defer db.Close()
+
+	contextUser := data.User{Name: "System"}
+	oldRole, oldErr := db.GetRole(contextUser, "Old role")
+	newRole, _ := db.GetRole(contextUser, "New role")
+	deletedRole, deletedErr := db.WithDeleted(true).GetRole(contextUser, "Deleted role")
+
+	//	Assert
+	if oldErr != nil || oldRole.Version != 1 || newRole.Version != 4 {
+		t.Errorf("NewManager - Expected only the unversioned role to be migrated to version 1, but got %+v and %+v (%v)", oldRole, newRole, oldErr)
+	}
+
+	if deletedErr != nil || deletedRole.Version != 1 || !deletedRole.Deleted.Valid {
+		t.Errorf("NewManager - Expected the deleted role to be migrated (and stay deleted), but got %+v (%v)", deletedRole, deletedErr)
+	}
+
+	db.AddUser(contextUser, data.User{Name: "bob"}, "testpass")
+	if _, err := db.IfMatch(oldRole.Version).AttachRoleToUsers(contextUser, "Old role", "bob"); err != nil {
+		t.Errorf("AttachRoleToUsers - Should update the migrated role with its version, but got: %s", err)
+	}
+}
@@ -1,55 +1,175 @@
 package data
 
 import (
+	"encoding/json"
 	"fmt"
+	"math/rand"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/microcosm-cc/bluemonday"
 
 	"github.com/danesparza/badger"
 	"github.com/danesparza/iamserver/policy"
 	"github.com/rs/xid"
+	"github.com/xtgo/set"
 )
 
+// maxUpdateAttempts is the number of times a read-write transaction is attempted
+// before giving up, if it keeps conflicting with other (concurrent) transactions
+const maxUpdateAttempts = 25
+
 // Manager is the data manager
 type Manager struct {
 	systemdb *badger.DB
 	tokendb  *badger.DB
 	Matcher  matcher
 	Input    *bluemonday.Policy
+
+	//	DeletedRetention is how long a deleted item is kept around (and can be restored) before it is removed from the system
+	DeletedRetention time.Duration
+
+	//	expectedVersion is the version an item must be at for an update to be applied (0 means any version)
+	expectedVersion int64
+
+	//	source is the address changes are made from (recorded in audit events)
+	source string
+
+	//	includeDeleted returns deleted items (in the recycle bin) when getting a single item
+	includeDeleted bool
+
+	//	grantNotBefore / grantExpires are the time period of time-bound attachments (a zero grantExpires means attachments are permanent)
+	grantNotBefore time.Time
+	grantExpires   time.Time
+
+	//	policies caches the effective policies of users.  If bypassPolicyCache is set, the
+	//	cache isn't used for lookups (but is still invalidated by changes)
+	policies          *policyCache
+	bypassPolicyCache bool
 }
 
 var (
 	// SystemUser represents the system user
 	SystemUser = User{Name: "System"}
 
-	sysreqAddUser              = &Request{"System", "AddUser"}
-	sysreqGetUser              = &Request{"System", "GetUser"}
-	sysreqGetAllUsers          = &Request{"System", "GetAllUsers"}
-	sysreqDeleteUser           = &Request{"System", "DeleteUser"}
-	sysreqAddGroup             = &Request{"System", "AddGroup"}
-	sysreqGetGroup             = &Request{"System", "GetGroup"}
-	sysreqGetAllGroups         = &Request{"System", "GetAllGroups"}
-	sysreqAddUsersToGroup      = &Request{"System", "AddUsersToGroup"}
-	sysreqAddResource          = &Request{"System", "AddResource"}
-	sysreqGetResource          = &Request{"System", "GetResource"}
-	sysreqGetAllResources      = &Request{"System", "GetAllResources"}
-	sysreqAddActionToResource  = &Request{"System", "AddActionToResource"}
-	sysreqAddRole              = &Request{"System", "AddRole"}
-	sysreqGetRole              = &Request{"System", "GetRole"}
-	sysreqGetAllRoles          = &Request{"System", "GetAllRoles"}
-	sysreqAttachPoliciesToRole = &Request{"System", "AttachPoliciesToRole"}
-	sysreqAttachRoleToUsers    = &Request{"System", "AttachRoleToUsers"}
-	sysreqAttachRoleToGroups   = &Request{"System", "AttachRoleToGroups"}
-	sysreqAddPolicy            = &Request{"System", "AddPolicy"}
-	sysreqGetPolicy            = &Request{"System", "GetPolicy"}
-	sysreqGetAllPolicies       = &Request{"System", "GetAllPolicies"}
-	sysreqAttachPolicyToUsers  = &Request{"System", "AttachPolicyToUsers"}
-	sysreqAttachPolicyToGroups = &Request{"System", "AttachPolicyToGroups"}
-	sysreqGetPoliciesForUser   = &Request{"System", "GetPoliciesForUser"}
+	sysreqAddUser              = &Request{Resource: "System", Action: "AddUser"}
+	sysreqGetUser              = &Request{Resource: "System", Action: "GetUser"}
+	sysreqGetAllUsers          = &Request{Resource: "System", Action: "GetAllUsers"}
+	sysreqDeleteUser           = &Request{Resource: "System", Action: "DeleteUser"}
+	sysreqRestoreUser          = &Request{Resource: "System", Action: "RestoreUser"}
+	sysreqSetUserAttributes    = &Request{Resource: "System", Action: "SetUserAttributes"}
+	sysreqAddGroup             = &Request{Resource: "System", Action: "AddGroup"}
+	sysreqGetGroup             = &Request{Resource: "System", Action: "GetGroup"}
+	sysreqGetAllGroups         = &Request{Resource: "System", Action: "GetAllGroups"}
+	sysreqAddUsersToGroup      = &Request{Resource: "System", Action: "AddUsersToGroup"}
+	sysreqDeleteGroup          = &Request{Resource: "System", Action: "DeleteGroup"}
+	sysreqRestoreGroup         = &Request{Resource: "System", Action: "RestoreGroup"}
+	sysreqAddResource          = &Request{Resource: "System", Action: "AddResource"}
+	sysreqGetResource          = &Request{Resource: "System", Action: "GetResource"}
+	sysreqGetAllResources      = &Request{Resource: "System", Action: "GetAllResources"}
+	sysreqAddActionToResource  = &Request{Resource: "System", Action: "AddActionToResource"}
+	sysreqAddRole              = &Request{Resource: "System", Action: "AddRole"}
+	sysreqGetRole              = &Request{Resource: "System", Action: "GetRole"}
+	sysreqGetAllRoles          = &Request{Resource: "System", Action: "GetAllRoles"}
+	sysreqAttachPoliciesToRole = &Request{Resource: "System", Action: "AttachPoliciesToRole"}
+	sysreqAttachRoleToUsers    = &Request{Resource: "System", Action: "AttachRoleToUsers"}
+	sysreqAttachRoleToGroups   = &Request{Resource: "System", Action: "AttachRoleToGroups"}
+	sysreqDeleteRole           = &Request{Resource: "System", Action: "DeleteRole"}
+	sysreqRestoreRole          = &Request{Resource: "System", Action: "RestoreRole"}
+	sysreqAddPolicy            = &Request{Resource: "System", Action: "AddPolicy"}
+	sysreqGetPolicy            = &Request{Resource: "System", Action: "GetPolicy"}
+	sysreqGetAllPolicies       = &Request{Resource: "System", Action: "GetAllPolicies"}
+	sysreqAttachPolicyToUsers  = &Request{Resource: "System", Action: "AttachPolicyToUsers"}
+	sysreqAttachPolicyToGroups = &Request{Resource: "System", Action: "AttachPolicyToGroups"}
+	sysreqGetPoliciesForUser   = &Request{Resource: "System", Action: "GetPoliciesForUser"}
+	sysreqDeletePolicy         = &Request{Resource: "System", Action: "DeletePolicy"}
+	sysreqRestorePolicy        = &Request{Resource: "System", Action: "RestorePolicy"}
+	sysreqLintPolicies         = &Request{Resource: "System", Action: "LintPolicies"}
+	sysreqSimulatePolicies     = &Request{Resource: "System", Action: "SimulatePolicies"}
+	sysreqWhoCan               = &Request{Resource: "System", Action: "WhoCan"}
+	sysreqAddCampaign          = &Request{Resource: "System", Action: "AddCampaign"}
+	sysreqGetCampaign          = &Request{Resource: "System", Action: "GetCampaign"}
+	sysreqGetAllCampaigns      = &Request{Resource: "System", Action: "GetAllCampaigns"}
+	sysreqRecordReviewDecision = &Request{Resource: "System", Action: "RecordReviewDecision"}
+	sysreqCloseCampaign        = &Request{Resource: "System", Action: "CloseCampaign"}
+	sysreqGetGrants            = &Request{Resource: "System", Action: "GetGrants"}
+	sysreqExpireGrants         = &Request{Resource: "System", Action: "ExpireGrants"}
+	sysreqGetRecycleBin        = &Request{Resource: "System", Action: "GetRecycleBin"}
+	sysreqGetAuditLog          = &Request{Resource: "System", Action: "GetAuditLog"}
+	sysreqAddWebhook           = &Request{Resource: "System", Action: "AddWebhook"}
+	sysreqGetWebhook           = &Request{Resource: "System", Action: "GetWebhook"}
+	sysreqGetAllWebhooks       = &Request{Resource: "System", Action: "GetAllWebhooks"}
+	sysreqDeleteWebhook        = &Request{Resource: "System", Action: "DeleteWebhook"}
+	sysreqGetWebhookOutbox     = &Request{Resource: "System", Action: "GetWebhookOutbox"}
+	sysreqGetChanges           = &Request{Resource: "System", Action: "GetChanges"}
 )
 
+// systemRequests are the requests for each of the system actions (the actions of the System resource)
+var systemRequests = []*Request{
+	sysreqAddUser,
+	sysreqGetUser,
+	sysreqGetAllUsers,
+	sysreqDeleteUser,
+	sysreqRestoreUser,
+	sysreqSetUserAttributes,
+	sysreqAddGroup,
+	sysreqGetGroup,
+	sysreqGetAllGroups,
+	sysreqAddUsersToGroup,
+	sysreqDeleteGroup,
+	sysreqRestoreGroup,
+	sysreqAddResource,
+	sysreqGetResource,
+	sysreqGetAllResources,
+	sysreqAddActionToResource,
+	sysreqAddRole,
+	sysreqGetRole,
+	sysreqGetAllRoles,
+	sysreqAttachPoliciesToRole,
+	sysreqAttachRoleToUsers,
+	sysreqAttachRoleToGroups,
+	sysreqDeleteRole,
+	sysreqRestoreRole,
+	sysreqAddPolicy,
+	sysreqGetPolicy,
+	sysreqGetAllPolicies,
+	sysreqAttachPolicyToUsers,
+	sysreqAttachPolicyToGroups,
+	sysreqGetPoliciesForUser,
+	sysreqDeletePolicy,
+	sysreqRestorePolicy,
+	sysreqLintPolicies,
+	sysreqSimulatePolicies,
+	sysreqWhoCan,
+	sysreqAddCampaign,
+	sysreqGetCampaign,
+	sysreqGetAllCampaigns,
+	sysreqRecordReviewDecision,
+	sysreqCloseCampaign,
+	sysreqGetGrants,
+	sysreqExpireGrants,
+	sysreqGetRecycleBin,
+	sysreqGetAuditLog,
+	sysreqAddWebhook,
+	sysreqGetWebhook,
+	sysreqGetAllWebhooks,
+	sysreqDeleteWebhook,
+	sysreqGetWebhookOutbox,
+	sysreqGetChanges,
+}
+
+// systemActions gets the names of the system actions
+func systemActions() []string {
+	retval := []string{}
+	for _, request := range systemRequests {
+		retval = append(retval, request.Action)
+	}
+
+	return retval
+}
+
 // SystemOverview represents the system overview data
 type SystemOverview struct {
 	UserCount     int
@@ -75,6 +195,12 @@ func NewManager(systemdbpath, tokendbpath string) (*Manager, error) {
 	//	Create the sanitizer policy
 	retval.Input = bluemonday.StrictPolicy()
 
+	//	Keep deleted items around for the default amount of time
+	retval.DeletedRetention = defaultDeletedRetention
+
+	//	Cache the effective policies of users
+	retval.policies = newPolicyCache(defaultPolicyCacheSize)
+
 	//	Open the systemDB
 	sysopts := badger.DefaultOptions
 	sysopts.Dir = systemdbpath
@@ -95,6 +221,21 @@ func NewManager(systemdbpath, tokendbpath string) (*Manager, error) {
 	}
 	retval.tokendb = tokdb
 
+	//	Migrate policies stored before policies had statements
+	if err := retval.migratePolicies(); err != nil {
+		return retval, fmt.Errorf("Problem migrating policies: %s", err)
+	}
+
+	//	Migrate items stored before items had versions
+	if err := retval.migrateVersions(); err != nil {
+		return retval, fmt.Errorf("Problem migrating item versions: %s", err)
+	}
+
+	//	Add system actions added since the system was bootstrapped
+	if err := retval.migrateSystemActions(); err != nil {
+		return retval, fmt.Errorf("Problem migrating the system actions: %s", err)
+	}
+
 	//	Return our Manager reference
 	return retval, nil
 }
@@ -143,41 +284,21 @@ func (store Manager) SystemBootstrap() (User, string, error) {
 	}
 
 	//	Add system actions
-	store.AddActionToResource(contextUser, "System",
-		sysreqAddUser.Action,
-		sysreqGetUser.Action,
-		sysreqGetAllUsers.Action,
-		sysreqAddGroup.Action,
-		sysreqGetGroup.Action,
-		sysreqGetAllGroups.Action,
-		sysreqAddUsersToGroup.Action,
-		sysreqAddResource.Action,
-		sysreqGetResource.Action,
-		sysreqGetAllResources.Action,
-		sysreqAddActionToResource.Action,
-		sysreqAddRole.Action,
-		sysreqGetRole.Action,
-		sysreqGetAllRoles.Action,
-		sysreqAttachPoliciesToRole.Action,
-		sysreqAttachRoleToUsers.Action,
-		sysreqAttachRoleToGroups.Action,
-		sysreqAddPolicy.Action,
-		sysreqGetPolicy.Action,
-		sysreqGetAllPolicies.Action,
-		sysreqAttachPolicyToUsers.Action,
-		sysreqAttachPolicyToGroups.Action,
-		sysreqGetPoliciesForUser.Action,
-	)
+	store.AddActionToResource(contextUser, "System", systemActions()...)
 
 	//	Create the initial system policies
 	adminEverything := Policy{
-		Name:   "Administer everything",
-		Effect: policy.Allow,
-		Resources: []string{
-			"<.*>", // All resources
-		},
-		Actions: []string{
-			"<.*>", // All actions
+		Name: "Administer everything",
+		Statements: []Statement{
+			{
+				Effect: policy.Allow,
+				Resources: []string{
+					"<.*>", // All resources
+				},
+				Actions: []string{
+					"<.*>", // All actions
+				},
+			},
 		},
 	}
 	_, err = store.AddPolicy(contextUser, adminEverything)
@@ -556,3 +677,174 @@ func GetKey(entityType string, keyPart ...string) []byte {
 	allparts = append(allparts, keyPart...)
 	return []byte(strings.Join(allparts, ":"))
 }
+
+// getItem gets the item with the given key (as part of the given transaction)
+// and unmarshals it into retval
+func getItem(txn reader, key []byte, retval interface{}) error {
+	item, err := txn.Get(key)
+	if err != nil {
+		return err
+	}
+	val, err := item.Value()
+	if err != nil {
+		return err
+	}
+
+	if len(val) > 0 {
+		//	Unmarshal data into our item
+		if err := json.Unmarshal(val, retval); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// forEachItem calls fn with the value of each item (as part of the given transaction)
+// whose key starts with the given prefix
+func forEachItem(txn reader, prefix []byte, fn func(val []byte) error) error {
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		val, err := it.Item().Value()
+		if err != nil {
+			return err
+		}
+
+		if len(val) > 0 {
+			if err := fn(val); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// setItem serializes the item to JSON and saves it with the given key
+// (as part of the given transaction)
+func setItem(txn *writeTxn, key []byte, item interface{}) error {
+	encoded, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	if err := txn.recordChange(key, encoded); err != nil {
+		return err
+	}
+
+	return txn.Set(key, encoded)
+}
+
+// setItemWithTTL serializes the item to JSON and saves it with the given key
+// (as part of the given transaction).  The item is removed after the ttl
+func setItemWithTTL(txn *writeTxn, key []byte, item interface{}, ttl time.Duration) error {
+	encoded, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	if err := txn.recordChange(key, encoded); err != nil {
+		return err
+	}
+
+	return txn.SetWithTTL(key, encoded, ttl)
+}
+
+// mergeItems returns a sorted, de-duplicated list of the existing items and the new items
+func mergeItems(existing []string, items ...string) []string {
+	allItems := append([]string{}, existing...)
+	allItems = append(allItems, items...)
+	allUniqueItems := sort.StringSlice(allItems)
+
+	sort.Sort(allUniqueItems)           // sort the data first
+	n := set.Uniq(allUniqueItems)       // Uniq returns the size of the set
+	allUniqueItems = allUniqueItems[:n] // trim the duplicate elements
+
+	return allUniqueItems
+}
+
+// update runs fn in a single read-write transaction against the system database.
+// All reads and writes for one logical operation should happen in fn, so the operation
+// is applied completely or not at all.  If the transaction conflicts with another
+// transaction that committed first, fn is run again (so fn must not depend on state
+// from a previous attempt).  A short, randomized pause between attempts keeps
+// concurrent writers from conflicting with each other in lockstep.
+//
+// The changes made in fn are recorded in the given audit event, which is saved as
+// part of the same transaction.  Once the transaction is committed, the cached effective
+// policies of the users affected by the changes are invalidated
+func (store Manager) update(event AuditEvent, fn func(txn *writeTxn) error) error {
+	var err error
+	var affected []string
+
+	event.SourceIP = store.source
+
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		err = store.systemdb.Update(func(txn *badger.Txn) error {
+			wtxn := &writeTxn{Txn: txn}
+			if err := fn(wtxn); err != nil {
+				return err
+			}
+
+			if err := wtxn.recordEvent(event); err != nil {
+				return err
+			}
+
+			//	Find the users whose effective policies are changed (so we can invalidate their cached policies)
+			users, err := affectedUsers(wtxn, wtxn.changes)
+			affected = users
+			return err
+		})
+		if err == nil {
+			store.policies.invalidate(affected)
+		}
+		if err != badger.ErrConflict {
+			return err
+		}
+
+		time.Sleep(time.Duration(rand.Intn(5*(attempt+1))+1) * time.Millisecond)
+	}
+
+	return err
+}
+
+// IfMatch returns a copy of the manager that will only update an existing item if
+// the item is still at the given version.  If the item has changed since, the update
+// fails with ErrVersionMismatch.  A version of 0 matches any version
+func (store Manager) IfMatch(version int64) Manager {
+	store.expectedVersion = version
+	return store
+}
+
+// WithoutPolicyCache returns a copy of the manager that always resolves the effective
+// policies of users from the database (instead of using cached policies)
+func (store Manager) WithoutPolicyCache() Manager {
+	store.bypassPolicyCache = true
+	return store
+}
+
+// WithDeleted returns a copy of the manager that also gets deleted items (that are still in the recycle
+// bin) from GetUser, GetGroup, GetRole and GetPolicy, if include is true.  By default, they are filtered out
+func (store Manager) WithDeleted(include bool) Manager {
+	store.includeDeleted = include
+	return store
+}
+
+// WithSource returns a copy of the manager that records the given source
+// address (usually the IP address of the client) in audit events
+func (store Manager) WithSource(source string) Manager {
+	store.source = source
+	return store
+}
+
+// checkVersion returns ErrVersionMismatch if the given (current) version of an item
+// doesn't match the version the manager expects
+func (store Manager) checkVersion(current int64) error {
+	if store.expectedVersion != 0 && store.expectedVersion != current {
+		return ErrVersionMismatch
+	}
+
+	return nil
+}
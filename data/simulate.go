@@ -0,0 +1,177 @@
+package data
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/danesparza/badger"
+)
+
+// Simulation is a what-if question:  how would the decisions for a user's requests change if the user had other
+// policies, roles or groups?  They wrap up the following ideas:
+// - User: The user to simulate.  If the user doesn't exist, a hypothetical user (without any policies, roles or groups) is used (a deleted user can't be simulated)
+// - Attributes: The attributes of the user after the change (the user keeps the current attributes if they aren't given)
+// - AddPolicies / RemovePolicies: Policies to attach to (or remove from) the user (and their grants).  Removed policies aren't in effect at all
+// - AddRoles / RemoveRoles: Roles to attach to (or remove from) the user, in the same way
+// - AddGroups / RemoveGroups: Groups to add the user to (or remove the user from, along with any grant of the group)
+// - DraftPolicies: Policies that aren't in the system yet, attached to the user
+// - Requests: The requests to decide, before and after the change
+//
+// Removed policies and roles aren't in effect even if the user gets them through a group (or role).  A draft
+// policy with the name of an existing policy replaces it (to see what changing a policy would do)
+type Simulation struct {
+	User           string            `json:"user"`
+	Attributes     map[string]string `json:"attributes"`
+	AddPolicies    []string          `json:"add_policies"`
+	RemovePolicies []string          `json:"remove_policies"`
+	AddRoles       []string          `json:"add_roles"`
+	RemoveRoles    []string          `json:"remove_roles"`
+	AddGroups      []string          `json:"add_groups"`
+	RemoveGroups   []string          `json:"remove_groups"`
+	DraftPolicies  []Policy          `json:"draft_policies"`
+	Requests       []Request         `json:"requests"`
+}
+
+// SimulationResult has the decisions for each of the requests of a simulation (and the number of decisions that changed)
+type SimulationResult struct {
+	User         string              `json:"user"`
+	Hypothetical bool                `json:"hypothetical"`
+	Decisions    []SimulatedDecision `json:"decisions"`
+	Changed      int                 `json:"changed"`
+}
+
+// SimulatedDecision is the decision for a request before and after the change.  Changed is true if the
+// request is allowed before and denied after the change (or the other way around)
+type SimulatedDecision struct {
+	Before  Decision `json:"before"`
+	After   Decision `json:"after"`
+	Changed bool     `json:"changed"`
+}
+
+// SimulatePolicies decides each of the requests of the simulation with the user's policies as they are, and as they
+// would be after the change.  Nothing is written to the system
+func (store Manager) SimulatePolicies(context User, simulation Simulation) (SimulationResult, error) {
+	//	Our return item
+	retval := SimulationResult{User: simulation.User, Decisions: []SimulatedDecision{}}
+
+	//	Security check:  Are we authorized to perform this action?
+	if !store.IsUserRequestAuthorized(context, sysreqSimulatePolicies) {
+		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
+	}
+
+	if simulation.User == "" {
+		return retval, fmt.Errorf("Simulation must have a user")
+	}
+
+	if len(simulation.Requests) == 0 {
+		return retval, fmt.Errorf("Simulation must have at least one request")
+	}
+
+	//	Check the draft policies (the same way they would be checked when they are added)
+	drafts := map[string]Policy{}
+	for _, draft := range simulation.DraftPolicies {
+		checked, err := normalizePolicy(draft)
+		if err != nil {
+			return retval, fmt.Errorf("Draft policy '%s' is not valid: %s", draft.Name, err)
+		}
+		drafts[checked.Name] = checked
+	}
+
+	//	Resolve the policies before and after the change (in a single transaction)
+	var before, after userPolicies
+	err := store.systemdb.View(func(txn *badger.Txn) error {
+		for entityType, names := range map[string][]string{"Policy": simulation.AddPolicies, "Role": simulation.AddRoles, "Group": simulation.AddGroups} {
+			for _, name := range names {
+				if _, err := txn.Get(GetKey(entityType, name)); err != nil {
+					return fmt.Errorf("%s '%s' doesn't exist", entityType, name)
+				}
+			}
+		}
+
+		user := User{Name: simulation.User}
+		if err := getItem(txn, GetKey("User", simulation.User), &user); err != nil {
+			retval.Hypothetical = true
+			user = User{Name: simulation.User}
+		}
+		if user.Deleted.Valid {
+			return fmt.Errorf("User %s is deleted", simulation.User)
+		}
+		before = resolveUserPolicies(txn, user, nil)
+
+		//	Make the change to (a copy of) the user
+		changed := user
+		changed.Policies = removeItems(mergeItems(user.Policies, simulation.AddPolicies...), simulation.RemovePolicies)
+		changed.Roles = removeItems(mergeItems(user.Roles, simulation.AddRoles...), simulation.RemoveRoles)
+		changed.Groups = removeItems(mergeItems(user.Groups, simulation.AddGroups...), simulation.RemoveGroups)
+		changed.Grants = append([]Grant{}, user.Grants...)
+		for access, names := range map[string][]string{AccessPolicy: simulation.RemovePolicies, AccessRole: simulation.RemoveRoles, AccessGroup: simulation.RemoveGroups} {
+			for _, name := range names {
+				changed.Grants = removeGrant(changed.Grants, access, name)
+			}
+		}
+		if simulation.Attributes != nil {
+			changed.Attributes = simulation.Attributes
+		}
+
+		after = resolveUserPolicies(txn, changed, func(entityType, name string) bool {
+			return (entityType == "Role" && containsItem(simulation.RemoveRoles, name)) ||
+				(entityType == "Policy" && containsItem(simulation.RemovePolicies, name))
+		})
+		return nil
+	})
+	if err != nil {
+		return retval, err
+	}
+
+	for name, draft := range drafts {
+		after.policies[name] = draft
+	}
+
+	//	Decide each of the requests before and after the change
+	beforePolicies := expandPolicies(before.policies, before.variables)
+	afterPolicies := expandPolicies(after.policies, after.variables)
+	for i := range simulation.Requests {
+		request := &simulation.Requests[i]
+
+		decision := SimulatedDecision{
+			Before: store.simulateDecision(simulation.User, request, beforePolicies),
+			After:  store.simulateDecision(simulation.User, request, afterPolicies),
+		}
+		decision.Changed = decision.Before.Allowed != decision.After.Allowed
+		if decision.Changed {
+			retval.Changed++
+		}
+
+		retval.Decisions = append(retval.Decisions, decision)
+	}
+
+	return retval, nil
+}
+
+// simulateDecision decides the request for the user with the given (expanded) policies
+func (store Manager) simulateDecision(userName string, request *Request, policies map[string]Policy) Decision {
+	start := time.Now()
+	retval := Decision{
+		Time:     start,
+		User:     userName,
+		Resource: request.Resource,
+		Action:   request.Action,
+	}
+
+	retval.setOutcome(store.DecidePolicies(request, policies))
+
+	retval.Latency = time.Since(start)
+	return retval
+}
+
+// removeItems returns the list without the given items
+func removeItems(list []string, items []string) []string {
+	retval := []string{}
+	for _, item := range list {
+		if !containsItem(items, item) {
+			retval = append(retval, item)
+		}
+	}
+
+	return retval
+}
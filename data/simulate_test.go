@@ -0,0 +1,173 @@
+package data_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/danesparza/iamserver/data"
+	"github.com/danesparza/iamserver/policy"
+)
+
+func TestManager_SimulatePolicies_DecidesBeforeAndAfter(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	db.AddResource(contextUser, "Serenity", "")
+	db.AddUser(contextUser, data.User{Name: "bob"}, "testpass")
+	db.AddGroup(contextUser, "crew", "")
+	db.AddUsersToGroup(contextUser, "crew", "bob")
+	db.AddPolicy(contextUser, data.Policy{Name: "Fly", Effect: policy.Allow, Resources: []string{"Serenity"}, Actions: []string{"Fly"}})
+	db.AttachPolicyToGroups(contextUser, "Fly", "crew")
+	db.AddPolicy(contextUser, data.Policy{Name: "Land", Effect: policy.Allow, Resources: []string{"Serenity"}, Actions: []string{"Land"}})
+	db.AddRole(contextUser, "pilot", "")
+	db.AttachPoliciesToRole(contextUser, "pilot", "Land")
+	db.AddUser(contextUser, data.User{Name: "zoe"}, "testpass")
+	db.During(time.Time{}, time.Now().Add(time.Hour)).AddUsersToGroup(contextUser, "crew", "zoe")
+	db.During(time.Time{}, time.Now().Add(time.Hour)).AttachRoleToUsers(contextUser, "pilot", "zoe")
+
+	requests := []data.Request{
+		{Resource: "Serenity", Action: "Fly"},
+		{Resource: "Serenity", Action: "Land"},
+		{Resource: "Cargo", Action: "Move"},
+		{Resource: "Serenity", Action: "Sell"},
+	}
+
+	tests := []struct {
+		name         string
+		simulation   data.Simulation
+		before       []bool
+		after        []bool
+		hypothetical bool
+	}{
+		{
+			name: "changed memberships and a draft policy",
+			simulation: data.Simulation{
+				User:          "bob",
+				RemoveGroups:  []string{"crew"},
+				AddRoles:      []string{"pilot"},
+				DraftPolicies: []data.Policy{{Name: "Cargo", Effect: policy.Allow, Resources: []string{"Cargo"}, Actions: []string{"Move"}}},
+			},
+			before: []bool{true, false, false, false},
+			after:  []bool{false, true, true, false},
+		},
+		{
+			name:       "removed policy (from a group)",
+			simulation: data.Simulation{User: "bob", RemovePolicies: []string{"Fly"}},
+			before:     []bool{true, false, false, false},
+			after:      []bool{false, false, false, false},
+		},
+		{
+			name:       "removed granted group and role",
+			simulation: data.Simulation{User: "zoe", RemoveGroups: []string{"crew"}, RemoveRoles: []string{"pilot"}},
+			before:     []bool{true, true, false, false},
+			after:      []bool{false, false, false, false},
+		},
+		{
+			name:         "hypothetical user",
+			simulation:   data.Simulation{User: "newbie", AddGroups: []string{"crew"}, AddPolicies: []string{"Land"}},
+			before:       []bool{false, false, false, false},
+			after:        []bool{true, true, false, false},
+			hypothetical: true,
+		},
+	}
+
+	for _, test := range tests {
+		test.simulation.Requests = requests
+
+		//	Act
+		result, err := db.SimulatePolicies(contextUser, test.simulation)
+
+		//	Assert
+		if err != nil {
+			t.Errorf("SimulatePolicies - Should simulate the %s without error, but got: %s", test.name, err)
+			continue
+		}
+
+		if result.Hypothetical != test.hypothetical || len(result.Decisions) != len(requests) {
+			t.Errorf("SimulatePolicies - Expected %v decisions (hypothetical: %v) for the %s, but got %+v", len(requests), test.hypothetical, test.name, result)
+			continue
+		}
+
+		changed := 0
+		for i, decision := range result.Decisions {
+			if decision.Before.Allowed != test.before[i] || decision.After.Allowed != test.after[i] {
+				t.Errorf("SimulatePolicies - Expected %+v to be allowed: %v before and %v after the %s, but got %+v", requests[i], test.before[i], test.after[i], test.name, decision)
+			}
+			if test.before[i] != test.after[i] {
+				changed++
+			}
+		}
+
+		if result.Changed != changed {
+			t.Errorf("SimulatePolicies - Expected %v changed decisions for the %s, but got %v", changed, test.name, result.Changed)
+		}
+	}
+
+	//	Nothing was written
+	bob, _ := db.GetUser(contextUser, "bob")
+	if len(bob.Groups) != 1 || len(bob.Roles) != 0 {
+		t.Errorf("SimulatePolicies - Expected the user to be unchanged, but got %+v", bob)
+	}
+
+	if _, err := db.GetPolicy(contextUser, "Cargo"); err == nil {
+		t.Errorf("SimulatePolicies - Should not add the draft policy")
+	}
+
+	if _, err := db.GetUser(contextUser, "newbie"); err == nil {
+		t.Errorf("SimulatePolicies - Should not add the hypothetical user")
+	}
+
+}
+
+func TestManager_SimulatePolicies_InvalidSimulation_ReturnsError(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	db.AddUser(contextUser, data.User{Name: "bob"}, "testpass")
+	db.AddUser(contextUser, data.User{Name: "wash"}, "testpass")
+	db.DeleteUser(contextUser, "wash")
+	requests := []data.Request{{Resource: "Serenity", Action: "Fly"}}
+
+	tests := map[string]data.Simulation{
+		"no user":         {Requests: requests},
+		"no requests":     {User: "bob"},
+		"deleted user":    {User: "wash", Requests: requests},
+		"missing role":    {User: "bob", AddRoles: []string{"Missing"}, Requests: requests},
+		"invalid draft":   {User: "bob", DraftPolicies: []data.Policy{{Name: "Bad", Effect: "maybe", Resources: []string{"x"}, Actions: []string{"y"}}}, Requests: requests},
+		"invalid pattern": {User: "bob", DraftPolicies: []data.Policy{{Name: "Bad", Effect: policy.Allow, Resources: []string{"<x"}, Actions: []string{"y"}}}, Requests: requests},
+	}
+
+	for name, simulation := range tests {
+		//	Act
+		_, err := db.SimulatePolicies(contextUser, simulation)
+
+		//	Assert
+		if err == nil {
+			t.Errorf("SimulatePolicies - Should not simulate with %s", name)
+		}
+	}
+
+}
@@ -0,0 +1,274 @@
+package data
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/danesparza/iamserver/policy"
+)
+
+// Statement is a single rule of a policy document.  They wrap up the following ideas:
+// - Sid: An (optional) name for the statement.  It has to be unique in the policy
+// - Effect: The permissive effect of the statement (allow or deny)
+// - Resources / Actions: The resources and actions the statement applies to
+// - NotResources / NotActions: Used instead of resources / actions to match everything except the given items
+// - Conditions: Additional requirements on the context of the request (see Conditions)
+type Statement struct {
+	Sid          string     `json:"sid" yaml:"sid"`
+	Effect       string     `json:"effect" yaml:"effect"`
+	Resources    []string   `json:"resources" yaml:"resources"`
+	Actions      []string   `json:"actions" yaml:"actions"`
+	NotResources []string   `json:"not_resources" yaml:"not_resources"`
+	NotActions   []string   `json:"not_actions" yaml:"not_actions"`
+	Conditions   Conditions `json:"conditions" yaml:"conditions"`
+}
+
+// Conditions are the requirements a request has to meet for a statement to apply.  They are keyed by
+// operator (like 'StringEquals'), then by a request context key (like 'context.team'), with the values to compare with:
+//
+//	{"StringEquals": {"context.team": ["${user.attributes.team}"]}}
+//
+// Every condition has to be true.  A condition is true if the context value matches one of the values (or, for
+// the 'Not' operators, none of them).  If the request doesn't have the context value, only the 'Not' operators are true.
+// Values can have policy variables, and a '\' escapes the character after it
+type Conditions map[string]map[string][]string
+
+// PatternError is returned when a pattern of a policy can't be compiled (or has a variable that doesn't exist)
+type PatternError struct {
+	Statement string
+	Field     string
+	Pattern   string
+	Err       error
+}
+
+// Error returns the error message, with the statement, field and pattern
+func (e *PatternError) Error() string {
+	return fmt.Sprintf("Statement '%s' has an invalid pattern in '%s': '%s' (%s)", e.Statement, e.Field, e.Pattern, e.Err)
+}
+
+// StatusCode returns the status code of this error.
+func (e *PatternError) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+// conditionMatcher matches the values of the 'Like' condition operators
+var conditionMatcher = NewGlobMatcher(512)
+
+// statements gets the statements of the policy.  A policy without statements that has a top-level
+// effect, resources and actions (the way policies were stored before statements) has a single statement
+func (p Policy) statements() []Statement {
+	if len(p.Statements) > 0 || !p.hasTopLevelStatement() {
+		return p.Statements
+	}
+
+	return []Statement{{
+		Effect:       p.Effect,
+		Resources:    p.Resources,
+		Actions:      p.Actions,
+		NotResources: p.NotResources,
+		NotActions:   p.NotActions,
+	}}
+}
+
+// hasTopLevelStatement returns true if the policy has any of the top-level statement fields
+func (p Policy) hasTopLevelStatement() bool {
+	return p.Effect != "" || len(p.Resources) > 0 || len(p.Actions) > 0 || len(p.NotResources) > 0 || len(p.NotActions) > 0
+}
+
+// withStatements returns the policy with the given statements (and without the top-level statement fields)
+func (p Policy) withStatements(statements []Statement) Policy {
+	p.Statements = statements
+	p.Effect = ""
+	p.Resources, p.Actions, p.NotResources, p.NotActions = nil, nil, nil, nil
+	return p
+}
+
+// statementID gets the sid of the statement (or its index in the policy, if it doesn't have a sid)
+func statementID(s Statement, index int) string {
+	if s.Sid != "" {
+		return s.Sid
+	}
+
+	return strconv.Itoa(index)
+}
+
+// validateStatements checks that the statements of a policy are valid
+func validateStatements(statements []Statement) error {
+	if len(statements) == 0 {
+		return fmt.Errorf("Policy must have at least one statement")
+	}
+
+	sids := map[string]bool{}
+	for _, s := range statements {
+		//	Check Sid (it's optional, but has to be unique)
+		if s.Sid != "" && sids[s.Sid] {
+			return fmt.Errorf("Policy can't have more than one statement with sid '%s'", s.Sid)
+		}
+		sids[s.Sid] = true
+
+		//	Check Effect
+		if (s.Effect != policy.Allow) && (s.Effect != policy.Deny) {
+			return fmt.Errorf("Policy must have 'allow' or 'deny' effect")
+		}
+
+		// 	Check Resources / Actions (they can't be blank or empty)
+		if (len(s.Resources) == 0 && len(s.NotResources) == 0) || (len(s.Actions) == 0 && len(s.NotActions) == 0) {
+			return fmt.Errorf("Policy must have 'resources' (or 'not_resources') and 'actions' (or 'not_actions') associated with it")
+		}
+
+		//	A statement can't have both the positive and negative versions of resources / actions
+		if len(s.Resources) > 0 && len(s.NotResources) > 0 {
+			return fmt.Errorf("Policy can't have both 'resources' and 'not_resources'")
+		}
+
+		if len(s.Actions) > 0 && len(s.NotActions) > 0 {
+			return fmt.Errorf("Policy can't have both 'actions' and 'not_actions'")
+		}
+
+		//	Check Conditions
+		for operator, keys := range s.Conditions {
+			switch operator {
+			case policy.StringEquals, policy.StringNotEquals, policy.StringLike, policy.StringNotLike:
+			default:
+				return fmt.Errorf("Condition operator '%s' is not supported", operator)
+			}
+
+			for key := range keys {
+				if !strings.HasPrefix(key, "context.") {
+					return fmt.Errorf("Condition key '%s' must be a request context key (like 'context.%s')", key, key)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// validatePatterns compiles the patterns of each statement of a policy (and the values of the 'Like' conditions),
+// so a pattern that can't be compiled is found when the policy is saved -- rather than failing every decision later.
+// Variables are checked too:  a variable that doesn't exist would never match anything
+func validatePatterns(p Policy) error {
+	type field struct {
+		name     string
+		syntax   string
+		patterns []string
+	}
+
+	for i, s := range p.statements() {
+		fields := []field{
+			{"resources", p.Syntax, s.Resources},
+			{"actions", p.Syntax, s.Actions},
+			{"not_resources", p.Syntax, s.NotResources},
+			{"not_actions", p.Syntax, s.NotActions},
+		}
+
+		//	Condition values are checked like globs (whatever the syntax of the policy)
+		for operator, keys := range s.Conditions {
+			for key, values := range keys {
+				fields = append(fields, field{fmt.Sprintf("conditions.%s.%s", operator, key), policy.Glob, values})
+			}
+		}
+
+		for _, f := range fields {
+			for _, pattern := range f.patterns {
+				if err := validatePattern(pattern, f.syntax); err != nil {
+					return &PatternError{Statement: statementID(s, i), Field: f.name, Pattern: pattern, Err: err}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// validatePattern compiles the pattern (in the given syntax), with a placeholder value for each of its variables
+func validatePattern(pattern, syntax string) error {
+	placeholders := map[string][]string{}
+	for rest := pattern; ; {
+		start := strings.Index(rest, variableStart)
+		if start < 0 {
+			break
+		}
+		end := strings.Index(rest[start:], variableEnd)
+		if end < 0 {
+			break
+		}
+
+		name := rest[start+len(variableStart) : start+end]
+		if !isVariable(name) {
+			return fmt.Errorf("Policy variable '%s%s%s' doesn't exist", variableStart, name, variableEnd)
+		}
+		placeholders[name] = []string{"x"}
+		rest = rest[start+end+len(variableEnd):]
+	}
+
+	for _, expanded := range expandPattern(pattern, syntax, placeholders) {
+		if _, err := compilePattern(expanded, syntax, 0); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// conditionsMatch returns true if all of the conditions are true for the given (request) variables
+func conditionsMatch(conditions Conditions, variables map[string][]string) (bool, error) {
+	for operator, keys := range conditions {
+		negated := operator == policy.StringNotEquals || operator == policy.StringNotLike
+
+		for key, values := range keys {
+			matched := false
+			for _, value := range variables[key] {
+				var err error
+				if operator == policy.StringLike || operator == policy.StringNotLike {
+					matched, err = conditionMatcher.Matches(Policy{}, values, value)
+				} else {
+					matched = containsItem(unescapeValues(values), value)
+				}
+
+				if err != nil {
+					return false, err
+				}
+				if matched {
+					break
+				}
+			}
+
+			if matched == negated {
+				return false, nil
+			}
+		}
+	}
+
+	return true, nil
+}
+
+// unescapeValues removes the escape characters from condition values
+func unescapeValues(values []string) []string {
+	retval := []string{}
+	for _, value := range values {
+		if !strings.Contains(value, `\`) {
+			retval = append(retval, value)
+			continue
+		}
+
+		unescaped := ""
+		escaped := false
+		for _, r := range value {
+			if r == '\\' && !escaped {
+				escaped = true
+				continue
+			}
+			unescaped += string(r)
+			escaped = false
+		}
+		if escaped {
+			unescaped += `\`
+		}
+		retval = append(retval, unescaped)
+	}
+
+	return retval
+}
@@ -0,0 +1,233 @@
+package data_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/danesparza/badger"
+	"github.com/danesparza/iamserver/data"
+	"github.com/danesparza/iamserver/policy"
+)
+
+func TestPolicy_AddPolicy_Statements_DecidesByStatement(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	db.AddUser(contextUser, data.User{Name: "bob"}, "testpass")
+	db.AddResource(contextUser, "Serenity", "")
+
+	_, err = db.AddPolicy(contextUser, data.Policy{
+		Name: "Crew",
+		Statements: []data.Statement{
+			{Sid: "Fly the ship", Effect: policy.Allow, Resources: []string{"Serenity"}, Actions: []string{"<.*>"}},
+			{Sid: "Stay out of the infirmary", Effect: policy.Deny, Resources: []string{"Serenity"}, Actions: []string{"OpenInfirmary"}},
+			{Effect: policy.Allow, Resources: []string{"Cargo:<.*>"}, Actions: []string{"Move"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("AddPolicy - Should add a policy with statements without error, but got: %s", err)
+	}
+	db.AttachPolicyToUsers(contextUser, "Crew", "bob")
+	bob := data.User{Name: "bob"}
+
+	tests := []struct {
+		request   *data.Request
+		allowed   bool
+		statement string
+	}{
+		{&data.Request{Resource: "Serenity", Action: "Fly"}, true, "Fly the ship"},
+		{&data.Request{Resource: "Serenity", Action: "OpenInfirmary"}, false, "Stay out of the infirmary"},
+		{&data.Request{Resource: "Cargo:Crates", Action: "Move"}, true, "2"},
+		{&data.Request{Resource: "Cargo:Crates", Action: "Sell"}, false, ""},
+	}
+
+	for _, test := range tests {
+		//	Act
+		cached := db.AuthorizeUserRequest(bob, test.request)
+		uncached := db.WithoutPolicyCache().AuthorizeUserRequest(bob, test.request)
+
+		//	Assert
+		for _, decision := range []data.Decision{cached, uncached} {
+			if decision.Allowed != test.allowed || decision.Statement != test.statement {
+				t.Errorf("AuthorizeUserRequest - Expected %+v to be allowed: %v by statement '%s', but got %+v", test.request, test.allowed, test.statement, decision)
+			}
+
+			if test.statement != "" && decision.Policy != "Crew" {
+				t.Errorf("AuthorizeUserRequest - Expected the decision to be made by the 'Crew' policy, but got %+v", decision)
+			}
+		}
+	}
+
+}
+
+func TestPolicy_AddPolicy_InvalidStatements_ReturnsError(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	valid := data.Statement{Sid: "Valid", Effect: policy.Allow, Resources: []string{"<.*>"}, Actions: []string{"Fly"}}
+	tests := map[string]data.Policy{
+		"no statements":        {Name: "UnitTest1"},
+		"duplicate sid":        {Name: "UnitTest2", Statements: []data.Statement{valid, valid}},
+		"top-level statement":  {Name: "UnitTest3", Statements: []data.Statement{valid}, Effect: policy.Allow, Actions: []string{"Fly"}},
+		"invalid operator":     {Name: "UnitTest4", Statements: []data.Statement{{Effect: policy.Allow, Resources: []string{"<.*>"}, Actions: []string{"Fly"}, Conditions: data.Conditions{"NumericEquals": {"context.deck": {"1"}}}}}},
+		"invalid key":          {Name: "UnitTest5", Statements: []data.Statement{{Effect: policy.Allow, Resources: []string{"<.*>"}, Actions: []string{"Fly"}, Conditions: data.Conditions{policy.StringEquals: {"user.name": {"bob"}}}}}},
+		"invalid effect":       {Name: "UnitTest6", Statements: []data.Statement{valid, {Effect: "someweirdeffect", Resources: []string{"<.*>"}, Actions: []string{"Fly"}}}},
+		"missing resource":     {Name: "UnitTest7", Statements: []data.Statement{valid, {Effect: policy.Allow, Resources: []string{"Missingresource"}, Actions: []string{"Fly"}}}},
+		"actions and not_ too": {Name: "UnitTest8", Statements: []data.Statement{{Effect: policy.Allow, Resources: []string{"<.*>"}, Actions: []string{"Fly"}, NotActions: []string{"Land"}}}},
+	}
+
+	for name, testPolicy := range tests {
+		//	Act
+		_, err := db.AddPolicy(contextUser, testPolicy)
+
+		//	Assert
+		if err == nil {
+			t.Errorf("AddPolicy - Should not add a policy with %s", name)
+		}
+	}
+
+}
+
+func TestManager_AuthorizeUserRequest_Conditions_Successful(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	db.AddUser(contextUser, data.User{Name: "bob"}, "testpass")
+	db.SetUserAttributes(contextUser, "bob", map[string]string{"team": "engine"})
+
+	_, err = db.AddPolicy(contextUser, data.Policy{
+		Name: "Team tickets",
+		Statements: []data.Statement{
+			{
+				Sid:        "Own team",
+				Effect:     policy.Allow,
+				Resources:  []string{"tickets:<.*>"},
+				Actions:    []string{"Update"},
+				Conditions: data.Conditions{policy.StringEquals: {"context.team": {"${user.attributes.team}"}}},
+			},
+			{
+				Sid:        "Not from outside",
+				Effect:     policy.Deny,
+				Resources:  []string{"tickets:<.*>"},
+				Actions:    []string{"<.*>"},
+				Conditions: data.Conditions{policy.StringNotLike: {"context.network": {"10.*"}}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("AddPolicy - Should add a policy with conditions without error, but got: %s", err)
+	}
+	db.AttachPolicyToUsers(contextUser, "Team tickets", "bob")
+	bob := data.User{Name: "bob"}
+
+	tests := []struct {
+		context map[string]string
+		want    bool
+	}{
+		{map[string]string{"team": "engine", "network": "10.0.0.1"}, true},
+		{map[string]string{"team": "bridge", "network": "10.0.0.1"}, false},
+		{map[string]string{"team": "engine", "network": "192.168.0.1"}, false},
+		{map[string]string{"team": "engine"}, false},
+		{map[string]string{"network": "10.0.0.1"}, false},
+	}
+
+	for _, test := range tests {
+		request := &data.Request{Resource: "tickets:1234", Action: "Update", Context: test.context}
+
+		//	Act
+		cached := db.AuthorizeUserRequest(bob, request)
+		uncached := db.WithoutPolicyCache().AuthorizeUserRequest(bob, request)
+
+		//	Assert
+		if cached.Allowed != test.want || uncached.Allowed != test.want {
+			t.Errorf("AuthorizeUserRequest - Expected the request with context %v to be allowed: %v, but got %+v (cached) and %+v (uncached)", test.context, test.want, cached, uncached)
+		}
+	}
+
+}
+
+func TestNewManager_SingleStatementPolicies_AreMigrated(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	defer func() {
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	//	Store a policy the way it was stored before policies had statements
+	opts := badger.DefaultOptions
+	opts.Dir = systemdb
+	opts.ValueDir = systemdb
+	rawdb, err := badger.Open(opts)
+	if err != nil {
+		t.Fatalf("badger.Open failed: %s", err)
+	}
+	err = rawdb.Update(func(txn *badger.Txn) error {
+		return txn.Set(data.GetKey("Policy", "Old policy"), []byte(`{"sid":"Old policy","effect":"allow","resources":["Serenity"],"actions":["Fly"],"users":["bob"],"version":3}`))
+	})
+	rawdb.Close()
+	if err != nil {
+		t.Fatalf("Storing the old policy failed: %s", err)
+	}
+
+	//	Act
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager - Should migrate policies without error, but got: %s", err)
+	}
+	defer db.Close()
+
+	migrated, err := db.GetPolicy(data.User{Name: "System"}, "Old policy")
+
+	//	Assert
+	if err != nil {
+		t.Fatalf("GetPolicy - Should get the migrated policy without error, but got: %s", err)
+	}
+
+	if len(migrated.Statements) != 1 || migrated.Statements[0].Effect != policy.Allow || migrated.Statements[0].Resources[0] != "Serenity" || migrated.Statements[0].Actions[0] != "Fly" {
+		t.Errorf("NewManager - Expected the policy to be migrated to a single statement, but got %+v", migrated)
+	}
+
+	if migrated.Effect != "" || len(migrated.Resources) != 0 || len(migrated.Actions) != 0 {
+		t.Errorf("NewManager - Expected the top-level statement to be removed, but got %+v", migrated)
+	}
+
+	if migrated.Version != 3 || len(migrated.Users) != 1 {
+		t.Errorf("NewManager - Expected the rest of the policy to stay the same, but got %+v", migrated)
+	}
+
+}
@@ -149,6 +149,11 @@ func (store Manager) GetUserForToken(tokenID string) (User, error) {
 			}
 		}
 
+		//	Tokens of a deleted user aren't valid anymore
+		if retval.Deleted.Valid {
+			return fmt.Errorf("User doesn't exist: %s", token.User)
+		}
+
 		return nil
 	})
 
@@ -95,17 +95,13 @@ func (store Manager) BeginTOTPEnrollment(userName string, expiresafter time.Dura
 		URL:    key.URL(),
 	}
 
-	//	Serialize to JSON format
-	encoded, err := json.Marshal(retval)
-	if err != nil {
-		return retval, fmt.Errorf("Problem serializing the data: %s", err)
-	}
-
 	//	Save it to the database:
-	err = store.systemdb.Update(func(txn *badger.Txn) error {
-		err := txn.SetWithTTL(GetKey("TotpEnrollment", retval.User), encoded, expiresafter)
-		return err
+	err = store.update(newAuditEvent(userName, "BeginTOTPEnrollment", "User", userName), func(txn *writeTxn) error {
+		return setItemWithTTL(txn, GetKey("TotpEnrollment", retval.User), retval, expiresafter)
 	})
+	if err != nil {
+		return retval, fmt.Errorf("Problem saving the enrollment: %s", err)
+	}
 
 	//	Return our data:
 	return retval, nil
@@ -114,94 +110,45 @@ func (store Manager) BeginTOTPEnrollment(userName string, expiresafter time.Dura
 // FinishTOTPEnrollment finishes TOTP enrollment for a user.  If the user already has two factor
 // authentication enabled, this will return an error
 func (store Manager) FinishTOTPEnrollment(userName, validationCode string) (User, error) {
-	//	Our return item
-	enrollment := TotpEnrollment{}
-
-	//	The user to check
+	//	The user to update
 	user := User{}
 
-	//	First, make sure we can look up the user's enrollment:
-	err := store.systemdb.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(GetKey("TotpEnrollment", userName))
-		if err != nil {
-			return err
-		}
-		val, err := item.Value()
-		if err != nil {
-			return err
+	err := store.update(newAuditEvent(userName, "FinishTOTPEnrollment", "User", userName), func(txn *writeTxn) error {
+		//	First, make sure we can look up the user's enrollment:
+		enrollment := TotpEnrollment{}
+		if err := getItem(txn, GetKey("TotpEnrollment", userName), &enrollment); err != nil {
+			return fmt.Errorf("Enrollment not found")
 		}
 
-		if len(val) > 0 {
-			//	Unmarshal data into our item
-			if err := json.Unmarshal(val, &enrollment); err != nil {
-				return err
-			}
+		//	Next -- find out if the user is already enrolled in two-factor authentication
+		user = User{}
+		if err := getItem(txn, GetKey("User", userName), &user); err != nil || user.Deleted.Valid {
+			return fmt.Errorf("User does not exist")
 		}
 
-		return nil
-	})
-
-	//	If we got an error, we have a problem:
-	if err != nil {
-		return user, fmt.Errorf("Enrollment not found")
-	}
-
-	//	Next -- find out if the user is already enrolled in two-factor authentication
-	err = store.systemdb.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(GetKey("User", userName))
-		if err != nil {
-			return err
-		}
-		val, err := item.Value()
-		if err != nil {
-			return err
+		//	If the user is already enrolled -- return an error
+		if user.TOTPEnabled == true {
+			return fmt.Errorf("User already has TOTP enabled.  To get a new TOTP key, disable TOTP and then re-enroll")
 		}
 
-		if len(val) > 0 {
-			//	Unmarshal data into our item
-			if err := json.Unmarshal(val, &user); err != nil {
-				return err
-			}
+		//	Validate the TOTP information:
+		validEnrollment := totp.Validate(validationCode, enrollment.Secret)
+		if !validEnrollment {
+			return fmt.Errorf("Not a valid OTP code.  Please use the code from your authentication app")
 		}
 
-		return nil
-	})
-
-	//	If we got an error, we have a problem:
-	if err != nil {
-		return user, fmt.Errorf("User does not exist")
-	}
-
-	//	If the user is already enrolled -- return an error
-	if user.TOTPEnabled == true {
-		return user, fmt.Errorf("User already has TOTP enabled.  To get a new TOTP key, disable TOTP and then re-enroll")
-	}
-
-	//	Validate the TOTP information:
-	validEnrollment := totp.Validate(validationCode, enrollment.Secret)
-	if !validEnrollment {
-		return user, fmt.Errorf("Not a valid OTP code.  Please use the code from your authentication app")
-	}
-
-	//	Set the secret and turn on two factor for the user:
-	user.TOTPEnabled = true
-	user.TOTPSecret = enrollment.Secret
-
-	//	Serialize user to JSON format
-	encoded, err := json.Marshal(user)
-	if err != nil {
-		return user, fmt.Errorf("Problem serializing the data: %s", err)
-	}
+		//	Set the secret and turn on two factor for the user:
+		user.TOTPEnabled = true
+		user.TOTPSecret = enrollment.Secret
 
-	//	Save user to the database:
-	err = store.systemdb.Update(func(txn *badger.Txn) error {
-		err := txn.Set(GetKey("User", user.Name), encoded)
-		return err
+		//	Save user to the database:
+		user.Version++
+		return setItem(txn, GetKey("User", user.Name), user)
 	})
 
-	//	If there was an error saving the data, report it:
+	//	If there was an error, report it:
 	if err != nil {
-		return user, fmt.Errorf("Problem saving the user: %s", err)
+		return user, err
 	}
 
 	//	Return our updated user:
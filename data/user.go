@@ -3,6 +3,7 @@ package data
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/danesparza/badger"
@@ -16,21 +17,24 @@ import (
 // They can be created/updated/deleted.  If they are deleted, eventually
 // they will be removed from the system.  The admin user can only be disabled, not deleted
 type User struct {
-	Name        string      `json:"name"`
-	Enabled     bool        `json:"enabled"`
-	Description string      `json:"description"`
-	SecretHash  string      `json:"secrethash"`
-	TOTPEnabled bool        `json:"totpenabled"`
-	TOTPSecret  string      `json:"totpsecret"`
-	Created     time.Time   `json:"created"`
-	CreatedBy   string      `json:"created_by"`
-	Updated     time.Time   `json:"updated"`
-	UpdatedBy   string      `json:"updated_by"`
-	Deleted     zero.Time   `json:"deleted"`
-	DeletedBy   null.String `json:"deleted_by"`
-	Groups      []string    `json:"groups"`
-	Policies    []string    `json:"policies"`
-	Roles       []string    `json:"roles"`
+	Name        string            `json:"name"`
+	Enabled     bool              `json:"enabled"`
+	Description string            `json:"description"`
+	SecretHash  string            `json:"secrethash"`
+	TOTPEnabled bool              `json:"totpenabled"`
+	TOTPSecret  string            `json:"totpsecret"`
+	Version     int64             `json:"version"`
+	Created     time.Time         `json:"created"`
+	CreatedBy   string            `json:"created_by"`
+	Updated     time.Time         `json:"updated"`
+	UpdatedBy   string            `json:"updated_by"`
+	Deleted     zero.Time         `json:"deleted"`
+	DeletedBy   null.String       `json:"deleted_by"`
+	Groups      []string          `json:"groups"`
+	Policies    []string          `json:"policies"`
+	Roles       []string          `json:"roles"`
+	Attributes  map[string]string `json:"attributes"`
+	Grants      []Grant           `json:"grants"`
 }
 
 // AddUser adds a user to the system
@@ -47,17 +51,6 @@ func (store Manager) AddUser(context User, user User, userPassword string) (User
 	user.Name = store.Input.Sanitize(user.Name)
 	user.Description = store.Input.Sanitize(user.Description)
 
-	//	First -- does the user exist already?
-	err := store.systemdb.View(func(txn *badger.Txn) error {
-		_, err := txn.Get(GetKey("User", user.Name))
-		return err
-	})
-
-	//	If we didn't get an error, we have a problem:
-	if err == nil {
-		return retval, fmt.Errorf("User already exists")
-	}
-
 	//	Hash the password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(userPassword), bcrypt.DefaultCost)
 	if err != nil {
@@ -81,21 +74,20 @@ func (store Manager) AddUser(context User, user User, userPassword string) (User
 	user.CreatedBy = context.Name
 	user.UpdatedBy = context.Name
 
-	//	Serialize to JSON format
-	encoded, err := json.Marshal(user)
-	if err != nil {
-		return retval, fmt.Errorf("Problem serializing the data: %s", err)
-	}
+	err = store.update(newAuditEvent(context.Name, sysreqAddUser.Action, "User", user.Name), func(txn *writeTxn) error {
+		//	First -- does the user exist already?
+		if _, err := txn.Get(GetKey("User", user.Name)); err == nil {
+			return fmt.Errorf("User already exists")
+		}
 
-	//	Save it to the database:
-	err = store.systemdb.Update(func(txn *badger.Txn) error {
-		err := txn.Set(GetKey("User", user.Name), encoded)
-		return err
+		//	Save it to the database (as the first version):
+		user.Version = 1
+		return setItem(txn, GetKey("User", user.Name), user)
 	})
 
 	//	If there was an error saving the data, report it:
 	if err != nil {
-		return retval, fmt.Errorf("Problem saving the data: %s", err)
+		return retval, err
 	}
 
 	//	Set our retval:
@@ -105,7 +97,7 @@ func (store Manager) AddUser(context User, user User, userPassword string) (User
 	return retval, nil
 }
 
-// GetUser gets a user from the system
+// GetUser gets a user from the system.  A deleted user is only returned if the manager gets deleted items (see WithDeleted)
 func (store Manager) GetUser(context User, userName string) (User, error) {
 	//	Our return item
 	retval := User{}
@@ -132,6 +124,11 @@ func (store Manager) GetUser(context User, userName string) (User, error) {
 			}
 		}
 
+		//	Deleted items are filtered out (unless they're asked for)
+		if retval.Deleted.Valid && !store.includeDeleted {
+			return badger.ErrKeyNotFound
+		}
+
 		return nil
 	})
 
@@ -144,124 +141,309 @@ func (store Manager) GetUser(context User, userName string) (User, error) {
 	return retval, nil
 }
 
-// DeleteUser adds a user to the system
-func (store Manager) DeleteUser(context User, user User, userPassword string) (User, error) {
+// DeleteUser removes a user from the system.  The user is removed from any groups, roles and
+// policies it is attached to (as part of the same transaction) and the list of affected items is returned
+func (store Manager) DeleteUser(context User, userName string) (CascadeResult, error) {
 	//	Our return item
-	retval := User{}
+	retval := CascadeResult{}
 
 	//	Security check:  Are we authorized to perform this action?
 	if !store.IsUserRequestAuthorized(context, sysreqDeleteUser) {
 		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
 	}
 
-	//	First -- does the user exist already?
-	err := store.systemdb.View(func(txn *badger.Txn) error {
-		_, err := txn.Get(GetKey("User", user.Name))
-		return err
+	err := store.update(newAuditEvent(context.Name, sysreqDeleteUser.Action, "User", userName), func(txn *writeTxn) error {
+		//	Start with an empty list of affected items
+		retval = CascadeResult{}
+
+		//	First -- does the user exist?
+		user := User{}
+		if err := getItem(txn, GetKey("User", userName), &user); err != nil {
+			return fmt.Errorf("User does not exist")
+		}
+
+		//	Make sure the user hasn't changed since the caller last read it
+		if err := store.checkVersion(user.Version); err != nil {
+			return err
+		}
+
+		//	If it's already deleted, there is nothing to do
+		if user.Deleted.Valid {
+			return fmt.Errorf("User is already deleted")
+		}
+
+		//	Remove the user from groups / roles / policies
+		for _, groupName := range user.Groups {
+			group := Group{}
+			if err := getItem(txn, GetKey("Group", groupName), &group); err != nil {
+				continue // The group is already gone
+			}
+
+			group.Users = removeItem(group.Users, user.Name)
+			group.Version++
+			if err := setItem(txn, GetKey("Group", group.Name), group); err != nil {
+				return err
+			}
+			retval.Groups = append(retval.Groups, group.Name)
+		}
+
+		for _, roleName := range user.Roles {
+			role := Role{}
+			if err := getItem(txn, GetKey("Role", roleName), &role); err != nil {
+				continue // The role is already gone
+			}
+
+			role.Users = removeItem(role.Users, user.Name)
+			role.Version++
+			if err := setItem(txn, GetKey("Role", role.Name), role); err != nil {
+				return err
+			}
+			retval.Roles = append(retval.Roles, role.Name)
+		}
+
+		for _, policyName := range user.Policies {
+			policy := Policy{}
+			if err := getItem(txn, GetKey("Policy", policyName), &policy); err != nil {
+				continue // The policy is already gone
+			}
+
+			policy.Users = removeItem(policy.Users, user.Name)
+			policy.Version++
+			if err := setItem(txn, GetKey("Policy", policy.Name), policy); err != nil {
+				return err
+			}
+			retval.Policies = append(retval.Policies, policy.Name)
+		}
+
+		//	Remove the user from the items it has grants for (grants aren't restored with the user)
+		for _, grant := range user.Grants {
+			if err := detachUser(txn, User{Name: user.Name}, grant.Access, grant.Name); err != nil {
+				return err
+			}
+		}
+
+		//	Make sure it's set to 'disabled':
+		user.Enabled = false
+
+		//	Reset the groups / roles / policies / grants collections:
+		user.Groups = []string{}
+		user.Roles = []string{}
+		user.Policies = []string{}
+		user.Grants = []Grant{}
+
+		//	Update the updated / deleted fields:
+		user.Deleted = zero.TimeFrom(time.Now())
+		user.Updated = time.Now()
+		user.DeletedBy = null.StringFrom(context.Name)
+		user.UpdatedBy = context.Name
+		user.Version++
+
+		//	Keep track of the items it was removed from (so it can be restored later):
+		if err := setItemWithTTL(txn, GetKey("RecycleBin", "User", user.Name), retval, store.DeletedRetention); err != nil {
+			return err
+		}
+
+		//	Save it to the database with a TTL:
+		return setItemWithTTL(txn, GetKey("User", user.Name), user, store.DeletedRetention)
 	})
 
-	//	If got an error, we have a problem:
+	//	If there was an error deleting the data, report it:
 	if err != nil {
-		return retval, fmt.Errorf("User does not exist")
+		return retval, err
 	}
 
-	//	Make sure it's initially set to 'enabled':
-	user.Enabled = false
+	//	Return our data:
+	return retval, nil
+}
 
-	//	Remove the user from groups / roles / policies
+// RestoreUser restores a deleted user (if it hasn't been removed from the system yet).  The user
+// is added back to the groups, roles and policies it was removed from when it was deleted (if they still exist)
+func (store Manager) RestoreUser(context User, userName string) (User, error) {
+	//	Our return item
+	retval := User{}
 
-	//	Reset the groups / roles / policies collections:
-	user.Groups = []string{}
-	user.Roles = []string{}
-	user.Policies = []string{}
+	//	Security check:  Are we authorized to perform this action?
+	if !store.IsUserRequestAuthorized(context, sysreqRestoreUser) {
+		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
+	}
 
-	//	Update the updated / deleted fields:
-	user.Deleted = zero.TimeFrom(time.Now())
-	user.Updated = time.Now()
-	user.DeletedBy = null.StringFrom(context.Name)
-	user.UpdatedBy = context.Name
+	err := store.update(newAuditEvent(context.Name, sysreqRestoreUser.Action, "User", userName), func(txn *writeTxn) error {
+		//	First -- does the user exist?
+		retval = User{}
+		if err := getItem(txn, GetKey("User", userName), &retval); err != nil {
+			return fmt.Errorf("User does not exist")
+		}
 
-	//	Serialize to JSON format
-	encoded, err := json.Marshal(user)
-	if err != nil {
-		return retval, fmt.Errorf("Problem serializing the data: %s", err)
-	}
+		//	Make sure the user hasn't changed since the caller last read it
+		if err := store.checkVersion(retval.Version); err != nil {
+			return err
+		}
+
+		//	We can only restore deleted items
+		if !retval.Deleted.Valid {
+			return fmt.Errorf("User is not deleted")
+		}
+
+		//	Get the items the user was removed from when it was deleted
+		removed := CascadeResult{}
+		getItem(txn, GetKey("RecycleBin", "User", retval.Name), &removed)
+
+		//	Add the user back to groups, roles and policies
+		for _, groupName := range removed.Groups {
+			group := Group{}
+			if err := getItem(txn, GetKey("Group", groupName), &group); err != nil || group.Deleted.Valid {
+				continue // The group is gone
+			}
+
+			group.Users = mergeItems(group.Users, retval.Name)
+			group.Version++
+			if err := setItem(txn, GetKey("Group", group.Name), group); err != nil {
+				return err
+			}
+			retval.Groups = mergeItems(retval.Groups, group.Name)
+		}
+
+		for _, roleName := range removed.Roles {
+			role := Role{}
+			if err := getItem(txn, GetKey("Role", roleName), &role); err != nil || role.Deleted.Valid {
+				continue // The role is gone
+			}
+
+			role.Users = mergeItems(role.Users, retval.Name)
+			role.Version++
+			if err := setItem(txn, GetKey("Role", role.Name), role); err != nil {
+				return err
+			}
+			retval.Roles = mergeItems(retval.Roles, role.Name)
+		}
+
+		for _, policyName := range removed.Policies {
+			policy := Policy{}
+			if err := getItem(txn, GetKey("Policy", policyName), &policy); err != nil || policy.Deleted.Valid {
+				continue // The policy is gone
+			}
+
+			policy.Users = mergeItems(policy.Users, retval.Name)
+			policy.Version++
+			if err := setItem(txn, GetKey("Policy", policy.Name), policy); err != nil {
+				return err
+			}
+			retval.Policies = mergeItems(retval.Policies, policy.Name)
+		}
 
-	//	Save it to the database with a TTL:
-	err = store.systemdb.Update(func(txn *badger.Txn) error {
-		err := txn.SetWithTTL(GetKey("User", user.Name), encoded, 168*time.Hour) // Set with an expire time of 1 week
-		return err
+		//	Make sure it's set to 'enabled' again:
+		retval.Enabled = true
+
+		//	Reset the deleted fields and update the updated fields:
+		retval.Deleted = zero.Time{}
+		retval.DeletedBy = null.String{}
+		retval.Updated = time.Now()
+		retval.UpdatedBy = context.Name
+		retval.Version++
+
+		//	We don't need to keep track of the removed items anymore:
+		if err := txn.Delete(GetKey("RecycleBin", "User", retval.Name)); err != nil {
+			return err
+		}
+
+		//	Save it to the database (without a TTL):
+		return setItem(txn, GetKey("User", retval.Name), retval)
 	})
 
-	//	If there was an error saving the data, report it:
+	//	If there was an error restoring the data, report it:
 	if err != nil {
-		return retval, fmt.Errorf("Problem saving the data: %s", err)
+		return retval, err
 	}
 
-	//	Set our retval:
-	retval = user
-
 	//	Return our data:
 	return retval, nil
 }
 
-// GetAllUsers gets all users in the system
-func (store Manager) GetAllUsers(context User) ([]User, error) {
+// SetUserAttributes sets the custom attributes of a user (replacing any attributes the user had).
+// Attributes can be used in policies with the '${user.attributes.<key>}' variable
+func (store Manager) SetUserAttributes(context User, userName string, attributes map[string]string) (User, error) {
 	//	Our return item
-	retval := []User{}
+	retval := User{}
 
 	//	Security check:  Are we authorized to perform this action?
-	if !store.IsUserRequestAuthorized(context, sysreqGetAllUsers) {
+	if !store.IsUserRequestAuthorized(context, sysreqSetUserAttributes) {
 		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
 	}
 
-	err := store.systemdb.View(func(txn *badger.Txn) error {
+	//	Attribute keys have to be usable in a policy variable
+	for key := range attributes {
+		if key == "" || strings.ContainsAny(key, variableEnd+" ") {
+			return retval, fmt.Errorf("Attribute key '%s' is not valid", key)
+		}
+	}
 
-		//	Get an iterator
-		it := txn.NewIterator(badger.DefaultIteratorOptions)
-		defer it.Close()
+	err := store.update(newAuditEvent(context.Name, sysreqSetUserAttributes.Action, "User", userName), func(txn *writeTxn) error {
+		//	First -- does the user exist?
+		retval = User{}
+		if err := getItem(txn, GetKey("User", userName), &retval); err != nil {
+			return fmt.Errorf("User does not exist")
+		}
+		if retval.Deleted.Valid {
+			return fmt.Errorf("User is deleted")
+		}
 
-		//	Set our prefix
-		prefix := GetKey("User")
+		//	Make sure the user hasn't changed since the caller last read it
+		if err := store.checkVersion(retval.Version); err != nil {
+			return err
+		}
 
-		//	Iterate over our values:
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		//	Set the attributes and update the updated fields:
+		retval.Attributes = attributes
+		retval.Updated = time.Now()
+		retval.UpdatedBy = context.Name
+		retval.Version++
 
-			//	Get the item
-			item := it.Item()
+		return setItem(txn, GetKey("User", retval.Name), retval)
+	})
 
-			//	Get the item key
-			// k := item.Key()
+	//	If there was an error saving the data, report it:
+	if err != nil {
+		return retval, err
+	}
 
-			//	Get the item value
-			val, err := item.Value()
-			if err != nil {
-				return err
-			}
+	//	Return our data:
+	return retval, nil
+}
 
-			if len(val) > 0 {
-				//	Create our item:
-				item := User{}
+// GetAllUsers gets the users in the system that match the list options (a page at a time, if the
+// options have a limit).  Returns the users and the cursor for the next page (empty if this is the last page)
+func (store Manager) GetAllUsers(context User, options ListOptions) ([]User, string, error) {
+	//	Our return item
+	retval := []User{}
 
-				//	Unmarshal data into our item
-				if err := json.Unmarshal(val, &item); err != nil {
-					return err
-				}
+	//	Security check:  Are we authorized to perform this action?
+	if !store.IsUserRequestAuthorized(context, sysreqGetAllUsers) {
+		return retval, "", fmt.Errorf("User %s is not authorized to perform the action", context.Name)
+	}
 
-				//	Add to the array of returned users:
-				retval = append(retval, item)
-			}
+	entries, nextCursor, err := store.listItems("User", options, func(val []byte) (listEntry, error) {
+		//	Unmarshal data into our item
+		item := User{}
+		if err := json.Unmarshal(val, &item); err != nil {
+			return listEntry{}, err
 		}
-		return nil
+
+		return listEntry{item: item, name: item.Name, created: item.Created, updated: item.Updated, deleted: item.Deleted.Valid,
+			enabled: item.Enabled, groups: item.Groups, roles: item.Roles, policies: item.Policies}, nil
 	})
 
 	//	If there was an error, report it:
 	if err != nil {
-		return retval, fmt.Errorf("Problem getting the list of items: %s", err)
+		return retval, "", err
+	}
+
+	//	Add to the array of returned users:
+	for _, entry := range entries {
+		retval = append(retval, entry.item.(User))
 	}
 
 	//	Return our data:
-	return retval, nil
+	return retval, nextCursor, nil
 }
 
 // GetUserWithCredentials gets a user given a set of credentials
@@ -289,7 +471,8 @@ func (store Manager) GetUserWithCredentials(name, secret string) (User, error) {
 		return nil
 	})
 
-	if err != nil {
+	//	A deleted user can't log in
+	if err != nil || tmpUser.Deleted.Valid {
 		return retUser, fmt.Errorf("The user was not found or the password was incorrect")
 	}
 
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/danesparza/iamserver/data"
+	"github.com/danesparza/iamserver/policy"
 )
 
 func TestUser_AddUser_ValidUser_Successful(t *testing.T) {
@@ -233,7 +234,7 @@ func TestUser_GetAllUsers_UserExists_ReturnsAllUsers(t *testing.T) {
 		t.Fatalf("AddUser - Should add user without error, but got: %s", err)
 	}
 
-	allusers, err := db.GetAllUsers(contextUser)
+	allusers, _, err := db.GetAllUsers(contextUser, data.ListOptions{})
 
 	//	Assert
 	if err != nil {
@@ -323,3 +324,234 @@ func TestUser_GetUserWithCredentials_InvalidParams_ReturnsError(t *testing.T) {
 	}
 
 }
+
+func TestUser_DeleteUser_UserDoesntExist_ReturnsError(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+
+	//	Act
+	_, err = db.DeleteUser(contextUser, "Unittestuser1")
+
+	//	Assert
+	if err == nil {
+		t.Errorf("DeleteUser - Should return an error when the user doesn't exist")
+	}
+}
+
+func TestUser_DeleteUser_UserIsAttached_RemovesReferences(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+
+	db.AddUser(contextUser, data.User{Name: "Unittestuser1"}, "testpass")
+	db.AddUser(contextUser, data.User{Name: "Unittestuser2"}, "testpass")
+	db.AddGroup(contextUser, "Unittestgroup1", "")
+	db.AddRole(contextUser, "Unittestrole1", "")
+	db.AddResource(contextUser, "Someresource", "")
+	db.AddPolicy(contextUser, data.Policy{Name: "Unittestpolicy1", Effect: policy.Allow, Resources: []string{"Someresource"}, Actions: []string{"Someaction"}})
+
+	db.AddUsersToGroup(contextUser, "Unittestgroup1", "Unittestuser1", "Unittestuser2")
+	db.AttachRoleToUsers(contextUser, "Unittestrole1", "Unittestuser1", "Unittestuser2")
+	db.AttachPolicyToUsers(contextUser, "Unittestpolicy1", "Unittestuser1", "Unittestuser2")
+
+	//	Act
+	cascade, err := db.DeleteUser(contextUser, "Unittestuser1")
+
+	//	Assert
+	if err != nil {
+		t.Errorf("DeleteUser - Should delete user without error, but got: %s", err)
+	}
+
+	if len(cascade.Groups) != 1 || len(cascade.Roles) != 1 || len(cascade.Policies) != 1 {
+		t.Errorf("DeleteUser - Should have reported 1 affected group, role and policy, but got: %+v", cascade)
+	}
+
+	group1, _ := db.GetGroup(contextUser, "Unittestgroup1")
+	if len(group1.Users) != 1 || group1.Users[0] != "Unittestuser2" {
+		t.Errorf("DeleteUser - Should have removed the user from the group, but group users are: %v", group1.Users)
+	}
+
+	role1, _ := db.GetRole(contextUser, "Unittestrole1")
+	if len(role1.Users) != 1 || role1.Users[0] != "Unittestuser2" {
+		t.Errorf("DeleteUser - Should have removed the user from the role, but role users are: %v", role1.Users)
+	}
+
+	policy1, _ := db.GetPolicy(contextUser, "Unittestpolicy1")
+	if len(policy1.Users) != 1 || policy1.Users[0] != "Unittestuser2" {
+		t.Errorf("DeleteUser - Should have removed the user from the policy, but policy users are: %v", policy1.Users)
+	}
+
+	user1, _ := db.WithDeleted(true).GetUser(contextUser, "Unittestuser1")
+	if !user1.Deleted.Valid || user1.Enabled {
+		t.Errorf("DeleteUser - Should have marked the user as deleted and disabled: %+v", user1)
+	}
+
+	if _, err := db.GetUser(contextUser, "Unittestuser1"); err == nil {
+		t.Errorf("GetUser - Should not return a deleted user (unless it's asked for)")
+	}
+
+	if _, err := db.SetUserAttributes(contextUser, "Unittestuser1", map[string]string{"team": "ops"}); err == nil {
+		t.Errorf("SetUserAttributes - Should not update a deleted user")
+	}
+
+	if _, err := db.GetUserWithCredentials("Unittestuser1", "testpass"); err == nil {
+		t.Errorf("GetUserWithCredentials - Should not let a deleted user log in")
+	}
+}
+
+func TestUser_GetAllUsers_UserIsDeleted_FiltersDeletedUsers(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+
+	db.AddUser(contextUser, data.User{Name: "Unittestuser1"}, "testpass")
+	db.AddUser(contextUser, data.User{Name: "Unittestuser2"}, "testpass")
+	db.DeleteUser(contextUser, "Unittestuser1")
+
+	//	Act
+	liveUsers, _, err := db.GetAllUsers(contextUser, data.ListOptions{})
+	allUsers, _, _ := db.GetAllUsers(contextUser, data.ListOptions{IncludeDeleted: true})
+	recycleBin, _ := db.GetRecycleBin(contextUser)
+
+	//	Assert
+	if err != nil {
+		t.Errorf("GetAllUsers - Should get users without error, but got: %s", err)
+	}
+
+	if len(liveUsers) != 1 || liveUsers[0].Name != "Unittestuser2" {
+		t.Errorf("GetAllUsers - Should only return the user that isn't deleted, but got: %v", liveUsers)
+	}
+
+	if len(allUsers) != 2 {
+		t.Errorf("GetAllUsers - Should return 2 users when including deleted users, but got %v", len(allUsers))
+	}
+
+	if len(recycleBin.Users) != 1 || recycleBin.Users[0].Name != "Unittestuser1" {
+		t.Errorf("GetRecycleBin - Should return the deleted user, but got: %v", recycleBin.Users)
+	}
+}
+
+func TestUser_RestoreUser_UserIsDeleted_RestoresReferences(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+
+	db.AddUser(contextUser, data.User{Name: "Unittestuser1"}, "testpass")
+	db.AddGroup(contextUser, "Unittestgroup1", "")
+	db.AddRole(contextUser, "Unittestrole1", "")
+	db.AddResource(contextUser, "Someresource", "")
+	db.AddPolicy(contextUser, data.Policy{Name: "Unittestpolicy1", Effect: policy.Allow, Resources: []string{"Someresource"}, Actions: []string{"Someaction"}})
+
+	db.AddUsersToGroup(contextUser, "Unittestgroup1", "Unittestuser1")
+	db.AttachRoleToUsers(contextUser, "Unittestrole1", "Unittestuser1")
+	db.AttachPolicyToUsers(contextUser, "Unittestpolicy1", "Unittestuser1")
+
+	db.DeleteUser(contextUser, "Unittestuser1")
+
+	//	Act
+	user1, err := db.RestoreUser(contextUser, "Unittestuser1")
+
+	//	Assert
+	if err != nil {
+		t.Errorf("RestoreUser - Should restore user without error, but got: %s", err)
+	}
+
+	if user1.Deleted.Valid || !user1.Enabled {
+		t.Errorf("RestoreUser - Should have marked the user as not deleted and enabled: %+v", user1)
+	}
+
+	if len(user1.Groups) != 1 || len(user1.Roles) != 1 || len(user1.Policies) != 1 {
+		t.Errorf("RestoreUser - Should have restored the user's group, role and policy, but got: %+v", user1)
+	}
+
+	group1, _ := db.GetGroup(contextUser, "Unittestgroup1")
+	if len(group1.Users) != 1 {
+		t.Errorf("RestoreUser - Should have added the user back to the group, but group users are: %v", group1.Users)
+	}
+
+	role1, _ := db.GetRole(contextUser, "Unittestrole1")
+	if len(role1.Users) != 1 {
+		t.Errorf("RestoreUser - Should have added the user back to the role, but role users are: %v", role1.Users)
+	}
+
+	policy1, _ := db.GetPolicy(contextUser, "Unittestpolicy1")
+	if len(policy1.Users) != 1 {
+		t.Errorf("RestoreUser - Should have added the user back to the policy, but policy users are: %v", policy1.Users)
+	}
+
+	allUsers, _, _ := db.GetAllUsers(contextUser, data.ListOptions{})
+	if len(allUsers) != 1 {
+		t.Errorf("RestoreUser - Should list the restored user again, but got: %v", allUsers)
+	}
+}
+
+func TestUser_RestoreUser_UserIsNotDeleted_ReturnsError(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	db.AddUser(contextUser, data.User{Name: "Unittestuser1"}, "testpass")
+
+	//	Act
+	_, err = db.RestoreUser(contextUser, "Unittestuser1")
+
+	//	Assert
+	if err == nil {
+		t.Errorf("RestoreUser - Should return an error when the user isn't deleted")
+	}
+}
@@ -0,0 +1,309 @@
+package data
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/danesparza/iamserver/policy"
+)
+
+// Policy variables let a single policy apply to each user in its own way (like 'profile:${user.name}').
+// Variables in the resources, actions and condition values of a policy are resolved when the policy is evaluated:
+// - ${user.name}: The name of the user
+// - ${user.groups}: Each of the groups the user is in
+// - ${user.attributes.<key>}: A custom attribute of the user
+// - ${context.<key>}: A value from the context of the request
+//
+// A pattern with a variable that has more than one value (like ${user.groups}) is expanded to a pattern
+// for each value.  A pattern with a variable that has no value doesn't match anything.  Values are escaped
+// before they are substituted, so they only ever match themselves (a user named '<.*>' can't match every resource)
+const (
+	variableStart = "${"
+	variableEnd   = "}"
+)
+
+// userVariables gets the policy variables for the user
+func userVariables(user User) map[string][]string {
+	retval := map[string][]string{
+		"user.name":   {user.Name},
+		"user.groups": append([]string{}, user.Groups...),
+	}
+
+	for key, value := range user.Attributes {
+		retval["user.attributes."+key] = []string{value}
+	}
+
+	return retval
+}
+
+// requestVariables gets the policy variables for the context of the request
+func requestVariables(r *Request) map[string][]string {
+	retval := map[string][]string{}
+
+	for key, value := range r.Context {
+		retval["context."+key] = []string{value}
+	}
+
+	return retval
+}
+
+// isVariable returns true if the name is the name of a policy variable
+func isVariable(name string) bool {
+	switch {
+	case name == "user.name", name == "user.groups":
+		return true
+	case strings.HasPrefix(name, "user.attributes.") && len(name) > len("user.attributes."):
+		return true
+	case strings.HasPrefix(name, "context.") && len(name) > len("context."):
+		return true
+	}
+
+	return false
+}
+
+// hasVariables returns true if any of the statements of the policy have a variable
+func hasVariables(p Policy) bool {
+	for _, s := range p.statements() {
+		if statementHasVariables(s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// statementHasVariables returns true if any of the resources, actions ('not_' resources or actions)
+// or condition values of the statement have a variable
+func statementHasVariables(s Statement) bool {
+	patterns := [][]string{s.Resources, s.Actions, s.NotResources, s.NotActions}
+	for _, keys := range s.Conditions {
+		for _, values := range keys {
+			patterns = append(patterns, values)
+		}
+	}
+
+	for _, list := range patterns {
+		for _, pattern := range list {
+			if strings.Contains(pattern, variableStart) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// expandPolicies expands the variables in each of the policies.  See expandPolicy
+func expandPolicies(policies map[string]Policy, variables map[string][]string) map[string]Policy {
+	retval := make(map[string]Policy, len(policies))
+	for name, p := range policies {
+		retval[name] = expandPolicy(p, variables)
+	}
+
+	return retval
+}
+
+// expandPolicy expands the variables (that have values) in the statements of the policy.
+// Variables that don't have values are left as they are -- they may be expanded later.  Policies
+// without variables are returned as they are
+func expandPolicy(p Policy, variables map[string][]string) Policy {
+	if !hasVariables(p) {
+		return p
+	}
+
+	statements := []Statement{}
+	for _, s := range p.statements() {
+		statements = append(statements, expandStatement(s, p.Syntax, variables))
+	}
+
+	return p.withStatements(statements)
+}
+
+// expandStatement expands the variables (that have values) in the statement.  See expandPolicy
+func expandStatement(s Statement, syntax string, variables map[string][]string) Statement {
+	if !statementHasVariables(s) {
+		return s
+	}
+
+	s.Resources = expandPatterns(s.Resources, syntax, variables)
+	s.Actions = expandPatterns(s.Actions, syntax, variables)
+	s.NotResources = expandPatterns(s.NotResources, syntax, variables)
+	s.NotActions = expandPatterns(s.NotActions, syntax, variables)
+
+	//	Condition values are escaped like globs (whatever the syntax of the policy)
+	if len(s.Conditions) > 0 {
+		conditions := Conditions{}
+		for operator, keys := range s.Conditions {
+			conditions[operator] = map[string][]string{}
+			for key, values := range keys {
+				conditions[operator][key] = expandPatterns(values, policy.Glob, variables)
+			}
+		}
+		s.Conditions = conditions
+	}
+
+	return s
+}
+
+// expandPatterns expands the variables in each of the patterns.  See expandPattern
+func expandPatterns(patterns []string, syntax string, variables map[string][]string) []string {
+	if len(patterns) == 0 {
+		return patterns
+	}
+
+	retval := []string{}
+	for _, pattern := range patterns {
+		retval = append(retval, expandPattern(pattern, syntax, variables)...)
+	}
+
+	return retval
+}
+
+// resolveStatement expands the variables in the statement (of a policy with the given syntax) and removes
+// the patterns and condition values with variables that don't have values (so they can't match anything).
+//
+// Removing a 'not_' pattern (or a value of a 'Not' condition) makes the statement match more.  That's fine
+// for a 'deny' statement (it denies more), but an 'allow' statement with a 'not_' pattern (or a 'Not' condition
+// value) that can't be resolved matches nothing at all
+func resolveStatement(s Statement, syntax string, variables map[string][]string) Statement {
+	if !statementHasVariables(s) {
+		return s
+	}
+
+	s = expandStatement(s, syntax, variables)
+	notResources, notActions := withoutVariables(s.NotResources), withoutVariables(s.NotActions)
+	unresolvedNot := len(notResources) != len(s.NotResources) || len(notActions) != len(s.NotActions)
+
+	conditions := Conditions{}
+	for operator, keys := range s.Conditions {
+		conditions[operator] = map[string][]string{}
+		for key, values := range keys {
+			resolved := withoutVariables(values)
+			if len(resolved) != len(values) && (operator == policy.StringNotEquals || operator == policy.StringNotLike) {
+				unresolvedNot = true
+			}
+			conditions[operator][key] = resolved
+		}
+	}
+
+	if s.Effect == policy.Allow && unresolvedNot {
+		return Statement{Sid: s.Sid, Effect: s.Effect, Resources: []string{}, Actions: []string{}}
+	}
+
+	s.Resources = withoutVariables(s.Resources)
+	s.Actions = withoutVariables(s.Actions)
+	s.Conditions = conditions
+
+	//	If all of the 'not_' patterns were removed, the statement matches everything
+	if len(notResources) == 0 && len(s.NotResources) > 0 {
+		s.Resources = []string{matchAllPattern(syntax)}
+	}
+	if len(notActions) == 0 && len(s.NotActions) > 0 {
+		s.Actions = []string{matchAllPattern(syntax)}
+	}
+
+	s.NotResources, s.NotActions = notResources, notActions
+	return s
+}
+
+// matchAllPattern gets the pattern (in the given syntax) that matches everything
+func matchAllPattern(syntax string) string {
+	if syntax == policy.Glob {
+		return "*"
+	}
+
+	return "<(?s:.*)>"
+}
+
+// withoutVariables gets the patterns that don't have variables
+func withoutVariables(patterns []string) []string {
+	retval := []string{}
+	for _, pattern := range patterns {
+		if !strings.Contains(pattern, variableStart) {
+			retval = append(retval, pattern)
+		}
+	}
+
+	return retval
+}
+
+// expandPattern expands the variables in the pattern (in the given syntax) and returns a pattern
+// for each combination of values.  If a variable has no values, no patterns are returned
+func expandPattern(pattern, syntax string, variables map[string][]string) []string {
+	retval := []string{""}
+	depth := 0
+
+	for {
+		//	Find the next variable (if there is one)
+		start := strings.Index(pattern, variableStart)
+		end := -1
+		if start >= 0 {
+			end = strings.Index(pattern[start:], variableEnd)
+		}
+		if end < 0 {
+			return appendToAll(retval, pattern)
+		}
+		end += start
+
+		//	Keep track of whether the variable is inside a regexp template
+		literal := pattern[:start]
+		depth += strings.Count(literal, string(regexpStartDelimeter)) - strings.Count(literal, string(regexpEndDelimeter))
+		retval = appendToAll(retval, literal)
+
+		values, ok := variables[pattern[start+len(variableStart):end]]
+		if !ok {
+			//	Leave the variable for later
+			retval = appendToAll(retval, pattern[start:end+len(variableEnd)])
+		} else {
+			expanded := []string{}
+			for _, prefix := range retval {
+				for _, value := range values {
+					expanded = append(expanded, prefix+escapeVariable(value, syntax, depth > 0))
+				}
+			}
+			retval = expanded
+		}
+
+		pattern = pattern[end+len(variableEnd):]
+	}
+}
+
+// appendToAll appends the text to each of the patterns
+func appendToAll(patterns []string, text string) []string {
+	for i := range patterns {
+		patterns[i] += text
+	}
+
+	return patterns
+}
+
+// escapeVariable escapes the value of a variable, so it only matches itself in a pattern of the given
+// syntax.  In the regexp syntax, values with special characters are quoted in a template of their own
+// (or are just quoted if the variable is already inside a template)
+func escapeVariable(value, syntax string, inTemplate bool) string {
+	if syntax == policy.Glob {
+		retval := ""
+		for _, r := range value {
+			if strings.ContainsRune(globMetaChars+"${", r) {
+				retval += `\`
+			}
+			retval += string(r)
+		}
+		return retval
+	}
+
+	if !inTemplate && !strings.ContainsAny(value, "<>${") {
+		return value
+	}
+
+	//	The template delimiters can't appear in a template (even when quoted)
+	quoted := regexp.QuoteMeta(value)
+	quoted = strings.Replace(quoted, string(regexpStartDelimeter), `\x3c`, -1)
+	quoted = strings.Replace(quoted, string(regexpEndDelimeter), `\x3e`, -1)
+
+	if inTemplate {
+		return quoted
+	}
+
+	return string(regexpStartDelimeter) + quoted + string(regexpEndDelimeter)
+}
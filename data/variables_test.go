@@ -0,0 +1,196 @@
+package data_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/danesparza/iamserver/data"
+	"github.com/danesparza/iamserver/policy"
+)
+
+func TestManager_AuthorizeUserRequest_PolicyVariables_Successful(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	db.AddUser(contextUser, data.User{Name: "bob"}, "testpass")
+	db.AddUser(contextUser, data.User{Name: "jayne"}, "testpass")
+	db.AddGroup(contextUser, "crew", "")
+	db.AddUsersToGroup(contextUser, "crew", "bob", "jayne")
+	db.SetUserAttributes(contextUser, "bob", map[string]string{"ship": "Serenity"})
+
+	testPolicies := []data.Policy{
+		{Name: "Own profile", Effect: policy.Allow, Resources: []string{"profile:${user.name}"}, Actions: []string{"<Get|Update>"}},
+		{Name: "Group profiles", Effect: policy.Allow, Syntax: policy.Glob, Resources: []string{"group:${user.groups}/*"}, Actions: []string{"Get"}},
+		{Name: "Own ship", Effect: policy.Allow, Resources: []string{"ship:${user.attributes.ship}:<.*>"}, Actions: []string{"Fly"}},
+		{Name: "Own deck", Effect: policy.Allow, Resources: []string{"deck:${context.deck}"}, Actions: []string{"Visit"}},
+	}
+	for _, testPolicy := range testPolicies {
+		if _, err := db.AddPolicy(contextUser, testPolicy); err != nil {
+			t.Fatalf("AddPolicy - Should add a policy with variables without error, but got: %s", err)
+		}
+		db.AttachPolicyToGroups(contextUser, testPolicy.Name, "crew")
+	}
+
+	bob := data.User{Name: "bob"}
+	tests := []struct {
+		request *data.Request
+		want    bool
+	}{
+		{&data.Request{Resource: "profile:bob", Action: "Update"}, true},
+		{&data.Request{Resource: "profile:jayne", Action: "Update"}, false},
+		{&data.Request{Resource: "profile:${user.name}", Action: "Update"}, false},
+		{&data.Request{Resource: "group:crew/roster", Action: "Get"}, true},
+		{&data.Request{Resource: "group:passengers/roster", Action: "Get"}, false},
+		{&data.Request{Resource: "ship:Serenity:Bridge", Action: "Fly"}, true},
+		{&data.Request{Resource: "ship:Alliance:Bridge", Action: "Fly"}, false},
+		{&data.Request{Resource: "deck:Cargo", Action: "Visit", Context: map[string]string{"deck": "Cargo"}}, true},
+		{&data.Request{Resource: "deck:Cargo", Action: "Visit", Context: map[string]string{"deck": "Engine"}}, false},
+		{&data.Request{Resource: "deck:", Action: "Visit"}, false},
+	}
+
+	for _, test := range tests {
+		//	Act
+		cached := db.AuthorizeUserRequest(bob, test.request)
+		uncached := db.WithoutPolicyCache().AuthorizeUserRequest(bob, test.request)
+
+		//	Assert
+		if cached.Allowed != test.want || uncached.Allowed != test.want {
+			t.Errorf("AuthorizeUserRequest - Expected %+v to be allowed: %v, but got %v (cached) and %v (uncached)", test.request, test.want, cached.Allowed, uncached.Allowed)
+		}
+	}
+
+}
+
+func TestManager_AuthorizeUserRequest_PolicyVariables_ValuesMatchLiterally(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	db.AddUser(contextUser, data.User{Name: "bob"}, "testpass")
+	db.SetUserAttributes(contextUser, "bob", map[string]string{"team": "<.*>", "glob": "*", "nested": "${user.name}"})
+
+	db.AddPolicy(contextUser, data.Policy{Name: "Team regexp", Effect: policy.Allow, Resources: []string{"team:${user.attributes.team}", "nested:<${user.attributes.team}|x>"}, Actions: []string{"Get"}})
+	db.AddPolicy(contextUser, data.Policy{Name: "Team glob", Effect: policy.Allow, Syntax: policy.Glob, Resources: []string{"glob:${user.attributes.glob}"}, Actions: []string{"Get"}})
+	db.AddPolicy(contextUser, data.Policy{Name: "Team nested", Effect: policy.Allow, Resources: []string{"name:${user.attributes.nested}"}, Actions: []string{"Get"}})
+	db.AttachPolicyToUsers(contextUser, "Team regexp", "bob")
+	db.AttachPolicyToUsers(contextUser, "Team glob", "bob")
+	db.AttachPolicyToUsers(contextUser, "Team nested", "bob")
+
+	bob := data.User{Name: "bob"}
+	tests := []struct {
+		resource string
+		want     bool
+	}{
+		{"team:<.*>", true},
+		{"team:anything", false},
+		{"nested:<.*>", true},
+		{"nested:x", true},
+		{"nested:anything", false},
+		{"glob:*", true},
+		{"glob:anything", false},
+		{"name:${user.name}", true},
+		{"name:bob", false},
+	}
+
+	for _, test := range tests {
+		//	Act
+		cached := db.AuthorizeUserRequest(bob, &data.Request{Resource: test.resource, Action: "Get"})
+		uncached := db.WithoutPolicyCache().AuthorizeUserRequest(bob, &data.Request{Resource: test.resource, Action: "Get"})
+
+		//	Assert
+		if cached.Allowed != test.want || uncached.Allowed != test.want {
+			t.Errorf("AuthorizeUserRequest - Expected %s to be allowed: %v, but got %v (cached) and %v (uncached)", test.resource, test.want, cached.Allowed, uncached.Allowed)
+		}
+	}
+
+}
+
+func TestUser_SetUserAttributes_InvalidatesPolicyCache(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	db.AddUser(contextUser, data.User{Name: "bob"}, "testpass")
+	db.SetUserAttributes(contextUser, "bob", map[string]string{"ship": "Serenity"})
+	db.AddPolicy(contextUser, data.Policy{Name: "Own ship", Effect: policy.Allow, Resources: []string{"ship:${user.attributes.ship}"}, Actions: []string{"Fly"}})
+	db.AttachPolicyToUsers(contextUser, "Own ship", "bob")
+
+	bob := data.User{Name: "bob"}
+	before := db.AuthorizeUserRequest(bob, &data.Request{Resource: "ship:Serenity", Action: "Fly"})
+
+	//	Act
+	updated, err := db.SetUserAttributes(contextUser, "bob", map[string]string{"ship": "Alliance"})
+	after := db.AuthorizeUserRequest(bob, &data.Request{Resource: "ship:Serenity", Action: "Fly"})
+
+	//	Assert
+	if err != nil {
+		t.Fatalf("SetUserAttributes - Should set attributes without error, but got: %s", err)
+	}
+
+	if updated.Attributes["ship"] != "Alliance" {
+		t.Errorf("SetUserAttributes - Expected the attribute to be set, but got %+v", updated.Attributes)
+	}
+
+	if !before.Allowed || after.Allowed {
+		t.Errorf("AuthorizeUserRequest - Expected the request to be allowed before the change (got %v) and denied after it (got %v)", before.Allowed, after.Allowed)
+	}
+
+}
+
+func TestUser_SetUserAttributes_InvalidKey_ReturnsError(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	db.AddUser(contextUser, data.User{Name: "bob"}, "testpass")
+
+	//	Act
+	_, err = db.SetUserAttributes(contextUser, "bob", map[string]string{"bad}key": "value"})
+
+	//	Assert
+	if err == nil {
+		t.Errorf("SetUserAttributes - Should not set an attribute with an invalid key")
+	}
+
+}
@@ -0,0 +1,458 @@
+package data
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/danesparza/badger"
+)
+
+// Webhook represents a subscription to change events.  When an audit event is recorded for a
+// change (to users, group memberships, roles, policies and so on) the event is posted to the
+// webhook URL.  Events is the list of actions (like 'AddUsersToGroup') the webhook is interested
+// in -- if it's empty, every event is posted
+type Webhook struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	URL         string    `json:"url"`
+	Secret      string    `json:"secret,omitempty"`
+	Events      []string  `json:"events"`
+	Version     int64     `json:"version"`
+	Created     time.Time `json:"created"`
+	CreatedBy   string    `json:"created_by"`
+	Updated     time.Time `json:"updated"`
+	UpdatedBy   string    `json:"updated_by"`
+}
+
+// WebhookDelivery is an event waiting in the outbox to be posted to a webhook.  Deliveries are
+// added to the outbox in the same transaction as the change, so they survive a restart
+type WebhookDelivery struct {
+	Webhook     string     `json:"webhook"`
+	Event       AuditEvent `json:"event"`
+	Attempts    int        `json:"attempts"`
+	NextAttempt time.Time  `json:"next_attempt"`
+	LastError   string     `json:"last_error"`
+	Failed      bool       `json:"failed"`
+}
+
+// WebhookDispatcher posts the deliveries in the outbox to their webhooks.  Each delivery
+// is signed with the webhook secret (HMAC-SHA256 of the timestamp and body, in the X-IAM-Signature header).
+// Failed deliveries are retried with exponential backoff (starting at BaseBackoff, up to
+// MaxBackoff) and given up on after MaxAttempts
+type WebhookDispatcher struct {
+	DB          *Manager
+	Client      *http.Client
+	MaxAttempts int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+}
+
+// NewWebhookDispatcher creates a webhook dispatcher with the default settings
+func NewWebhookDispatcher(db *Manager) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		DB:          db,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts: 10,
+		BaseBackoff: 5 * time.Second,
+		MaxBackoff:  1 * time.Hour,
+	}
+}
+
+// wants returns true if the webhook is interested in the given action
+func (hook Webhook) wants(action string) bool {
+	return len(hook.Events) == 0 || containsItem(hook.Events, action)
+}
+
+// enqueueWebhooks adds a delivery to the outbox for each webhook that is interested
+// in the event (as part of the given transaction)
+func (txn *writeTxn) enqueueWebhooks(event AuditEvent) error {
+	return forEachItem(txn, GetKey("Webhook", ""), func(val []byte) error {
+		hook := Webhook{}
+		if err := json.Unmarshal(val, &hook); err != nil {
+			return err
+		}
+
+		if !hook.wants(event.Action) {
+			return nil
+		}
+
+		delivery := WebhookDelivery{Webhook: hook.Name, Event: event, NextAttempt: event.Time}
+		return setItem(txn, GetKey("Outbox", event.ID, hook.Name), delivery)
+	})
+}
+
+// AddWebhook adds a webhook to the system.  If the webhook doesn't have a secret, one is
+// created.  The secret is only returned when the webhook is added
+func (store Manager) AddWebhook(context User, hook Webhook) (Webhook, error) {
+	//	Our return item
+	retval := Webhook{}
+
+	//	Security check:  Are we authorized to perform this action?
+	if !store.IsUserRequestAuthorized(context, sysreqAddWebhook) {
+		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
+	}
+
+	//	Validate the webhook:
+	if hook.Name == "" {
+		return retval, fmt.Errorf("Webhook name can't be blank")
+	}
+
+	if target, err := url.Parse(hook.URL); err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return retval, fmt.Errorf("Webhook URL must be an http or https URL")
+	}
+
+	if hook.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return retval, fmt.Errorf("Problem creating the webhook secret: %s", err)
+		}
+		hook.Secret = hex.EncodeToString(secret)
+	}
+
+	//	Update the created / updated fields:
+	hook.Created = time.Now()
+	hook.Updated = time.Now()
+	hook.CreatedBy = context.Name
+	hook.UpdatedBy = context.Name
+
+	err := store.update(newAuditEvent(context.Name, sysreqAddWebhook.Action, "Webhook", hook.Name), func(txn *writeTxn) error {
+		//	First -- does the webhook exist already?
+		if _, err := txn.Get(GetKey("Webhook", hook.Name)); err == nil {
+			return fmt.Errorf("Webhook already exists")
+		}
+
+		//	Save it to the database (as the first version):
+		hook.Version = 1
+		return setItem(txn, GetKey("Webhook", hook.Name), hook)
+	})
+
+	//	If there was an error saving the data, report it:
+	if err != nil {
+		return retval, err
+	}
+
+	//	Set our retval:
+	retval = hook
+
+	//	Return our data:
+	return retval, nil
+}
+
+// GetWebhook gets a webhook from the system (without its secret)
+func (store Manager) GetWebhook(context User, webhookName string) (Webhook, error) {
+	//	Our return item
+	retval := Webhook{}
+
+	//	Security check:  Are we authorized to perform this action?
+	if !store.IsUserRequestAuthorized(context, sysreqGetWebhook) {
+		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
+	}
+
+	err := store.systemdb.View(func(txn *badger.Txn) error {
+		return getItem(txn, GetKey("Webhook", webhookName), &retval)
+	})
+
+	//	If there was an error, report it:
+	if err != nil {
+		return retval, fmt.Errorf("Problem getting the data: %s", err)
+	}
+
+	//	Return our data:
+	retval.Secret = ""
+	return retval, nil
+}
+
+// GetAllWebhooks gets all webhooks in the system (without their secrets)
+func (store Manager) GetAllWebhooks(context User) ([]Webhook, error) {
+	//	Our return item
+	retval := []Webhook{}
+
+	//	Security check:  Are we authorized to perform this action?
+	if !store.IsUserRequestAuthorized(context, sysreqGetAllWebhooks) {
+		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
+	}
+
+	err := store.systemdb.View(func(txn *badger.Txn) error {
+		return forEachItem(txn, GetKey("Webhook", ""), func(val []byte) error {
+			hook := Webhook{}
+			if err := json.Unmarshal(val, &hook); err != nil {
+				return err
+			}
+
+			hook.Secret = ""
+			retval = append(retval, hook)
+			return nil
+		})
+	})
+
+	//	If there was an error, report it:
+	if err != nil {
+		return []Webhook{}, fmt.Errorf("Problem getting the list of items: %s", err)
+	}
+
+	//	Return our data:
+	return retval, nil
+}
+
+// DeleteWebhook removes a webhook from the system.  Deliveries still in the outbox for the webhook are dropped
+func (store Manager) DeleteWebhook(context User, webhookName string) error {
+	//	Security check:  Are we authorized to perform this action?
+	if !store.IsUserRequestAuthorized(context, sysreqDeleteWebhook) {
+		return fmt.Errorf("User %s is not authorized to perform the action", context.Name)
+	}
+
+	return store.update(newAuditEvent(context.Name, sysreqDeleteWebhook.Action, "Webhook", webhookName), func(txn *writeTxn) error {
+		//	First -- validate that the webhook exists
+		hook := Webhook{}
+		if err := getItem(txn, GetKey("Webhook", webhookName), &hook); err != nil {
+			return fmt.Errorf("Webhook does not exist")
+		}
+
+		//	Make sure the webhook hasn't changed since the caller last read it
+		if err := store.checkVersion(hook.Version); err != nil {
+			return err
+		}
+
+		if err := txn.recordChange(GetKey("Webhook", webhookName), nil); err != nil {
+			return err
+		}
+
+		if err := txn.Delete(GetKey("Webhook", webhookName)); err != nil {
+			return err
+		}
+
+		//	Drop the webhook's deliveries from the outbox
+		outboxKeys := [][]byte{}
+		err := forEachItem(txn, GetKey("Outbox", ""), func(val []byte) error {
+			delivery := WebhookDelivery{}
+			if err := json.Unmarshal(val, &delivery); err != nil {
+				return err
+			}
+
+			if delivery.Webhook == webhookName {
+				outboxKeys = append(outboxKeys, GetKey("Outbox", delivery.Event.ID, delivery.Webhook))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, key := range outboxKeys {
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// GetWebhookOutbox gets the deliveries in the outbox (oldest event first), including
+// the deliveries that have been given up on
+func (store Manager) GetWebhookOutbox(context User) ([]WebhookDelivery, error) {
+	//	Our return item
+	retval := []WebhookDelivery{}
+
+	//	Security check:  Are we authorized to perform this action?
+	if !store.IsUserRequestAuthorized(context, sysreqGetWebhookOutbox) {
+		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
+	}
+
+	err := store.systemdb.View(func(txn *badger.Txn) error {
+		return forEachItem(txn, GetKey("Outbox", ""), func(val []byte) error {
+			delivery := WebhookDelivery{}
+			if err := json.Unmarshal(val, &delivery); err != nil {
+				return err
+			}
+
+			retval = append(retval, delivery)
+			return nil
+		})
+	})
+
+	//	If there was an error, report it:
+	if err != nil {
+		return []WebhookDelivery{}, fmt.Errorf("Problem getting the webhook outbox: %s", err)
+	}
+
+	//	Return our data:
+	return retval, nil
+}
+
+// SignWebhookPayload gets the signature of a delivery:  the hex encoded HMAC-SHA256 of the timestamp (in unix seconds),
+// a '.' and the body using the webhook secret.  The X-IAM-Signature header is sent as 't=<timestamp>,sha256=<signature>'.
+// Receivers should compare the signature and reject old timestamps (so a captured delivery can't be replayed)
+func SignWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Run delivers the outbox every interval, until stop is closed
+func (dispatcher *WebhookDispatcher) Run(stop <-chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := dispatcher.DeliverDue(time.Now()); err != nil {
+				log.Printf("[ERROR] Problem delivering webhooks: %s", err)
+			}
+		}
+	}
+}
+
+// DeliverDue posts the deliveries in the outbox that are due at the given time.
+// Returns the number of deliveries that were successfully posted
+func (dispatcher *WebhookDispatcher) DeliverDue(now time.Time) (int, error) {
+	delivered := 0
+
+	//	Find the deliveries that are due (along with their webhooks)
+	type dueDelivery struct {
+		key      []byte
+		delivery WebhookDelivery
+		hook     Webhook
+		found    bool
+	}
+	due := []dueDelivery{}
+
+	err := dispatcher.DB.systemdb.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := GetKey("Outbox", "")
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			val, err := it.Item().Value()
+			if err != nil {
+				return err
+			}
+
+			current := dueDelivery{key: it.Item().KeyCopy(nil)}
+			if err := json.Unmarshal(val, &current.delivery); err != nil {
+				return err
+			}
+
+			if current.delivery.Failed || current.delivery.NextAttempt.After(now) {
+				continue
+			}
+
+			err = getItem(txn, GetKey("Webhook", current.delivery.Webhook), &current.hook)
+			if err != nil && err != badger.ErrKeyNotFound {
+				return err
+			}
+			current.found = err == nil
+
+			due = append(due, current)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return delivered, err
+	}
+
+	//	Post each delivery (outside of a transaction) and then update the outbox
+	for _, current := range due {
+		postErr := fmt.Errorf("The webhook no longer exists")
+		if current.found {
+			postErr = dispatcher.post(current.hook, current.delivery)
+		}
+
+		err := dispatcher.DB.systemdb.Update(func(txn *badger.Txn) error {
+			//	If it was delivered (or the webhook is gone), remove it from the outbox
+			if postErr == nil || !current.found {
+				return txn.Delete(current.key)
+			}
+
+			//	Otherwise, try again later (or give up)
+			delivery := current.delivery
+			delivery.Attempts++
+			delivery.LastError = postErr.Error()
+			delivery.NextAttempt = now.Add(dispatcher.backoff(delivery.Attempts))
+			delivery.Failed = delivery.Attempts >= dispatcher.MaxAttempts
+
+			encoded, err := json.Marshal(delivery)
+			if err != nil {
+				return err
+			}
+
+			if delivery.Failed {
+				log.Printf("[WARN] Giving up on delivering event %s to webhook %s: %s", delivery.Event.ID, delivery.Webhook, postErr)
+
+				//	Keep failed deliveries around (for troubleshooting) as long as deleted items
+				return txn.SetWithTTL(current.key, encoded, dispatcher.DB.DeletedRetention)
+			}
+
+			return txn.Set(current.key, encoded)
+		})
+		if err != nil {
+			return delivered, err
+		}
+
+		if postErr == nil {
+			delivered++
+		}
+	}
+
+	return delivered, nil
+}
+
+// backoff gets how long to wait before the next attempt, after the given number of attempts
+func (dispatcher *WebhookDispatcher) backoff(attempts int) time.Duration {
+	retval := dispatcher.BaseBackoff
+	for i := 1; i < attempts && retval < dispatcher.MaxBackoff; i++ {
+		retval *= 2
+	}
+
+	if retval > dispatcher.MaxBackoff {
+		retval = dispatcher.MaxBackoff
+	}
+
+	return retval
+}
+
+// post posts the delivery event to the webhook.  Any response other than a 2xx is an error
+func (dispatcher *WebhookDispatcher) post(hook Webhook, delivery WebhookDelivery) error {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("X-IAM-Event", delivery.Event.Action)
+	req.Header.Set("X-IAM-Delivery", delivery.Event.ID)
+	timestamp := time.Now().Unix()
+	req.Header.Set("X-IAM-Signature", fmt.Sprintf("t=%d,sha256=%s", timestamp, SignWebhookPayload(hook.Secret, timestamp, body)))
+
+	resp, err := dispatcher.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("The webhook returned %s", resp.Status)
+	}
+
+	return nil
+}
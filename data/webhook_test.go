@@ -0,0 +1,248 @@
+package data_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danesparza/iamserver/data"
+)
+
+// webhookReceiver records the deliveries posted to it
+type webhookReceiver struct {
+	mu         sync.Mutex
+	status     int
+	events     []data.AuditEvent
+	signatures []string
+	bodies     [][]byte
+}
+
+func (receiver *webhookReceiver) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+
+	body, _ := ioutil.ReadAll(req.Body)
+	event := data.AuditEvent{}
+	json.Unmarshal(body, &event)
+
+	receiver.events = append(receiver.events, event)
+	receiver.signatures = append(receiver.signatures, req.Header.Get("X-IAM-Signature"))
+	receiver.bodies = append(receiver.bodies, body)
+	rw.WriteHeader(receiver.status)
+}
+
+func TestWebhook_DeliverDue_PostsSignedEvents(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	receiver := &webhookReceiver{status: http.StatusOK}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	contextUser := data.User{Name: "System"}
+	hook, err := db.AddWebhook(contextUser, data.Webhook{Name: "HRSync", URL: server.URL, Events: []string{"AddUser", "AddUsersToGroup"}})
+	if err != nil {
+		t.Fatalf("AddWebhook - Should add the webhook without error, but got: %s", err)
+	}
+
+	db.AddUser(contextUser, data.User{Name: "bob"}, "testpass")
+	db.AddGroup(contextUser, "Crew", "")
+	db.AddUsersToGroup(contextUser, "Crew", "bob")
+
+	//	Act
+	delivered, err := data.NewWebhookDispatcher(db).DeliverDue(time.Now())
+
+	//	Assert
+	if err != nil {
+		t.Fatalf("DeliverDue - Should deliver without error, but got: %s", err)
+	}
+
+	if delivered != 2 || len(receiver.events) != 2 || receiver.events[0].Action != "AddUser" || receiver.events[1].Action != "AddUsersToGroup" {
+		t.Fatalf("DeliverDue - Expected the AddUser and AddUsersToGroup events to be delivered, but got %v: %+v", delivered, receiver.events)
+	}
+
+	for i, body := range receiver.bodies {
+		parts := strings.SplitN(strings.TrimPrefix(receiver.signatures[i], "t="), ",", 2)
+		timestamp, _ := strconv.ParseInt(parts[0], 10, 64)
+		if time.Since(time.Unix(timestamp, 0)) > time.Minute || receiver.signatures[i] != fmt.Sprintf("t=%d,sha256=%s", timestamp, data.SignWebhookPayload(hook.Secret, timestamp, body)) {
+			t.Errorf("DeliverDue - Expected the delivery to be signed (with a timestamp) with the webhook secret, but got %s", receiver.signatures[i])
+		}
+	}
+
+	outbox, _ := db.GetWebhookOutbox(contextUser)
+	if len(outbox) != 0 {
+		t.Errorf("GetWebhookOutbox - Expected delivered events to be removed from the outbox, but got %+v", outbox)
+	}
+}
+
+func TestWebhook_DeliverDue_FailedDelivery_RetriesWithBackoff(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	receiver := &webhookReceiver{status: http.StatusInternalServerError}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	contextUser := data.User{Name: "System"}
+	db.AddWebhook(contextUser, data.Webhook{Name: "Gateway", URL: server.URL, Events: []string{"AddUser"}})
+	db.AddUser(contextUser, data.User{Name: "bob"}, "testpass")
+
+	dispatcher := data.NewWebhookDispatcher(db)
+	dispatcher.MaxAttempts = 3
+	dispatcher.BaseBackoff = time.Minute
+	now := time.Now()
+
+	//	Act
+	dispatcher.DeliverDue(now)
+	dispatcher.DeliverDue(now.Add(30 * time.Second)) // Not due yet
+	afterFirst, _ := db.GetWebhookOutbox(contextUser)
+
+	dispatcher.DeliverDue(now.Add(time.Minute))
+	dispatcher.DeliverDue(now.Add(4 * time.Minute))
+	afterLast, _ := db.GetWebhookOutbox(contextUser)
+
+	//	Assert
+	if len(afterFirst) != 1 || afterFirst[0].Attempts != 1 || !afterFirst[0].NextAttempt.Equal(now.Add(time.Minute)) || !strings.Contains(afterFirst[0].LastError, "500") {
+		t.Errorf("DeliverDue - Expected the delivery to be retried in a minute, but got %+v", afterFirst)
+	}
+
+	if len(receiver.events) != 3 {
+		t.Errorf("DeliverDue - Expected 3 attempts (with backoff), but got %v", len(receiver.events))
+	}
+
+	if len(afterLast) != 1 || afterLast[0].Attempts != 3 || !afterLast[0].Failed {
+		t.Errorf("DeliverDue - Expected the delivery to be given up on after 3 attempts, but got %+v", afterLast)
+	}
+}
+
+func TestWebhook_Outbox_SurvivesRestart(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+
+	receiver := &webhookReceiver{status: http.StatusNoContent}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	contextUser := data.User{Name: "System"}
+	db.AddWebhook(contextUser, data.Webhook{Name: "Gateway", URL: server.URL, Events: []string{"AddUser"}})
+	db.AddUser(contextUser, data.User{Name: "bob"}, "testpass")
+
+	//	Act
+	db.Close()
+	db, err = data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	delivered, err := data.NewWebhookDispatcher(db).DeliverDue(time.Now())
+
+	//	Assert
+	if err != nil || delivered != 1 || len(receiver.events) != 1 || receiver.events[0].Target != "User:bob" {
+		t.Errorf("DeliverDue - Expected the event from before the restart to be delivered, but got %v (%v): %+v", delivered, err, receiver.events)
+	}
+}
+
+func TestWebhook_GetWebhook_DoesNotReturnSecret(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+
+	//	Act
+	added, err := db.AddWebhook(contextUser, data.Webhook{Name: "Gateway", URL: "https://gateway.example.com/hooks"})
+	if err != nil {
+		t.Fatalf("AddWebhook - Should add the webhook without error, but got: %s", err)
+	}
+	fetched, err := db.GetWebhook(contextUser, "Gateway")
+	_, badURLErr := db.AddWebhook(contextUser, data.Webhook{Name: "Bad", URL: "ftp://example.com"})
+
+	//	Assert
+	if err != nil || added.Secret == "" || fetched.Secret != "" || fetched.URL != added.URL {
+		t.Errorf("GetWebhook - Expected the secret to only be returned when added, but got %+v / %+v (%v)", added, fetched, err)
+	}
+
+	if badURLErr == nil {
+		t.Errorf("AddWebhook - Expected an error for a non-http URL, but didn't get one")
+	}
+}
+
+func TestWebhook_DeleteWebhook_DropsOutboxDeliveries(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	db.AddWebhook(contextUser, data.Webhook{Name: "Gateway", URL: "https://gateway.example.com/hook", Events: []string{"AddUser"}})
+	db.AddWebhook(contextUser, data.Webhook{Name: "HRSync", URL: "https://hr.example.com/hook", Events: []string{"AddUser"}})
+	db.AddUser(contextUser, data.User{Name: "bob"}, "testpass")
+
+	//	Act
+	err = db.DeleteWebhook(contextUser, "Gateway")
+
+	//	Assert
+	if err != nil {
+		t.Fatalf("DeleteWebhook - Should delete the webhook without error, but got: %s", err)
+	}
+
+	outbox, _ := db.GetWebhookOutbox(contextUser)
+	if len(outbox) != 1 || outbox[0].Webhook != "HRSync" {
+		t.Errorf("DeleteWebhook - Expected only the other webhook's delivery to be left in the outbox, but got %+v", outbox)
+	}
+}
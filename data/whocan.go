@@ -0,0 +1,200 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/danesparza/badger"
+	"github.com/pkg/errors"
+)
+
+// Grant paths:  how a policy is attached to a user
+const (
+	GrantDirect    = "direct"
+	GrantGroup     = "group"
+	GrantRole      = "role"
+	GrantGroupRole = "group_role"
+)
+
+// AccessGrant is a statement of a policy that decides a request for a user, and the path the policy
+// is attached to the user by.  Group and Role are the group and role on the path (if there are any)
+type AccessGrant struct {
+	Path      string `json:"path"`
+	Group     string `json:"group,omitempty"`
+	Role      string `json:"role,omitempty"`
+	Policy    string `json:"policy"`
+	Statement string `json:"statement"`
+}
+
+// AccessUser is a user that has access (or would have access, if it wasn't for an explicit deny) to a
+// resource and action.  Grants are the allows of the user's policies, and Denies are the denies that override them
+type AccessUser struct {
+	User   string        `json:"user"`
+	Grants []AccessGrant `json:"grants"`
+	Denies []AccessGrant `json:"denies,omitempty"`
+}
+
+// WhoCanResult has the users that are allowed to perform an action on a resource.  Denied are the users
+// that have an allow for the request, but are denied by an explicit deny that overrides it
+type WhoCanResult struct {
+	Resource string       `json:"resource"`
+	Action   string       `json:"action"`
+	Allowed  []AccessUser `json:"allowed"`
+	Denied   []AccessUser `json:"denied"`
+}
+
+// policyPath is a policy attached to a user, and the path it is attached by
+type policyPath struct {
+	path  string
+	group string
+	role  string
+}
+
+// WhoCan finds every user that is allowed to perform the action of the request on the resource, along with
+// the path each of the allowing policies is attached to the user by.  The request is decided for each user
+// the same way DoPoliciesAllow decides it (with the user's variables and the context of the request)
+func (store Manager) WhoCan(context User, request Request) (WhoCanResult, error) {
+	//	Our return item
+	retval := WhoCanResult{Resource: request.Resource, Action: request.Action, Allowed: []AccessUser{}, Denied: []AccessUser{}}
+
+	//	Security check:  Are we authorized to perform this action?
+	if !store.IsUserRequestAuthorized(context, sysreqWhoCan) {
+		return retval, fmt.Errorf("User %s is not authorized to perform the action", context.Name)
+	}
+
+	if request.Resource == "" || request.Action == "" {
+		return retval, fmt.Errorf("Request must have a resource and an action")
+	}
+
+	//	Get the effective policies of each user (and the paths they are attached by) in a single transaction
+	now := time.Now()
+	users := map[string]userPolicies{}
+	paths := map[string]map[string][]policyPath{}
+	userNames := []string{}
+	err := store.systemdb.View(func(txn *badger.Txn) error {
+		return forEachItem(txn, GetKey("User"), func(val []byte) error {
+			user := User{}
+			if err := json.Unmarshal(val, &user); err != nil || user.Deleted.Valid {
+				return err
+			}
+
+			userNames = append(userNames, user.Name)
+			users[user.Name] = resolveUserPolicies(txn, user, nil)
+			paths[user.Name] = getPolicyPaths(txn, user.withGrants(now))
+			return nil
+		})
+	})
+	if err != nil {
+		return retval, fmt.Errorf("Problem reading the users: %s", err)
+	}
+	sort.Strings(userNames)
+
+	//	Decide the request for each user
+	for _, userName := range userNames {
+		policies := expandPolicies(users[userName].policies, users[userName].variables)
+
+		decidedBy, err := store.DecidePolicies(&request, policies)
+		cause := errors.Cause(err)
+		if err != nil && cause != ErrRequestDenied && cause != ErrRequestForcefullyDenied {
+			return retval, fmt.Errorf("Problem deciding the request for user %s: %s", userName, err)
+		}
+
+		//	If the request is denied (and not because of an explicit deny), the user has no access
+		if cause == ErrRequestDenied {
+			continue
+		}
+
+		//	Find the allows -- each policy is decided on its own, so each allowing policy is found
+		access := AccessUser{User: userName, Grants: []AccessGrant{}}
+		for _, name := range sortedPolicyNames(policies) {
+			allowedBy, err := store.DecidePolicies(&request, map[string]Policy{name: policies[name]})
+			if err == nil {
+				access.Grants = append(access.Grants, accessGrants(allowedBy, paths[userName][name])...)
+			}
+		}
+
+		if cause == ErrRequestForcefullyDenied {
+			//	Only note the deny if it overrides an allow
+			if len(access.Grants) == 0 {
+				continue
+			}
+			access.Denies = accessGrants(decidedBy, paths[userName][decidedBy.Policy])
+			retval.Denied = append(retval.Denied, access)
+			continue
+		}
+
+		retval.Allowed = append(retval.Allowed, access)
+	}
+
+	return retval, nil
+}
+
+// getPolicyPaths gets the paths each of the user's policies are attached to the user by.  The access of
+// the user's grants should already be added to the user (a granted policy is attached directly)
+func getPolicyPaths(txn reader, user User) map[string][]policyPath {
+	retval := map[string][]policyPath{}
+
+	addRole := func(roleName string, path policyPath) {
+		role := Role{}
+		if err := getItem(txn, GetKey("Role", roleName), &role); err != nil {
+			return
+		}
+		for _, policyName := range role.Policies {
+			retval[policyName] = append(retval[policyName], path)
+		}
+	}
+
+	for _, policyName := range user.Policies {
+		retval[policyName] = append(retval[policyName], policyPath{path: GrantDirect})
+	}
+
+	for _, roleName := range user.Roles {
+		addRole(roleName, policyPath{path: GrantRole, role: roleName})
+	}
+
+	for _, groupName := range user.Groups {
+		group := Group{}
+		if err := getItem(txn, GetKey("Group", groupName), &group); err != nil {
+			continue
+		}
+
+		for _, policyName := range group.Policies {
+			retval[policyName] = append(retval[policyName], policyPath{path: GrantGroup, group: groupName})
+		}
+
+		for _, roleName := range group.Roles {
+			addRole(roleName, policyPath{path: GrantGroupRole, group: groupName, role: roleName})
+		}
+	}
+
+	return retval
+}
+
+// accessGrants returns a grant for the statement for each of the paths its policy is attached by
+func accessGrants(statement PolicyStatement, paths []policyPath) []AccessGrant {
+	retval := []AccessGrant{}
+	for _, path := range paths {
+		retval = append(retval, AccessGrant{
+			Path:      path.path,
+			Group:     path.group,
+			Role:      path.role,
+			Policy:    statement.Policy,
+			Statement: statement.Statement,
+		})
+	}
+
+	return retval
+}
+
+// sortedPolicyNames returns the names of the policies, sorted
+func sortedPolicyNames(policies map[string]Policy) []string {
+	retval := []string{}
+	for name := range policies {
+		retval = append(retval, name)
+	}
+	sort.Strings(retval)
+
+	return retval
+}
@@ -0,0 +1,108 @@
+package data_test
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/danesparza/iamserver/data"
+	"github.com/danesparza/iamserver/policy"
+)
+
+func TestManager_WhoCan_ReturnsUsersWithGrantPaths(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	contextUser := data.User{Name: "System"}
+	db.AddResource(contextUser, "Serenity", "")
+	for _, name := range []string{"bob", "jayne", "kaylee", "wash", "zoe", "river", "mal"} {
+		db.AddUser(contextUser, data.User{Name: name}, "testpass")
+	}
+	db.AddPolicy(contextUser, data.Policy{Name: "Fly", Effect: policy.Allow, Resources: []string{"Serenity"}, Actions: []string{"Fly"}})
+	db.AddPolicy(contextUser, data.Policy{Name: "Grounded", Statements: []data.Statement{{Sid: "NoFlying", Effect: policy.Deny, Resources: []string{"Serenity"}, Actions: []string{"<.*>"}}}})
+	db.AddPolicy(contextUser, data.Policy{Name: "Land", Effect: policy.Allow, Resources: []string{"Serenity"}, Actions: []string{"Land"}})
+
+	db.AttachPolicyToUsers(contextUser, "Fly", "bob")
+	db.AddGroup(contextUser, "crew", "")
+	db.AddUsersToGroup(contextUser, "crew", "jayne", "kaylee")
+	db.AttachPolicyToGroups(contextUser, "Fly", "crew")
+	db.AddRole(contextUser, "pilot", "")
+	db.AttachPoliciesToRole(contextUser, "pilot", "Fly")
+	db.AttachRoleToUsers(contextUser, "pilot", "wash")
+	db.AddGroup(contextUser, "officers", "")
+	db.AddUsersToGroup(contextUser, "officers", "zoe")
+	db.AttachRoleToGroups(contextUser, "pilot", "officers")
+	db.AttachPolicyToUsers(contextUser, "Grounded", "kaylee", "river")
+	db.AttachPolicyToUsers(contextUser, "Land", "mal")
+
+	//	Act
+	result, err := db.WhoCan(contextUser, data.Request{Resource: "Serenity", Action: "Fly"})
+
+	//	Assert
+	if err != nil {
+		t.Fatalf("WhoCan - Should find the users without error, but got: %s", err)
+	}
+
+	expected := []data.AccessUser{
+		{User: "bob", Grants: []data.AccessGrant{{Path: data.GrantDirect, Policy: "Fly", Statement: "0"}}},
+		{User: "jayne", Grants: []data.AccessGrant{{Path: data.GrantGroup, Group: "crew", Policy: "Fly", Statement: "0"}}},
+		{User: "wash", Grants: []data.AccessGrant{{Path: data.GrantRole, Role: "pilot", Policy: "Fly", Statement: "0"}}},
+		{User: "zoe", Grants: []data.AccessGrant{{Path: data.GrantGroupRole, Group: "officers", Role: "pilot", Policy: "Fly", Statement: "0"}}},
+	}
+	if !reflect.DeepEqual(result.Allowed, expected) {
+		t.Errorf("WhoCan - Expected the allowed users %+v, but got %+v", expected, result.Allowed)
+	}
+
+	expectedDenied := []data.AccessUser{
+		{
+			User:   "kaylee",
+			Grants: []data.AccessGrant{{Path: data.GrantGroup, Group: "crew", Policy: "Fly", Statement: "0"}},
+			Denies: []data.AccessGrant{{Path: data.GrantDirect, Policy: "Grounded", Statement: "NoFlying"}},
+		},
+	}
+	if !reflect.DeepEqual(result.Denied, expectedDenied) {
+		t.Errorf("WhoCan - Expected the users denied by an explicit deny %+v, but got %+v", expectedDenied, result.Denied)
+	}
+
+}
+
+func TestManager_WhoCan_InvalidRequest_ReturnsError(t *testing.T) {
+
+	//	Arrange
+	systemdb, tokendb := getTestFiles()
+	db, err := data.NewManager(systemdb, tokendb)
+	if err != nil {
+		t.Fatalf("NewManager failed: %s", err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(systemdb)
+		os.RemoveAll(tokendb)
+	}()
+
+	db.AddUser(data.User{Name: "System"}, data.User{Name: "bob"}, "testpass")
+
+	//	Act
+	_, errNoAction := db.WhoCan(data.User{Name: "System"}, data.Request{Resource: "Serenity"})
+	_, errNotAuthorized := db.WhoCan(data.User{Name: "bob"}, data.Request{Resource: "Serenity", Action: "Fly"})
+
+	//	Assert
+	if errNoAction == nil {
+		t.Errorf("WhoCan - Should not find users for a request without an action")
+	}
+
+	if errNotAuthorized == nil {
+		t.Errorf("WhoCan - Should not find users for a user without access")
+	}
+
+}
@@ -0,0 +1,94 @@
+package decisionlog
+
+import (
+	"encoding/json"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/danesparza/iamserver/data"
+)
+
+// Sink is a destination for logged decisions.  Each decision is written as a single JSON line
+type Sink interface {
+	Write(line []byte) error
+	Close() error
+}
+
+// Logger logs authorization decisions to one or more sinks.  Only a sample of the
+// decisions is logged:  AllowSampleRate and DenySampleRate are the fractions (from 0 to 1)
+// of allowed and denied decisions that are logged.  A nil Logger doesn't log anything
+type Logger struct {
+	AllowSampleRate float64
+	DenySampleRate  float64
+	Sinks           []Sink
+
+	mu     sync.Mutex
+	random *rand.Rand
+}
+
+// NewLogger creates a new decision logger that logs to the given sinks
+func NewLogger(allowSampleRate, denySampleRate float64, sinks ...Sink) *Logger {
+	return &Logger{
+		AllowSampleRate: allowSampleRate,
+		DenySampleRate:  denySampleRate,
+		Sinks:           sinks,
+		random:          rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Log logs the decision (if it is part of the sample)
+func (l *Logger) Log(decision data.Decision) {
+	if l == nil || len(l.Sinks) == 0 || !l.sampled(decision) {
+		return
+	}
+
+	line, err := json.Marshal(decision)
+	if err != nil {
+		log.Printf("[ERROR] Problem serializing the authorization decision: %s", err)
+		return
+	}
+
+	for _, sink := range l.Sinks {
+		if err := sink.Write(line); err != nil {
+			log.Printf("[ERROR] Problem writing the authorization decision: %s", err)
+		}
+	}
+}
+
+// Close closes all sinks
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	var retval error
+	for _, sink := range l.Sinks {
+		if err := sink.Close(); err != nil {
+			retval = err
+		}
+	}
+
+	return retval
+}
+
+// sampled returns true if the decision should be logged
+func (l *Logger) sampled(decision data.Decision) bool {
+	rate := l.AllowSampleRate
+	if !decision.Allowed {
+		rate = l.DenySampleRate
+	}
+
+	if rate >= 1 {
+		return true
+	}
+	if rate <= 0 {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.random.Float64() < rate
+}
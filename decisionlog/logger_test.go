@@ -0,0 +1,116 @@
+package decisionlog_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/danesparza/iamserver/data"
+	"github.com/danesparza/iamserver/decisionlog"
+)
+
+// memorySink keeps logged decisions in memory
+type memorySink struct {
+	lines [][]byte
+}
+
+func (s *memorySink) Write(line []byte) error {
+	s.lines = append(s.lines, line)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	return nil
+}
+
+func TestLogger_Log_SampleRates_OnlyLogsSampledDecisions(t *testing.T) {
+	//	Arrange
+	sink := &memorySink{}
+	logger := decisionlog.NewLogger(0, 1, sink)
+
+	//	Act
+	for i := 0; i < 10; i++ {
+		logger.Log(data.Decision{User: "bob", Resource: "System", Action: "AddUser", Allowed: true})
+		logger.Log(data.Decision{User: "bob", Resource: "System", Action: "DeleteUser", Allowed: false})
+	}
+
+	//	Assert
+	if len(sink.lines) != 10 {
+		t.Fatalf("Log - Expected only the 10 denied decisions to be logged, but got %v", len(sink.lines))
+	}
+
+	decision := data.Decision{}
+	if err := json.Unmarshal(sink.lines[0], &decision); err != nil || decision.Allowed || decision.Action != "DeleteUser" {
+		t.Errorf("Log - Expected a denied decision, but got %s (%v)", sink.lines[0], err)
+	}
+}
+
+func TestLogger_Log_NilLogger_DoesNothing(t *testing.T) {
+	//	Arrange
+	var logger *decisionlog.Logger
+
+	//	Act
+	logger.Log(data.Decision{User: "bob"})
+
+	//	Assert
+	if err := logger.Close(); err != nil {
+		t.Errorf("Close - Should not return an error for a nil logger, but got %s", err)
+	}
+}
+
+func TestWriterSink_Write_WritesLines(t *testing.T) {
+	//	Arrange
+	buf := &bytes.Buffer{}
+	logger := decisionlog.NewLogger(1, 1, decisionlog.NewWriterSink(buf))
+
+	//	Act
+	logger.Log(data.Decision{User: "bob", Allowed: true})
+	logger.Log(data.Decision{User: "alice", Allowed: false})
+
+	//	Assert
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], `"user":"alice"`) {
+		t.Errorf("Write - Expected 2 JSON lines, but got %s", buf.String())
+	}
+}
+
+func TestFileSink_Write_RotatesFiles(t *testing.T) {
+	//	Arrange
+	dir, err := ioutil.TempDir(os.Getenv("IAM_TEST_ROOT"), "decisionlog")
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "decisions.log")
+	sink, err := decisionlog.NewFileSink(path, 100, 2)
+	if err != nil {
+		t.Fatalf("NewFileSink failed: %s", err)
+	}
+
+	//	Act
+	line := []byte(strings.Repeat("x", 40))
+	for i := 0; i < 10; i++ {
+		if err := sink.Write(line); err != nil {
+			t.Fatalf("Write - Should write without error, but got %s", err)
+		}
+	}
+	sink.Close()
+
+	//	Assert
+	files, _ := filepath.Glob(path + "*")
+	if len(files) != 3 {
+		t.Errorf("Write - Expected the log file and 2 backups, but got %v", files)
+	}
+
+	for _, file := range files {
+		info, _ := os.Stat(file)
+		if info.Size() > 100 {
+			t.Errorf("Write - Expected %s to be rotated at 100 bytes, but it is %v bytes", file, info.Size())
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package decisionlog
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// WriterSink writes decisions to an io.Writer (like os.Stdout)
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterSink creates a sink that writes decisions to the given writer
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+// NewStdoutSink creates a sink that writes decisions to stdout
+func NewStdoutSink() *WriterSink {
+	return NewWriterSink(os.Stdout)
+}
+
+// Write writes a decision line
+func (s *WriterSink) Write(line []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.w.Write(append(line, '\n'))
+	return err
+}
+
+// Close doesn't do anything -- the writer is owned by the caller
+func (s *WriterSink) Close() error {
+	return nil
+}
+
+// FileSink writes decisions to a JSONL file.  When the file grows past MaxSize bytes it is
+// rotated:  the current file is renamed to <path>.1 (and <path>.1 to <path>.2, and so on).
+// Only MaxBackups rotated files are kept
+type FileSink struct {
+	Path       string
+	MaxSize    int64
+	MaxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewFileSink creates a sink that writes decisions to the file at the given path
+func NewFileSink(path string, maxSize int64, maxBackups int) (*FileSink, error) {
+	retval := &FileSink{Path: path, MaxSize: maxSize, MaxBackups: maxBackups}
+
+	if err := retval.open(); err != nil {
+		return nil, err
+	}
+
+	return retval, nil
+}
+
+// Write writes a decision line (and rotates the file first, if it's full)
+func (s *FileSink) Write(line []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return fmt.Errorf("The decision log file is closed")
+	}
+
+	if s.MaxSize > 0 && s.size > 0 && s.size+int64(len(line))+1 > s.MaxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(append(line, '\n'))
+	s.size += int64(n)
+	return err
+}
+
+// Close closes the file
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// open opens (or creates) the file for appending
+func (s *FileSink) open() error {
+	file, err := os.OpenFile(s.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("Problem opening the decision log file: %s", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("Problem getting the size of the decision log file: %s", err)
+	}
+
+	s.file = file
+	s.size = info.Size()
+	return nil
+}
+
+// rotate moves the current file to the first backup (shifting older backups) and opens a new file
+func (s *FileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	s.file = nil
+
+	if s.MaxBackups > 0 {
+		//	Shift the backups -- the oldest one is overwritten
+		for i := s.MaxBackups - 1; i > 0; i-- {
+			os.Rename(s.backupPath(i), s.backupPath(i+1))
+		}
+
+		if err := os.Rename(s.Path, s.backupPath(1)); err != nil {
+			return fmt.Errorf("Problem rotating the decision log file: %s", err)
+		}
+	} else if err := os.Remove(s.Path); err != nil {
+		return fmt.Errorf("Problem rotating the decision log file: %s", err)
+	}
+
+	return s.open()
+}
+
+// backupPath gets the path of the given backup
+func (s *FileSink) backupPath(backup int) string {
+	return fmt.Sprintf("%s.%d", s.Path, backup)
+}
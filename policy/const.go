@@ -7,3 +7,25 @@ const (
 	// Deny is the non-permissive policy effect
 	Deny = "deny"
 )
+
+const (
+	// Regexp is the default pattern syntax.  Patterns are regular expressions wrapped in '<' and '>' (like 'Serenity:<.*>')
+	Regexp = "regexp"
+
+	// Glob is the wildcard pattern syntax.  A '*' matches any run of characters and a '?' matches any single character (like 'arn:app:orders/*')
+	Glob = "glob"
+)
+
+const (
+	// StringEquals is the condition operator that requires a context value to equal one of the condition values
+	StringEquals = "StringEquals"
+
+	// StringNotEquals is the condition operator that requires a context value to equal none of the condition values
+	StringNotEquals = "StringNotEquals"
+
+	// StringLike is the condition operator that requires a context value to match one of the condition values (as globs)
+	StringLike = "StringLike"
+
+	// StringNotLike is the condition operator that requires a context value to match none of the condition values (as globs)
+	StringNotLike = "StringNotLike"
+)